@@ -0,0 +1,222 @@
+package main
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/service"
+	"Memento/memento/storage"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const usage = `Usage: memento [command]
+
+Without a command the server is started.
+
+Commands:
+  export-site [-user name] [-url url] [-zip] <output>
+        write the public memos as a static website to a directory or zip file
+  migrate-storage [-delete] -to local|s3
+        copy posts, uploads and avatars to another storage backend and switch
+        to it; the s3 settings are read from the storage section of the config
+  migrate-posts [-compress] [-keep]
+        move the contents of posts from files into the database, verify them
+        and keep new posts in the database from now on
+  dedup-uploads
+        store the files uploaded before deduplication by their content hash
+  link-files
+        record the uploads linked from all memos again, which the first start
+        after the upgrade does once, so the attachments of private memos
+        become private
+  gc-files [-days n] [-delete]
+        list the uploads older than n days no memo or comment links and the
+        stored blobs nothing references, and remove them with -delete
+`
+
+// runCommand runs a maintenance command given on the command line instead of
+// starting the server.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "export-site":
+		return exportSite(args[1:])
+	case "migrate-storage":
+		return migrateStorage(args[1:])
+	case "migrate-posts":
+		return migratePosts(args[1:])
+	case "dedup-uploads":
+		return dedupUploads(args[1:])
+	case "link-files":
+		return linkFiles(args[1:])
+	case "gc-files":
+		return gcFiles(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return nil
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func exportSite(args []string) error {
+	flags := flag.NewFlagSet("export-site", flag.ContinueOnError)
+	options := service.SiteOptions{}
+	flags.StringVar(&options.Username, "user", "", "only export the memos of this user")
+	flags.StringVar(&options.Url, "url", "", "address the site is published at, used by feeds and the sitemap")
+	flags.BoolVar(&options.Zip, "zip", false, "write a zip archive instead of a directory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("export-site needs exactly one output path")
+	}
+	options.Output = flags.Arg(0)
+	if err := service.ExportStaticSite(options); err != nil {
+		return err
+	}
+	fmt.Printf("Site written to %s\n", options.Output)
+	return nil
+}
+
+func migrateStorage(args []string) error {
+	flags := flag.NewFlagSet("migrate-storage", flag.ContinueOnError)
+	to := flags.String("to", "", "driver of the destination, local or s3")
+	remove := flags.Bool("delete", false, "remove the blobs from the current backend once copied")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	config := memento.GetConfig()
+	if *to == "" || flags.NArg() != 0 {
+		return errors.New("migrate-storage needs the destination driver")
+	}
+	from := config.Storage.Driver
+	if from == "" {
+		from = "local"
+	}
+	if *to == from {
+		return fmt.Errorf("the blobs are already stored with %s", from)
+	}
+	destination := config.Storage
+	destination.Driver = *to
+	dst, err := memento.OpenStorage(destination)
+	if err != nil {
+		return err
+	}
+	count, err := storage.Migrate(dst, storage.Default(), storage.Prefixes, *remove, func(object storage.Object) {
+		fmt.Println(object.Key)
+	})
+	if err != nil {
+		return fmt.Errorf("migration stopped after %d blobs: %w", count, err)
+	}
+	config.Storage.Driver = *to
+	if err := memento.WriteConfig(); err != nil {
+		return err
+	}
+	fmt.Printf("Moved %d blobs from %s to %s storage\n", count, from, *to)
+	return nil
+}
+
+func migratePosts(args []string) error {
+	flags := flag.NewFlagSet("migrate-posts", flag.ContinueOnError)
+	compress := flags.Bool("compress", false, "compress the contents with gzip")
+	keep := flags.Bool("keep", false, "keep the files of the migrated posts")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	failed := 0
+	count, err := service.MigratePostContents(*compress, *keep, func(post *model.Post, err error) {
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "post %d: %s\n", post.ID, err.Error())
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("migration stopped after %d posts: %w", count, err)
+	}
+	config := memento.GetConfig()
+	config.Storage.PostContent = "database"
+	config.Storage.CompressPosts = *compress
+	if err := memento.WriteConfig(); err != nil {
+		return err
+	}
+	fmt.Printf("Moved %d posts into the database\n", count)
+	if failed > 0 {
+		return fmt.Errorf("%d posts could not be migrated and are still read from their files", failed)
+	}
+	return nil
+}
+
+func dedupUploads(args []string) error {
+	if len(args) != 0 {
+		return errors.New("dedup-uploads takes no arguments")
+	}
+	failed := 0
+	count, err := service.DeduplicateFiles(func(file *model.File, err error) {
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "file %d: %s\n", file.ID, err.Error())
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("deduplication stopped after %d files: %w", count, err)
+	}
+	fmt.Printf("Deduplicated %d files\n", count)
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be deduplicated", failed)
+	}
+	return nil
+}
+
+func linkFiles(args []string) error {
+	if len(args) != 0 {
+		return errors.New("link-files takes no arguments")
+	}
+	failed := 0
+	count, err := memento.LinkAllPostFiles(func(post *model.Post, err error) {
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "post %d: %s\n", post.ID, err.Error())
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("linking stopped after %d posts: %w", count, err)
+	}
+	fmt.Printf("Linked the files of %d posts\n", count)
+	if failed > 0 {
+		return fmt.Errorf("the files of %d posts could not be linked", failed)
+	}
+	return nil
+}
+
+func gcFiles(args []string) error {
+	flags := flag.NewFlagSet("gc-files", flag.ContinueOnError)
+	days := flags.Int("days", memento.GetConfig().Storage.OrphanDays, "minimum age of the uploads in days")
+	remove := flags.Bool("delete", false, "remove the orphaned uploads instead of listing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("gc-files takes no arguments")
+	}
+	// uploads younger than a day may belong to a post being written
+	if *days < 1 {
+		return errors.New("the age of orphaned uploads must be at least 1 day")
+	}
+	report, err := service.CollectOrphans(*days, *remove)
+	if err != nil {
+		return err
+	}
+	for _, file := range report.Files {
+		fmt.Printf("file %d\t%s\t%s\t%d bytes\n", file.ID, file.Username, file.Filename, file.Size)
+	}
+	for _, key := range report.Blobs {
+		fmt.Println(key)
+	}
+	action := "Found"
+	if report.Removed {
+		action = "Removed"
+	}
+	fmt.Printf("%s %d orphaned uploads and %d blobs, %d bytes\n", action, len(report.Files), len(report.Blobs), report.Bytes)
+	return nil
+}
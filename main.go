@@ -2,12 +2,14 @@ package main
 
 import (
 	"Memento/memento"
+	"Memento/memento/model"
 	"Memento/memento/query"
 	"Memento/memento/service"
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
+	"os"
 )
 
 func main() {
@@ -17,15 +19,29 @@ func main() {
 		log.Errorf("Error initializing memento server: %s\n", err.Error())
 		return
 	}
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Errorf("%s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
 	fmt.Println(memento.GetConfig().ServerConfig)
 	e := echo.New()
 	// Middleware
 	e.Use(middleware.Logger())
-	e.Use(middleware.Gzip())
+	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{Skipper: service.SkipGzip}))
 	e.Use(service.SEOFrontEndMiddleware)
 	e.Use(middleware.CORS())
 
 	e.GET("/rss/:username", service.HandleRss)
+	feed := e.Group("/feed")
+	{
+		feed.GET("/:format", service.HandleSiteFeed)
+		feed.GET("/:format/user/:username", service.HandleUserFeed)
+		feed.GET("/:format/tag/:tag", service.HandleTagFeed)
+		feed.GET("/:format/search", service.HandleSearchFeed)
+	}
 
 	api := e.Group("/api")
 	{
@@ -35,15 +51,19 @@ func main() {
 			postApi.GET("/all", service.HandleGetAllPosts)
 			postApi.GET("/get", service.HandleGetPost)
 			postApi.GET("/userPosts", service.HandleGetUserPosts)
-			postApi.POST("/create", service.HandlePostCreate)
-			postApi.POST("/edit", service.HandlePostEdit)
-			postApi.DELETE("/delete/:id", service.HandlePostDelete)
-			postApi.POST("/like", service.HandlePostLike)
-			postApi.POST("/unlike", service.HandlePostCancelLike)
+			postApi.POST("/create", service.HandlePostCreate, service.RequirePermission(model.PermPostWrite))
+			postApi.POST("/edit", service.HandlePostEdit, service.RequirePermission(model.PermPostWrite))
+			postApi.DELETE("/delete/:id", service.HandlePostDelete, service.RequirePermission(model.PermPostWrite))
+			postApi.POST("/like", service.HandlePostLike, service.RequirePermission(model.PermPostLike))
+			postApi.POST("/unlike", service.HandlePostCancelLike, service.RequirePermission(model.PermPostLike))
 			postApi.GET("/taggedPosts", service.HandleGetTaggedPost)
 			postApi.GET("/likedPosts", service.HandleGetLikedPosts)
 			postApi.GET("/tags", service.HandleGetTags)
 			postApi.GET("/following", service.HandleGetFollowingPosts)
+			postApi.POST("/hide", service.HandlePostHide, service.RequirePermission(model.PermPostHide))
+			postApi.POST("/unhide", service.HandlePostUnhide, service.RequirePermission(model.PermPostHide))
+			postApi.GET("/mentions", service.HandleGetPostMentions)
+			postApi.DELETE("/mention/:id", service.HandleDeleteMention)
 		}
 		userApi := api.Group("/user")
 		{
@@ -52,29 +72,66 @@ func main() {
 			userApi.POST("/create", service.HandleCreate)
 			userApi.GET("/get", service.HandleGetUser)
 			userApi.POST("/changePwd", service.HandleUserChangePwd)
-			userApi.POST("/edit", service.HandleUserEdit)
+			userApi.POST("/edit", service.HandleUserEdit, service.RequirePermission(model.PermProfileEdit))
 			userApi.DELETE("/:username", service.HandleUserDelete)
 			userApi.GET("/heatmap", service.HandleUserHeatMap)
-			userApi.POST("/follow", service.HandleUserFollow)
+			userApi.POST("/follow", service.HandleUserFollow, service.RequirePermission(model.PermUserFollow))
 			userApi.POST("/unfollow", service.HandleUserUnfollow)
 			userApi.GET("/follower", service.HandlerGetUserFollower)
 			userApi.GET("/following", service.HandlerGetUserFollowing)
 			userApi.GET("/avatar/:name", service.HandleGetAvatar)
+			userApi.GET("/warnings", service.HandleGetWarnings)
+			userApi.POST("/block", service.HandleUserBlock)
+			userApi.POST("/unblock", service.HandleUserUnblock)
+			userApi.POST("/mute", service.HandleUserMute)
+			userApi.POST("/unmute", service.HandleUserUnmute)
+			userApi.GET("/blocks", service.HandleGetBlocks)
+			userApi.GET("/mutes", service.HandleGetMutes)
+			userApi.GET("/followRequests/incoming", service.HandleGetIncomingFollowRequests)
+			userApi.GET("/followRequests/outgoing", service.HandleGetOutgoingFollowRequests)
+			userApi.POST("/followRequests/approve", service.HandleApproveFollowRequest)
+			userApi.POST("/followRequests/reject", service.HandleRejectFollowRequest)
+			userApi.GET("/tokens", service.HandleGetAccessTokens)
+			userApi.POST("/tokens", service.HandleCreateAccessToken)
+			userApi.DELETE("/tokens/:id", service.HandleDeleteAccessToken)
+			userApi.GET("/export", service.HandleGetExports)
+			userApi.POST("/export", service.HandleCreateExport)
+			userApi.DELETE("/export/:id", service.HandleDeleteExport)
+			userApi.GET("/export/:id/download", service.HandleDownloadExport)
+			userApi.GET("/git", service.HandleGetGitRepo)
+			userApi.POST("/git", service.HandleEnableGitRepo)
+			userApi.DELETE("/git", service.HandleDisableGitRepo)
+		}
+		importApi := api.Group("/import")
+		{
+			importApi.GET("", service.HandleGetImports)
+			importApi.POST("", service.HandleCreateImport, service.RequirePermission(model.PermPostWrite))
+			importApi.GET("/sources", service.HandleGetImportSources)
+			importApi.GET("/:id", service.HandleGetImport)
+			importApi.POST("/:id/run", service.HandleRunImport, service.RequirePermission(model.PermPostWrite))
+			importApi.DELETE("/:id", service.HandleDeleteImport)
 		}
 		fileApi := api.Group("/file")
 		{
 			fileApi.GET("/download/:id", service.HandleGetFile)
-			fileApi.POST("/upload", service.HandleFileUpload)
+			fileApi.POST("/upload", service.HandleFileUpload, service.RequirePermission(model.PermFileUpload))
 			fileApi.DELETE("/delete/:id", service.HandleFileDelete)
 			fileApi.GET("/all", service.HandleGetResourcesList)
+			fileApi.GET("/quota", service.HandleGetQuota)
+			fileApi.POST("/visibility", service.HandleSetFileVisibility)
+			fileApi.GET("/link/:id", service.HandleGetFileLink)
+			fileApi.POST("/uploads", service.HandleCreateUpload, service.RequirePermission(model.PermFileUpload))
+			fileApi.HEAD("/uploads/:id", service.HandleGetUpload)
+			fileApi.PATCH("/uploads/:id", service.HandleAppendUpload, service.RequirePermission(model.PermFileUpload))
+			fileApi.DELETE("/uploads/:id", service.HandleDeleteUpload)
 		}
 		commentApi := api.Group("/comment")
 		{
-			commentApi.POST("/create", service.HandleCommentCreate)
-			commentApi.POST("/edit", service.HandleCommentEdit)
+			commentApi.POST("/create", service.HandleCommentCreate, service.RequirePermission(model.PermCommentWrite))
+			commentApi.POST("/edit", service.HandleCommentEdit, service.RequirePermission(model.PermCommentWrite))
 			commentApi.DELETE("/delete", service.HandleCommentDelete)
-			commentApi.POST("/like", service.HandleCommentLike)
-			commentApi.POST("/unlike", service.HandleCommentCancelLike)
+			commentApi.POST("/like", service.HandleCommentLike, service.RequirePermission(model.PermPostLike))
+			commentApi.POST("/unlike", service.HandleCommentCancelLike, service.RequirePermission(model.PermPostLike))
 			commentApi.GET("/postComments", service.HandleGetPostComments)
 			commentApi.GET("/userComments", service.HandleGetUserComments)
 		}
@@ -85,13 +142,33 @@ func main() {
 		}
 		adminApi := api.Group("/admin")
 		{
-			adminApi.Use(service.AdminCheck)
-			adminApi.GET("/config", service.HandleGetConfigs)
-			adminApi.POST("/config", service.HandleSetConfig)
-			adminApi.GET("/listUsers", service.HandleListUsers)
-			adminApi.DELETE("/deleteUser/:username", service.HandleAdminDeleteUser)
-			adminApi.POST("/setPermission", service.HandleSetUserPermission)
-			adminApi.POST("/setIcon", service.HandleSetNewIcon)
+			adminApi.GET("/config", service.HandleGetConfigs, service.RequirePermission(model.PermConfigManage))
+			adminApi.POST("/config", service.HandleSetConfig, service.RequirePermission(model.PermConfigManage))
+			adminApi.GET("/listUsers", service.HandleListUsers, service.RequirePermission(model.PermUserManage))
+			adminApi.DELETE("/deleteUser/:username", service.HandleAdminDeleteUser, service.RequirePermission(model.PermUserManage))
+			adminApi.POST("/setPermission", service.HandleSetUserPermission, service.RequirePermission(model.PermRoleManage))
+			adminApi.GET("/roles", service.HandleGetRoles, service.RequirePermission(model.PermRoleManage))
+			adminApi.POST("/setRole", service.HandleSetUserRole, service.RequirePermission(model.PermRoleManage))
+			adminApi.POST("/setIcon", service.HandleSetNewIcon, service.RequirePermission(model.PermConfigManage))
+			adminApi.GET("/audit", service.HandleGetAuditLogs, service.RequirePermission(model.PermAuditView))
+			adminApi.POST("/suspendUser", service.HandleSuspendUser, service.RequirePermission(model.PermUserSuspend))
+			adminApi.POST("/banUser", service.HandleBanUser, service.RequirePermission(model.PermUserManage))
+			adminApi.POST("/restoreUser", service.HandleRestoreUser, service.RequirePermission(model.PermUserManage))
+			adminApi.POST("/site", service.HandleAdminExportSite, service.RequirePermission(model.PermConfigManage))
+			adminApi.GET("/storage", service.HandleAdminStorageReport, service.RequirePermission(model.PermConfigManage))
+			adminApi.GET("/storage/orphans", service.HandleAdminOrphans, service.RequirePermission(model.PermConfigManage))
+			adminApi.POST("/storage/orphans", service.HandleAdminOrphans, service.RequirePermission(model.PermConfigManage))
+			adminApi.POST("/setQuota", service.HandleSetQuota, service.RequirePermission(model.PermUserManage))
+		}
+		reportApi := api.Group("/report")
+		{
+			reportApi.POST("/create", service.HandleReportCreate)
+			reportApi.GET("/mine", service.HandleGetMyReports)
+		}
+		moderationApi := api.Group("/moderation")
+		{
+			moderationApi.GET("/reports", service.HandleGetReports, service.RequirePermission(model.PermReportHandle))
+			moderationApi.POST("/resolve", service.HandleResolveReport, service.RequirePermission(model.PermReportHandle))
 		}
 		captchaApi := api.Group("/captcha")
 		{
@@ -100,10 +177,94 @@ func main() {
 		}
 	}
 
+	e.Any("/git/:username/*", service.HandleGit)
+	e.Match(service.DavMethods, "/dav/:username", service.HandleDav)
+	e.Match(service.DavMethods, "/dav/:username/*", service.HandleDav)
+
+	e.GET("/micropub", service.HandleMicropubQuery)
+	e.POST("/micropub", service.HandleMicropub)
+	e.POST("/webmention", service.HandleWebmention)
+
+	e.GET("/.well-known/webfinger", service.HandleWebFinger, service.RequireFederation)
+	ap := e.Group("/ap", service.RequireFederation)
+	{
+		ap.GET("/users/:username", service.HandleActor)
+		ap.GET("/users/:username/outbox", service.HandleOutbox)
+		ap.GET("/users/:username/followers", service.HandleFollowers)
+		ap.GET("/users/:username/following", service.HandleFollowing)
+		ap.POST("/users/:username/inbox", service.HandleInbox)
+		ap.POST("/inbox", service.HandleInbox)
+		ap.GET("/posts/:id", service.HandleNote)
+	}
+
+	oauth := e.Group("/oauth")
+	{
+		oauth.GET("/authorize", service.HandleAuthorizeForm)
+		oauth.POST("/authorize", service.HandleAuthorize)
+		oauth.POST("/token", service.HandleOAuthToken)
+		oauth.POST("/revoke", service.HandleOAuthRevoke)
+	}
+	v1 := e.Group("/api/v1", service.AccessTokenAuth)
+	{
+		v1.POST("/apps", service.HandleCreateApp)
+		v1.GET("/apps/verify_credentials", service.HandleVerifyAppCredentials, service.RequireScope(model.ScopeRead))
+		v1.GET("/instance", service.HandleMastodonInstance)
+		v1.GET("/custom_emojis", service.HandleMastodonCustomEmojis)
+
+		v1.GET("/accounts/verify_credentials", service.HandleMastodonVerifyCredentials, service.RequireScope("read:accounts"))
+		v1.GET("/accounts/relationships", service.HandleMastodonRelationships, service.RequireScope("read:follows", model.ScopeFollow))
+		v1.GET("/accounts/lookup", service.HandleMastodonLookupAccount)
+		v1.GET("/accounts/:id", service.HandleMastodonGetAccount)
+		v1.GET("/accounts/:id/statuses", service.HandleMastodonAccountStatuses)
+		v1.GET("/accounts/:id/followers", service.HandleMastodonFollowers)
+		v1.GET("/accounts/:id/following", service.HandleMastodonFollowing)
+		v1.POST("/accounts/:id/follow", service.HandleMastodonFollow, service.RequireScope("write:follows", model.ScopeFollow), service.RequirePermission(model.PermUserFollow))
+		v1.POST("/accounts/:id/unfollow", service.HandleMastodonUnfollow, service.RequireScope("write:follows", model.ScopeFollow))
+		v1.GET("/follow_requests", service.HandleMastodonFollowRequests, service.RequireScope("read:follows", model.ScopeFollow))
+		v1.POST("/follow_requests/:id/authorize", service.HandleMastodonAuthorizeFollowRequest, service.RequireScope("write:follows", model.ScopeFollow))
+		v1.POST("/follow_requests/:id/reject", service.HandleMastodonRejectFollowRequest, service.RequireScope("write:follows", model.ScopeFollow))
+
+		v1.POST("/statuses", service.HandleMastodonCreateStatus, service.RequireScope("write:statuses"))
+		v1.GET("/statuses/:id", service.HandleMastodonGetStatus)
+		v1.PUT("/statuses/:id", service.HandleMastodonEditStatus, service.RequireScope("write:statuses"), service.RequirePermission(model.PermPostWrite))
+		v1.DELETE("/statuses/:id", service.HandleMastodonDeleteStatus, service.RequireScope("write:statuses"))
+		v1.GET("/statuses/:id/context", service.HandleMastodonStatusContext)
+		v1.GET("/statuses/:id/source", service.HandleMastodonStatusSource, service.RequireScope("read:statuses"))
+		v1.GET("/statuses/:id/favourited_by", service.HandleMastodonFavouritedBy)
+		v1.POST("/statuses/:id/favourite", service.HandleMastodonFavourite, service.RequireScope("write:favourites"), service.RequirePermission(model.PermPostLike))
+		v1.POST("/statuses/:id/unfavourite", service.HandleMastodonUnfavourite, service.RequireScope("write:favourites"), service.RequirePermission(model.PermPostLike))
+
+		v1.GET("/timelines/home", service.HandleMastodonHomeTimeline, service.RequireScope("read:statuses"))
+		v1.GET("/timelines/public", service.HandleMastodonPublicTimeline)
+		v1.GET("/timelines/tag/:hashtag", service.HandleMastodonTagTimeline)
+		v1.GET("/favourites", service.HandleMastodonFavourites, service.RequireScope("read:favourites"))
+		v1.GET("/notifications", service.HandleMastodonNotifications, service.RequireScope("read:notifications"))
+
+		v1.POST("/media", service.HandleMastodonUploadMedia, service.RequireScope("write:media"), service.RequirePermission(model.PermFileUpload))
+		v1.GET("/media/:id", service.HandleMastodonGetMedia, service.RequireScope("write:media"))
+		v1.PUT("/media/:id", service.HandleMastodonGetMedia, service.RequireScope("write:media"))
+
+		// compatibility with the REST API of Memos
+		v1.GET("/memo", service.HandleMemosListMemos)
+		v1.GET("/memo/all", service.HandleMemosListAllMemos)
+		v1.POST("/memo", service.HandleMemosCreateMemo, service.RequireScope(model.ScopeCreate, "write:statuses"), service.RequirePermission(model.PermPostWrite))
+		v1.GET("/memo/:id", service.HandleMemosGetMemo)
+		v1.PATCH("/memo/:id", service.HandleMemosPatchMemo, service.RequireScope(model.ScopeUpdate, "write:statuses"), service.RequirePermission(model.PermPostWrite))
+		v1.DELETE("/memo/:id", service.HandleMemosDeleteMemo, service.RequireScope(model.ScopeDelete, "write:statuses"), service.RequirePermission(model.PermPostWrite))
+		v1.GET("/resource", service.HandleMemosListResources)
+		v1.POST("/resource/blob", service.HandleMemosUploadResource, service.RequireScope(model.ScopeCreate, "write:media"), service.RequirePermission(model.PermFileUpload))
+		v1.DELETE("/resource/:id", service.HandleMemosDeleteResource, service.RequireScope(model.ScopeDelete, "write:media"))
+		v1.GET("/tag", service.HandleMemosListTags)
+		v1.POST("/tag", service.HandleMemosCreateTag, service.RequireScope(model.ScopeCreate, "write:statuses"))
+		v1.GET("/user/me", service.HandleMemosGetMe)
+	}
+	e.POST("/api/v2/media", service.HandleMastodonUploadMedia, service.AccessTokenAuth, service.RequireScope("write:media"), service.RequirePermission(model.PermFileUpload))
+
 	public := e.Group("/public")
 	{
 		public.GET("/article/:id", service.HandlePublicArticle)
 	}
 
+	service.StartOrphanCollection()
 	e.Logger.Fatal(e.Start(fmt.Sprintf("0.0.0.0:1323")))
 }
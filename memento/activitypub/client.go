@@ -0,0 +1,102 @@
+package activitypub
+
+import (
+	"Memento/memento/netguard"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxResponseSize limits the documents fetched from remote servers.
+const maxResponseSize = 1 << 20
+
+// Client performs the HTTP requests to remote servers. It is an interface so
+// that a fake remote instance can be plugged in.
+type Client interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// DefaultClient only connects to public addresses, as the urls of actors and
+// inboxes come from remote servers.
+var DefaultClient Client = netguard.NewClient(10*time.Second, true)
+
+// FetchActor loads the actor document with the given id. The request is
+// signed when a signer is given, which servers in secure mode require.
+func FetchActor(client Client, signer *Signer, id string) (*Actor, error) {
+	if err := netguard.CheckURL(id, true); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ContentType+", "+LdContentType)
+	if signer != nil {
+		if err := signer.Sign(req, nil); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching actor %s: status %d", id, resp.StatusCode)
+	}
+	var actor Actor
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&actor); err != nil {
+		return nil, err
+	}
+	if actor.ID == "" || actor.Inbox == "" {
+		return nil, errors.New("invalid actor document")
+	}
+	return &actor, nil
+}
+
+// Deliver posts a signed activity to a remote inbox.
+func Deliver(client Client, signer *Signer, inbox string, activity interface{}) error {
+	if err := netguard.CheckURL(inbox, true); err != nil {
+		return err
+	}
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if err := signer.Sign(req, body); err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("delivering to %s: status %d", inbox, resp.StatusCode)
+	}
+	return nil
+}
+
+// KeyOwner strips the fragment from a key id, which yields the actor id for
+// the key ids used by Mastodon and Memento.
+func KeyOwner(keyID string) string {
+	if i := strings.Index(keyID, "#"); i >= 0 {
+		return keyID[:i]
+	}
+	return keyID
+}
@@ -0,0 +1,71 @@
+package activitypub
+
+import (
+	"Memento/memento/netguard"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeClient serves an actor document for every request it is given.
+type fakeClient struct {
+	requests []string
+}
+
+func (f *fakeClient) Do(req *http.Request) (*http.Response, error) {
+	f.requests = append(f.requests, req.URL.String())
+	body := `{"id":"` + req.URL.String() + `","inbox":"` + req.URL.String() + `/inbox"}`
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {ContentType}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestFetchActorRefusesPrivateKeyIDs(t *testing.T) {
+	for _, keyID := range []string{
+		"https://127.0.0.1/users/alice#main-key",
+		"https://169.254.169.254/latest/meta-data#main-key",
+		"https://[::1]/users/alice#main-key",
+		"https://localhost/users/alice#main-key",
+		"https://10.0.0.5:8080/users/alice#main-key",
+		"http://example.com/users/alice#main-key",
+		"file:///etc/passwd#main-key",
+	} {
+		client := &fakeClient{}
+		_, err := FetchActor(client, nil, KeyOwner(keyID))
+		if err == nil {
+			t.Errorf("key id %s was fetched", keyID)
+		} else if !errors.Is(err, netguard.ErrAddress) && !errors.Is(err, netguard.ErrScheme) {
+			t.Errorf("key id %s: %v", keyID, err)
+		}
+		if len(client.requests) > 0 {
+			t.Errorf("key id %s: requested %v", keyID, client.requests)
+		}
+	}
+}
+
+func TestFetchActorPublicKeyID(t *testing.T) {
+	client := &fakeClient{}
+	actor, err := FetchActor(client, nil, KeyOwner("https://example.com/users/alice#main-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actor.ID != "https://example.com/users/alice" || len(client.requests) != 1 {
+		t.Errorf("got actor %q after requests %v", actor.ID, client.requests)
+	}
+}
+
+func TestDeliverRefusesPrivateInboxes(t *testing.T) {
+	client := &fakeClient{}
+	err := Deliver(client, nil, "https://192.168.0.1/inbox", map[string]string{"type": "Follow"})
+	if !errors.Is(err, netguard.ErrAddress) {
+		t.Errorf("got %v, want %v", err, netguard.ErrAddress)
+	}
+	if len(client.requests) > 0 {
+		t.Errorf("requested %v", client.requests)
+	}
+}
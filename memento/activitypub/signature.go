@@ -0,0 +1,194 @@
+package activitypub
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxClockSkew is how far the Date header of a signed request may drift.
+const maxClockSkew = 12 * time.Hour
+
+// Signer signs outgoing requests on behalf of an actor.
+type Signer struct {
+	KeyID string
+	Key   *rsa.PrivateKey
+}
+
+// GenerateKeyPair creates a new RSA key pair encoded as PEM blocks.
+func GenerateKeyPair() (privatePem string, publicPem string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+	privateDer := x509.MarshalPKCS1PrivateKey(key)
+	publicDer, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	privatePem = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: privateDer}))
+	publicPem = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer}))
+	return privatePem, publicPem, nil
+}
+
+func ParsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return rsaKey, nil
+}
+
+func ParsePublicKey(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid public key")
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("unsupported public key type")
+		}
+		return rsaKey, nil
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+// Digest returns the value of the Digest header for a request body.
+func Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Sign adds Date, Digest and Signature headers to the request following the
+// draft-cavage-http-signatures scheme used by Mastodon.
+func (s *Signer) Sign(req *http.Request, body []byte) error {
+	if req.Header.Get("Date") == "" {
+		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	if req.Host == "" {
+		req.Host = req.URL.Host
+	}
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		req.Header.Set("Digest", Digest(body))
+		headers = append(headers, "digest")
+	}
+	signingString := buildSigningString(req, headers)
+	hash := sha256.Sum256([]byte(signingString))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA256, hash[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signature", `keyId="`+s.KeyID+`",algorithm="rsa-sha256",headers="`+
+		strings.Join(headers, " ")+`",signature="`+base64.StdEncoding.EncodeToString(signature)+`"`)
+	return nil
+}
+
+// KeyResolver returns the public key of the given key id.
+type KeyResolver func(keyID string) (*rsa.PublicKey, error)
+
+// Verify checks the signature of an incoming request and returns the key id
+// which signed it.
+func Verify(req *http.Request, body []byte, resolve KeyResolver) (string, error) {
+	params := parseSignatureHeader(req.Header.Get("Signature"))
+	keyID := params["keyId"]
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if keyID == "" || err != nil {
+		return "", errors.New("missing or malformed signature")
+	}
+	if alg := params["algorithm"]; alg != "" && alg != "rsa-sha256" && alg != "hs2019" {
+		return "", errors.New("unsupported signature algorithm")
+	}
+	headers := strings.Fields(strings.ToLower(params["headers"]))
+	if len(headers) == 0 {
+		headers = []string{"date"}
+	}
+	if !containsHeader(headers, "(request-target)") || !containsHeader(headers, "date") {
+		return "", errors.New("signature must cover (request-target) and date")
+	}
+	date, err := http.ParseTime(req.Header.Get("Date"))
+	if err != nil || time.Since(date) > maxClockSkew || time.Until(date) > maxClockSkew {
+		return "", errors.New("request date out of range")
+	}
+	if body != nil && len(body) > 0 {
+		if !containsHeader(headers, "digest") {
+			return "", errors.New("signature must cover digest")
+		}
+		if req.Header.Get("Digest") != Digest(body) {
+			return "", errors.New("digest mismatch")
+		}
+	}
+	key, err := resolve(keyID)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(buildSigningString(req, headers)))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+		return "", errors.New("invalid signature")
+	}
+	return keyID, nil
+}
+
+func buildSigningString(req *http.Request, headers []string) string {
+	var b bytes.Buffer
+	for i, h := range headers {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		switch h {
+		case "(request-target)":
+			b.WriteString("(request-target): " + strings.ToLower(req.Method) + " " + req.URL.RequestURI())
+		case "host":
+			host := req.Host
+			if host == "" {
+				host = req.URL.Host
+			}
+			b.WriteString("host: " + host)
+		default:
+			b.WriteString(h + ": " + strings.Join(req.Header.Values(h), ", "))
+		}
+	}
+	return b.String()
+}
+
+func parseSignatureHeader(header string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		params[key] = strings.Trim(value, `"`)
+	}
+	return params
+}
+
+func containsHeader(headers []string, name string) bool {
+	for _, h := range headers {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package activitypub
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newSigner(t *testing.T, keyID string) (*Signer, *rsa.PublicKey) {
+	t.Helper()
+	privatePem, publicPem, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePrivateKey(privatePem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ParsePublicKey(publicPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Signer{KeyID: keyID, Key: key}, publicKey
+}
+
+func TestSignVerify(t *testing.T) {
+	const keyID = "https://example.com/users/alice#main-key"
+	signer, publicKey := newSigner(t, keyID)
+	_, otherKey := newSigner(t, keyID)
+
+	// the server checks the signature with the key it is given
+	var serverKey *rsa.PublicKey
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signedBy, err := Verify(r, body, func(id string) (*rsa.PublicKey, error) {
+			if id != keyID {
+				return nil, errors.New("unknown key " + id)
+			}
+			return serverKey, nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.WriteString(w, signedBy)
+	}))
+	defer server.Close()
+
+	send := func(body []byte, sent []byte) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/users/bob/inbox?page=1", bytes.NewReader(sent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := signer.Sign(req, body); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	body := []byte(`{"type":"Follow"}`)
+	tests := []struct {
+		name   string
+		key    *rsa.PublicKey
+		sent   []byte
+		status int
+	}{
+		{"signed body", publicKey, body, http.StatusOK},
+		{"other key", otherKey, body, http.StatusUnauthorized},
+		{"changed body", publicKey, []byte(`{"type":"Block"}`), http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		serverKey = test.key
+		status, text := send(body, test.sent)
+		if status != test.status {
+			t.Errorf("%s: status %d (%s), want %d", test.name, status, text, test.status)
+		} else if status == http.StatusOK && text != keyID {
+			t.Errorf("%s: signed by %q", test.name, text)
+		}
+	}
+}
+
+func TestVerifyNeedsDate(t *testing.T) {
+	signer, publicKey := newSigner(t, "https://example.com/users/alice#main-key")
+	req := httptest.NewRequest(http.MethodPost, "https://example.org/inbox", nil)
+	req.Header.Set("Date", "Mon, 02 Jan 2006 15:04:05 GMT")
+	if err := signer.Sign(req, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Verify(req, []byte("{}"), func(string) (*rsa.PublicKey, error) { return publicKey, nil })
+	if err == nil {
+		t.Error("a request signed long ago was accepted")
+	}
+}
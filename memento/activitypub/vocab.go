@@ -0,0 +1,181 @@
+package activitypub
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	ContentType        = "application/activity+json"
+	LdContentType      = `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+	JrdContentType     = "application/jrd+json"
+	ActivityStreams    = "https://www.w3.org/ns/activitystreams"
+	SecurityContext    = "https://w3id.org/security/v1"
+	PublicCollection   = "https://www.w3.org/ns/activitystreams#Public"
+	ActivityFollow     = "Follow"
+	ActivityAccept     = "Accept"
+	ActivityUndo       = "Undo"
+	ActivityLike       = "Like"
+	ActivityCreate     = "Create"
+	ActivityUpdate     = "Update"
+	ActivityDelete     = "Delete"
+	ObjectNote         = "Note"
+	ObjectPerson       = "Person"
+	ObjectTombstone    = "Tombstone"
+	ObjectHashtag      = "Hashtag"
+	CollectionOrdered  = "OrderedCollection"
+	CollectionPageType = "OrderedCollectionPage"
+)
+
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+type Image struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType,omitempty"`
+	URL       string `json:"url"`
+}
+
+type Endpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+type Actor struct {
+	Context                   interface{} `json:"@context,omitempty"`
+	ID                        string      `json:"id"`
+	Type                      string      `json:"type"`
+	PreferredUsername         string      `json:"preferredUsername"`
+	Name                      string      `json:"name,omitempty"`
+	Summary                   string      `json:"summary,omitempty"`
+	URL                       string      `json:"url,omitempty"`
+	Inbox                     string      `json:"inbox"`
+	Outbox                    string      `json:"outbox,omitempty"`
+	Followers                 string      `json:"followers,omitempty"`
+	Following                 string      `json:"following,omitempty"`
+	ManuallyApprovesFollowers bool        `json:"manuallyApprovesFollowers"`
+	Published                 *time.Time  `json:"published,omitempty"`
+	Icon                      *Image      `json:"icon,omitempty"`
+	Endpoints                 *Endpoints  `json:"endpoints,omitempty"`
+	PublicKey                 PublicKey   `json:"publicKey"`
+}
+
+type Tag struct {
+	Type string `json:"type"`
+	Href string `json:"href"`
+	Name string `json:"name"`
+}
+
+type Note struct {
+	Context      interface{} `json:"@context,omitempty"`
+	ID           string      `json:"id"`
+	Type         string      `json:"type"`
+	AttributedTo string      `json:"attributedTo"`
+	InReplyTo    string      `json:"inReplyTo,omitempty"`
+	Content      string      `json:"content"`
+	URL          string      `json:"url,omitempty"`
+	Published    *time.Time  `json:"published,omitempty"`
+	Updated      *time.Time  `json:"updated,omitempty"`
+	To           []string    `json:"to,omitempty"`
+	Cc           []string    `json:"cc,omitempty"`
+	Tag          []Tag       `json:"tag,omitempty"`
+}
+
+// Activity is an outgoing activity. Object is either an id or an embedded object.
+type Activity struct {
+	Context   interface{} `json:"@context,omitempty"`
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Actor     string      `json:"actor"`
+	Object    interface{} `json:"object"`
+	Published *time.Time  `json:"published,omitempty"`
+	To        []string    `json:"to,omitempty"`
+	Cc        []string    `json:"cc,omitempty"`
+}
+
+// IncomingActivity is an activity received in an inbox. The object is kept
+// raw because remote servers send either an id or an embedded object.
+type IncomingActivity struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// ObjectID returns the id of the activity object, whether it was embedded or not.
+func (a *IncomingActivity) ObjectID() string {
+	var id string
+	if err := json.Unmarshal(a.Object, &id); err == nil {
+		return id
+	}
+	var object struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(a.Object, &object); err == nil {
+		return object.ID
+	}
+	return ""
+}
+
+// ObjectType returns the type of an embedded object, or an empty string if
+// the object is only referenced by id.
+func (a *IncomingActivity) ObjectType() string {
+	var object struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(a.Object, &object); err == nil {
+		return object.Type
+	}
+	return ""
+}
+
+// NestedActivity decodes an embedded activity, as found in Undo.
+func (a *IncomingActivity) NestedActivity() (*IncomingActivity, error) {
+	var nested IncomingActivity
+	if err := json.Unmarshal(a.Object, &nested); err != nil {
+		return nil, err
+	}
+	return &nested, nil
+}
+
+// IncomingNote is a Note received from a remote server.
+type IncomingNote struct {
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	AttributedTo string    `json:"attributedTo"`
+	InReplyTo    string    `json:"inReplyTo"`
+	Content      string    `json:"content"`
+	Published    time.Time `json:"published"`
+}
+
+type OrderedCollection struct {
+	Context    interface{} `json:"@context,omitempty"`
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	TotalItems int64       `json:"totalItems"`
+	First      string      `json:"first,omitempty"`
+}
+
+type OrderedCollectionPage struct {
+	Context      interface{}   `json:"@context,omitempty"`
+	ID           string        `json:"id"`
+	Type         string        `json:"type"`
+	PartOf       string        `json:"partOf,omitempty"`
+	Next         string        `json:"next,omitempty"`
+	TotalItems   int64         `json:"totalItems"`
+	OrderedItems []interface{} `json:"orderedItems"`
+}
+
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href,omitempty"`
+}
+
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases,omitempty"`
+	Links   []WebFingerLink `json:"links"`
+}
@@ -0,0 +1,223 @@
+// Package audio reads the metadata of uploaded audio files: their duration,
+// codec and format. It only parses the containers, in pure Go, and never
+// decodes the audio itself.
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// ErrUnsupported is returned for formats which cannot be inspected.
+	ErrUnsupported = errors.New("unsupported audio type")
+	// ErrInvalid is returned for damaged files.
+	ErrInvalid = errors.New("invalid audio file")
+)
+
+// Info describes an audio file.
+type Info struct {
+	Duration time.Duration
+	// Codec is the encoding of the audio, like "aac", "mp3", "opus" or
+	// "pcm".
+	Codec      string
+	SampleRate int
+	Channels   int
+	// Bitrate is the average bitrate in bits per second.
+	Bitrate int
+}
+
+// CanInspect reports whether Inspect reads files of a MIME type.
+func CanInspect(mimeType string) bool {
+	switch mimeType {
+	case "audio/wav", "audio/mpeg", "audio/ogg", "audio/mp4", "audio/flac":
+		return true
+	}
+	return false
+}
+
+// Inspect reads the metadata of an audio file of a MIME type, which is size
+// bytes long.
+func Inspect(r io.ReaderAt, size int64, mimeType string) (Info, error) {
+	var info Info
+	var err error
+	switch mimeType {
+	case "audio/wav":
+		info, err = inspectWAV(r, size)
+	case "audio/mpeg":
+		info, err = inspectMPEG(r, size)
+	case "audio/ogg":
+		info, err = inspectOgg(r, size)
+	case "audio/mp4":
+		info, err = inspectMP4(r, size)
+	case "audio/flac":
+		info, err = inspectFLAC(r, size)
+	default:
+		return Info{}, ErrUnsupported
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	if info.Bitrate == 0 && info.Duration > 0 {
+		info.Bitrate = int(float64(size*8) / info.Duration.Seconds())
+	}
+	return info, nil
+}
+
+// DetectType detects the audio types the standard library does not know
+// from the first bytes of a file. It returns an empty string for other
+// content.
+func DetectType(head []byte) string {
+	switch {
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		switch string(head[8:12]) {
+		case "M4A ", "M4B ", "M4P ", "F4A ":
+			return "audio/mp4"
+		}
+	case bytes.HasPrefix(head, []byte("RIFF")) && len(head) >= 12 && string(head[8:12]) == "WAVE":
+		return "audio/wav"
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(head, []byte("OggS")) && len(head) >= 27:
+		packet := head[min(27+int(head[26]), len(head)):]
+		for _, magic := range []string{"\x01vorbis", "OpusHead", "\x7fFLAC", "Speex   "} {
+			if bytes.HasPrefix(packet, []byte(magic)) {
+				return "audio/ogg"
+			}
+		}
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return "audio/mpeg"
+	}
+	if _, ok := parseFrameHeader(head); ok {
+		return "audio/mpeg"
+	}
+	return ""
+}
+
+// Transcriber turns recorded speech into text, e.g. by calling a speech
+// recognition service.
+type Transcriber interface {
+	// Transcribe returns the text spoken in audio of a MIME type.
+	Transcribe(ctx context.Context, audio io.Reader, mimeType string) (string, error)
+}
+
+var transcriber Transcriber
+
+// SetTranscriber sets the Transcriber new audio files are transcribed with.
+// None is set by default.
+func SetTranscriber(t Transcriber) {
+	transcriber = t
+}
+
+// DefaultTranscriber returns the Transcriber set with SetTranscriber, or nil.
+func DefaultTranscriber() Transcriber {
+	return transcriber
+}
+
+// readAt reads n bytes at an offset, failing with ErrInvalid past the end.
+func readAt(r io.ReaderAt, offset int64, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrInvalid
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// seconds converts a number of samples at a rate into a duration.
+func seconds(samples uint64, rate uint64) time.Duration {
+	if rate == 0 {
+		return 0
+	}
+	return time.Duration(float64(samples) / float64(rate) * float64(time.Second))
+}
+
+var wavCodecs = map[uint16]string{
+	0x0001: "pcm",
+	0x0003: "pcm_float",
+	0x0006: "alaw",
+	0x0007: "mulaw",
+	0x0011: "adpcm",
+	0x0055: "mp3",
+}
+
+func inspectWAV(r io.ReaderAt, size int64) (Info, error) {
+	var info Info
+	var byteRate uint32
+	for offset := int64(12); offset+8 <= size; {
+		header, err := readAt(r, offset, 8)
+		if err != nil {
+			return Info{}, err
+		}
+		length := int64(binary.LittleEndian.Uint32(header[4:]))
+		switch string(header[:4]) {
+		case "fmt ":
+			if length < 16 {
+				return Info{}, ErrInvalid
+			}
+			fmt, err := readAt(r, offset+8, int(min(length, 40)))
+			if err != nil {
+				return Info{}, err
+			}
+			format := binary.LittleEndian.Uint16(fmt)
+			if format == 0xFFFE && len(fmt) >= 26 {
+				// WAVE_FORMAT_EXTENSIBLE names the format in its sub format
+				format = binary.LittleEndian.Uint16(fmt[24:])
+			}
+			info.Codec = wavCodecs[format]
+			if info.Codec == "" {
+				info.Codec = "wav"
+			}
+			info.Channels = int(binary.LittleEndian.Uint16(fmt[2:]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(fmt[4:]))
+			byteRate = binary.LittleEndian.Uint32(fmt[8:])
+			info.Bitrate = int(byteRate) * 8
+		case "data":
+			if byteRate == 0 {
+				return Info{}, ErrInvalid
+			}
+			if length > size-offset-8 {
+				// streaming recorders leave the length unset
+				length = size - offset - 8
+			}
+			info.Duration = seconds(uint64(length), uint64(byteRate))
+			return info, nil
+		}
+		// chunks are padded to an even length
+		offset += 8 + length + length%2
+	}
+	return Info{}, ErrInvalid
+}
+
+func inspectFLAC(r io.ReaderAt, size int64) (Info, error) {
+	// the STREAMINFO block always comes first
+	header, err := readAt(r, 0, 8+34)
+	if err != nil {
+		return Info{}, err
+	}
+	if string(header[:4]) != "fLaC" || header[4]&0x7F != 0 {
+		return Info{}, ErrInvalid
+	}
+	return flacStreamInfo(header[8:])
+}
+
+// flacStreamInfo reads a STREAMINFO metadata block of FLAC.
+func flacStreamInfo(block []byte) (Info, error) {
+	if len(block) < 18 {
+		return Info{}, ErrInvalid
+	}
+	rate := uint64(block[10])<<12 | uint64(block[11])<<4 | uint64(block[12])>>4
+	samples := uint64(block[13]&0x0F)<<32 | uint64(binary.BigEndian.Uint32(block[14:]))
+	return Info{
+		Duration:   seconds(samples, rate),
+		Codec:      "flac",
+		SampleRate: int(rate),
+		Channels:   int(block[12]>>1&0x07) + 1,
+	}, nil
+}
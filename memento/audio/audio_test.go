@@ -0,0 +1,208 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func le16(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+func le32(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+func be16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func be32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// wavFile is 2.5 seconds of 16 kHz mono PCM.
+func wavFile() []byte {
+	data := make([]byte, 80000)
+	format := concat(le16(1), le16(1), le32(16000), le32(32000), le16(2), le16(16))
+	body := concat([]byte("WAVE"),
+		[]byte("fmt "), le32(uint32(len(format))), format,
+		[]byte("data"), le32(uint32(len(data))), data)
+	return concat([]byte("RIFF"), le32(uint32(len(body))), body)
+}
+
+// mp3File is an ID3 tag and about 3 seconds of 128 kbit/s frames at 44.1
+// kHz.
+func mp3File() []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	id3 := concat([]byte("ID3\x03\x00\x00"), []byte{0, 0, 0, 20}, make([]byte, 20))
+	return concat(id3, bytes.Repeat(frame, 114))
+}
+
+// oggPageBytes builds an Ogg page with a single packet.
+func oggPageBytes(granule uint64, serial uint32, seq uint32, packet []byte, flags byte) []byte {
+	var segments []byte
+	for n := len(packet); ; n -= 255 {
+		if n < 255 {
+			segments = append(segments, byte(n))
+			break
+		}
+		segments = append(segments, 255)
+	}
+	header := concat([]byte("OggS"), []byte{0, flags},
+		binary.LittleEndian.AppendUint64(nil, granule), le32(serial), le32(seq), le32(0),
+		[]byte{byte(len(segments))}, segments)
+	return concat(header, packet)
+}
+
+// opusFile is 4 seconds of Opus.
+func opusFile() []byte {
+	head := concat([]byte("OpusHead"), []byte{1, 1}, le16(312), le32(16000), le16(0), []byte{0})
+	return concat(
+		oggPageBytes(0, 77, 0, head, 2),
+		oggPageBytes(0, 77, 1, concat([]byte("OpusTags"), make([]byte, 8)), 0),
+		oggPageBytes(48000*4+312, 77, 2, make([]byte, 100), 4))
+}
+
+// vorbisFile is 7 seconds of Vorbis.
+func vorbisFile() []byte {
+	head := concat([]byte("\x01vorbis"), le32(0), []byte{2}, le32(44100),
+		le32(0), le32(128000), le32(0), []byte{0xb8, 1})
+	return concat(oggPageBytes(0, 5, 0, head, 2), oggPageBytes(44100*7, 5, 1, make([]byte, 50), 4))
+}
+
+// flacFile is 10 seconds of 48 kHz stereo.
+func flacFile() []byte {
+	info := concat(be16(4096), be16(4096), make([]byte, 6),
+		binary.BigEndian.AppendUint64(nil, 48000<<44|1<<41|15<<36|480000), make([]byte, 16))
+	return concat([]byte("fLaC"), []byte{0x80, 0, 0, 34}, info, make([]byte, 100))
+}
+
+func mp4Box(kind string, payload ...[]byte) []byte {
+	content := concat(payload...)
+	return concat(be32(uint32(8+len(content))), []byte(kind), content)
+}
+
+// m4aFile is 12 seconds of stereo AAC at 44.1 kHz, in a movie with the
+// handlers given.
+func m4aFile(brand string, handlers ...string) []byte {
+	var traks [][]byte
+	for _, handler := range handlers {
+		entry := mp4Box("mp4a", make([]byte, 6), be16(1), make([]byte, 8),
+			be16(2), be16(16), be16(0), be16(0), be32(44100<<16), mp4Box("esds", make([]byte, 20)))
+		stbl := mp4Box("stbl", mp4Box("stsd", make([]byte, 4), be32(1), entry))
+		mdia := mp4Box("mdia",
+			mp4Box("mdhd", make([]byte, 4), be32(0), be32(0), be32(44100), be32(44100*12), make([]byte, 4)),
+			mp4Box("hdlr", make([]byte, 8), []byte(handler), make([]byte, 12), []byte("Sound\x00")),
+			mp4Box("minf", mp4Box("smhd", make([]byte, 8)), stbl))
+		traks = append(traks, mp4Box("trak", mp4Box("tkhd", make([]byte, 84)), mdia))
+	}
+	moov := mp4Box("moov", append([][]byte{mp4Box("mvhd", make([]byte, 100))}, traks...)...)
+	return concat(mp4Box("ftyp", []byte(brand), make([]byte, 4), []byte(brand)), mp4Box("mdat", make([]byte, 1000)), moov)
+}
+
+var samples = []struct {
+	name     string
+	data     []byte
+	mimeType string
+	codec    string
+	duration time.Duration
+}{
+	{"wav", wavFile(), "audio/wav", "pcm", 2500 * time.Millisecond},
+	{"mp3", mp3File(), "audio/mpeg", "mp3", 2971 * time.Millisecond},
+	{"opus", opusFile(), "audio/ogg", "opus", 4 * time.Second},
+	{"vorbis", vorbisFile(), "audio/ogg", "vorbis", 7 * time.Second},
+	{"flac", flacFile(), "audio/flac", "flac", 10 * time.Second},
+	{"m4a", m4aFile("M4A ", "soun"), "audio/mp4", "aac", 12 * time.Second},
+}
+
+func TestInspect(t *testing.T) {
+	for _, s := range samples {
+		if got := DetectType(s.data[:min(512, len(s.data))]); got != s.mimeType {
+			t.Errorf("%s: detected %q, want %q", s.name, got, s.mimeType)
+		}
+		info, err := Inspect(bytes.NewReader(s.data), int64(len(s.data)), s.mimeType)
+		if err != nil {
+			t.Errorf("%s: %v", s.name, err)
+			continue
+		}
+		if info.Codec != s.codec || info.Duration.Round(time.Millisecond) != s.duration {
+			t.Errorf("%s: got %s for %v, want %s for %v", s.name, info.Codec, info.Duration, s.codec, s.duration)
+		}
+	}
+}
+
+func TestInspectMovie(t *testing.T) {
+	movie := m4aFile("isom", "vide", "soun")
+	if _, err := Inspect(bytes.NewReader(movie), int64(len(movie)), "audio/mp4"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("movie: got %v, want %v", err, ErrUnsupported)
+	}
+	sound := m4aFile("isom", "soun")
+	if _, err := Inspect(bytes.NewReader(sound), int64(len(sound)), "audio/mp4"); err != nil {
+		t.Errorf("m4a with the brand of movies: %v", err)
+	}
+}
+
+func TestInspectEmptyMediaHeader(t *testing.T) {
+	data := m4aFile("M4A ", "soun")
+	mdhd := bytes.Index(data, []byte("mdhd")) - 4
+	// an empty mdhd box, with the following boxes moved up
+	broken := concat(data[:mdhd], mp4Box("mdhd"), data[mdhd+32:])
+	for _, kind := range []string{"mdia", "trak", "moov"} {
+		i := bytes.Index(broken, []byte(kind)) - 4
+		binary.BigEndian.PutUint32(broken[i:], binary.BigEndian.Uint32(broken[i:])-24)
+	}
+	if _, err := Inspect(bytes.NewReader(broken), int64(len(broken)), "audio/mp4"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("got %v, want %v", err, ErrInvalid)
+	}
+}
+
+// TestInspectTruncated inspects every prefix of the samples, which must fail
+// or succeed without panicking.
+func TestInspectTruncated(t *testing.T) {
+	for _, s := range samples {
+		for n := 0; n < len(s.data); n++ {
+			data := s.data[:n]
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("%s truncated to %d bytes: %v", s.name, n, r)
+					}
+				}()
+				DetectType(data)
+				_, _ = Inspect(bytes.NewReader(data), int64(len(data)), s.mimeType)
+			}()
+		}
+	}
+}
+
+// TestInspectShrunkBoxes shrinks each box of an m4a file, so its content is
+// cut short while the file goes on.
+func TestInspectShrunkBoxes(t *testing.T) {
+	data := m4aFile("M4A ", "soun")
+	for _, kind := range []string{"moov", "mvhd", "trak", "mdia", "mdhd", "hdlr", "minf", "stbl", "stsd", "mp4a"} {
+		i := bytes.Index(data, []byte(kind)) - 4
+		length := binary.BigEndian.Uint32(data[i:])
+		for size := uint32(0); size < length; size++ {
+			broken := bytes.Clone(data)
+			binary.BigEndian.PutUint32(broken[i:], size)
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("%s box of %d bytes: %v", kind, size, r)
+					}
+				}()
+				_, _ = Inspect(bytes.NewReader(broken), int64(len(broken)), "audio/mp4")
+			}()
+		}
+	}
+}
+
+func FuzzInspect(f *testing.F) {
+	for _, s := range samples {
+		f.Add(s.data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		detected := DetectType(data[:min(512, len(data))])
+		for _, mimeType := range []string{detected, "audio/wav", "audio/mpeg", "audio/ogg", "audio/mp4", "audio/flac"} {
+			_, _ = Inspect(bytes.NewReader(data), int64(len(data)), mimeType)
+		}
+	})
+}
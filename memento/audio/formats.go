@@ -0,0 +1,416 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// MPEG audio
+
+var (
+	// mpegBitrates are the bitrates in kbit/s by version, layer and index.
+	mpegBitrates = [2][3][15]int{
+		// MPEG-1
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+		// MPEG-2 and 2.5
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+	}
+	mpegSampleRates = [3]int{44100, 48000, 32000}
+)
+
+// frameHeader is the header of an MPEG audio frame.
+type frameHeader struct {
+	// version is 1 for MPEG-1, 2 for MPEG-2 and 3 for MPEG-2.5.
+	version    int
+	layer      int
+	bitrate    int
+	sampleRate int
+	channels   int
+	padding    int
+}
+
+// parseFrameHeader parses the 4 byte header of an MPEG audio frame.
+func parseFrameHeader(b []byte) (frameHeader, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return frameHeader{}, false
+	}
+	var h frameHeader
+	switch b[1] >> 3 & 0x03 {
+	case 3:
+		h.version = 1
+	case 2:
+		h.version = 2
+	case 0:
+		h.version = 3
+	default:
+		return frameHeader{}, false
+	}
+	h.layer = 4 - int(b[1]>>1&0x03)
+	index, rate := int(b[2]>>4), int(b[2]>>2&0x03)
+	if h.layer == 4 || index == 0 || index == 15 || rate == 3 {
+		return frameHeader{}, false
+	}
+	table := 0
+	if h.version > 1 {
+		table = 1
+	}
+	h.bitrate = mpegBitrates[table][h.layer-1][index] * 1000
+	h.sampleRate = mpegSampleRates[rate] >> (h.version - 1)
+	h.padding = int(b[2] >> 1 & 0x01)
+	h.channels = 2
+	if b[3]>>6 == 3 {
+		h.channels = 1
+	}
+	return h, true
+}
+
+// samples returns the number of samples per channel in a frame.
+func (h frameHeader) samples() int {
+	switch {
+	case h.layer == 1:
+		return 384
+	case h.layer == 3 && h.version > 1:
+		return 576
+	}
+	return 1152
+}
+
+// length returns the length of the frame in bytes.
+func (h frameHeader) length() int {
+	if h.layer == 1 {
+		return (12*h.bitrate/h.sampleRate + h.padding) * 4
+	}
+	return h.samples()/8*h.bitrate/h.sampleRate + h.padding
+}
+
+// sideInfo returns the length of the side information of layer III frames,
+// which precedes the Xing header.
+func (h frameHeader) sideInfo() int {
+	switch {
+	case h.version == 1 && h.channels == 2:
+		return 32
+	case h.version == 1, h.channels == 2:
+		return 17
+	}
+	return 9
+}
+
+func inspectMPEG(r io.ReaderAt, size int64) (Info, error) {
+	start := int64(0)
+	if id3, err := readAt(r, 0, 10); err == nil && string(id3[:3]) == "ID3" {
+		// the size of an ID3v2 tag is stored in 7 bits per byte
+		start = 10 + (int64(id3[6]&0x7F)<<21 | int64(id3[7]&0x7F)<<14 | int64(id3[8]&0x7F)<<7 | int64(id3[9]&0x7F))
+		if id3[5]&0x10 != 0 {
+			start += 10
+		}
+	}
+	end := size
+	if tag, err := readAt(r, size-128, 3); err == nil && string(tag) == "TAG" {
+		end -= 128
+	}
+	// the first frame follows the tag, maybe after some padding
+	buf := make([]byte, min(64<<10, max(end-start, 0)))
+	n, err := r.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return Info{}, err
+	}
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		h, ok := parseFrameHeader(buf[i:])
+		if !ok {
+			continue
+		}
+		// a second frame must follow, unless the file ends
+		next := i + h.length()
+		if next+4 <= len(buf) {
+			if _, ok := parseFrameHeader(buf[next:]); !ok {
+				continue
+			}
+		}
+		info := Info{
+			Codec:      "mp" + string(rune('0'+h.layer)),
+			SampleRate: h.sampleRate,
+			Channels:   h.channels,
+			Bitrate:    h.bitrate,
+		}
+		if frames := vbrFrames(buf[i:], h); frames > 0 {
+			info.Duration = seconds(uint64(frames)*uint64(h.samples()), uint64(h.sampleRate))
+			info.Bitrate = 0
+		} else {
+			info.Duration = seconds(uint64(end-start-int64(i))*8, uint64(h.bitrate))
+		}
+		return info, nil
+	}
+	return Info{}, ErrInvalid
+}
+
+// vbrFrames returns the number of frames from the Xing or VBRI header in the
+// first frame of files with a variable bitrate, or 0.
+func vbrFrames(frame []byte, h frameHeader) uint32 {
+	if xing := 4 + h.sideInfo(); len(frame) >= xing+12 {
+		tag := string(frame[xing : xing+4])
+		flags := binary.BigEndian.Uint32(frame[xing+4:])
+		if (tag == "Xing" || tag == "Info") && flags&0x01 != 0 {
+			return binary.BigEndian.Uint32(frame[xing+8:])
+		}
+	}
+	if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+		return binary.BigEndian.Uint32(frame[36+14:])
+	}
+	return 0
+}
+
+// Ogg
+
+// oggPage reads the header of the Ogg page at an offset and returns its
+// granule position, serial number and the offset of its data.
+func oggPage(r io.ReaderAt, offset int64) (granule int64, serial uint32, data int64, err error) {
+	header, err := readAt(r, offset, 27)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if string(header[:4]) != "OggS" {
+		return 0, 0, 0, ErrInvalid
+	}
+	granule = int64(binary.LittleEndian.Uint64(header[6:]))
+	serial = binary.LittleEndian.Uint32(header[14:])
+	return granule, serial, offset + 27 + int64(header[26]), nil
+}
+
+func inspectOgg(r io.ReaderAt, size int64) (Info, error) {
+	_, serial, data, err := oggPage(r, 0)
+	if err != nil {
+		return Info{}, err
+	}
+	packet := make([]byte, 64)
+	n, err := r.ReadAt(packet, data)
+	if err != nil && err != io.EOF {
+		return Info{}, err
+	}
+	packet = packet[:n]
+	var info Info
+	// the granule position counts samples at the rate of the stream, after
+	// skipping the first samples of Opus
+	rate, skip := uint64(0), int64(0)
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")) && len(packet) >= 16:
+		info.Codec = "vorbis"
+		info.Channels = int(packet[11])
+		info.SampleRate = int(binary.LittleEndian.Uint32(packet[12:]))
+		rate = uint64(info.SampleRate)
+	case bytes.HasPrefix(packet, []byte("OpusHead")) && len(packet) >= 16:
+		info.Codec = "opus"
+		info.Channels = int(packet[9])
+		skip = int64(binary.LittleEndian.Uint16(packet[10:]))
+		info.SampleRate = int(binary.LittleEndian.Uint32(packet[12:]))
+		rate = 48000
+	case bytes.HasPrefix(packet, []byte("\x7fFLAC")) && len(packet) >= 13+4+34:
+		stream, err := flacStreamInfo(packet[13+4:])
+		if err != nil {
+			return Info{}, err
+		}
+		info, rate = stream, uint64(stream.SampleRate)
+	case bytes.HasPrefix(packet, []byte("Speex   ")) && len(packet) >= 52:
+		info.Codec = "speex"
+		info.SampleRate = int(binary.LittleEndian.Uint32(packet[36:]))
+		info.Channels = int(binary.LittleEndian.Uint32(packet[48:]))
+		rate = uint64(info.SampleRate)
+	default:
+		return Info{}, ErrUnsupported
+	}
+	// the last page of the stream holds the position of its end
+	tail := min(size, 64<<10)
+	buf := make([]byte, tail)
+	if _, err := r.ReadAt(buf, size-tail); err != nil && err != io.EOF {
+		return Info{}, err
+	}
+	for i := bytes.LastIndex(buf, []byte("OggS")); i >= 0; i = bytes.LastIndex(buf[:i], []byte("OggS")) {
+		granule, pageSerial, _, err := oggPage(r, size-tail+int64(i))
+		if err != nil || pageSerial != serial || granule < 0 {
+			continue
+		}
+		info.Duration = seconds(uint64(max(granule-skip, 0)), rate)
+		return info, nil
+	}
+	return Info{}, ErrInvalid
+}
+
+// MP4
+
+// box is a box of the ISO base media file format.
+type box struct {
+	kind string
+	// data and end are the offsets of the content of the box and its end.
+	data int64
+	end  int64
+}
+
+// boxes returns the boxes between two offsets.
+func boxes(r io.ReaderAt, start int64, end int64) ([]box, error) {
+	var result []box
+	for offset := start; offset+8 <= end; {
+		header, err := readAt(r, offset, 16)
+		if err != nil {
+			header, err = readAt(r, offset, 8)
+			if err != nil {
+				return nil, err
+			}
+		}
+		b := box{kind: string(header[4:8]), data: offset + 8}
+		switch length := int64(binary.BigEndian.Uint32(header)); {
+		case length == 0:
+			b.end = end
+		case length == 1 && len(header) == 16:
+			b.data += 8
+			b.end = offset + int64(binary.BigEndian.Uint64(header[8:]))
+		default:
+			b.end = offset + length
+		}
+		if b.end < b.data || b.end > end {
+			return nil, ErrInvalid
+		}
+		result = append(result, b)
+		offset = b.end
+	}
+	return result, nil
+}
+
+// child returns the first box of a kind in a box.
+func child(r io.ReaderAt, parent box, kind string) (box, bool) {
+	children, err := boxes(r, parent.data, parent.end)
+	if err != nil {
+		return box{}, false
+	}
+	for _, b := range children {
+		if b.kind == kind {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// timing reads the time scale and duration of a movie or media header box.
+func timing(r io.ReaderAt, header box) (uint64, uint64, error) {
+	b, err := readAt(r, header.data, int(min(header.end-header.data, 32)))
+	if err != nil {
+		return 0, 0, err
+	}
+	// version 0 needs 20 bytes and version 1 needs 32
+	if len(b) < 20 {
+		return 0, 0, ErrInvalid
+	}
+	if b[0] == 1 {
+		if len(b) < 32 {
+			return 0, 0, ErrInvalid
+		}
+		return uint64(binary.BigEndian.Uint32(b[20:])), binary.BigEndian.Uint64(b[24:]), nil
+	}
+	return uint64(binary.BigEndian.Uint32(b[12:])), uint64(binary.BigEndian.Uint32(b[16:])), nil
+}
+
+var mp4Codecs = map[string]string{
+	"mp4a": "aac",
+	"alac": "alac",
+	"Opus": "opus",
+	"fLaC": "flac",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	".mp3": "mp3",
+	"samr": "amr",
+	"sawb": "amr-wb",
+}
+
+func inspectMP4(r io.ReaderAt, size int64) (Info, error) {
+	top, err := boxes(r, 0, size)
+	if err != nil {
+		return Info{}, err
+	}
+	var sound box
+	for _, moov := range top {
+		if moov.kind != "moov" {
+			continue
+		}
+		traks, err := boxes(r, moov.data, moov.end)
+		if err != nil {
+			return Info{}, err
+		}
+		for _, trak := range traks {
+			if trak.kind != "trak" {
+				continue
+			}
+			mdia, ok := child(r, trak, "mdia")
+			if !ok {
+				continue
+			}
+			hdlr, ok := child(r, mdia, "hdlr")
+			if !ok {
+				continue
+			}
+			handler, err := readAt(r, hdlr.data+8, 4)
+			if err != nil {
+				continue
+			}
+			switch string(handler) {
+			case "vide":
+				// movies are not audio files even when they have sound
+				return Info{}, ErrUnsupported
+			case "soun":
+				if sound.kind == "" {
+					sound = mdia
+				}
+			}
+		}
+	}
+	if sound.kind == "" {
+		return Info{}, ErrInvalid
+	}
+	return mp4Track(r, sound)
+}
+
+// mp4Track reads the media box of a sound track.
+func mp4Track(r io.ReaderAt, mdia box) (Info, error) {
+	var info Info
+	if mdhd, ok := child(r, mdia, "mdhd"); ok {
+		scale, duration, err := timing(r, mdhd)
+		if err != nil {
+			return Info{}, err
+		}
+		info.Duration = seconds(duration, scale)
+	}
+	minf, ok := child(r, mdia, "minf")
+	if !ok {
+		return Info{}, ErrInvalid
+	}
+	stbl, ok := child(r, minf, "stbl")
+	if !ok {
+		return Info{}, ErrInvalid
+	}
+	stsd, ok := child(r, stbl, "stsd")
+	if !ok {
+		return Info{}, ErrInvalid
+	}
+	// the first sample entry follows the version and the entry count
+	entry, err := readAt(r, stsd.data+8, 36)
+	if err != nil {
+		return Info{}, err
+	}
+	kind := string(entry[4:8])
+	info.Codec = mp4Codecs[kind]
+	if info.Codec == "" {
+		info.Codec = strings.ToLower(strings.TrimSpace(kind))
+	}
+	info.Channels = int(binary.BigEndian.Uint16(entry[24:]))
+	// the sample rate is a 16.16 fixed point number
+	info.SampleRate = int(binary.BigEndian.Uint16(entry[32:]))
+	return info, nil
+}
@@ -0,0 +1,311 @@
+package feed
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"time"
+)
+
+const (
+	FormatRSS  = "rss"
+	FormatAtom = "atom"
+	FormatJSON = "json"
+)
+
+type Author struct {
+	Name   string
+	URL    string
+	Avatar string
+}
+
+type Item struct {
+	// ID is a permanent unique id, used as the RSS guid and the Atom id.
+	ID          string
+	Title       string
+	Link        string
+	ContentHTML string
+	Summary     string
+	Author      *Author
+	Published   time.Time
+	Updated     time.Time
+	Tags        []string
+}
+
+type Feed struct {
+	Title       string
+	Link        string
+	FeedURL     string
+	Description string
+	Icon        string
+	Author      *Author
+	Updated     time.Time
+	Items       []Item
+}
+
+// ContentType returns the media type of a feed format.
+func ContentType(format string) string {
+	switch format {
+	case FormatAtom:
+		return "application/atom+xml; charset=utf-8"
+	case FormatJSON:
+		return "application/feed+json; charset=utf-8"
+	}
+	return "application/rss+xml; charset=utf-8"
+}
+
+func IsValidFormat(format string) bool {
+	return format == FormatRSS || format == FormatAtom || format == FormatJSON
+}
+
+// Render encodes the feed in the given format.
+func (f *Feed) Render(format string) ([]byte, error) {
+	switch format {
+	case FormatRSS:
+		return f.RSS()
+	case FormatAtom:
+		return f.Atom()
+	case FormatJSON:
+		return f.JSON()
+	}
+	return nil, errors.New("unknown feed format")
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Guid        rssGuid  `xml:"guid"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"content:encoded"`
+	Author      string   `xml:"dc:creator,omitempty"`
+	Categories  []string `xml:"category"`
+	PubDate     string   `xml:"pubDate"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssChannel struct {
+	Title         string       `xml:"title"`
+	Link          string       `xml:"link"`
+	Description   string       `xml:"description"`
+	AtomLink      *rssAtomLink `xml:"atom:link,omitempty"`
+	LastBuildDate string       `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem    `xml:"item"`
+}
+
+type rss struct {
+	XMLName      xml.Name   `xml:"rss"`
+	Version      string     `xml:"version,attr"`
+	ContentNS    string     `xml:"xmlns:content,attr"`
+	DublinCoreNS string     `xml:"xmlns:dc,attr"`
+	AtomNS       string     `xml:"xmlns:atom,attr"`
+	Channel      rssChannel `xml:"channel"`
+}
+
+// RSS encodes the feed as RSS 2.0 with the full content in content:encoded.
+func (f *Feed) RSS() ([]byte, error) {
+	channel := rssChannel{
+		Title:       f.Title,
+		Link:        f.Link,
+		Description: f.Description,
+	}
+	if f.FeedURL != "" {
+		channel.AtomLink = &rssAtomLink{Href: f.FeedURL, Rel: "self", Type: ContentType(FormatRSS)}
+	}
+	if !f.Updated.IsZero() {
+		channel.LastBuildDate = f.Updated.UTC().Format(time.RFC1123Z)
+	}
+	for _, item := range f.Items {
+		i := rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			Guid:        rssGuid{IsPermaLink: item.ID == item.Link, Value: item.ID},
+			Description: item.Summary,
+			Content:     item.ContentHTML,
+			Categories:  item.Tags,
+			PubDate:     item.Published.UTC().Format(time.RFC1123Z),
+		}
+		if item.Author != nil {
+			i.Author = item.Author.Name
+		}
+		channel.Items = append(channel.Items, i)
+	}
+	return marshalXML(rss{
+		Version:      "2.0",
+		ContentNS:    "http://purl.org/rss/1.0/modules/content/",
+		DublinCoreNS: "http://purl.org/dc/elements/1.1/",
+		AtomNS:       "http://www.w3.org/2005/Atom",
+		Channel:      channel,
+	})
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Links      []atomLink     `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     *atomPerson    `xml:"author,omitempty"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    atomText       `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atom struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Links    []atomLink  `xml:"link"`
+	Updated  string      `xml:"updated"`
+	Author   *atomPerson `xml:"author,omitempty"`
+	Icon     string      `xml:"icon,omitempty"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+// Atom encodes the feed as Atom 1.0.
+func (f *Feed) Atom() ([]byte, error) {
+	id := f.FeedURL
+	if id == "" {
+		id = f.Link
+	}
+	feed := atom{
+		ID:       id,
+		Title:    f.Title,
+		Subtitle: f.Description,
+		Links:    []atomLink{{Href: f.Link, Rel: "alternate", Type: "text/html"}},
+		Updated:  f.Updated.UTC().Format(time.RFC3339),
+		Author:   atomAuthor(f.Author),
+		Icon:     f.Icon,
+	}
+	if f.FeedURL != "" {
+		feed.Links = append(feed.Links, atomLink{Href: f.FeedURL, Rel: "self", Type: ContentType(FormatAtom)})
+	}
+	for _, item := range f.Items {
+		entry := atomEntry{
+			ID:        item.ID,
+			Title:     item.Title,
+			Links:     []atomLink{{Href: item.Link, Rel: "alternate", Type: "text/html"}},
+			Published: item.Published.UTC().Format(time.RFC3339),
+			Updated:   item.Updated.UTC().Format(time.RFC3339),
+			Author:    atomAuthor(item.Author),
+			Content:   atomText{Type: "html", Value: item.ContentHTML},
+		}
+		if item.Summary != "" {
+			entry.Summary = &atomText{Type: "text", Value: item.Summary}
+		}
+		for _, t := range item.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: t})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return marshalXML(feed)
+}
+
+func atomAuthor(author *Author) *atomPerson {
+	if author == nil {
+		return nil
+	}
+	return &atomPerson{Name: author.Name, URI: author.URL}
+}
+
+type jsonAuthor struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type jsonItem struct {
+	ID            string       `json:"id"`
+	URL           string       `json:"url,omitempty"`
+	Title         string       `json:"title,omitempty"`
+	ContentHTML   string       `json:"content_html"`
+	Summary       string       `json:"summary,omitempty"`
+	DatePublished string       `json:"date_published"`
+	DateModified  string       `json:"date_modified"`
+	Authors       []jsonAuthor `json:"authors,omitempty"`
+	Tags          []string     `json:"tags,omitempty"`
+}
+
+type jsonFeed struct {
+	Version     string       `json:"version"`
+	Title       string       `json:"title"`
+	HomePageURL string       `json:"home_page_url,omitempty"`
+	FeedURL     string       `json:"feed_url,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Icon        string       `json:"icon,omitempty"`
+	Authors     []jsonAuthor `json:"authors,omitempty"`
+	Items       []jsonItem   `json:"items"`
+}
+
+// JSON encodes the feed as JSON Feed 1.1.
+func (f *Feed) JSON() ([]byte, error) {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.Link,
+		FeedURL:     f.FeedURL,
+		Description: f.Description,
+		Icon:        f.Icon,
+		Authors:     jsonAuthors(f.Author),
+		Items:       make([]jsonItem, 0, len(f.Items)),
+	}
+	for _, item := range f.Items {
+		feed.Items = append(feed.Items, jsonItem{
+			ID:            item.ID,
+			URL:           item.Link,
+			Title:         item.Title,
+			ContentHTML:   item.ContentHTML,
+			Summary:       item.Summary,
+			DatePublished: item.Published.UTC().Format(time.RFC3339),
+			DateModified:  item.Updated.UTC().Format(time.RFC3339),
+			Authors:       jsonAuthors(item.Author),
+			Tags:          item.Tags,
+		})
+	}
+	return json.MarshalIndent(feed, "", "  ")
+}
+
+func jsonAuthors(author *Author) []jsonAuthor {
+	if author == nil {
+		return nil
+	}
+	return []jsonAuthor{{Name: author.Name, URL: author.URL, Avatar: author.Avatar}}
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
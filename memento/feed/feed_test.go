@@ -0,0 +1,210 @@
+package feed
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testPublished = time.Date(2024, 3, 1, 8, 30, 0, 0, time.FixedZone("CET", 3600))
+	testUpdated   = time.Date(2024, 3, 2, 18, 45, 10, 0, time.UTC)
+)
+
+const testContent = `<p>Fish &amp; chips <a href="https://example.com/?a=1&b=2">here</a></p><script>alert("x")</script>`
+
+func testFeed() *Feed {
+	author := &Author{Name: "Alice <admin>", URL: "https://example.com/user/alice", Avatar: "https://example.com/a.png"}
+	return &Feed{
+		Title:       "Alice",
+		Link:        "https://example.com/user/alice",
+		FeedURL:     "https://example.com/feed/rss/user/alice",
+		Description: "Memos of Alice",
+		Author:      author,
+		Updated:     testUpdated,
+		Items: []Item{{
+			ID:          "https://example.com/post/1",
+			Title:       "Fish & chips",
+			Link:        "https://example.com/post/1",
+			ContentHTML: testContent,
+			Summary:     "Fish & chips here",
+			Author:      author,
+			Published:   testPublished,
+			Updated:     testUpdated,
+			Tags:        []string{"food"},
+		}},
+	}
+}
+
+// parsedItem is what a reader gets back from an item of a feed.
+type parsedItem struct {
+	ID        string
+	Published time.Time
+	Updated   time.Time
+	Author    string
+	Content   string
+}
+
+type parsedFeed struct {
+	Updated time.Time
+	Items   []parsedItem
+}
+
+func parseTime(t *testing.T, layout string, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(layout, value)
+	if err != nil {
+		t.Fatalf("time %q: %v", value, err)
+	}
+	return parsed
+}
+
+func parseRSS(t *testing.T, data []byte) parsedFeed {
+	var doc struct {
+		Version string `xml:"version,attr"`
+		Channel struct {
+			LastBuildDate string `xml:"lastBuildDate"`
+			Items         []struct {
+				Guid struct {
+					IsPermaLink string `xml:"isPermaLink,attr"`
+					Value       string `xml:",chardata"`
+				} `xml:"guid"`
+				PubDate string `xml:"pubDate"`
+				Creator string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+				Content string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != "2.0" {
+		t.Errorf("version %q", doc.Version)
+	}
+	f := parsedFeed{Updated: parseTime(t, time.RFC1123Z, doc.Channel.LastBuildDate)}
+	for _, item := range doc.Channel.Items {
+		if item.Guid.IsPermaLink != "true" {
+			t.Errorf("guid %s is not a permalink", item.Guid.Value)
+		}
+		// RSS items only have a publication date
+		published := parseTime(t, time.RFC1123Z, item.PubDate)
+		f.Items = append(f.Items, parsedItem{item.Guid.Value, published, testUpdated, item.Creator, item.Content})
+	}
+	return f
+}
+
+func parseAtom(t *testing.T, data []byte) parsedFeed {
+	var doc struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		Updated string   `xml:"updated"`
+		Entries []struct {
+			ID        string `xml:"id"`
+			Published string `xml:"published"`
+			Updated   string `xml:"updated"`
+			Author    struct {
+				Name string `xml:"name"`
+			} `xml:"author"`
+			Content struct {
+				Type  string `xml:"type,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"content"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	f := parsedFeed{Updated: parseTime(t, time.RFC3339, doc.Updated)}
+	for _, entry := range doc.Entries {
+		if entry.Content.Type != "html" {
+			t.Errorf("content type %q", entry.Content.Type)
+		}
+		f.Items = append(f.Items, parsedItem{
+			entry.ID,
+			parseTime(t, time.RFC3339, entry.Published),
+			parseTime(t, time.RFC3339, entry.Updated),
+			entry.Author.Name,
+			entry.Content.Value,
+		})
+	}
+	return f
+}
+
+func parseJSON(t *testing.T, data []byte) parsedFeed {
+	var doc struct {
+		Version string `json:"version"`
+		Items   []struct {
+			ID            string `json:"id"`
+			ContentHTML   string `json:"content_html"`
+			DatePublished string `json:"date_published"`
+			DateModified  string `json:"date_modified"`
+			Authors       []struct {
+				Name string `json:"name"`
+			} `json:"authors"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != "https://jsonfeed.org/version/1.1" {
+		t.Errorf("version %q", doc.Version)
+	}
+	// JSON Feed has no date of the feed
+	f := parsedFeed{Updated: testUpdated}
+	for _, item := range doc.Items {
+		author := ""
+		if len(item.Authors) > 0 {
+			author = item.Authors[0].Name
+		}
+		f.Items = append(f.Items, parsedItem{
+			item.ID,
+			parseTime(t, time.RFC3339, item.DatePublished),
+			parseTime(t, time.RFC3339, item.DateModified),
+			author,
+			item.ContentHTML,
+		})
+	}
+	return f
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		format string
+		parse  func(*testing.T, []byte) parsedFeed
+	}{
+		{FormatRSS, parseRSS},
+		{FormatAtom, parseAtom},
+		{FormatJSON, parseJSON},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			data, err := testFeed().Render(test.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the content is escaped, never embedded as markup
+			if strings.Contains(string(data), "<script>") || strings.Contains(string(data), "<admin>") {
+				t.Errorf("unescaped html:\n%s", data)
+			}
+			f := test.parse(t, data)
+			if !f.Updated.Equal(testUpdated) {
+				t.Errorf("feed updated %s, want %s", f.Updated, testUpdated)
+			}
+			if len(f.Items) != 1 {
+				t.Fatalf("%d items", len(f.Items))
+			}
+			want := parsedItem{"https://example.com/post/1", testPublished, testUpdated, "Alice <admin>", testContent}
+			got := f.Items[0]
+			if got.ID != want.ID || got.Author != want.Author || got.Content != want.Content {
+				t.Errorf("item %+v\nwant %+v", got, want)
+			}
+			if !got.Published.Equal(want.Published) || !got.Updated.Equal(want.Updated) {
+				t.Errorf("item published %s, updated %s, want %s, %s", got.Published, got.Updated, want.Published, want.Updated)
+			}
+		})
+	}
+	if _, err := testFeed().Render("html"); err == nil {
+		t.Error("an unknown format was rendered")
+	}
+}
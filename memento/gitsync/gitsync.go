@@ -0,0 +1,247 @@
+package gitsync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cgi"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Branch is the only branch which is synced.
+const Branch = "main"
+
+const ref = "refs/heads/" + Branch
+
+// ErrRefChanged is returned by Commit when the branch moved since the parent
+// was read, e.g. because a push landed in between.
+var ErrRefChanged = errors.New("the branch was changed concurrently")
+
+// Repo is a bare repository driven through the git command.
+type Repo struct {
+	Path string
+}
+
+// Signature is the author of a commit.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// Change sets the content of a file, or removes it when Content is nil.
+type Change struct {
+	Path    string
+	Content []byte
+}
+
+// FileChange is a file changed between two commits.
+type FileChange struct {
+	// Status is A, M or D, since renames are not detected.
+	Status string
+	Path   string
+}
+
+// Available reports whether the git command can be run.
+func Available() bool {
+	_, err := exec.LookPath("git")
+	return err == nil
+}
+
+// Init creates a bare repository which rejects forced pushes and the
+// deletion of branches.
+func Init(path string) (*Repo, error) {
+	if err := os.MkdirAll(path, 0777); err != nil {
+		return nil, err
+	}
+	r := &Repo{Path: path}
+	if _, err := r.git(nil, nil, "init", "--bare", "--quiet"); err != nil {
+		return nil, err
+	}
+	config := [][]string{
+		{"symbolic-ref", "HEAD", ref},
+		{"config", "receive.denyNonFastForwards", "true"},
+		{"config", "receive.denyDeletes", "true"},
+		{"config", "http.receivepack", "true"},
+	}
+	for _, args := range config {
+		if _, err := r.git(nil, nil, args...); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Open returns the bare repository at path.
+func Open(path string) *Repo {
+	return &Repo{Path: path}
+}
+
+func (r *Repo) git(stdin []byte, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.Path
+	cmd.Env = append(os.Environ(), "GIT_DIR="+r.Path, "GIT_TERMINAL_PROMPT=0")
+	cmd.Env = append(cmd.Env, env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()+" "+err.Error()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Head returns the commit of the branch, or "" when nothing was committed.
+func (r *Repo) Head() (string, error) {
+	out, err := r.git(nil, nil, "for-each-ref", "--format=%(objectname)", ref)
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// Commit applies the changes on top of parent and moves the branch to the new
+// commit, unless the branch no longer points at parent. It returns parent
+// when the changes leave the files as they are.
+func (r *Repo) Commit(parent string, changes []Change, author Signature, message string) (string, error) {
+	index, err := os.CreateTemp("", "memento-index-")
+	if err != nil {
+		return "", err
+	}
+	_ = index.Close()
+	// git refuses an existing empty index file
+	_ = os.Remove(index.Name())
+	defer func(name string) {
+		_ = os.Remove(name)
+	}(index.Name())
+	env := []string{"GIT_INDEX_FILE=" + index.Name()}
+	if parent != "" {
+		if _, err := r.git(nil, env, "read-tree", parent); err != nil {
+			return "", err
+		}
+	}
+	for _, change := range changes {
+		if change.Content == nil {
+			if _, err := r.git(nil, env, "update-index", "--force-remove", "--", change.Path); err != nil {
+				return "", err
+			}
+			continue
+		}
+		blob, err := r.git(change.Content, nil, "hash-object", "-w", "--stdin")
+		if err != nil {
+			return "", err
+		}
+		if _, err := r.git(nil, env, "update-index", "--add", "--cacheinfo", "100644,"+blob+","+change.Path); err != nil {
+			return "", err
+		}
+	}
+	tree, err := r.git(nil, env, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	if parent != "" {
+		parentTree, err := r.git(nil, nil, "rev-parse", parent+"^{tree}")
+		if err != nil {
+			return "", err
+		}
+		if parentTree == tree {
+			return parent, nil
+		}
+	}
+	date := author.When.Format(time.RFC3339)
+	env = []string{
+		"GIT_AUTHOR_NAME=" + author.Name, "GIT_AUTHOR_EMAIL=" + author.Email, "GIT_AUTHOR_DATE=" + date,
+		"GIT_COMMITTER_NAME=Memento", "GIT_COMMITTER_EMAIL=memento@localhost", "GIT_COMMITTER_DATE=" + date,
+	}
+	args := []string{"commit-tree", tree}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	commit, err := r.git([]byte(message), env, args...)
+	if err != nil {
+		return "", err
+	}
+	// the old value makes the update fail when a push moved the branch
+	old := parent
+	if old == "" {
+		old = strings.Repeat("0", len(commit))
+	}
+	if _, err := r.git(nil, nil, "update-ref", ref, commit, old); err != nil {
+		if head, headErr := r.Head(); headErr == nil && head != parent {
+			return "", ErrRefChanged
+		}
+		return "", err
+	}
+	return commit, nil
+}
+
+// Diff lists the files changed from one commit to another. An empty from
+// compares with the empty tree.
+func (r *Repo) Diff(from string, to string) ([]FileChange, error) {
+	if from == "" {
+		tree, err := r.git(nil, nil, "hash-object", "-t", "tree", os.DevNull)
+		if err != nil {
+			return nil, err
+		}
+		from = tree
+	}
+	out, err := r.git(nil, nil, "diff-tree", "-r", "-z", "--no-renames", "--name-status", from, to)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Split(strings.Trim(out, "\x00"), "\x00")
+	var changes []FileChange
+	for i := 0; i+1 < len(fields); i += 2 {
+		changes = append(changes, FileChange{Status: fields[i], Path: fields[i+1]})
+	}
+	return changes, nil
+}
+
+// ReadFile returns the content of a file at a commit.
+func (r *Repo) ReadFile(commit string, path string) ([]byte, error) {
+	cmd := exec.Command("git", "cat-file", "blob", commit+":"+path)
+	cmd.Env = append(os.Environ(), "GIT_DIR="+r.Path)
+	return cmd.Output()
+}
+
+// Files lists the files of a commit.
+func (r *Repo) Files(commit string) ([]string, error) {
+	if commit == "" {
+		return nil, nil
+	}
+	out, err := r.git(nil, nil, "ls-tree", "-r", "-z", "--name-only", commit)
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+	return strings.Split(strings.Trim(out, "\x00"), "\x00"), nil
+}
+
+// Handler serves the repositories below root over the smart HTTP protocol
+// through git http-backend. Requests are expected below prefix, followed by
+// the name of the repository. The user is trusted to be authenticated.
+func Handler(root string, prefix string, user string) (http.Handler, error) {
+	execPath, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		return nil, err
+	}
+	return &cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(execPath)), "git-http-backend"),
+		Root: prefix,
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + root,
+			"GIT_HTTP_EXPORT_ALL=1",
+			"REMOTE_USER=" + user,
+		},
+	}, nil
+}
@@ -0,0 +1,209 @@
+// Package imaging processes uploaded images: it removes their metadata,
+// measures them and scales them to thumbnails. It only uses the decoders of
+// the standard library, so WebP and AVIF images are measured and stripped
+// but not scaled.
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/nfnt/resize"
+)
+
+// MaxPixels limits the size of the images which are decoded, since decoding
+// needs four bytes of memory per pixel.
+const MaxPixels = 50_000_000
+
+var (
+	// ErrUnsupported is returned for images which cannot be decoded.
+	ErrUnsupported = errors.New("unsupported image type")
+	// ErrTooLarge is returned for images with more than MaxPixels pixels.
+	ErrTooLarge = errors.New("image too large")
+)
+
+// Info describes an image as it is shown, that is with its orientation
+// applied.
+type Info struct {
+	Width  int
+	Height int
+	// Orientation is the EXIF orientation, from 1 for upright to 8.
+	Orientation int
+}
+
+// Inspect measures an image of a MIME type. data only needs to hold the
+// beginning of the image up to its dimensions.
+func Inspect(data []byte, mimeType string) (Info, error) {
+	info := Info{Orientation: orientation(data, mimeType)}
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif":
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return Info{}, err
+		}
+		info.Width, info.Height = config.Width, config.Height
+	case "image/webp":
+		var err error
+		if info.Width, info.Height, err = webpSize(data); err != nil {
+			return Info{}, err
+		}
+	default:
+		return Info{}, ErrUnsupported
+	}
+	if info.Orientation >= 5 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+	return info, nil
+}
+
+// orientation returns the EXIF orientation of an image, 1 when it has none.
+func orientation(data []byte, mimeType string) int {
+	o := 0
+	switch mimeType {
+	case "image/jpeg":
+		_, _ = jpegSegments(data, func(marker byte, payload []byte) {
+			if o == 0 && marker == 0xe1 && bytes.HasPrefix(payload, exifHeader) {
+				o = exifOrientation(payload[len(exifHeader):])
+			}
+		})
+	case "image/png":
+		_ = pngChunks(data, func(typ string, chunk []byte) error {
+			if typ == "eXIf" {
+				o = exifOrientation(chunk[8 : len(chunk)-4])
+			}
+			return nil
+		})
+	case "image/webp":
+		_ = webpChunks(data, func(typ string, payload []byte) {
+			if typ == "EXIF" {
+				o = exifOrientation(bytes.TrimPrefix(payload, exifHeader))
+			}
+		})
+	}
+	if o == 0 {
+		return 1
+	}
+	return o
+}
+
+// webpSize reads the canvas size of a WebP from the header of its first
+// chunk.
+func webpSize(data []byte) (int, int, error) {
+	if len(data) < 30 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, errors.New("not a WebP image")
+	}
+	chunk := data[20:]
+	switch string(data[12:16]) {
+	case "VP8X":
+		w := int(chunk[4]) | int(chunk[5])<<8 | int(chunk[6])<<16
+		h := int(chunk[7]) | int(chunk[8])<<8 | int(chunk[9])<<16
+		return w + 1, h + 1, nil
+	case "VP8L":
+		if chunk[0] != 0x2f {
+			return 0, 0, errors.New("invalid WebP image")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	case "VP8 ":
+		if chunk[3] != 0x9d || chunk[4] != 0x01 || chunk[5] != 0x2a {
+			return 0, 0, errors.New("invalid WebP image")
+		}
+		return int(binary.LittleEndian.Uint16(chunk[6:]) & 0x3fff), int(binary.LittleEndian.Uint16(chunk[8:]) & 0x3fff), nil
+	}
+	return 0, 0, errors.New("invalid WebP image")
+}
+
+// CanResize reports whether images of a MIME type can be scaled.
+func CanResize(mimeType string) bool {
+	return mimeType == "image/jpeg" || mimeType == "image/png" || mimeType == "image/gif"
+}
+
+// VariantType returns the MIME type of the thumbnails of an image type:
+// JPEG for photos and PNG for images which may be transparent.
+func VariantType(mimeType string) string {
+	if mimeType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// Thumbnail scales an image down to fit into a square of size pixels, turns
+// it upright and encodes it without metadata as VariantType. Smaller images
+// are only turned and encoded. Animated GIFs keep their first frame.
+func Thumbnail(data []byte, mimeType string, size int) ([]byte, error) {
+	if !CanResize(mimeType) {
+		return nil, ErrUnsupported
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	var img image.Image
+	switch mimeType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		img, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	img = Orient(resize.Thumbnail(uint(size), uint(size), img, resize.Lanczos3), orientation(data, mimeType))
+	var buf bytes.Buffer
+	if VariantType(mimeType) == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Orient turns an image with an EXIF orientation upright.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
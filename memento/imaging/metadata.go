@@ -0,0 +1,291 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var (
+	errTruncated = errors.New("truncated image")
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader   = []byte("Exif\x00\x00")
+)
+
+// Strip removes the metadata of a JPEG, PNG or WebP image, like the camera,
+// the time and the GPS position it was taken at, without decoding it. The
+// orientation is kept in a minimal EXIF block so viewers still show the image
+// upright, and so are color profiles. Other types are returned as they are.
+func Strip(data []byte, mimeType string) ([]byte, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	}
+	return data, nil
+}
+
+// CanStrip reports whether Strip removes the metadata of a MIME type.
+func CanStrip(mimeType string) bool {
+	return mimeType == "image/jpeg" || mimeType == "image/png" || mimeType == "image/webp"
+}
+
+// jpegSegments calls f with the marker and the payload of every segment of a
+// JPEG before the image data, and returns the offset the image data starts
+// at.
+func jpegSegments(data []byte, f func(marker byte, payload []byte)) (int, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return 0, errors.New("not a JPEG image")
+	}
+	i := 2
+	for {
+		if i+2 > len(data) {
+			return 0, errTruncated
+		}
+		if data[i] != 0xff {
+			return 0, errors.New("invalid JPEG marker")
+		}
+		marker := data[i+1]
+		if marker == 0xff {
+			// fill byte
+			i++
+			continue
+		}
+		if marker == 0xd8 || marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			// markers without a payload
+			i += 2
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			return i, nil
+		}
+		if i+4 > len(data) {
+			return 0, errTruncated
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return 0, errTruncated
+		}
+		f(marker, data[i+4:end])
+		i = end
+	}
+}
+
+// keepJPEGSegment reports whether a segment is needed to show the image:
+// everything but the application segments, except JFIF, Adobe and ICC
+// profiles, and the comments.
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xe0, marker == 0xee:
+		return true
+	case marker == 0xe2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker >= 0xe1 && marker <= 0xef, marker == 0xfe:
+		return false
+	}
+	return true
+}
+
+func appendJPEGSegment(out []byte, marker byte, payload []byte) []byte {
+	out = append(out, 0xff, marker)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+	return append(out, payload...)
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	orientation := 1
+	var segments [][]byte
+	var markers []byte
+	start, err := jpegSegments(data, func(marker byte, payload []byte) {
+		if marker == 0xe1 && bytes.HasPrefix(payload, exifHeader) {
+			if o := exifOrientation(payload[len(exifHeader):]); o != 0 {
+				orientation = o
+			}
+		}
+		if keepJPEGSegment(marker, payload) {
+			markers = append(markers, marker)
+			segments = append(segments, payload)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, 0xd8)
+	exifWritten := orientation == 1
+	for i, marker := range markers {
+		if !exifWritten && marker != 0xe0 {
+			// EXIF follows JFIF
+			out = appendJPEGSegment(out, 0xe1, append(append([]byte(nil), exifHeader...), orientationExif(orientation)...))
+			exifWritten = true
+		}
+		out = appendJPEGSegment(out, marker, segments[i])
+	}
+	return append(out, data[start:]...), nil
+}
+
+// pngChunks calls f with the type and the whole of every chunk of a PNG.
+func pngChunks(data []byte, f func(typ string, chunk []byte) error) error {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return errors.New("not a PNG image")
+	}
+	for i := len(pngSignature); i < len(data); {
+		if i+8 > len(data) {
+			return errTruncated
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i+12 {
+			return errTruncated
+		}
+		if err := f(string(data[i+4:i+8]), data[i:end]); err != nil {
+			return err
+		}
+		i = end
+	}
+	return nil
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	err := pngChunks(data, func(typ string, chunk []byte) error {
+		switch typ {
+		case "eXIf":
+			if o := exifOrientation(chunk[8 : len(chunk)-4]); o > 1 {
+				exif := orientationExif(o)
+				out = binary.BigEndian.AppendUint32(out, uint32(len(exif)))
+				typed := append([]byte("eXIf"), exif...)
+				out = append(out, typed...)
+				out = binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(typed))
+			}
+		case "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, chunk...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// webpChunks calls f with the type and the payload of every chunk of a WebP.
+func webpChunks(data []byte, f func(typ string, payload []byte)) error {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return errors.New("not a WebP image")
+	}
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return errTruncated
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size
+		if end > len(data) || end < i+8 {
+			return errTruncated
+		}
+		f(string(data[i:i+4]), data[i+8:end])
+		i = end + size%2
+	}
+	return nil
+}
+
+func appendWebPChunk(out []byte, typ string, payload []byte) []byte {
+	out = append(out, typ...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(payload)))
+	out = append(out, payload...)
+	if len(payload)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func stripWebP(data []byte) ([]byte, error) {
+	orientation := 1
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+	err := webpChunks(data, func(typ string, payload []byte) {
+		switch typ {
+		case "EXIF":
+			if o := exifOrientation(bytes.TrimPrefix(payload, exifHeader)); o != 0 {
+				orientation = o
+			}
+		case "XMP ":
+		case "VP8X":
+			if len(payload) > 0 {
+				payload = append([]byte(nil), payload...)
+				// clear the EXIF and XMP flags, the EXIF one is set again
+				// for the orientation
+				payload[0] &^= 0x0c
+			}
+			out = appendWebPChunk(out, typ, payload)
+		default:
+			out = appendWebPChunk(out, typ, payload)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if orientation > 1 && len(out) > 20 && string(out[12:16]) == "VP8X" {
+		// only extended WebPs can carry EXIF
+		out[20] |= 0x08
+		out = appendWebPChunk(out, "EXIF", orientationExif(orientation))
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// exifOrientation returns the orientation of the EXIF data in TIFF format,
+// or 0 when it has none.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o < 1 || o > 8 {
+				return 0
+			}
+			return o
+		}
+	}
+	return 0
+}
+
+// orientationExif returns EXIF data in TIFF format holding only an
+// orientation.
+func orientationExif(orientation int) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	// the orientation tag, a SHORT with one value
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = append(tiff, 0, 0)
+	// no next IFD
+	return binary.BigEndian.AppendUint32(tiff, 0)
+}
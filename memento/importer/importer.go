@@ -0,0 +1,340 @@
+// Package importer reads posts out of the archives of other note-taking
+// services. An importer only parses its source; storing the items is left
+// to the caller.
+package importer
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// linkScheme prefixes the placeholders in the content of items. They are
+	// replaced once the linked post or file exists.
+	linkScheme     = "memento-import:"
+	postLinkPrefix = linkScheme + "post/"
+	fileLinkPrefix = linkScheme + "file/"
+)
+
+// PostLinkPattern matches the placeholders of links between items.
+var PostLinkPattern = regexp.MustCompile(regexp.QuoteMeta(postLinkPrefix) + `[^\s)"\]]+`)
+
+// Item is a post read from an archive.
+type Item struct {
+	// Key identifies the item within its archive, e.g. the path of a note.
+	Key       string
+	Content   string
+	CreatedAt time.Time
+	EditedAt  time.Time
+	Private   bool
+	// Tags are added to the content unless it mentions them already.
+	Tags        []string
+	Attachments []Attachment
+}
+
+// Attachment is a file belonging to an item.
+type Attachment struct {
+	Name string
+	// Ref is the placeholder of the file in the content of the item.
+	Ref  string
+	Open func() (io.ReadCloser, error)
+}
+
+// Result holds the items of an archive. Attachments can be read until it is
+// closed.
+type Result struct {
+	Items    []Item
+	Warnings []string
+	closer   io.Closer
+}
+
+func (r *Result) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+func (r *Result) warnf(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// sort orders the items from the oldest to the newest, so they are created
+// in their original order.
+func (r *Result) sort() {
+	sort.SliceStable(r.Items, func(i, j int) bool {
+		return r.Items[i].CreatedAt.Before(r.Items[j].CreatedAt)
+	})
+}
+
+// Options adjust how an archive is read. Importers ignore the options which
+// do not apply to their source.
+type Options struct {
+	// Private is the visibility of items whose source does not have one.
+	Private bool `json:"private"`
+	// User selects the account to import from sources with several users.
+	User string `json:"user"`
+	// Replies includes replies to other accounts.
+	Replies bool `json:"replies"`
+}
+
+type Importer interface {
+	// Read parses the archive stored at path.
+	Read(path string, options Options) (*Result, error)
+}
+
+var importers = map[string]Importer{}
+
+// Register makes an importer available under a name.
+func Register(name string, importer Importer) {
+	importers[name] = importer
+}
+
+func Get(name string) (Importer, bool) {
+	importer, ok := importers[name]
+	return importer, ok
+}
+
+// Names returns the names of the registered importers.
+func Names() []string {
+	names := make([]string, 0, len(importers))
+	for name := range importers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register("markdown", Markdown{})
+	Register("memos", Memos{})
+	Register("obsidian", Obsidian{})
+	Register("twitter", Twitter{})
+}
+
+// PostLink returns the placeholder of a link to the item with the key.
+func PostLink(key string) string {
+	return postLinkPrefix + url.PathEscape(key)
+}
+
+// PostLinkKey returns the key of the item a placeholder links to.
+func PostLinkKey(link string) string {
+	key, err := url.PathUnescape(strings.TrimPrefix(link, postLinkPrefix))
+	if err != nil {
+		return ""
+	}
+	return key
+}
+
+// attach adds a file to the item and returns its placeholder.
+func (item *Item) attach(name string, open func() (io.ReadCloser, error)) string {
+	ref := fmt.Sprintf("%s%d", fileLinkPrefix, len(item.Attachments))
+	item.Attachments = append(item.Attachments, Attachment{Name: name, Ref: ref, Open: open})
+	return ref
+}
+
+var imageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".webp", ".svg", ".bmp", ".avif"}
+
+func isImage(name string) bool {
+	for _, ext := range imageExtensions {
+		if strings.EqualFold(path.Ext(name), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// zipArchive indexes the files of a zip archive by their path.
+type zipArchive struct {
+	*zip.ReadCloser
+	files map[string]*zip.File
+}
+
+func openZip(name string) (*zipArchive, error) {
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, errors.New("the file is not a zip archive")
+	}
+	archive := &zipArchive{ReadCloser: r, files: make(map[string]*zip.File)}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		archive.files[path.Clean(f.Name)] = f
+	}
+	return archive, nil
+}
+
+// paths returns the sorted paths of the files with the extension.
+func (a *zipArchive) paths(ext string) []string {
+	var result []string
+	for name := range a.files {
+		if strings.EqualFold(path.Ext(name), ext) && !isHidden(name) {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (a *zipArchive) read(name string) ([]byte, error) {
+	f, ok := a.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func(r io.ReadCloser) {
+		_ = r.Close()
+	}(r)
+	return io.ReadAll(r)
+}
+
+func (a *zipArchive) opener(name string) func() (io.ReadCloser, error) {
+	f := a.files[name]
+	return func() (io.ReadCloser, error) {
+		return f.Open()
+	}
+}
+
+// isHidden reports whether a path is in a hidden folder or is a hidden file.
+func isHidden(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// frontMatter is the YAML block at the start of a Markdown file.
+type frontMatter map[string]interface{}
+
+// splitFrontMatter separates the front matter from the Markdown content.
+func splitFrontMatter(content string) (frontMatter, string, error) {
+	content = strings.TrimPrefix(strings.ReplaceAll(content, "\r\n", "\n"), "\ufeff")
+	if !strings.HasPrefix(content, "---\n") {
+		return frontMatter{}, content, nil
+	}
+	end := strings.Index(content[4:], "\n---")
+	if end < 0 {
+		return frontMatter{}, content, nil
+	}
+	block := content[4 : 4+end]
+	rest := strings.TrimPrefix(content[4+end+4:], "\n")
+	fm := frontMatter{}
+	if err := yaml.Unmarshal([]byte(block), &fm); err != nil {
+		return nil, content, fmt.Errorf("invalid front matter: %s", err.Error())
+	}
+	return fm, rest, nil
+}
+
+var timeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// date returns the first of the keys which holds a timestamp.
+func (fm frontMatter) date(keys ...string) time.Time {
+	for _, key := range keys {
+		switch v := fm[key].(type) {
+		case time.Time:
+			return v
+		case string:
+			for _, format := range timeFormats {
+				if t, err := time.ParseInLocation(format, strings.TrimSpace(v), time.Local); err == nil {
+					return t
+				}
+			}
+		case int:
+			return time.Unix(int64(v), 0)
+		}
+	}
+	return time.Time{}
+}
+
+// private reads the visibility of a post, falling back to def.
+func (fm frontMatter) private(def bool) bool {
+	if v, ok := fm["visibility"].(string); ok {
+		return !strings.EqualFold(v, "public")
+	}
+	if v, ok := fm["private"].(bool); ok {
+		return v
+	}
+	if v, ok := fm["draft"].(bool); ok && v {
+		return true
+	}
+	return def
+}
+
+// list returns the values of the first key found, which may be written as a
+// YAML list or a comma separated string.
+func (fm frontMatter) list(keys ...string) []string {
+	for _, key := range keys {
+		var result []string
+		switch v := fm[key].(type) {
+		case []interface{}:
+			for _, s := range v {
+				result = append(result, fmt.Sprint(s))
+			}
+		case string:
+			result = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+		default:
+			continue
+		}
+		return result
+	}
+	return nil
+}
+
+// orNow returns t, or the current time if it is not set.
+func orNow(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
+}
+
+var markdownLinkPattern = regexp.MustCompile(`(!?)\[([^\]]*)\]\(<?([^)\s>]+)>?(\s+"[^"]*")?\)`)
+
+// resolveLinks replaces the relative Markdown links of a note. Links to other
+// notes become post placeholders and links to other files become
+// attachments of the item. Other links are kept.
+func resolveLinks(item *Item, archive *zipArchive, notes map[string]bool, dir string) {
+	item.Content = markdownLinkPattern.ReplaceAllStringFunc(item.Content, func(link string) string {
+		m := markdownLinkPattern.FindStringSubmatch(link)
+		target := m[3]
+		if strings.Contains(target, ":") || strings.HasPrefix(target, "#") || strings.HasPrefix(target, "/") {
+			return link
+		}
+		name, err := url.PathUnescape(target)
+		if err != nil {
+			return link
+		}
+		name, _, _ = strings.Cut(name, "#")
+		name = path.Join(dir, name)
+		if notes[name] {
+			return m[1] + "[" + m[2] + "](" + PostLink(name) + ")"
+		}
+		if _, ok := archive.files[name]; ok {
+			return m[1] + "[" + m[2] + "](" + item.attach(path.Base(name), archive.opener(name)) + ")"
+		}
+		return link
+	})
+}
@@ -0,0 +1,64 @@
+package importer
+
+import (
+	"path"
+	"strings"
+)
+
+// Markdown imports a zip archive of Markdown files. The front matter of a
+// file may set its date, edited time, visibility and tags; relative links
+// to other files of the archive are kept.
+type Markdown struct{}
+
+func (Markdown) Read(name string, options Options) (*Result, error) {
+	archive, err := openZip(name)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{closer: archive}
+	paths := archive.paths(".md")
+	notes := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		notes[p] = true
+	}
+	for _, p := range paths {
+		data, err := archive.read(p)
+		if err != nil {
+			result.warnf("%s: %s", p, err.Error())
+			continue
+		}
+		fm, content, err := splitFrontMatter(string(data))
+		if err != nil {
+			result.warnf("%s: %s", p, err.Error())
+			continue
+		}
+		item := Item{
+			Key:       p,
+			Content:   strings.TrimSpace(content),
+			CreatedAt: fm.date("created", "date", "createdAt", "created_at"),
+			Private:   fm.private(options.Private),
+			Tags:      fm.list("tags", "categories"),
+		}
+		if item.CreatedAt.IsZero() {
+			item.CreatedAt = orNow(archive.files[p].Modified)
+		}
+		item.EditedAt = fm.date("edited", "updated", "lastmod", "editedAt", "edited_at")
+		if item.EditedAt.IsZero() {
+			item.EditedAt = item.CreatedAt
+		}
+		if title, ok := fm["title"].(string); ok && title != "" && !strings.HasPrefix(item.Content, "# ") {
+			item.Content = "# " + title + "\n\n" + item.Content
+		}
+		resolveLinks(&item, archive, notes, path.Dir(p))
+		if item.Content == "" && len(item.Attachments) == 0 {
+			result.warnf("%s: empty note skipped", p)
+			continue
+		}
+		result.Items = append(result.Items, item)
+	}
+	if len(paths) == 0 {
+		result.warnf("the archive contains no Markdown files")
+	}
+	result.sort()
+	return result, nil
+}
@@ -0,0 +1,178 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Memos imports the SQLite database of a usememos/memos instance. The
+// memos of Options.User are read, or those of the only user or the host.
+// Resources stored in the database become attachments; resources stored
+// on the disk of the old instance can not be read and are reported.
+type Memos struct{}
+
+type memosUser struct {
+	ID       int
+	Username string
+	Role     string
+}
+
+type memosMemo struct {
+	ID         int
+	CreatedTs  int64
+	UpdatedTs  int64
+	RowStatus  string
+	Content    string
+	Visibility string
+}
+
+type memosResource struct {
+	ID           int
+	MemoID       int
+	Filename     string
+	ExternalLink string
+	InternalPath string
+	HasBlob      bool
+}
+
+func (Memos) Read(name string, options Options) (*Result, error) {
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=ro"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return nil, errors.New("the file is not a SQLite database")
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	migrator := db.Migrator()
+	if !migrator.HasTable("memo") || !migrator.HasTable("user") {
+		_ = sqlDB.Close()
+		return nil, errors.New("the file is not a Memos database")
+	}
+	user, err := memosCreator(db, options.User)
+	if err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+	result := &Result{closer: sqlDB}
+	var memos []memosMemo
+	err = db.Raw("SELECT id, created_ts, updated_ts, row_status, content, visibility FROM memo WHERE creator_id = ? ORDER BY created_ts", user.ID).
+		Scan(&memos).Error
+	if err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+	resources, err := memosResources(db, user.ID)
+	if err != nil {
+		result.warnf("resources can not be read: %s", err.Error())
+	}
+	archived := 0
+	for _, m := range memos {
+		item := Item{
+			Key:       fmt.Sprint(m.ID),
+			Content:   strings.TrimSpace(m.Content),
+			CreatedAt: time.Unix(m.CreatedTs, 0),
+			EditedAt:  time.Unix(m.UpdatedTs, 0),
+			Private:   m.Visibility != "PUBLIC",
+		}
+		// Memento has no archive, so archived memos are kept private
+		if m.RowStatus == "ARCHIVED" {
+			item.Private = true
+			archived++
+		}
+		for _, r := range resources[m.ID] {
+			switch {
+			case r.HasBlob:
+				ref := item.attach(r.Filename, memosBlobOpener(db, r.ID))
+				if isImage(r.Filename) {
+					item.Content += "\n\n![image](" + ref + ")"
+				} else {
+					item.Content += "\n\n[" + r.Filename + "](" + ref + ")"
+				}
+			case r.ExternalLink != "":
+				item.Content += "\n\n[" + r.Filename + "](" + r.ExternalLink + ")"
+			default:
+				result.warnf("memo %d: %s is stored outside the database at %s", m.ID, r.Filename, r.InternalPath)
+			}
+		}
+		item.Content = strings.TrimSpace(item.Content)
+		result.Items = append(result.Items, item)
+	}
+	if archived > 0 {
+		result.warnf("%d archived memos were imported as private posts", archived)
+	}
+	return result, nil
+}
+
+// memosCreator selects the user whose memos are imported.
+func memosCreator(db *gorm.DB, username string) (*memosUser, error) {
+	var users []memosUser
+	if err := db.Raw("SELECT id, username, role FROM user").Scan(&users).Error; err != nil {
+		return nil, err
+	}
+	for i, u := range users {
+		if username != "" && u.Username == username {
+			return &users[i], nil
+		}
+	}
+	if username != "" {
+		return nil, fmt.Errorf("the user %s is not in the database", username)
+	}
+	if len(users) == 1 {
+		return &users[0], nil
+	}
+	for i, u := range users {
+		if u.Role == "HOST" {
+			return &users[i], nil
+		}
+	}
+	return nil, errors.New("the database has several users, please select one")
+}
+
+// memosResources loads the resources of the memos of a user by memo id.
+// Newer versions of Memos store the memo on the resource, older ones use a
+// relation table.
+func memosResources(db *gorm.DB, userID int) (map[int][]memosResource, error) {
+	migrator := db.Migrator()
+	columns := "resource.id, resource.filename, COALESCE(length(resource.blob), 0) > 0 AS has_blob"
+	for _, c := range []string{"external_link", "internal_path"} {
+		if migrator.HasColumn("resource", c) {
+			columns += ", resource." + c
+		}
+	}
+	var query *gorm.DB
+	if migrator.HasColumn("resource", "memo_id") {
+		query = db.Raw("SELECT "+columns+", resource.memo_id FROM resource WHERE resource.creator_id = ? AND resource.memo_id IS NOT NULL", userID)
+	} else if migrator.HasTable("memo_resource") {
+		query = db.Raw("SELECT "+columns+", memo_resource.memo_id FROM resource JOIN memo_resource ON memo_resource.resource_id = resource.id WHERE resource.creator_id = ?", userID)
+	} else {
+		return nil, nil
+	}
+	var resources []memosResource
+	if err := query.Scan(&resources).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[int][]memosResource)
+	for _, r := range resources {
+		result[r.MemoID] = append(result[r.MemoID], r)
+	}
+	return result, nil
+}
+
+func memosBlobOpener(db *gorm.DB, id int) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		var blob []byte
+		if err := db.Raw("SELECT blob FROM resource WHERE id = ?", id).Row().Scan(&blob); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(blob)), nil
+	}
+}
@@ -0,0 +1,116 @@
+package importer
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	wikiEmbedPattern = regexp.MustCompile(`!\[\[([^\]|#]+)(#[^\]|]*)?(\|[^\]]*)?\]\]`)
+	wikiLinkPattern  = regexp.MustCompile(`\[\[([^\]|#]+)(#[^\]|]*)?(\|([^\]]*))?\]\]`)
+)
+
+// Obsidian imports a zipped Obsidian vault. Wiki-links between notes become
+// links between the imported posts and embedded files become attachments.
+type Obsidian struct{}
+
+func (Obsidian) Read(name string, options Options) (*Result, error) {
+	archive, err := openZip(name)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{closer: archive}
+	paths := archive.paths(".md")
+	notes := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		notes[p] = true
+	}
+	// Obsidian resolves links by the shortest unique name, so index the
+	// files of the vault by their names as well.
+	byName := make(map[string]string)
+	for p := range archive.files {
+		if isHidden(p) {
+			continue
+		}
+		for _, key := range []string{strings.ToLower(path.Base(p)), strings.ToLower(p)} {
+			if _, ok := byName[key]; !ok {
+				byName[key] = p
+			}
+		}
+	}
+	resolve := func(target string) (string, bool) {
+		target = strings.ToLower(strings.TrimSpace(target))
+		for _, key := range []string{target, target + ".md", path.Base(target), path.Base(target) + ".md"} {
+			if p, ok := byName[key]; ok {
+				return p, true
+			}
+		}
+		return "", false
+	}
+	for _, p := range paths {
+		data, err := archive.read(p)
+		if err != nil {
+			result.warnf("%s: %s", p, err.Error())
+			continue
+		}
+		fm, content, err := splitFrontMatter(string(data))
+		if err != nil {
+			result.warnf("%s: %s", p, err.Error())
+			continue
+		}
+		modified := orNow(archive.files[p].Modified)
+		item := Item{
+			Key:       p,
+			Content:   strings.TrimSpace(content),
+			CreatedAt: fm.date("created", "date", "created_at"),
+			EditedAt:  fm.date("updated", "modified", "edited"),
+			Private:   fm.private(options.Private),
+			Tags:      fm.list("tags", "tag"),
+		}
+		if item.CreatedAt.IsZero() {
+			item.CreatedAt = modified
+		}
+		if item.EditedAt.IsZero() {
+			item.EditedAt = modified
+		}
+		title := strings.TrimSuffix(path.Base(p), path.Ext(p))
+		if !strings.HasPrefix(item.Content, "# ") {
+			item.Content = strings.TrimSpace("# " + title + "\n\n" + item.Content)
+		}
+		item.Content = wikiEmbedPattern.ReplaceAllStringFunc(item.Content, func(embed string) string {
+			m := wikiEmbedPattern.FindStringSubmatch(embed)
+			target, ok := resolve(m[1])
+			if !ok {
+				result.warnf("%s: %s not found", p, m[1])
+				return embed
+			}
+			if notes[target] {
+				return "[" + m[1] + "](" + PostLink(target) + ")"
+			}
+			ref := item.attach(path.Base(target), archive.opener(target))
+			if isImage(target) {
+				return "![image](" + ref + ")"
+			}
+			return "[" + path.Base(target) + "](" + ref + ")"
+		})
+		item.Content = wikiLinkPattern.ReplaceAllStringFunc(item.Content, func(link string) string {
+			m := wikiLinkPattern.FindStringSubmatch(link)
+			text := m[1]
+			if m[4] != "" {
+				text = m[4]
+			}
+			if target, ok := resolve(m[1]); ok && notes[target] {
+				return "[" + text + "](" + PostLink(target) + ")"
+			}
+			return text
+		})
+		resolveLinks(&item, archive, notes, path.Dir(p))
+		result.Items = append(result.Items, item)
+	}
+	if len(paths) == 0 {
+		result.warnf("the archive contains no notes")
+	}
+	result.sort()
+	return result, nil
+}
@@ -0,0 +1,165 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// tweetFilePattern matches the data files holding the tweets. Large
+// archives split them into parts.
+var tweetFilePattern = regexp.MustCompile(`^tweets?(-part\d+)?\.js$`)
+
+type tweetMedia struct {
+	URL       string `json:"url"`
+	MediaURL  string `json:"media_url_https"`
+	Type      string `json:"type"`
+	VideoInfo struct {
+		Variants []struct {
+			URL string `json:"url"`
+		} `json:"variants"`
+	} `json:"video_info"`
+}
+
+type tweet struct {
+	ID              string `json:"id_str"`
+	FullText        string `json:"full_text"`
+	CreatedAt       string `json:"created_at"`
+	InReplyToUserID string `json:"in_reply_to_user_id_str"`
+	Entities        struct {
+		URLs []struct {
+			URL         string `json:"url"`
+			ExpandedURL string `json:"expanded_url"`
+		} `json:"urls"`
+		Media []tweetMedia `json:"media"`
+	} `json:"entities"`
+	ExtendedEntities struct {
+		Media []tweetMedia `json:"media"`
+	} `json:"extended_entities"`
+}
+
+// Twitter imports the archive downloaded from Twitter/X. Retweets are
+// skipped, as are replies to other accounts unless Options.Replies is set.
+type Twitter struct{}
+
+// readTwitterData decodes a data file of the archive, which is a script
+// assigning a JSON array, e.g. "window.YTD.tweets.part0 = [...]".
+func readTwitterData(archive *zipArchive, name string, v interface{}) error {
+	data, err := archive.read(name)
+	if err != nil {
+		return err
+	}
+	if i := bytes.IndexByte(data, '='); i >= 0 && bytes.HasPrefix(data, []byte("window.")) {
+		data = data[i+1:]
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (Twitter) Read(name string, options Options) (*Result, error) {
+	archive, err := openZip(name)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{closer: archive}
+	var tweetFiles []string
+	for p := range archive.files {
+		if path.Dir(p) == "data" && tweetFilePattern.MatchString(path.Base(p)) {
+			tweetFiles = append(tweetFiles, p)
+		}
+	}
+	if len(tweetFiles) == 0 {
+		_ = archive.Close()
+		return nil, errors.New("the archive contains no data/tweets.js")
+	}
+	var accounts []struct {
+		Account struct {
+			AccountID string `json:"accountId"`
+		} `json:"account"`
+	}
+	accountID := ""
+	if err := readTwitterData(archive, "data/account.js", &accounts); err == nil && len(accounts) > 0 {
+		accountID = accounts[0].Account.AccountID
+	}
+	skipped := 0
+	for _, p := range tweetFiles {
+		var tweets []struct {
+			Tweet tweet `json:"tweet"`
+		}
+		if err := readTwitterData(archive, p, &tweets); err != nil {
+			result.warnf("%s: %s", p, err.Error())
+			continue
+		}
+		for _, t := range tweets {
+			tw := t.Tweet
+			if strings.HasPrefix(tw.FullText, "RT @") ||
+				(tw.InReplyToUserID != "" && tw.InReplyToUserID != accountID && !options.Replies) {
+				skipped++
+				continue
+			}
+			created, err := time.Parse(time.RubyDate, tw.CreatedAt)
+			if err != nil {
+				result.warnf("tweet %s: invalid date %s", tw.ID, tw.CreatedAt)
+				continue
+			}
+			item := Item{
+				Key:       tw.ID,
+				Content:   html.UnescapeString(tw.FullText),
+				CreatedAt: created,
+				EditedAt:  created,
+				Private:   options.Private,
+			}
+			for _, u := range tw.Entities.URLs {
+				item.Content = strings.ReplaceAll(item.Content, u.URL, u.ExpandedURL)
+			}
+			media := tw.ExtendedEntities.Media
+			if len(media) == 0 {
+				media = tw.Entities.Media
+			}
+			for _, m := range media {
+				item.Content = strings.TrimSpace(strings.ReplaceAll(item.Content, m.URL, ""))
+				file, ok := tweetMediaFile(archive, tw.ID, m)
+				if !ok {
+					result.warnf("tweet %s: media %s not found in the archive", tw.ID, m.MediaURL)
+					continue
+				}
+				ref := item.attach(path.Base(file), archive.opener(file))
+				if m.Type == "photo" {
+					item.Content += "\n\n![image](" + ref + ")"
+				} else {
+					item.Content += "\n\n[" + path.Base(file) + "](" + ref + ")"
+				}
+			}
+			item.Content = strings.TrimSpace(item.Content)
+			result.Items = append(result.Items, item)
+		}
+	}
+	if skipped > 0 {
+		result.warnf("%d retweets and replies were skipped", skipped)
+	}
+	result.sort()
+	return result, nil
+}
+
+// tweetMediaFile finds the file of a media entity, which the archive names
+// after the tweet and the original file name.
+func tweetMediaFile(archive *zipArchive, tweetID string, m tweetMedia) (string, bool) {
+	urls := []string{m.MediaURL}
+	for _, v := range m.VideoInfo.Variants {
+		urls = append(urls, v.URL)
+	}
+	for _, dir := range []string{"data/tweets_media", "data/tweet_media"} {
+		for _, u := range urls {
+			u, _, _ = strings.Cut(u, "?")
+			name := path.Join(dir, tweetID+"-"+path.Base(u))
+			if _, ok := archive.files[name]; ok {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,179 @@
+package mastodon
+
+// Version is reported by the instance endpoint. Clients enable features by
+// the Mastodon version, so it names the API level Memento implements.
+const Version = "4.0.0 (compatible; Memento)"
+
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+const (
+	MediaImage   = "image"
+	MediaVideo   = "video"
+	MediaAudio   = "audio"
+	MediaUnknown = "unknown"
+)
+
+const (
+	NotificationMention       = "mention"
+	NotificationFollowRequest = "follow_request"
+	NotificationFollow        = "follow"
+)
+
+// Timestamps are formatted like Mastodon does, in UTC with milliseconds.
+const TimeFormat = "2006-01-02T15:04:05.000Z"
+
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Source struct {
+	Privacy   string  `json:"privacy"`
+	Sensitive bool    `json:"sensitive"`
+	Language  string  `json:"language"`
+	Note      string  `json:"note"`
+	Fields    []Field `json:"fields"`
+}
+
+type Account struct {
+	ID             string        `json:"id"`
+	Username       string        `json:"username"`
+	Acct           string        `json:"acct"`
+	DisplayName    string        `json:"display_name"`
+	Locked         bool          `json:"locked"`
+	Bot            bool          `json:"bot"`
+	Discoverable   bool          `json:"discoverable"`
+	Group          bool          `json:"group"`
+	CreatedAt      string        `json:"created_at"`
+	Note           string        `json:"note"`
+	URL            string        `json:"url"`
+	Avatar         string        `json:"avatar"`
+	AvatarStatic   string        `json:"avatar_static"`
+	Header         string        `json:"header"`
+	HeaderStatic   string        `json:"header_static"`
+	FollowersCount int64         `json:"followers_count"`
+	FollowingCount int64         `json:"following_count"`
+	StatusesCount  int64         `json:"statuses_count"`
+	LastStatusAt   *string       `json:"last_status_at"`
+	Emojis         []interface{} `json:"emojis"`
+	Fields         []Field       `json:"fields"`
+	Source         *Source       `json:"source,omitempty"`
+}
+
+type MediaAttachment struct {
+	ID          string      `json:"id"`
+	Type        string      `json:"type"`
+	URL         string      `json:"url"`
+	PreviewURL  string      `json:"preview_url"`
+	RemoteURL   *string     `json:"remote_url"`
+	TextURL     *string     `json:"text_url"`
+	Meta        interface{} `json:"meta"`
+	Description *string     `json:"description"`
+	Blurhash    *string     `json:"blurhash"`
+}
+
+type Tag struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type Application struct {
+	Name    string  `json:"name"`
+	Website *string `json:"website"`
+}
+
+type Status struct {
+	ID                 string            `json:"id"`
+	URI                string            `json:"uri"`
+	URL                string            `json:"url"`
+	CreatedAt          string            `json:"created_at"`
+	EditedAt           *string           `json:"edited_at"`
+	Account            Account           `json:"account"`
+	Content            string            `json:"content"`
+	Text               *string           `json:"text,omitempty"`
+	Visibility         string            `json:"visibility"`
+	Sensitive          bool              `json:"sensitive"`
+	SpoilerText        string            `json:"spoiler_text"`
+	MediaAttachments   []MediaAttachment `json:"media_attachments"`
+	Mentions           []interface{}     `json:"mentions"`
+	Tags               []Tag             `json:"tags"`
+	Emojis             []interface{}     `json:"emojis"`
+	RepliesCount       int64             `json:"replies_count"`
+	ReblogsCount       int64             `json:"reblogs_count"`
+	FavouritesCount    int64             `json:"favourites_count"`
+	Favourited         bool              `json:"favourited"`
+	Reblogged          bool              `json:"reblogged"`
+	Muted              bool              `json:"muted"`
+	Bookmarked         bool              `json:"bookmarked"`
+	Pinned             bool              `json:"pinned"`
+	InReplyToID        *string           `json:"in_reply_to_id"`
+	InReplyToAccountID *string           `json:"in_reply_to_account_id"`
+	Reblog             *Status           `json:"reblog"`
+	Poll               interface{}       `json:"poll"`
+	Card               interface{}       `json:"card"`
+	Language           *string           `json:"language"`
+	Application        *Application      `json:"application,omitempty"`
+}
+
+type StatusSource struct {
+	ID          string `json:"id"`
+	Text        string `json:"text"`
+	SpoilerText string `json:"spoiler_text"`
+}
+
+type Context struct {
+	Ancestors   []Status `json:"ancestors"`
+	Descendants []Status `json:"descendants"`
+}
+
+type Relationship struct {
+	ID                  string `json:"id"`
+	Following           bool   `json:"following"`
+	ShowingReblogs      bool   `json:"showing_reblogs"`
+	Notifying           bool   `json:"notifying"`
+	FollowedBy          bool   `json:"followed_by"`
+	Blocking            bool   `json:"blocking"`
+	BlockedBy           bool   `json:"blocked_by"`
+	Muting              bool   `json:"muting"`
+	MutingNotifications bool   `json:"muting_notifications"`
+	Requested           bool   `json:"requested"`
+	DomainBlocking      bool   `json:"domain_blocking"`
+	Endorsed            bool   `json:"endorsed"`
+	Note                string `json:"note"`
+}
+
+type Notification struct {
+	ID        string  `json:"id"`
+	Type      string  `json:"type"`
+	CreatedAt string  `json:"created_at"`
+	Account   Account `json:"account"`
+	Status    *Status `json:"status,omitempty"`
+}
+
+type InstanceStats struct {
+	UserCount   int64 `json:"user_count"`
+	StatusCount int64 `json:"status_count"`
+	DomainCount int64 `json:"domain_count"`
+}
+
+type Instance struct {
+	URI              string                 `json:"uri"`
+	Title            string                 `json:"title"`
+	ShortDescription string                 `json:"short_description"`
+	Description      string                 `json:"description"`
+	Email            string                 `json:"email"`
+	Version          string                 `json:"version"`
+	URLs             map[string]string      `json:"urls"`
+	Stats            InstanceStats          `json:"stats"`
+	Thumbnail        string                 `json:"thumbnail"`
+	Languages        []string               `json:"languages"`
+	Registrations    bool                   `json:"registrations"`
+	ApprovalRequired bool                   `json:"approval_required"`
+	InvitesEnabled   bool                   `json:"invites_enabled"`
+	Configuration    map[string]interface{} `json:"configuration"`
+	ContactAccount   *Account               `json:"contact_account"`
+	Rules            []interface{}          `json:"rules"`
+}
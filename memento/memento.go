@@ -2,6 +2,8 @@ package memento
 
 import (
 	"Memento/memento/model"
+	"Memento/memento/netguard"
+	"Memento/memento/storage"
 	"Memento/memento/utils"
 	"errors"
 	"fmt"
@@ -123,6 +125,43 @@ func initFolder() error {
 		log.Errorf("Error creating avatar folder: %s\n", err.Error())
 		return err
 	}
+	if err := os.MkdirAll(GetImportPath(), 0777); err != nil {
+		log.Errorf("Error creating import folder: %s\n", err.Error())
+		return err
+	}
+	if err := os.MkdirAll(GetExportPath(), 0777); err != nil {
+		log.Errorf("Error creating export folder: %s\n", err.Error())
+		return err
+	}
+	if err := os.MkdirAll(GetSitePath(), 0777); err != nil {
+		log.Errorf("Error creating site folder: %s\n", err.Error())
+		return err
+	}
+	if err := os.MkdirAll(GetGitPath(), 0777); err != nil {
+		log.Errorf("Error creating git folder: %s\n", err.Error())
+		return err
+	}
+	return nil
+}
+
+// OpenStorage returns the storage backend of a configuration. Local blobs
+// are stored below the base folder.
+func OpenStorage(config utils.StorageConfig) (storage.Storage, error) {
+	switch config.Driver {
+	case "", "local":
+		return storage.NewLocal(GetBasePath()), nil
+	case "s3":
+		return storage.NewS3(config.Endpoint, config.Region, config.Bucket, config.AccessKey, config.SecretKey, config.PathStyle)
+	}
+	return nil, fmt.Errorf("unknown storage driver %q", config.Driver)
+}
+
+func initStorage() error {
+	s, err := OpenStorage(GetConfig().Storage)
+	if err != nil {
+		return err
+	}
+	storage.SetDefault(s)
 	return nil
 }
 
@@ -130,7 +169,7 @@ func initSearchEngine() error {
 	idx, err := bleve.Open(path.Join(GetBasePath(), "post_index.bleve"))
 	if err != nil {
 		mapping := bleve.NewIndexMapping()
-		memento.PostIndex, err = bleve.New(path.Join(GetBasePath(), "post_index.bleve"), mapping)
+		idx, err = bleve.New(path.Join(GetBasePath(), "post_index.bleve"), mapping)
 		if err != nil {
 			log.Errorf("Error creating post index: %s\n", err.Error())
 			return err
@@ -151,16 +190,67 @@ func Init() error {
 		log.Errorf("Error initializing sub-folders: %s\n", err.Error())
 		return err
 	}
+	err = initStorage()
+	if err != nil {
+		log.Errorf("Error initializing storage: %s\n", err.Error())
+		return err
+	}
+	netguard.SetDevMode(GetConfig().DevMode)
 	err = initDbConnection()
 	if err != nil {
 		log.Errorf("Error establishing database connection: %s\n", err.Error())
 		return err
 	}
+	// posts linked their files before the links were recorded
+	linked := Db().Migrator().HasTable("post_files")
 	_ = Db().AutoMigrate(&model.Tag{})
 	_ = Db().AutoMigrate(&model.File{})
+	_ = Db().AutoMigrate(&model.Blob{})
+	_ = Db().AutoMigrate(&model.UploadSession{})
 	_ = Db().AutoMigrate(&model.Comment{})
 	_ = Db().AutoMigrate(&model.Post{})
 	_ = Db().AutoMigrate(&model.User{})
+	_ = Db().AutoMigrate(&model.AuditLog{})
+	_ = Db().AutoMigrate(&model.Report{})
+	_ = Db().AutoMigrate(&model.Warning{})
+	_ = Db().AutoMigrate(&model.FollowRequest{})
+	_ = Db().AutoMigrate(&model.RemoteFollower{})
+	_ = Db().AutoMigrate(&model.RemoteLike{})
+	_ = Db().AutoMigrate(&model.AccessToken{})
+	_ = Db().AutoMigrate(&model.Webmention{})
+	_ = Db().AutoMigrate(&model.OAuthApp{})
+	_ = Db().AutoMigrate(&model.OAuthCode{})
+	_ = Db().AutoMigrate(&model.ImportJob{})
+	_ = Db().AutoMigrate(&model.ExportJob{})
+	_ = Db().AutoMigrate(&model.GitRepo{})
+	_ = Db().AutoMigrate(&model.GitFile{})
+	err = migrateRoles()
+	if err != nil {
+		log.Errorf("Error migrating user roles: %s\n", err.Error())
+		return err
+	}
+	err = migrateStorageKeys()
+	if err != nil {
+		log.Errorf("Error migrating storage keys: %s\n", err.Error())
+		return err
+	}
+	if !linked {
+		err = migratePostFiles()
+		if err != nil {
+			log.Errorf("Error linking the files of posts: %s\n", err.Error())
+			return err
+		}
+	}
+	err = recountUsedBytes()
+	if err != nil {
+		log.Errorf("Error counting used storage: %s\n", err.Error())
+		return err
+	}
+	err = failInterruptedJobs()
+	if err != nil {
+		log.Errorf("Error updating interrupted jobs: %s\n", err.Error())
+		return err
+	}
 	err = initSearchEngine()
 	if err != nil {
 		log.Errorf("Error initializing bleve search: %s\n", err.Error())
@@ -168,6 +258,164 @@ func Init() error {
 	}
 	return nil
 }
+
+// migrateRoles assigns a role to the users created before roles existed.
+func migrateRoles() error {
+	err := Db().Model(&model.User{}).
+		Where("(role IS NULL OR role = '') AND is_admin = ?", true).
+		Update("role", model.RoleAdmin).
+		Error
+	if err != nil {
+		return err
+	}
+	return Db().Model(&model.User{}).
+		Where("role IS NULL OR role = ''").
+		Update("role", model.RoleMember).
+		Error
+}
+
+// migrateStorageKeys replaces the absolute paths of the blobs stored before
+// storage backends existed with their keys, which are relative to the base
+// folder. Both are made absolute and cleaned before they are compared, as
+// the base folder may be configured relative or with a trailing slash.
+func migrateStorageKeys() error {
+	base, err := filepath.Abs(GetBasePath())
+	if err != nil {
+		return err
+	}
+	columns := []struct {
+		model  interface{}
+		column string
+	}{
+		{&model.Post{}, "content_url"},
+		{&model.File{}, "content_url"},
+		{&model.User{}, "avatar_url"},
+	}
+	for _, c := range columns {
+		// keys are skipped, so only the paths of old blobs are loaded
+		db := Db().Unscoped().Model(c.model).Where(c.column + " <> ''")
+		for _, prefix := range storage.Prefixes {
+			db = db.Where(c.column+" NOT LIKE ?", prefix+"%")
+		}
+		var rows []struct {
+			ID    uint
+			Value string
+		}
+		if err := db.Select("id", c.column+" AS value").Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			key, ok := storageKey(base, row.Value)
+			if !ok {
+				continue
+			}
+			err := Db().Unscoped().Model(c.model).
+				Where("id = ?", row.ID).
+				UpdateColumn(c.column, key).
+				Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// storageKey returns the key of the blob stored at file, or false when file
+// is not below base, which is absolute.
+func storageKey(base string, file string) (string, bool) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(base, file)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path.Clean(strings.ReplaceAll(filepath.ToSlash(rel), `\`, "/")), true
+}
+
+// LinkPostFiles records the files of the author linked from a post, whose
+// visibility follows the post.
+func LinkPostFiles(db *gorm.DB, post *model.Post, content string) error {
+	var ids []string
+	for _, m := range utils.FileLinkPattern.FindAllStringSubmatch(content, -1) {
+		ids = append(ids, m[1])
+	}
+	files := make([]*model.File, 0, len(ids))
+	if len(ids) > 0 {
+		if err := db.Where("id IN ? AND username = ?", ids, post.Username).Find(&files).Error; err != nil {
+			return err
+		}
+	}
+	return db.Model(post).Association("Files").Replace(files)
+}
+
+// LinkAllPostFiles records the files linked from every post. progress is
+// called for every post with the error of its links.
+func LinkAllPostFiles(progress func(post *model.Post, err error)) (int, error) {
+	var posts []model.Post
+	if err := Db().Order("id").Find(&posts).Error; err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := range posts {
+		content, err := utils.PostContent(&posts[i])
+		if err == nil {
+			err = LinkPostFiles(Db(), &posts[i], content)
+		}
+		if err == nil {
+			count++
+		}
+		if progress != nil {
+			progress(&posts[i], err)
+		}
+	}
+	return count, nil
+}
+
+// migratePostFiles records the files linked from the posts written before
+// links were recorded, so the attachments of private posts become private
+// on the first start after the upgrade.
+func migratePostFiles() error {
+	count, err := LinkAllPostFiles(func(post *model.Post, err error) {
+		if err != nil {
+			log.Errorf("Error linking the files of post %d: %s\n", post.ID, err.Error())
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		log.Infof("Linked the files of %d posts", count)
+	}
+	return nil
+}
+
+// recountUsedBytes sets the storage used by the users to the size of their
+// files. Files uploaded before sizes were recorded count once dedup-uploads
+// has measured them.
+func recountUsedBytes() error {
+	return Db().Exec("UPDATE users SET used_bytes = " +
+		"(SELECT COALESCE(SUM(size), 0) FROM files WHERE files.username = users.username AND files.deleted_at IS NULL)").
+		Error
+}
+
+// failInterruptedJobs marks the imports and exports which were running when
+// the server stopped as failed.
+func failInterruptedJobs() error {
+	for _, job := range []interface{}{&model.ImportJob{}, &model.ExportJob{}} {
+		err := Db().Model(job).
+			Where("status IN ?", []string{model.JobPending, model.JobRunning}).
+			Updates(map[string]interface{}{"status": model.JobFailed, "error": "interrupted by a restart"}).
+			Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func GetBasePath() string {
 	return memento.Config.BasePath
 }
@@ -184,6 +432,31 @@ func GetUploadPath() string {
 	return path.Join(memento.Config.BasePath, "upload")
 }
 
+// GetUploadSessionPath is the folder of the data of resumable uploads.
+func GetUploadSessionPath() string {
+	return path.Join(memento.Config.BasePath, "upload-sessions")
+}
+
+// GetImportPath is the folder of the uploaded archives of imports.
+func GetImportPath() string {
+	return path.Join(memento.Config.BasePath, "import")
+}
+
+// GetExportPath is the folder of the archives built by account exports.
+func GetExportPath() string {
+	return path.Join(memento.Config.BasePath, "export")
+}
+
+// GetSitePath is the folder of the static sites exported by admins.
+func GetSitePath() string {
+	return path.Join(memento.Config.BasePath, "site")
+}
+
+// GetGitPath is the folder of the Git repositories users sync the memos with.
+func GetGitPath() string {
+	return path.Join(memento.Config.BasePath, "git")
+}
+
 func GetConfig() *utils.MementoConfig {
 	return &memento.Config
 }
@@ -199,7 +472,7 @@ func Unlock() {
 	memento.lock.Unlock()
 }
 func IndexPost(post *model.Post) error {
-	content, err := os.ReadFile(post.ContentUrl)
+	content, err := utils.PostContent(post)
 	if err != nil {
 		return err
 	}
@@ -236,6 +509,7 @@ func isPublicPath(path string) bool {
 		"/api/search",
 		"/api/post/taggedPosts",
 		"/api/post/likedPosts",
+		"/api/post/mentions",
 		"/api/user/avatar",
 		"/api/user/get",
 		"/api/user/follower",
@@ -244,6 +518,7 @@ func isPublicPath(path string) bool {
 		"/api/user/login",
 		"/api/user/refresh",
 		"/api/user/create",
+		"/api/user/export/",
 		"/api/comment/userComments",
 		"/api/captcha/create",
 		"/api/captcha/verify",
@@ -274,6 +549,13 @@ func TokenValidator() echo.MiddlewareFunc {
 			if !ok {
 				return utils.RespondUnauthorized(c)
 			}
+			var user model.User
+			if err := Db().First(&user, "username=?", claims.Username).Error; err != nil {
+				return utils.RespondUnauthorized(c)
+			}
+			if restriction := user.Restriction(); restriction != "" {
+				return utils.RespondForbidden(c, restriction)
+			}
 			c.Set("username", claims.Username)
 			return next(c)
 		}
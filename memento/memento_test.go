@@ -0,0 +1,24 @@
+package memento
+
+import "testing"
+
+func TestStorageKey(t *testing.T) {
+	tests := []struct {
+		file string
+		key  string
+		ok   bool
+	}{
+		{"/srv/memento/upload/a.png", "upload/a.png", true},
+		{"/srv/memento//avatar/./b.png", "avatar/b.png", true},
+		{"/srv/memento/post/../post/c.md", "post/c.md", true},
+		{"/srv/memento", "", false},
+		{"/srv/mementos/upload/a.png", "", false},
+		{"/srv/upload/a.png", "", false},
+	}
+	for _, test := range tests {
+		key, ok := storageKey("/srv/memento", test.file)
+		if key != test.key || ok != test.ok {
+			t.Errorf("%s: got %q, %v, want %q, %v", test.file, key, ok, test.key, test.ok)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package memos
+
+// Entities of the v1 REST API of usememos/memos, which browser extensions
+// and shortcuts written for Memos talk to. Timestamps are unix seconds.
+
+const (
+	RowStatusNormal   = "NORMAL"
+	RowStatusArchived = "ARCHIVED"
+)
+
+const (
+	VisibilityPrivate = "PRIVATE"
+	// VisibilityProtected memos are visible to signed-in users in Memos.
+	// Memento has no such level and keeps them private.
+	VisibilityProtected = "PROTECTED"
+	VisibilityPublic    = "PUBLIC"
+)
+
+const (
+	RoleAdmin = "ADMIN"
+	RoleUser  = "USER"
+)
+
+type Resource struct {
+	ID           uint   `json:"id"`
+	CreatorID    uint   `json:"creatorId"`
+	CreatedTs    int64  `json:"createdTs"`
+	UpdatedTs    int64  `json:"updatedTs"`
+	Filename     string `json:"filename"`
+	ExternalLink string `json:"externalLink"`
+	Type         string `json:"type"`
+	Size         int64  `json:"size"`
+}
+
+type Memo struct {
+	ID              uint          `json:"id"`
+	RowStatus       string        `json:"rowStatus"`
+	CreatorID       uint          `json:"creatorId"`
+	CreatedTs       int64         `json:"createdTs"`
+	UpdatedTs       int64         `json:"updatedTs"`
+	DisplayTs       int64         `json:"displayTs"`
+	Content         string        `json:"content"`
+	Visibility      string        `json:"visibility"`
+	Pinned          bool          `json:"pinned"`
+	CreatorName     string        `json:"creatorName"`
+	CreatorUsername string        `json:"creatorUsername"`
+	ResourceList    []Resource    `json:"resourceList"`
+	RelationList    []interface{} `json:"relationList"`
+}
+
+type User struct {
+	ID        uint   `json:"id"`
+	RowStatus string `json:"rowStatus"`
+	CreatedTs int64  `json:"createdTs"`
+	UpdatedTs int64  `json:"updatedTs"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	Email     string `json:"email"`
+	Nickname  string `json:"nickname"`
+	AvatarURL string `json:"avatarUrl"`
+}
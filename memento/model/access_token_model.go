@@ -0,0 +1,51 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const AccessTokenPrefix = "mmt_"
+
+// Scopes of personal access tokens. The Micropub scopes keep the names
+// used by the specification.
+const (
+	ScopeCreate = "create"
+	ScopeUpdate = "update"
+	ScopeDelete = "delete"
+)
+
+var AccessTokenScopes = []string{ScopeCreate, ScopeUpdate, ScopeDelete}
+
+// AccessToken is a personal token used by third-party clients. Only the
+// SHA-256 hash of the token is stored.
+type AccessToken struct {
+	gorm.Model
+	Username   string `gorm:"index"`
+	Name       string
+	TokenHash  string `gorm:"uniqueIndex"`
+	Scope      string
+	LastUsedAt time.Time
+	// AppID is set for tokens issued to an OAuth application.
+	AppID uint `gorm:"index"`
+}
+
+func (t *AccessToken) HasScope(scope string) bool {
+	for _, s := range strings.Fields(t.Scope) {
+		if s == scope || strings.HasPrefix(scope, s+":") {
+			return true
+		}
+	}
+	return false
+}
+
+type AccessTokenViewModel struct {
+	ID         uint      `json:"id"`
+	Name       string    `json:"name"`
+	Scope      string    `json:"scope"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	Token      string    `json:"token,omitempty"`
+}
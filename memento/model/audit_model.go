@@ -0,0 +1,58 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AuditConfigSet    = "config.set"
+	AuditIconSet      = "config.icon"
+	AuditUserDelete   = "user.delete"
+	AuditUserRole     = "user.role"
+	AuditLoginLockout = "login.lockout"
+	AuditPostHide     = "post.hide"
+	AuditPostUnhide   = "post.unhide"
+	AuditUserSuspend  = "user.suspend"
+	AuditUserBan      = "user.ban"
+	AuditUserRestore  = "user.restore"
+	AuditReportAction = "report.resolve"
+	AuditSiteExport   = "site.export"
+	AuditUserQuota    = "user.quota"
+	AuditStorageClean = "storage.clean"
+)
+
+var ErrAuditLogReadOnly = errors.New("audit log is append-only")
+
+// AuditLog is an append-only record of an administrative action or a security event.
+type AuditLog struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Actor     string    `gorm:"index"`
+	Action    string    `gorm:"index"`
+	Target    string    `gorm:"index"`
+	IP        string
+	Before    string
+	After     string
+}
+
+func (l *AuditLog) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditLogReadOnly
+}
+
+func (l *AuditLog) BeforeDelete(*gorm.DB) error {
+	return ErrAuditLogReadOnly
+}
+
+type AuditLogViewModel struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	IP        string    `json:"ip"`
+	Before    string    `json:"before"`
+	After     string    `json:"after"`
+}
@@ -13,17 +13,24 @@ type Comment struct {
 	EditedAt  time.Time
 	Content   string
 	Liked     int64
+	IsHidden  bool
+	// RemoteActor is set for replies received over ActivityPub, in which
+	// case Username holds the remote handle.
+	RemoteActor string
+	RemoteName  string
+	ActivityID  string `gorm:"index"`
 }
 
 type CommentViewModel struct {
-	CommentID uint          `json:"commentId"`
-	PostID    uint          `json:"postId"`
-	User      UserViewModel `json:"user"`
-	CreatedAt time.Time     `json:"createdAt"`
-	EditedAt  time.Time     `json:"editedAt"`
-	Content   string        `json:"content"`
-	Liked     int64         `json:"liked"`
-	IsLiked   bool          `json:"isLiked"`
+	CommentID   uint          `json:"commentId"`
+	PostID      uint          `json:"postId"`
+	User        UserViewModel `json:"user"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	EditedAt    time.Time     `json:"editedAt"`
+	Content     string        `json:"content"`
+	Liked       int64         `json:"liked"`
+	IsLiked     bool          `json:"isLiked"`
+	RemoteActor string        `json:"remoteActor,omitempty"`
 }
 
 type CommentWithPost struct {
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExportJob builds the archive of all data of a user in the background.
+// The archive is removed when its download link expires.
+type ExportJob struct {
+	gorm.Model
+	Username string `gorm:"index"`
+	Status   string
+	// Archive is the path of the built archive.
+	Archive    string
+	Size       int64
+	Error      string
+	FinishedAt time.Time
+	ExpiresAt  time.Time
+}
+
+type ExportJobViewModel struct {
+	ID          uint       `json:"id"`
+	Status      string     `json:"status"`
+	Size        int64      `json:"size"`
+	Error       string     `json:"error"`
+	DownloadUrl string     `json:"downloadUrl,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	FinishedAt  *time.Time `json:"finishedAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+}
@@ -0,0 +1,23 @@
+package model
+
+import (
+	"gorm.io/gorm"
+)
+
+// RemoteFollower is an ActivityPub actor on another server following a local user.
+type RemoteFollower struct {
+	gorm.Model
+	Username    string `gorm:"index"`
+	ActorID     string `gorm:"index"`
+	Inbox       string
+	SharedInbox string
+	FollowID    string
+}
+
+// RemoteLike is a Like activity received for a local post.
+type RemoteLike struct {
+	gorm.Model
+	PostID     uint   `gorm:"index"`
+	ActorID    string `gorm:"index"`
+	ActivityID string `gorm:"index"`
+}
@@ -11,10 +11,96 @@ type File struct {
 	Username   string
 	Filename   string
 	ContentUrl string
+	// Hash is the SHA-256 of the content, whose blob is shared by all files
+	// with the same content. Files uploaded before deduplication have none.
+	Hash string `gorm:"index"`
+	Size int64
+	// MimeType is sniffed from the content when the file is uploaded.
+	MimeType string
+	// Width and Height are the size of images as they are shown, 0 for
+	// other files.
+	Width  int
+	Height int
+	// Visibility is FilePublic or FilePrivate when the owner set it, and
+	// empty when the file follows the posts linking it.
+	Visibility string
+	// Duration is the length of audio in seconds, and Codec its encoding.
+	Duration float64
+	Codec    string
+	// Transcript is the text spoken in audio when a transcriber is set.
+	Transcript string
+}
+
+// Visibilities of files. Files without one are private unless a post anyone
+// may open links them.
+const (
+	FilePublic  = "public"
+	FilePrivate = "private"
+)
+
+// Blob is the stored content of uploaded files, counted by the files which
+// reference it.
+type Blob struct {
+	Hash      string `gorm:"primaryKey"`
+	Key       string
+	Size      int64
+	RefCount  int64
+	CreatedAt time.Time
 }
 
 type FileViewModel struct {
 	ID       uint      `json:"id"`
 	Filename string    `json:"filename"`
 	Time     time.Time `json:"time"`
+	MimeType string    `json:"mimeType"`
+	Width    int       `json:"width"`
+	Height   int       `json:"height"`
+	// Visibility is empty when the file follows its posts, and IsPrivate
+	// tells the resulting visibility.
+	Visibility string `json:"visibility"`
+	IsPrivate  bool   `json:"isPrivate"`
+	// Posts are the posts of the owner linking the file.
+	Posts []uint `json:"posts"`
+	// Duration is in seconds, 0 for files which are not audio.
+	Duration   float64 `json:"duration"`
+	Codec      string  `json:"codec,omitempty"`
+	Transcript string  `json:"transcript,omitempty"`
+}
+
+// StorageReportViewModel shows how much space the deduplication of uploads
+// saves. Files uploaded before deduplication are only counted.
+type StorageReportViewModel struct {
+	Files        int64 `json:"files"`
+	Blobs        int64 `json:"blobs"`
+	LegacyFiles  int64 `json:"legacyFiles"`
+	LogicalBytes int64 `json:"logicalBytes"`
+	StoredBytes  int64 `json:"storedBytes"`
+	SavedBytes   int64 `json:"savedBytes"`
+}
+
+// QuotaViewModel is the storage usage of a user. Limits of 0 mean there is
+// none.
+type QuotaViewModel struct {
+	UsedBytes     int64    `json:"usedBytes"`
+	QuotaBytes    int64    `json:"quotaBytes"`
+	MaxUploadSize int64    `json:"maxUploadSize"`
+	AllowedTypes  []string `json:"allowedTypes"`
+}
+
+// OrphanFileViewModel is an upload no post or comment links.
+type OrphanFileViewModel struct {
+	ID        uint      `json:"id"`
+	Username  string    `json:"username"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// OrphanReportViewModel lists the uploads nothing links and the stored blobs
+// nothing references, like replaced avatars and variants of removed images.
+type OrphanReportViewModel struct {
+	Files   []OrphanFileViewModel `json:"files"`
+	Blobs   []string              `json:"blobs"`
+	Bytes   int64                 `json:"bytes"`
+	Removed bool                  `json:"removed"`
 }
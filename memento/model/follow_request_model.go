@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	FollowRequestPending  = "pending"
+	FollowRequestApproved = "approved"
+	FollowRequestRejected = "rejected"
+)
+
+// FollowRequest is created when someone follows a protected account and
+// waits for the approval of the account owner.
+type FollowRequest struct {
+	gorm.Model
+	Requester string `gorm:"index"`
+	Target    string `gorm:"index"`
+	Status    string `gorm:"index"`
+}
+
+type FollowRequestViewModel struct {
+	ID        uint          `json:"id"`
+	User      UserViewModel `json:"user"`
+	Status    string        `json:"status"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GitRepo is the Git repository a user syncs the memos with. Every change of
+// a post is committed and pushed changes are applied to the posts.
+type GitRepo struct {
+	gorm.Model
+	Username string `gorm:"uniqueIndex"`
+	// Head is the last commit whose files match the posts.
+	Head string
+	// Warnings are the problems found applying the last push.
+	Warnings string
+	PushedAt time.Time
+}
+
+// GitFile is the file of a post in the repository of its author.
+type GitFile struct {
+	ID       uint   `gorm:"primarykey"`
+	Username string `gorm:"index"`
+	PostID   uint   `gorm:"uniqueIndex"`
+	Path     string
+}
+
+type GitRepoViewModel struct {
+	Enabled  bool       `json:"enabled"`
+	CloneUrl string     `json:"cloneUrl,omitempty"`
+	Head     string     `json:"head,omitempty"`
+	Warnings []string   `json:"warnings"`
+	PushedAt *time.Time `json:"pushedAt"`
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ImportJob is the import of an uploaded archive, which runs in the
+// background. A dry run only reads the archive and keeps it, so the import
+// can be started after reviewing the preview.
+type ImportJob struct {
+	gorm.Model
+	Username string `gorm:"index"`
+	Source   string
+	Filename string
+	// Archive is the path of the uploaded archive.
+	Archive    string
+	Options    string
+	DryRun     bool
+	Status     string
+	Total      int
+	Done       int
+	Imported   int
+	Files      int
+	Error      string
+	Warnings   string
+	Preview    string
+	FinishedAt time.Time
+}
+
+// ImportPreviewItem describes a post an import would create.
+type ImportPreviewItem struct {
+	Excerpt     string    `json:"excerpt"`
+	CreatedAt   time.Time `json:"createdAt"`
+	EditedAt    time.Time `json:"editedAt"`
+	IsPrivate   bool      `json:"isPrivate"`
+	Tags        []string  `json:"tags"`
+	Attachments int       `json:"attachments"`
+}
+
+type ImportJobViewModel struct {
+	ID         uint                `json:"id"`
+	Source     string              `json:"source"`
+	Filename   string              `json:"filename"`
+	DryRun     bool                `json:"dryRun"`
+	Status     string              `json:"status"`
+	Total      int                 `json:"total"`
+	Done       int                 `json:"done"`
+	Imported   int                 `json:"imported"`
+	Files      int                 `json:"files"`
+	Error      string              `json:"error"`
+	Warnings   []string            `json:"warnings"`
+	Preview    []ImportPreviewItem `json:"preview,omitempty"`
+	CreatedAt  time.Time           `json:"createdAt"`
+	FinishedAt *time.Time          `json:"finishedAt"`
+}
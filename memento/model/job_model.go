@@ -0,0 +1,12 @@
+package model
+
+// Statuses of the jobs which run in the background, like imports and
+// exports.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+	// JobExpired is the status of an export whose archive was removed.
+	JobExpired = "expired"
+)
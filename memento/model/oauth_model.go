@@ -0,0 +1,58 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scopes of tokens issued to OAuth applications. A scope also grants its
+// granular variants, e.g. "write" grants "write:statuses".
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeFollow = "follow"
+	// ScopePush is accepted since most clients ask for it, but there are no
+	// push endpoints.
+	ScopePush = "push"
+)
+
+var OAuthScopes = []string{ScopeRead, ScopeWrite, ScopeFollow, ScopePush}
+
+// OAuthRedirectOOB is the redirect uri of clients which show the
+// authorization code to the user instead of receiving it.
+const OAuthRedirectOOB = "urn:ietf:wg:oauth:2.0:oob"
+
+// OAuthApp is a client application registered through the Mastodon
+// compatible /api/v1/apps endpoint.
+type OAuthApp struct {
+	gorm.Model
+	Name         string
+	Website      string
+	RedirectURIs string
+	Scopes       string
+	ClientID     string `gorm:"uniqueIndex"`
+	ClientSecret string
+}
+
+func (a *OAuthApp) AllowsRedirect(uri string) bool {
+	for _, u := range strings.Fields(a.RedirectURIs) {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// OAuthCode is a short-lived authorization code, exchanged by the
+// application for an access token.
+type OAuthCode struct {
+	gorm.Model
+	AppID       uint
+	Username    string
+	CodeHash    string `gorm:"uniqueIndex"`
+	RedirectURI string
+	Scope       string
+	ExpiresAt   time.Time
+}
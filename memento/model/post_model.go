@@ -5,22 +5,35 @@ import (
 	"time"
 )
 
+// ContentGzip is the encoding of post contents compressed with gzip.
+const ContentGzip = "gzip"
+
 type Post struct {
 	gorm.Model
 	IsPrivate    bool
+	IsHidden     bool
 	Username     string
 	TotalLiked   int64
 	CreatedAt    time.Time
 	EditedAt     time.Time
 	TotalComment int64
-	ContentUrl   string
-	Comments     []Comment
-	Tags         []*Tag `gorm:"many2many:post_tags;"`
+	// ContentUrl is the storage key of the content of posts stored as
+	// files. It is empty for posts whose content is kept in Content.
+	ContentUrl string
+	Content    []byte
+	// ContentEncoding is ContentGzip for compressed content.
+	ContentEncoding string
+	Comments        []Comment
+	Tags            []*Tag `gorm:"many2many:post_tags;"`
+	// Files are the uploads of the author linked from the content, whose
+	// visibility follows the post.
+	Files []*File `gorm:"many2many:post_files;"`
 }
 
 type PostViewModel struct {
 	IsLiked      bool          `json:"isLiked"`
 	IsPrivate    bool          `json:"isPrivate"`
+	IsHidden     bool          `json:"isHidden"`
 	PostID       uint          `json:"postID"`
 	User         UserViewModel `json:"user"`
 	TotalLiked   int64         `json:"totalLiked"`
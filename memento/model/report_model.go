@@ -0,0 +1,68 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ReportTargetPost    = "post"
+	ReportTargetComment = "comment"
+	ReportTargetUser    = "user"
+)
+
+const (
+	ReportStatusPending   = "pending"
+	ReportStatusResolved  = "resolved"
+	ReportStatusDismissed = "dismissed"
+)
+
+const (
+	ModerationHide    = "hide"
+	ModerationWarn    = "warn"
+	ModerationSuspend = "suspend"
+	ModerationDismiss = "dismiss"
+)
+
+type Report struct {
+	gorm.Model
+	Reporter   string `gorm:"index"`
+	TargetType string
+	Target     string
+	Reason     string
+	Status     string `gorm:"index"`
+	Action     string
+	Moderator  string
+	Note       string
+	ResolvedAt time.Time
+}
+
+type Warning struct {
+	gorm.Model
+	Username  string `gorm:"index"`
+	Moderator string
+	Reason    string
+	ReportID  uint
+}
+
+type ReportViewModel struct {
+	ID         uint      `json:"id"`
+	Reporter   string    `json:"reporter"`
+	TargetType string    `json:"targetType"`
+	Target     string    `json:"target"`
+	Reason     string    `json:"reason"`
+	Status     string    `json:"status"`
+	Action     string    `json:"action"`
+	Moderator  string    `json:"moderator,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ResolvedAt time.Time `json:"resolvedAt"`
+}
+
+type WarningViewModel struct {
+	ID        uint      `json:"id"`
+	Reason    string    `json:"reason"`
+	ReportID  uint      `json:"reportId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
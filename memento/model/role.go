@@ -0,0 +1,83 @@
+package model
+
+type Role string
+
+type Permission string
+
+const (
+	RoleAdmin      Role = "admin"
+	RoleModerator  Role = "moderator"
+	RoleMember     Role = "member"
+	RoleReadOnly   Role = "read-only"
+	RoleRestricted Role = "restricted"
+)
+
+const (
+	PermPostWrite     Permission = "post.write"
+	PermPostLike      Permission = "post.like"
+	PermCommentWrite  Permission = "comment.write"
+	PermFileUpload    Permission = "file.upload"
+	PermUserFollow    Permission = "user.follow"
+	PermProfileEdit   Permission = "user.edit"
+	PermPostHide      Permission = "moderation.post.hide"
+	PermCommentDelete Permission = "moderation.comment.delete"
+	PermReportHandle  Permission = "moderation.report"
+	PermUserSuspend   Permission = "moderation.user.suspend"
+	PermConfigManage  Permission = "admin.config"
+	PermUserManage    Permission = "admin.users"
+	PermRoleManage    Permission = "admin.roles"
+	PermAuditView     Permission = "admin.audit"
+)
+
+// Roles lists all roles from the most to the least privileged one.
+var Roles = []Role{RoleAdmin, RoleModerator, RoleMember, RoleRestricted, RoleReadOnly}
+
+var memberPermissions = []Permission{
+	PermPostWrite,
+	PermPostLike,
+	PermCommentWrite,
+	PermFileUpload,
+	PermUserFollow,
+	PermProfileEdit,
+}
+
+var RolePermissions = map[Role][]Permission{
+	RoleAdmin: append(append([]Permission{}, memberPermissions...),
+		PermPostHide,
+		PermCommentDelete,
+		PermReportHandle,
+		PermUserSuspend,
+		PermConfigManage,
+		PermUserManage,
+		PermRoleManage,
+		PermAuditView,
+	),
+	RoleModerator: append(append([]Permission{}, memberPermissions...),
+		PermPostHide,
+		PermCommentDelete,
+		PermReportHandle,
+		PermUserSuspend,
+	),
+	RoleMember: memberPermissions,
+	RoleRestricted: {
+		PermPostWrite,
+		PermPostLike,
+		PermCommentWrite,
+		PermProfileEdit,
+	},
+	RoleReadOnly: {},
+}
+
+func (r Role) IsValid() bool {
+	_, ok := RolePermissions[r]
+	return ok
+}
+
+func (r Role) HasPermission(permission Permission) bool {
+	for _, p := range RolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// UploadSession is a resumable upload following the tus protocol. The data
+// received so far is kept in a file until the upload is complete and saved
+// as a file of the user.
+type UploadSession struct {
+	ID       string `gorm:"primaryKey"`
+	Username string `gorm:"index"`
+	Filename string
+	Length   int64
+	Offset   int64
+	// Metadata is the Upload-Metadata header the upload was created with.
+	Metadata string
+	// Checksum is the SHA-256 of the whole file in hex, if the client sent
+	// one to verify it.
+	Checksum string
+	// TypeChecked is set once the sniffed type of the data passed the
+	// upload policy.
+	TypeChecked bool
+	// FileID is the file the complete upload was saved as.
+	FileID    uint
+	CreatedAt time.Time
+	// ExpiresAt is a day after the last chunk. Expired sessions are removed
+	// with their data.
+	ExpiresAt time.Time `gorm:"index"`
+}
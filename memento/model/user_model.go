@@ -8,27 +8,42 @@ import (
 
 type User struct {
 	gorm.Model
-	Username      string `gorm:"uniqueIndex"`
-	PasswordHash  string
-	PasswordRetry int
-	LockUntil     time.Time
-	AvatarUrl     string
-	Nickname      string
-	Bio           string
-	TotalLiked    int64
-	TotalComment  int64
-	TotalPosts    int64
-	TotalFiles    int64
-	TotalFollower int64
-	TotalFollows  int64
-	RegisteredAt  time.Time
-	IsAdmin       bool
-	Posts         []Post    `gorm:"foreignKey:Username;references:Username"`
-	Files         []File    `gorm:"foreignKey:Username;references:Username"`
-	Follows       []User    `gorm:"many2many:user_follows;joinForeignKey:UserID;JoinReferences:FollowID"`
-	Likes         []Post    `gorm:"many2many:user_liked_posts;foreignKey:Username;"`
-	Comments      []Comment `gorm:"foreignKey:Username;references:Username"`
-	LikedComments []Comment `gorm:"many2many:user_liked_comments;foreignKey:Username;"`
+	Username       string `gorm:"uniqueIndex"`
+	PasswordHash   string
+	PasswordRetry  int
+	LockUntil      time.Time
+	AvatarUrl      string
+	Nickname       string
+	Bio            string
+	TotalLiked     int64
+	TotalComment   int64
+	TotalPosts     int64
+	TotalFiles     int64
+	TotalFollower  int64
+	TotalFollows   int64
+	RegisteredAt   time.Time
+	IsAdmin        bool
+	Role           Role
+	SuspendedUntil time.Time
+	SuspendReason  string
+	IsBanned       bool
+	BanReason      string
+	IsProtected    bool
+	ApPublicKey    string
+	ApPrivateKey   string
+	Posts          []Post    `gorm:"foreignKey:Username;references:Username"`
+	Files          []File    `gorm:"foreignKey:Username;references:Username"`
+	Follows        []User    `gorm:"many2many:user_follows;joinForeignKey:UserID;JoinReferences:FollowID"`
+	Blocks         []User    `gorm:"many2many:user_blocks;joinForeignKey:UserID;JoinReferences:BlockID"`
+	Mutes          []User    `gorm:"many2many:user_mutes;joinForeignKey:UserID;JoinReferences:MuteID"`
+	Likes          []Post    `gorm:"many2many:user_liked_posts;foreignKey:Username;"`
+	Comments       []Comment `gorm:"foreignKey:Username;references:Username"`
+	LikedComments  []Comment `gorm:"many2many:user_liked_comments;foreignKey:Username;"`
+	// UsedBytes is the size of the files of the user.
+	UsedBytes int64 `gorm:"not null;default:0"`
+	// StorageQuota limits UsedBytes. 0 applies the default quota and a
+	// negative quota none.
+	StorageQuota int64 `gorm:"not null;default:0"`
 }
 
 type UserViewModel struct {
@@ -44,5 +59,38 @@ type UserViewModel struct {
 	RegisteredAt  time.Time `json:"registeredAt"`
 	Avatar        string    `json:"avatar"`
 	IsFollowed    bool      `json:"isFollowed"`
+	IsBlocked     bool      `json:"isBlocked"`
+	IsMuted       bool      `json:"isMuted"`
+	IsProtected   bool      `json:"isProtected"`
+	IsRequested   bool      `json:"isRequested"`
 	IsAdmin       bool      `json:"isAdmin"`
+	Role          Role      `json:"role"`
+}
+
+// EffectiveRole returns the role of the user, falling back to the legacy
+// IsAdmin flag for accounts created before roles existed.
+func (u *User) EffectiveRole() Role {
+	if u.Role.IsValid() {
+		return u.Role
+	}
+	if u.IsAdmin {
+		return RoleAdmin
+	}
+	return RoleMember
+}
+
+// Restriction returns a message describing why the user may not use the
+// service at the moment, or an empty string if the account is in good standing.
+func (u *User) Restriction() string {
+	if u.IsBanned {
+		return "account banned: " + u.BanReason
+	}
+	if u.SuspendedUntil.After(time.Now()) {
+		return "account suspended until " + u.SuspendedUntil.Format(time.RFC3339) + ": " + u.SuspendReason
+	}
+	return ""
+}
+
+func (u *User) HasPermission(permission Permission) bool {
+	return u.EffectiveRole().HasPermission(permission)
 }
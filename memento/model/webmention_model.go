@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	WebmentionPending  = "pending"
+	WebmentionAccepted = "accepted"
+	WebmentionRejected = "rejected"
+)
+
+// Webmention is a mention of a post received from another site.
+type Webmention struct {
+	gorm.Model
+	PostID     uint   `gorm:"index"`
+	Source     string `gorm:"index"`
+	Target     string
+	Author     string
+	Title      string
+	Content    string
+	Status     string `gorm:"index"`
+	VerifiedAt time.Time
+}
+
+type WebmentionViewModel struct {
+	ID        uint      `json:"id"`
+	PostID    uint      `json:"postId"`
+	Source    string    `json:"source"`
+	Author    string    `json:"author"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,139 @@
+// Package netguard keeps the requests to urls chosen by remote parties, like
+// the key ids of ActivityPub and the sources of webmentions, out of the
+// network of the server: loopback, private and link-local addresses, which
+// include the metadata services of clouds.
+package netguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrAddress is returned for urls and connections to addresses which
+	// are not public.
+	ErrAddress = errors.New("address is not public")
+	// ErrScheme is returned for urls with a scheme which is not allowed.
+	ErrScheme = errors.New("url scheme is not allowed")
+)
+
+var devMode atomic.Bool
+
+// SetDevMode allows plain http and the addresses of the local network, to
+// test federation with local instances.
+func SetDevMode(enabled bool) {
+	devMode.Store(enabled)
+}
+
+// reserved are the ranges which are neither private nor loopback but still
+// not reachable on the internet, or which translate into other addresses.
+var reserved = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// IsPublic reports whether an address is reachable on the internet.
+func IsPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range reserved {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckURL checks the scheme of a url, which is https or, unless httpsOnly
+// is set, http, and its host when it is an address or localhost. The
+// addresses of other hosts are only known when they are dialed.
+func CheckURL(rawURL string, httpsOnly bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && (!httpsOnly || devMode.Load()):
+	default:
+		return fmt.Errorf("%w: %s", ErrScheme, rawURL)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return fmt.Errorf("invalid url: %s", rawURL)
+	}
+	if devMode.Load() {
+		return nil
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrAddress, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !IsPublic(addr) {
+		return fmt.Errorf("%w: %s", ErrAddress, host)
+	}
+	return nil
+}
+
+// control refuses connections to addresses which are not public. It is
+// called with the resolved address, so host names which resolve into the
+// local network are refused as well.
+func control(network string, address string, _ syscall.RawConn) error {
+	if devMode.Load() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !IsPublic(addr) {
+		return fmt.Errorf("%w: %s", ErrAddress, host)
+	}
+	return nil
+}
+
+// NewClient returns a client which only connects to public addresses, and
+// follows redirects to urls passing CheckURL.
+func NewClient(timeout time.Duration, httpsOnly bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control:   control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would connect to the addresses instead of the dialer
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return CheckURL(req.URL.String(), httpsOnly)
+		},
+	}
+}
@@ -0,0 +1,101 @@
+package netguard
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsPublic(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.100.100.200":  false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fd00::1":          false,
+		"fe80::1":          false,
+		"::ffff:127.0.0.1": false,
+		"64:ff9b::a00:1":   false,
+	} {
+		if got := IsPublic(netip.MustParseAddr(addr)); got != public {
+			t.Errorf("IsPublic(%s) = %v, want %v", addr, got, public)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for _, c := range []struct {
+		url       string
+		httpsOnly bool
+		err       error
+	}{
+		{"https://example.com/users/alice", true, nil},
+		{"http://example.com/users/alice", false, nil},
+		{"http://example.com/users/alice", true, ErrScheme},
+		{"file:///etc/passwd", false, ErrScheme},
+		{"gopher://example.com", false, ErrScheme},
+		{"https://127.0.0.1/actor", true, ErrAddress},
+		{"https://[::1]:8443/actor", true, ErrAddress},
+		{"http://169.254.169.254/latest/meta-data/", false, ErrAddress},
+		{"https://localhost/actor", true, ErrAddress},
+		{"https://api.localhost./actor", true, ErrAddress},
+	} {
+		err := CheckURL(c.url, c.httpsOnly)
+		if c.err == nil && err != nil || c.err != nil && !errors.Is(err, c.err) {
+			t.Errorf("CheckURL(%q, %v) = %v, want %v", c.url, c.httpsOnly, err, c.err)
+		}
+	}
+}
+
+func TestDevMode(t *testing.T) {
+	SetDevMode(true)
+	defer SetDevMode(false)
+	if err := CheckURL("http://127.0.0.1:3000/actor", true); err != nil {
+		t.Errorf("CheckURL in dev mode: %v", err)
+	}
+}
+
+func TestClientRefusesLocalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	client := NewClient(time.Second, false)
+	// localhost is resolved before the connection is checked
+	for _, u := range []string{server.URL, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)} {
+		resp, err := client.Get(u)
+		if err == nil {
+			_ = resp.Body.Close()
+			t.Errorf("GET %s succeeded", u)
+		} else if !errors.Is(err, ErrAddress) {
+			t.Errorf("GET %s: %v, want %v", u, err, ErrAddress)
+		}
+	}
+}
+
+func TestClientChecksRedirects(t *testing.T) {
+	SetDevMode(true)
+	defer SetDevMode(false)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	}))
+	defer server.Close()
+	resp, err := NewClient(time.Second, false).Get(server.URL)
+	if err == nil {
+		_ = resp.Body.Close()
+		t.Fatal("redirect to file url followed")
+	}
+	if !errors.Is(err, ErrScheme) {
+		t.Errorf("got %v, want %v", err, ErrScheme)
+	}
+}
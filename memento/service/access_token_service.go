@@ -0,0 +1,210 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidAccessToken = errors.New("invalid token")
+	errInsufficientScope  = errors.New("insufficient scope")
+)
+
+func HandleCreateAccessToken(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	name := c.FormValue("name")
+	if name == "" || len([]rune(name)) > 50 {
+		return utils.RespondError(c, "invalid name")
+	}
+	scope := strings.Fields(c.FormValue("scope"))
+	if len(scope) == 0 {
+		scope = model.AccessTokenScopes
+	}
+	for _, s := range scope {
+		if !utils.Contains(model.AccessTokenScopes, s) {
+			return utils.RespondError(c, "invalid scope")
+		}
+	}
+	secret, token, err := newAccessToken(username, name, strings.Join(scope, " "), 0)
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	// the token is only shown once
+	view := accessTokenToView(token)
+	view.Token = secret
+	return c.JSON(http.StatusOK, view)
+}
+
+// newAccessToken stores a new token and returns its secret, which is not
+// stored and can not be recovered later.
+func newAccessToken(username string, name string, scope string, appID uint) (string, *model.AccessToken, error) {
+	secret, err := randomSecret(model.AccessTokenPrefix)
+	if err != nil {
+		log.Errorf(err.Error())
+		return "", nil, errors.New("token generation failed")
+	}
+	token := model.AccessToken{
+		Username:  username,
+		Name:      name,
+		TokenHash: hashAccessToken(secret),
+		Scope:     scope,
+		AppID:     appID,
+	}
+	if err := memento.Db().Create(&token).Error; err != nil {
+		log.Errorf(err.Error())
+		return "", nil, errors.New("unknown insertion error")
+	}
+	return secret, &token, nil
+}
+
+func randomSecret(prefix string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(raw), nil
+}
+
+func HandleGetAccessTokens(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	var tokens []model.AccessToken
+	err := memento.Db().Order("created_at desc").Find(&tokens, "username = ?", username).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	result := make([]model.AccessTokenViewModel, 0, len(tokens))
+	for _, t := range tokens {
+		result = append(result, *accessTokenToView(&t))
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func HandleDeleteAccessToken(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	result := memento.Db().Delete(&model.AccessToken{}, "id = ? AND username = ?", c.Param("id"), username)
+	if result.Error != nil {
+		log.Errorf(result.Error.Error())
+		return utils.RespondError(c, "unknown deletion error")
+	}
+	if result.RowsAffected == 0 {
+		return utils.RespondError(c, "token not exists")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func accessTokenToView(t *model.AccessToken) *model.AccessTokenViewModel {
+	return &model.AccessTokenViewModel{
+		ID:         t.ID,
+		Name:       t.Name,
+		Scope:      t.Scope,
+		CreatedAt:  t.CreatedAt,
+		LastUsedAt: t.LastUsedAt,
+	}
+}
+
+func hashAccessToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticateAccessToken returns the owner of a personal access token which
+// has the given scope. Restricted accounts are rejected.
+func authenticateAccessToken(secret string, scope string) (*model.User, *model.AccessToken, error) {
+	if !strings.HasPrefix(secret, model.AccessTokenPrefix) {
+		return nil, nil, errInvalidAccessToken
+	}
+	var token model.AccessToken
+	if err := memento.Db().First(&token, "token_hash = ?", hashAccessToken(secret)).Error; err != nil {
+		return nil, nil, errInvalidAccessToken
+	}
+	if scope != "" && !token.HasScope(scope) {
+		return nil, nil, errInsufficientScope
+	}
+	var user model.User
+	if err := memento.Db().First(&user, "username=?", token.Username).Error; err != nil {
+		return nil, nil, errInvalidAccessToken
+	}
+	if restriction := user.Restriction(); restriction != "" {
+		return nil, nil, errors.New(restriction)
+	}
+	if err := memento.Db().Model(&token).Update("last_used_at", time.Now()).Error; err != nil {
+		log.Errorf(err.Error())
+	}
+	return &user, &token, nil
+}
+
+// basicAuthAccessToken authenticates clients like Git and WebDAV, which log in
+// with the username and a personal access token as the password. Otherwise
+// it challenges the client and reports false.
+func basicAuthAccessToken(c echo.Context, username string) (*model.User, *model.AccessToken, bool) {
+	name, secret, ok := c.Request().BasicAuth()
+	if ok {
+		user, token, err := authenticateAccessToken(secret, "")
+		if err == nil && user.Username == username && (name == "" || name == username) {
+			return user, token, true
+		}
+	}
+	c.Response().Header().Set("WWW-Authenticate", `Basic realm="Memento"`)
+	_ = c.String(http.StatusUnauthorized, "authentication required")
+	return nil, nil, false
+}
+
+// AccessTokenAuth authenticates the requests of third-party clients by a
+// bearer access token. Requests without a token are anonymous.
+func AccessTokenAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Set("username", "")
+		secret, found := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+		if !found || secret == "" {
+			return next(c)
+		}
+		user, token, err := authenticateAccessToken(secret, "")
+		if err != nil {
+			if errors.Is(err, errInvalidAccessToken) {
+				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "The access token is invalid"})
+			}
+			return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+		}
+		c.Set("username", user.Username)
+		c.Set("accessToken", token)
+		return next(c)
+	}
+}
+
+// RequireScope rejects requests whose access token has none of the scopes.
+func RequireScope(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token, ok := c.Get("accessToken").(*model.AccessToken)
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "The access token is invalid"})
+			}
+			for _, scope := range scopes {
+				if token.HasScope(scope) {
+					return next(c)
+				}
+			}
+			return c.JSON(http.StatusForbidden, echo.Map{"error": "This action is outside the authorized scopes"})
+		}
+	}
+}
@@ -0,0 +1,712 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/activitypub"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"github.com/k3a/html2text"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxInboxSize limits the size of activities accepted by the inboxes.
+const maxInboxSize = 1 << 20
+
+// RequireFederation hides the ActivityPub endpoints unless federation is enabled.
+func RequireFederation(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !memento.GetConfig().EnableFederation {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return next(c)
+	}
+}
+
+// federationBase returns the base url of all ActivityPub ids.
+func federationBase() string {
+	if base := memento.GetConfig().PublicUrl; base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return scheme + "://" + domain
+}
+
+func federationHost(base string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return domain
+	}
+	return u.Host
+}
+
+func actorID(base string, username string) string {
+	return base + "/ap/users/" + username
+}
+
+func noteID(base string, postID uint) string {
+	return base + "/ap/posts/" + strconv.Itoa(int(postID))
+}
+
+// postIDFromNote returns the local post referenced by a note id.
+func postIDFromNote(base string, id string) (uint, bool) {
+	raw, found := strings.CutPrefix(id, base+"/ap/posts/")
+	if !found {
+		return 0, false
+	}
+	postID, err := strconv.Atoi(raw)
+	if err != nil || postID <= 0 {
+		return 0, false
+	}
+	return uint(postID), true
+}
+
+func usernameFromActor(base string, id string) (string, bool) {
+	username, found := strings.CutPrefix(id, base+"/ap/users/")
+	if !found || username == "" || strings.Contains(username, "/") {
+		return "", false
+	}
+	return username, true
+}
+
+func respondActivity(c echo.Context, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return utils.RespondInternalError(c, "marshal error")
+	}
+	return c.Blob(http.StatusOK, activitypub.ContentType, data)
+}
+
+// federatedUser loads a user published as an actor. Protected, banned and
+// suspended accounts are not federated.
+func federatedUser(username string) (*model.User, error) {
+	var user model.User
+	if err := memento.Db().First(&user, "username=?", username).Error; err != nil {
+		return nil, err
+	}
+	if user.IsProtected || user.Restriction() != "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &user, nil
+}
+
+// actorSigner returns the signer of a user, generating the key pair on first use.
+func actorSigner(base string, user *model.User) (*activitypub.Signer, error) {
+	if user.ApPrivateKey == "" {
+		privateKey, publicKey, err := activitypub.GenerateKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		err = memento.Db().Model(user).Updates(map[string]interface{}{
+			"ap_private_key": privateKey,
+			"ap_public_key":  publicKey,
+		}).Error
+		if err != nil {
+			return nil, err
+		}
+		user.ApPrivateKey = privateKey
+		user.ApPublicKey = publicKey
+	}
+	key, err := activitypub.ParsePrivateKey(user.ApPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &activitypub.Signer{KeyID: actorID(base, user.Username) + "#main-key", Key: key}, nil
+}
+
+func HandleWebFinger(c echo.Context) error {
+	base := federationBase()
+	resource := c.QueryParam("resource")
+	username := ""
+	if acct, found := strings.CutPrefix(resource, "acct:"); found {
+		name, host, _ := strings.Cut(acct, "@")
+		if host != federationHost(base) {
+			return c.NoContent(http.StatusNotFound)
+		}
+		username = name
+	} else if name, ok := usernameFromActor(base, resource); ok {
+		username = name
+	}
+	user, err := federatedUser(username)
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	id := actorID(base, user.Username)
+	data, err := json.Marshal(activitypub.WebFinger{
+		Subject: "acct:" + user.Username + "@" + federationHost(base),
+		Aliases: []string{id},
+		Links: []activitypub.WebFingerLink{
+			{Rel: "self", Type: activitypub.ContentType, Href: id},
+			{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: base + "/user/" + user.Username},
+		},
+	})
+	if err != nil {
+		return utils.RespondInternalError(c, "marshal error")
+	}
+	return c.Blob(http.StatusOK, activitypub.JrdContentType, data)
+}
+
+func HandleActor(c echo.Context) error {
+	base := federationBase()
+	user, err := federatedUser(c.Param("username"))
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	if _, err := actorSigner(base, user); err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondInternalError(c, "key generation failed")
+	}
+	id := actorID(base, user.Username)
+	view := utils.UserToView(user, false)
+	return respondActivity(c, activitypub.Actor{
+		Context:           []string{activitypub.ActivityStreams, activitypub.SecurityContext},
+		ID:                id,
+		Type:              activitypub.ObjectPerson,
+		PreferredUsername: user.Username,
+		Name:              user.Nickname,
+		Summary:           user.Bio,
+		URL:               base + "/user/" + user.Username,
+		Inbox:             id + "/inbox",
+		Outbox:            id + "/outbox",
+		Followers:         id + "/followers",
+		Following:         id + "/following",
+		Published:         &user.RegisteredAt,
+		Icon:              &activitypub.Image{Type: "Image", URL: base + "/api/user/avatar/" + view.Avatar},
+		Endpoints:         &activitypub.Endpoints{SharedInbox: base + "/ap/inbox"},
+		PublicKey: activitypub.PublicKey{
+			ID:           id + "#main-key",
+			Owner:        id,
+			PublicKeyPem: user.ApPublicKey,
+		},
+	})
+}
+
+// buildNote converts a post into a Note.
+func buildNote(base string, post *model.Post) (*activitypub.Note, error) {
+	view, err := utils.PostToView(post, &model.UserViewModel{}, false)
+	if err != nil {
+		return nil, err
+	}
+	published := post.CreatedAt
+	note := &activitypub.Note{
+		ID:           noteID(base, post.ID),
+		Type:         activitypub.ObjectNote,
+		AttributedTo: actorID(base, post.Username),
+		Content:      postHTML(base, view.Content),
+		URL:          base + "/public/article/" + strconv.Itoa(int(post.ID)),
+		Published:    &published,
+		To:           []string{activitypub.PublicCollection},
+		Cc:           []string{actorID(base, post.Username) + "/followers"},
+	}
+	if post.EditedAt.After(post.CreatedAt) {
+		edited := post.EditedAt
+		note.Updated = &edited
+	}
+	for _, t := range utils.GetTags(view.Content) {
+		t = strings.TrimPrefix(t, "#")
+		note.Tag = append(note.Tag, activitypub.Tag{
+			Type: activitypub.ObjectHashtag,
+			Href: base + "/tag/" + t,
+			Name: "#" + t,
+		})
+	}
+	return note, nil
+}
+
+func buildActivity(base string, activityType string, post *model.Post, object interface{}) activitypub.Activity {
+	published := post.CreatedAt
+	if activityType != activitypub.ActivityCreate {
+		published = time.Now()
+	}
+	return activitypub.Activity{
+		Context:   activitypub.ActivityStreams,
+		ID:        noteID(base, post.ID) + "/activity/" + strings.ToLower(activityType) + "/" + strconv.FormatInt(published.UnixNano(), 10),
+		Type:      activityType,
+		Actor:     actorID(base, post.Username),
+		Object:    object,
+		Published: &published,
+		To:        []string{activitypub.PublicCollection},
+		Cc:        []string{actorID(base, post.Username) + "/followers"},
+	}
+}
+
+func HandleNote(c echo.Context) error {
+	base := federationBase()
+	var post model.Post
+	if err := memento.Db().First(&post, "id=?", c.Param("id")).Error; err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	if !canViewPost("", &post) {
+		return c.NoContent(http.StatusNotFound)
+	}
+	if _, err := federatedUser(post.Username); err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	note, err := buildNote(base, &post)
+	if err != nil {
+		log.Errorf(err.Error())
+		return c.NoContent(http.StatusNotFound)
+	}
+	note.Context = activitypub.ActivityStreams
+	return respondActivity(c, note)
+}
+
+func HandleOutbox(c echo.Context) error {
+	base := federationBase()
+	user, err := federatedUser(c.Param("username"))
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	id := actorID(base, user.Username) + "/outbox"
+	db := visiblePosts(memento.Db(), "").Where("username = ? AND is_private = ?", user.Username, false)
+	var total int64
+	if err := db.Session(&gorm.Session{}).Model(&model.Post{}).Count(&total).Error; err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	if c.QueryParam("page") == "" {
+		return respondActivity(c, activitypub.OrderedCollection{
+			Context:    activitypub.ActivityStreams,
+			ID:         id,
+			Type:       activitypub.CollectionOrdered,
+			TotalItems: total,
+			First:      id + "?page=0",
+		})
+	}
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 0 {
+		return utils.RespondError(c, "invalid page")
+	}
+	posts := make([]model.Post, 0, memento.PageSize)
+	err = db.Session(&gorm.Session{}).
+		Order("created_at desc").
+		Offset(page * memento.PageSize).
+		Limit(memento.PageSize).
+		Find(&posts).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	items := make([]interface{}, 0, len(posts))
+	for i := range posts {
+		note, err := buildNote(base, &posts[i])
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+		items = append(items, buildActivity(base, activitypub.ActivityCreate, &posts[i], note))
+	}
+	result := activitypub.OrderedCollectionPage{
+		Context:      activitypub.ActivityStreams,
+		ID:           id + "?page=" + strconv.Itoa(page),
+		Type:         activitypub.CollectionPageType,
+		PartOf:       id,
+		TotalItems:   total,
+		OrderedItems: items,
+	}
+	if int64((page+1)*memento.PageSize) < total {
+		result.Next = id + "?page=" + strconv.Itoa(page+1)
+	}
+	return respondActivity(c, result)
+}
+
+func HandleFollowers(c echo.Context) error {
+	base := federationBase()
+	user, err := federatedUser(c.Param("username"))
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	var remote []string
+	err = memento.Db().Model(&model.RemoteFollower{}).
+		Where("username = ?", user.Username).
+		Order("created_at desc").
+		Pluck("actor_id", &remote).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	var local []string
+	err = memento.Db().Model(&model.User{}).
+		Joins("JOIN user_follows ON user_follows.user_id = users.id").
+		Where("user_follows.follow_id = ?", user.ID).
+		Where("users.is_protected = ?", false).
+		Pluck("users.username", &local).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	for _, name := range local {
+		remote = append(remote, actorID(base, name))
+	}
+	return respondCollection(c, actorID(base, user.Username)+"/followers", remote)
+}
+
+func HandleFollowing(c echo.Context) error {
+	base := federationBase()
+	user, err := federatedUser(c.Param("username"))
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	var follows []model.User
+	if err := memento.Db().Model(user).Association("Follows").Find(&follows); err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	ids := make([]string, 0, len(follows))
+	for _, f := range follows {
+		if !f.IsProtected {
+			ids = append(ids, actorID(base, f.Username))
+		}
+	}
+	return respondCollection(c, actorID(base, user.Username)+"/following", ids)
+}
+
+// respondCollection responds with a single page collection of actor ids.
+func respondCollection(c echo.Context, id string, ids []string) error {
+	items := make([]interface{}, 0, len(ids))
+	for _, i := range ids {
+		items = append(items, i)
+	}
+	return respondActivity(c, activitypub.OrderedCollectionPage{
+		Context:      activitypub.ActivityStreams,
+		ID:           id,
+		Type:         activitypub.CollectionOrdered,
+		TotalItems:   int64(len(ids)),
+		OrderedItems: items,
+	})
+}
+
+// HandleInbox accepts signed activities, both on the personal and the shared inbox.
+func HandleInbox(c echo.Context) error {
+	base := federationBase()
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxInboxSize))
+	if err != nil {
+		return utils.RespondError(c, "invalid body")
+	}
+	var activity activitypub.IncomingActivity
+	if err := json.Unmarshal(body, &activity); err != nil || activity.Actor == "" {
+		return utils.RespondError(c, "invalid activity")
+	}
+	var actor *activitypub.Actor
+	keyID, err := activitypub.Verify(c.Request(), body, func(keyID string) (*rsa.PublicKey, error) {
+		actor, err = activitypub.FetchActor(activitypub.DefaultClient, nil, activitypub.KeyOwner(keyID))
+		if err != nil {
+			return nil, err
+		}
+		if actor.PublicKey.ID != keyID {
+			return nil, errors.New("unknown key id")
+		}
+		return activitypub.ParsePublicKey(actor.PublicKey.PublicKeyPem)
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, err.Error())
+	}
+	if activitypub.KeyOwner(keyID) != activity.Actor || actor.ID != activity.Actor {
+		return c.JSON(http.StatusUnauthorized, "actor does not match the signature")
+	}
+	if username := c.Param("username"); username != "" {
+		if _, err := federatedUser(username); err != nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+	}
+	switch activity.Type {
+	case activitypub.ActivityFollow:
+		err = handleRemoteFollow(base, actor, &activity)
+	case activitypub.ActivityUndo:
+		err = handleRemoteUndo(base, actor, &activity)
+	case activitypub.ActivityLike:
+		err = handleRemoteLike(base, actor, &activity)
+	case activitypub.ActivityCreate:
+		err = handleRemoteReply(base, actor, &activity)
+	case activitypub.ActivityDelete:
+		err = handleRemoteDelete(actor, &activity)
+	}
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+func handleRemoteFollow(base string, actor *activitypub.Actor, activity *activitypub.IncomingActivity) error {
+	username, ok := usernameFromActor(base, activity.ObjectID())
+	if !ok {
+		return errors.New("invalid follow target")
+	}
+	user, err := federatedUser(username)
+	if err != nil {
+		return errors.New("username not exists")
+	}
+	follower := model.RemoteFollower{
+		Username: user.Username,
+		ActorID:  actor.ID,
+	}
+	err = memento.Db().Where(&follower).FirstOrCreate(&follower).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return errors.New("unknown insertion error")
+	}
+	follower.Inbox = actor.Inbox
+	follower.FollowID = activity.ID
+	if actor.Endpoints != nil {
+		follower.SharedInbox = actor.Endpoints.SharedInbox
+	}
+	if err := memento.Db().Save(&follower).Error; err != nil {
+		log.Errorf(err.Error())
+		return errors.New("unknown update error")
+	}
+	signer, err := actorSigner(base, user)
+	if err != nil {
+		log.Errorf(err.Error())
+		return errors.New("key generation failed")
+	}
+	accept := activitypub.Activity{
+		Context: activitypub.ActivityStreams,
+		ID:      actorID(base, user.Username) + "#accepts/" + strconv.Itoa(int(follower.ID)),
+		Type:    activitypub.ActivityAccept,
+		Actor:   actorID(base, user.Username),
+		Object:  activity,
+	}
+	go func() {
+		if err := activitypub.Deliver(activitypub.DefaultClient, signer, actor.Inbox, accept); err != nil {
+			log.Errorf("Error delivering Accept: %s\n", err.Error())
+		}
+	}()
+	return nil
+}
+
+func handleRemoteUndo(base string, actor *activitypub.Actor, activity *activitypub.IncomingActivity) error {
+	nested, err := activity.NestedActivity()
+	if err != nil {
+		// only the id of the undone activity was sent
+		nested = &activitypub.IncomingActivity{ID: activity.ObjectID()}
+	}
+	if nested.Type == activitypub.ActivityFollow || nested.Type == "" {
+		db := memento.Db().Where("actor_id = ?", actor.ID)
+		if username, ok := usernameFromActor(base, nested.ObjectID()); ok {
+			db = db.Where("username = ?", username)
+		} else {
+			db = db.Where("follow_id = ?", nested.ID)
+		}
+		if err := db.Delete(&model.RemoteFollower{}).Error; err != nil {
+			log.Errorf(err.Error())
+			return errors.New("unknown deletion error")
+		}
+	}
+	if nested.Type == activitypub.ActivityLike || nested.Type == "" {
+		return undoRemoteLike(base, actor, nested)
+	}
+	return nil
+}
+
+func handleRemoteLike(base string, actor *activitypub.Actor, activity *activitypub.IncomingActivity) error {
+	postID, ok := postIDFromNote(base, activity.ObjectID())
+	if !ok {
+		return errors.New("invalid like target")
+	}
+	var post model.Post
+	if err := memento.Db().First(&post, "id=?", postID).Error; err != nil || !canViewPost("", &post) {
+		return errors.New("post not exists")
+	}
+	return memento.Db().Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&model.RemoteLike{}).
+			Where("post_id = ? AND actor_id = ?", post.ID, actor.ID).
+			Count(&count).
+			Error
+		if err != nil || count > 0 {
+			return err
+		}
+		like := model.RemoteLike{PostID: post.ID, ActorID: actor.ID, ActivityID: activity.ID}
+		if err := tx.Create(&like).Error; err != nil {
+			return err
+		}
+		return addLikeCount(tx, &post, 1)
+	})
+}
+
+func undoRemoteLike(base string, actor *activitypub.Actor, nested *activitypub.IncomingActivity) error {
+	db := memento.Db().Where("actor_id = ?", actor.ID)
+	if postID, ok := postIDFromNote(base, nested.ObjectID()); ok {
+		db = db.Where("post_id = ?", postID)
+	} else {
+		db = db.Where("activity_id = ?", nested.ID)
+	}
+	var like model.RemoteLike
+	if err := db.First(&like).Error; err != nil {
+		return nil
+	}
+	return memento.Db().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&like).Error; err != nil {
+			return err
+		}
+		var post model.Post
+		if err := tx.First(&post, "id=?", like.PostID).Error; err != nil {
+			return nil
+		}
+		return addLikeCount(tx, &post, -1)
+	})
+}
+
+func addLikeCount(tx *gorm.DB, post *model.Post, delta int) error {
+	err := tx.Model(post).Update("total_liked", gorm.Expr("total_liked + ?", delta)).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&model.User{}).
+		Where("username = ?", post.Username).
+		Update("total_liked", gorm.Expr("total_liked + ?", delta)).
+		Error
+}
+
+// handleRemoteReply stores a remote Note replying to a local post as a comment.
+func handleRemoteReply(base string, actor *activitypub.Actor, activity *activitypub.IncomingActivity) error {
+	if activity.ObjectType() != activitypub.ObjectNote {
+		return nil
+	}
+	var note activitypub.IncomingNote
+	if err := json.Unmarshal(activity.Object, &note); err != nil {
+		return errors.New("invalid note")
+	}
+	postID, ok := postIDFromNote(base, note.InReplyTo)
+	if !ok {
+		// not a reply to one of our posts
+		return nil
+	}
+	if note.AttributedTo != actor.ID || note.ID == "" {
+		return errors.New("invalid note author")
+	}
+	var post model.Post
+	if err := memento.Db().First(&post, "id=?", postID).Error; err != nil || !canViewPost("", &post) {
+		return errors.New("post not exists")
+	}
+	var count int64
+	memento.Db().Model(&model.Comment{}).Where("activity_id = ?", note.ID).Count(&count)
+	if count > 0 {
+		return nil
+	}
+	content := strings.TrimSpace(html2text.HTML2Text(note.Content))
+	if content == "" {
+		return errors.New("empty content")
+	}
+	createdAt := note.Published
+	if createdAt.IsZero() || createdAt.After(time.Now()) {
+		createdAt = time.Now()
+	}
+	name := actor.Name
+	if name == "" {
+		name = actor.PreferredUsername
+	}
+	comment := model.Comment{
+		PostID:      post.ID,
+		Username:    remoteHandle(actor),
+		CreatedAt:   createdAt,
+		EditedAt:    createdAt,
+		Content:     content,
+		RemoteActor: actor.ID,
+		RemoteName:  name,
+		ActivityID:  note.ID,
+	}
+	return memento.Db().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&comment).Error; err != nil {
+			return err
+		}
+		return tx.Model(&post).Update("total_comment", gorm.Expr("total_comment + ?", 1)).Error
+	})
+}
+
+func handleRemoteDelete(actor *activitypub.Actor, activity *activitypub.IncomingActivity) error {
+	var comment model.Comment
+	err := memento.Db().First(&comment, "activity_id = ? AND remote_actor = ?", activity.ObjectID(), actor.ID).Error
+	if err != nil {
+		return nil
+	}
+	return memento.Db().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&comment).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.Post{}).
+			Where("id = ?", comment.PostID).
+			Update("total_comment", gorm.Expr("total_comment - ?", 1)).
+			Error
+	})
+}
+
+// remoteHandle returns the user@host handle of a remote actor.
+func remoteHandle(actor *activitypub.Actor) string {
+	u, err := url.Parse(actor.ID)
+	if err != nil {
+		return actor.ID
+	}
+	return actor.PreferredUsername + "@" + u.Host
+}
+
+// federatePost delivers the activity for a created, edited or deleted post to
+// the remote followers of its author. Posts which are not public are sent as
+// a Delete, so that an edit making a post private retracts it remotely, but
+// created posts which are not public are not sent at all.
+func federatePost(activityType string, post *model.Post) {
+	if !memento.GetConfig().EnableFederation {
+		return
+	}
+	if activityType == activitypub.ActivityCreate && !canViewPost("", post) {
+		return
+	}
+	base := federationBase()
+	user, err := federatedUser(post.Username)
+	if err != nil {
+		return
+	}
+	var followers []model.RemoteFollower
+	if err := memento.Db().Find(&followers, "username = ?", user.Username).Error; err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	if len(followers) == 0 {
+		return
+	}
+	var object interface{}
+	if activityType != activitypub.ActivityDelete && canViewPost("", post) {
+		note, err := buildNote(base, post)
+		if err != nil {
+			log.Errorf(err.Error())
+			return
+		}
+		object = note
+	} else {
+		activityType = activitypub.ActivityDelete
+		object = echo.Map{"id": noteID(base, post.ID), "type": activitypub.ObjectTombstone}
+	}
+	signer, err := actorSigner(base, user)
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	activity := buildActivity(base, activityType, post, object)
+	inboxes := make(map[string]bool)
+	for _, f := range followers {
+		if f.SharedInbox != "" {
+			inboxes[f.SharedInbox] = true
+		} else {
+			inboxes[f.Inbox] = true
+		}
+	}
+	go func() {
+		for inbox := range inboxes {
+			if err := activitypub.Deliver(activitypub.DefaultClient, signer, inbox, activity); err != nil {
+				log.Errorf("Error delivering %s: %s\n", activity.Type, err.Error())
+			}
+		}
+	}()
+}
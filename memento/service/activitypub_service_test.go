@@ -0,0 +1,331 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/activitypub"
+	"Memento/memento/model"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const testBase = "https://memento.example"
+
+// delivery is an activity posted to a remote inbox.
+type delivery struct {
+	inbox    string
+	kind     string
+	objectID string
+}
+
+// fakeRemote is the remote server of the actors, reached through the client
+// used for federation.
+type fakeRemote struct {
+	mu         sync.Mutex
+	actors     map[string][]byte
+	deliveries chan delivery
+}
+
+func (f *fakeRemote) Do(req *http.Request) (*http.Response, error) {
+	status, body := http.StatusOK, []byte{}
+	if req.Method == http.MethodPost {
+		data, _ := io.ReadAll(req.Body)
+		var activity activitypub.IncomingActivity
+		_ = json.Unmarshal(data, &activity)
+		f.deliveries <- delivery{req.URL.String(), activity.Type, activity.ObjectID()}
+		status = http.StatusAccepted
+	} else {
+		f.mu.Lock()
+		actor, ok := f.actors[req.URL.String()]
+		f.mu.Unlock()
+		if ok {
+			body = actor
+		} else {
+			status = http.StatusNotFound
+		}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {activitypub.ContentType}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// remoteActor is an actor of the fake remote server.
+type remoteActor struct {
+	id     string
+	signer *activitypub.Signer
+}
+
+func (f *fakeRemote) actor(t *testing.T, name string) *remoteActor {
+	t.Helper()
+	privatePem, publicPem, err := activitypub.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := activitypub.ParsePrivateKey(privatePem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := "https://remote.example/users/" + name
+	data, _ := json.Marshal(activitypub.Actor{
+		ID:                id,
+		Type:              activitypub.ObjectPerson,
+		PreferredUsername: name,
+		Inbox:             id + "/inbox",
+		PublicKey:         activitypub.PublicKey{ID: id + "#main-key", Owner: id, PublicKeyPem: publicPem},
+	})
+	f.mu.Lock()
+	f.actors[id] = data
+	f.mu.Unlock()
+	return &remoteActor{id: id, signer: &activitypub.Signer{KeyID: id + "#main-key", Key: key}}
+}
+
+// expect waits for the next delivery, which must be an activity of the kind.
+func (f *fakeRemote) expect(t *testing.T, kind string) delivery {
+	t.Helper()
+	select {
+	case d := <-f.deliveries:
+		if d.kind != kind {
+			t.Fatalf("%s of %s was delivered, want a %s", d.kind, d.objectID, kind)
+		}
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %s was delivered", kind)
+	}
+	return delivery{}
+}
+
+// federate enables federation for the test with a fake remote server, and
+// starts a server with the ActivityPub endpoints.
+func federate(t *testing.T) (*fakeRemote, *httptest.Server) {
+	t.Helper()
+	config := memento.GetConfig()
+	enabled, publicUrl, client := config.EnableFederation, config.PublicUrl, activitypub.DefaultClient
+	remote := &fakeRemote{actors: make(map[string][]byte), deliveries: make(chan delivery, 100)}
+	config.EnableFederation, config.PublicUrl, activitypub.DefaultClient = true, testBase, remote
+	e := echo.New()
+	e.GET("/.well-known/webfinger", HandleWebFinger, RequireFederation)
+	ap := e.Group("/ap", RequireFederation)
+	ap.POST("/users/:username/inbox", HandleInbox)
+	ap.POST("/inbox", HandleInbox)
+	server := httptest.NewServer(e)
+	t.Cleanup(func() {
+		server.Close()
+		config.EnableFederation, config.PublicUrl, activitypub.DefaultClient = enabled, publicUrl, client
+	})
+	return remote, server
+}
+
+// send posts an activity of the actor to the shared inbox, signed by the
+// signer.
+func send(t *testing.T, server *httptest.Server, signer *activitypub.Signer, activity map[string]interface{}) int {
+	t.Helper()
+	body, _ := json.Marshal(activity)
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/ap/inbox", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", activitypub.ContentType)
+	if err := signer.Sign(req, body); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestInboxFollow(t *testing.T) {
+	remote, server := federate(t)
+	user := newUser(t, "author")
+	alice := remote.actor(t, "alice")
+	status := send(t, server, alice.signer, map[string]interface{}{
+		"id":     alice.id + "#follows/1",
+		"type":   activitypub.ActivityFollow,
+		"actor":  alice.id,
+		"object": actorID(testBase, user.Username),
+	})
+	if status != http.StatusAccepted {
+		t.Fatalf("status %d", status)
+	}
+	var follower model.RemoteFollower
+	if err := memento.Db().First(&follower, "username = ? AND actor_id = ?", user.Username, alice.id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if follower.Inbox != alice.id+"/inbox" || follower.FollowID != alice.id+"#follows/1" {
+		t.Errorf("follower %+v", follower)
+	}
+	if d := remote.expect(t, activitypub.ActivityAccept); d.inbox != alice.id+"/inbox" {
+		t.Errorf("the Accept was delivered to %s", d.inbox)
+	}
+}
+
+func TestInboxLike(t *testing.T) {
+	remote, server := federate(t)
+	user := newUser(t, "author")
+	post := newPost(t, user, "a memo", false)
+	alice := remote.actor(t, "alice")
+	for i := 0; i < 2; i++ {
+		status := send(t, server, alice.signer, map[string]interface{}{
+			"id":     alice.id + "#likes/1",
+			"type":   activitypub.ActivityLike,
+			"actor":  alice.id,
+			"object": noteID(testBase, post.ID),
+		})
+		if status != http.StatusAccepted {
+			t.Fatalf("status %d", status)
+		}
+	}
+	memento.Db().First(post, post.ID)
+	if post.TotalLiked != 1 {
+		t.Errorf("%d likes after a repeated Like, want 1", post.TotalLiked)
+	}
+}
+
+func TestInboxReply(t *testing.T) {
+	remote, server := federate(t)
+	user := newUser(t, "author")
+	post := newPost(t, user, "a memo", false)
+	alice := remote.actor(t, "alice")
+	// replies are stored once per note id
+	reply := alice.id + "/notes/" + strconv.Itoa(int(post.ID))
+	status := send(t, server, alice.signer, map[string]interface{}{
+		"id":    reply + "/activity",
+		"type":  activitypub.ActivityCreate,
+		"actor": alice.id,
+		"object": map[string]interface{}{
+			"id":           reply,
+			"type":         activitypub.ObjectNote,
+			"attributedTo": alice.id,
+			"inReplyTo":    noteID(testBase, post.ID),
+			"content":      "<p>a <b>reply</b></p>",
+		},
+	})
+	if status != http.StatusAccepted {
+		t.Fatalf("status %d", status)
+	}
+	var comment model.Comment
+	if err := memento.Db().First(&comment, "post_id = ?", post.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if comment.Content != "a reply" || comment.RemoteActor != alice.id || comment.Username != "alice@remote.example" {
+		t.Errorf("comment %+v", comment)
+	}
+	memento.Db().First(post, post.ID)
+	if post.TotalComment != 1 {
+		t.Errorf("%d comments, want 1", post.TotalComment)
+	}
+}
+
+func TestInboxRejectsOtherActors(t *testing.T) {
+	remote, server := federate(t)
+	user := newUser(t, "author")
+	alice := remote.actor(t, "alice")
+	mallory := remote.actor(t, "mallory")
+	// mallory signs a Follow in the name of alice
+	status := send(t, server, mallory.signer, map[string]interface{}{
+		"id":     alice.id + "#follows/1",
+		"type":   activitypub.ActivityFollow,
+		"actor":  alice.id,
+		"object": actorID(testBase, user.Username),
+	})
+	if status != http.StatusUnauthorized {
+		t.Errorf("status %d, want %d", status, http.StatusUnauthorized)
+	}
+	var count int64
+	memento.Db().Model(&model.RemoteFollower{}).Where("username = ?", user.Username).Count(&count)
+	if count != 0 {
+		t.Errorf("%d followers were added", count)
+	}
+}
+
+func TestWebFinger(t *testing.T) {
+	_, server := federate(t)
+	user := newUser(t, "author")
+	protected := newUser(t, "protected")
+	memento.Db().Model(protected).Update("is_protected", true)
+	tests := []struct {
+		resource string
+		status   int
+	}{
+		{"acct:" + user.Username + "@memento.example", http.StatusOK},
+		{actorID(testBase, user.Username), http.StatusOK},
+		{"acct:" + user.Username + "@other.example", http.StatusNotFound},
+		{"acct:" + protected.Username + "@memento.example", http.StatusNotFound},
+		{"acct:nobody@memento.example", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(server.URL + "/.well-known/webfinger?resource=" + url.QueryEscape(test.resource))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var finger activitypub.WebFinger
+		_ = json.NewDecoder(resp.Body).Decode(&finger)
+		_ = resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.resource, resp.StatusCode, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		if finger.Subject != "acct:"+user.Username+"@memento.example" {
+			t.Errorf("%s: subject %s", test.resource, finger.Subject)
+		}
+		self := ""
+		for _, link := range finger.Links {
+			if link.Rel == "self" && link.Type == activitypub.ContentType {
+				self = link.Href
+			}
+		}
+		if self != actorID(testBase, user.Username) {
+			t.Errorf("%s: links %+v", test.resource, finger.Links)
+		}
+	}
+}
+
+func TestPrivatePostIsNotFederated(t *testing.T) {
+	remote, _ := federate(t)
+	user := newUser(t, "author")
+	err := memento.Db().Create(&model.RemoteFollower{
+		Username: user.Username,
+		ActorID:  "https://remote.example/users/alice",
+		Inbox:    "https://remote.example/users/alice/inbox",
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	private := newPost(t, user, "a private memo", true)
+	if err := editPost(private, "a private memo, edited", true); err != nil {
+		t.Fatal(err)
+	}
+	public := newPost(t, user, "a public memo", false)
+	if d := remote.expect(t, activitypub.ActivityCreate); d.objectID != noteID(testBase, public.ID) {
+		t.Errorf("created %s", d.objectID)
+	}
+	// followers get a post made public as a new one
+	if err := editPost(private, "a memo made public", false); err != nil {
+		t.Fatal(err)
+	}
+	if d := remote.expect(t, activitypub.ActivityCreate); d.objectID != noteID(testBase, private.ID) {
+		t.Errorf("created %s", d.objectID)
+	}
+	select {
+	case d := <-remote.deliveries:
+		t.Errorf("%s of %s was delivered", d.kind, d.objectID)
+	default:
+	}
+}
@@ -3,60 +3,57 @@ package service
 import (
 	"Memento/memento"
 	"Memento/memento/model"
+	"Memento/memento/storage"
 	"Memento/memento/utils"
 	"bytes"
 	"errors"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
 	"github.com/nfnt/resize"
+	"gorm.io/gorm"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/png"
 	"io"
 	"mime/multipart"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 )
 
-func AdminCheck(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		username := c.Get("username").(string)
-		if username == "" {
-			return c.JSON(400, echo.Map{
-				"error": "Admin permission required",
-			})
-		}
-		var user model.User
-		err := memento.Db().First(&user, "username=?", username).Error
-		if err != nil {
-			return c.JSON(401, echo.Map{
-				"error": "User not found",
-			})
-		}
-		if !user.IsAdmin {
-			return c.JSON(401, echo.Map{
-				"error": "Admin required",
-			})
-		}
-		return next(c)
-	}
+func HandleGetConfigs(c echo.Context) error {
+	return c.JSON(200, configSnapshot())
 }
 
-func HandleGetConfigs(c echo.Context) error {
-	return c.JSON(200, echo.Map{
-		"enableRegister": memento.GetConfig().EnableRegister,
-		"siteName":       memento.GetConfig().SiteName,
-		"description":    memento.GetConfig().Description,
-		"iconVersion":    memento.GetConfig().IconVersion,
-	})
+func configSnapshot() echo.Map {
+	return echo.Map{
+		"enableRegister":   memento.GetConfig().EnableRegister,
+		"siteName":         memento.GetConfig().SiteName,
+		"description":      memento.GetConfig().Description,
+		"iconVersion":      memento.GetConfig().IconVersion,
+		"enableFederation": memento.GetConfig().EnableFederation,
+		"publicUrl":        memento.GetConfig().PublicUrl,
+		"defaultQuota":     memento.GetConfig().DefaultQuota,
+		"maxUploadSize":    memento.GetConfig().MaxUploadSize,
+		"allowedTypes":     strings.Join(memento.GetConfig().AllowedTypes, ","),
+		"keepMetadata":     memento.GetConfig().KeepImageMetadata,
+	}
 }
 
 func HandleSetConfig(c echo.Context) error {
 	enable := c.FormValue("enableRegister")
 	siteName := c.FormValue("siteName")
 	description := c.FormValue("description")
+	federation := c.FormValue("enableFederation")
+	publicUrl := c.FormValue("publicUrl")
+	defaultQuota := c.FormValue("defaultQuota")
+	maxUploadSize := c.FormValue("maxUploadSize")
+	allowedTypes := c.FormValue("allowedTypes")
+	keepImageMetadata := c.FormValue("keepMetadata")
+	before := configSnapshot()
 	if enable != "" {
 		memento.GetConfig().EnableRegister = enable == "true"
 	}
@@ -66,10 +63,54 @@ func HandleSetConfig(c echo.Context) error {
 	if description != "" {
 		memento.GetConfig().Description = description
 	}
+	if federation != "" {
+		memento.GetConfig().EnableFederation = federation == "true"
+	}
+	if publicUrl != "" {
+		u, err := url.Parse(publicUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return utils.RespondError(c, "Invalid public url")
+		}
+		memento.GetConfig().PublicUrl = strings.TrimSuffix(publicUrl, "/")
+	}
+	if defaultQuota != "" {
+		quota, err := strconv.ParseInt(defaultQuota, 10, 64)
+		if err != nil || quota < 0 {
+			return utils.RespondError(c, "Invalid default quota")
+		}
+		memento.GetConfig().DefaultQuota = quota
+	}
+	if maxUploadSize != "" {
+		size, err := strconv.ParseInt(maxUploadSize, 10, 64)
+		if err != nil || size < 0 {
+			return utils.RespondError(c, "Invalid upload size")
+		}
+		memento.GetConfig().MaxUploadSize = size
+	}
+	if allowedTypes != "" {
+		// * allows all types
+		types := make([]string, 0)
+		for _, t := range strings.Split(allowedTypes, ",") {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if t == "*" || t == "*/*" {
+				types = nil
+				break
+			}
+			if !strings.Contains(t, "/") {
+				return utils.RespondError(c, "Invalid file type "+t)
+			}
+			types = append(types, t)
+		}
+		memento.GetConfig().AllowedTypes = types
+	}
+	if keepImageMetadata != "" {
+		memento.GetConfig().KeepImageMetadata = keepImageMetadata == "true"
+	}
 	err := memento.WriteConfig()
 	if err != nil {
 		return utils.RespondError(c, "Failed")
 	}
+	recordAudit(c, model.AuditConfigSet, "config", before, configSnapshot())
 	return c.NoContent(200)
 }
 
@@ -109,33 +150,51 @@ func HandleListUsers(c echo.Context) error {
 
 func HandleAdminDeleteUser(c echo.Context) error {
 	username := c.Param("username")
+	if current, _ := c.Get("username").(string); username == current {
+		return utils.RespondError(c, "Can not delete yourself")
+	}
 	var user model.User
 	err := memento.Db().First(&user, "username=?", username).Error
 	if err != nil {
 		return utils.RespondError(c, "User not found")
 	}
-	err = os.Remove(user.AvatarUrl)
+	err = memento.Db().Transaction(func(tx *gorm.DB) error {
+		if err := keepLastAdmin(tx, &user); err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
 	if err != nil {
-		log.Errorf(err.Error())
+		return utils.RespondError(c, err.Error())
 	}
-	err = memento.Db().Delete(&user).Error
-	if err != nil {
-		return utils.RespondError(c, "Failed")
+	if user.AvatarUrl != "" {
+		if err := storage.Default().Delete(user.AvatarUrl); err != nil {
+			log.Errorf(err.Error())
+		}
 	}
+	recordAudit(c, model.AuditUserDelete, user.Username, utils.UserToView(&user, false), nil)
 	return c.NoContent(200)
 }
 
 func HandleSetUserPermission(c echo.Context) error {
 	isAdmin := c.FormValue("is_admin") == "true"
 	username := c.FormValue("username")
-	err := memento.Db().
-		Model(&model.User{}).
-		Where("username = ?", username).
-		Update("is_admin", isAdmin).
-		Error
+	var user model.User
+	err := memento.Db().First(&user, "username=?", username).Error
 	if err != nil {
-		return utils.RespondError(c, "Failed")
+		return utils.RespondError(c, "User not found")
+	}
+	role := user.EffectiveRole()
+	if isAdmin {
+		role = model.RoleAdmin
+	} else if role == model.RoleAdmin {
+		role = model.RoleMember
+	}
+	err = setUserRole(username, role)
+	if err != nil {
+		return utils.RespondError(c, err.Error())
 	}
+	recordAudit(c, model.AuditUserRole, username, string(user.EffectiveRole()), string(role))
 	return c.NoContent(200)
 }
 
@@ -162,8 +221,10 @@ func HandleSetNewIcon(c echo.Context) error {
 	if err != nil {
 		return utils.RespondError(c, err.Error())
 	}
+	before := memento.GetConfig().IconVersion
 	memento.GetConfig().IconVersion++
 	_ = memento.WriteConfig()
+	recordAudit(c, model.AuditIconSet, "icon", before, memento.GetConfig().IconVersion)
 	return c.NoContent(200)
 }
 
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newAdmin creates a user with the admin role, who is deleted after the test
+// so the admins of other tests are not counted.
+func newAdmin(t *testing.T, name string) *model.User {
+	t.Helper()
+	user := newUser(t, name)
+	if err := setUserRole(user.Username, model.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		memento.Db().Delete(user)
+	})
+	return user
+}
+
+func deleteUser(t *testing.T, admin *model.User, user *model.User) int {
+	t.Helper()
+	return call(t, HandleAdminDeleteUser, http.MethodDelete, "/api/admin/user/"+user.Username, nil, admin.Username, "username", user.Username).Code
+}
+
+func TestAdminDeleteUserKeepsAnAdmin(t *testing.T) {
+	first := newAdmin(t, "admin")
+	second := newAdmin(t, "admin")
+	if status := deleteUser(t, first, first); status != http.StatusBadRequest {
+		t.Errorf("an admin deleted themselves: status %d", status)
+	}
+	if status := deleteUser(t, first, second); status != http.StatusOK {
+		t.Fatalf("deleting an admin: status %d", status)
+	}
+	// the first admin is the only one left
+	member := newUser(t, "member")
+	if status := deleteUser(t, member, first); status != http.StatusBadRequest {
+		t.Errorf("the last admin was deleted: status %d", status)
+	}
+	var count int64
+	memento.Db().Model(&model.User{}).Where("username = ?", first.Username).Count(&count)
+	if count != 1 {
+		t.Error("the last admin is gone")
+	}
+	if err := setUserRole(first.Username, model.RoleMember); err == nil {
+		t.Error("the role of the last admin was changed")
+	}
+}
+
+func TestRequirePermissionWithoutUser(t *testing.T) {
+	handler := RequirePermission(model.PermPostWrite)(HandleGetRoles)
+	// no username is set by a route without authentication
+	rec := httptest.NewRecorder()
+	if err := handler(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/api/roles", nil), rec)); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, rec, http.StatusUnauthorized)
+	expectStatus(t, call(t, handler, http.MethodGet, "/api/roles", nil, ""), http.StatusUnauthorized)
+}
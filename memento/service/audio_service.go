@@ -0,0 +1,116 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/audio"
+	"Memento/memento/model"
+	"Memento/memento/storage"
+	"Memento/memento/utils"
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/html"
+	"github.com/labstack/gommon/log"
+)
+
+// transcribeTimeout limits how long the transcription of one file may take.
+const transcribeTimeout = 10 * time.Minute
+
+// inspectAudio reads the duration and codec of the audio in tmp. MP4 files
+// without a video track are audio, like the m4a files of phones which carry
+// the brand of videos.
+func inspectAudio(tmp *os.File, size int64, meta *blobMeta) {
+	mimeType := meta.MimeType
+	if mimeType == "video/mp4" {
+		mimeType = "audio/mp4"
+	}
+	info, err := audio.Inspect(tmp, size, mimeType)
+	if err != nil {
+		if meta.MimeType != "video/mp4" {
+			// damaged files are stored without metadata
+			log.Warnf("can not inspect audio: %v", err)
+		}
+		return
+	}
+	meta.MimeType = mimeType
+	meta.Duration = info.Duration.Seconds()
+	meta.Codec = info.Codec
+}
+
+// isAudio reports whether a file is audio by its sniffed type, or its name
+// for files uploaded before types were sniffed.
+func isAudio(file *model.File) bool {
+	return strings.HasPrefix(fileMimeType(file), "audio/")
+}
+
+// transcribeAudio transcribes a new audio file in the background when a
+// transcriber is set.
+func transcribeAudio(file model.File) {
+	transcriber := audio.DefaultTranscriber()
+	if transcriber == nil || !isAudio(&file) {
+		return
+	}
+	go func() {
+		r, err := storage.Default().Get(file.ContentUrl)
+		if err != nil {
+			log.Errorf("transcribing file %d: %v", file.ID, err)
+			return
+		}
+		defer func(r io.ReadCloser) {
+			_ = r.Close()
+		}(r)
+		ctx, cancel := context.WithTimeout(context.Background(), transcribeTimeout)
+		defer cancel()
+		text, err := transcriber.Transcribe(ctx, r, fileMimeType(&file))
+		if err != nil {
+			log.Errorf("transcribing file %d: %v", file.ID, err)
+			return
+		}
+		err = memento.Db().Model(&model.File{}).Where("id = ?", file.ID).
+			UpdateColumn("transcript", text).
+			Error
+		if err != nil {
+			log.Errorf(err.Error())
+		}
+	}()
+}
+
+// isAudioEmbed reports whether a Markdown image embeds audio: its alt text is
+// "audio", like in ![audio](url), or it links an audio file by name.
+func isAudioEmbed(image *ast.Image) bool {
+	children := image.GetChildren()
+	if len(children) == 1 {
+		if text, ok := children[0].(*ast.Text); ok && strings.EqualFold(string(text.Literal), "audio") {
+			return true
+		}
+	}
+	link, err := url.Parse(string(image.Destination))
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(utils.MimeTypeByExtension(path.Ext(link.Path)), "audio/")
+}
+
+// renderAudio renders the images of Markdown which embed audio as players.
+func renderAudio(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	image, ok := node.(*ast.Image)
+	if !ok || !isAudioEmbed(image) {
+		return ast.GoToNext, false
+	}
+	if entering {
+		_, _ = io.WriteString(w, `<audio controls preload="metadata" src="`)
+		html.EscLink(w, image.Destination)
+		_, _ = io.WriteString(w, `"><a href="`)
+		html.EscLink(w, image.Destination)
+		_, _ = io.WriteString(w, `">`)
+		html.EscapeHTML(w, image.Destination)
+		_, _ = io.WriteString(w, `</a></audio>`)
+	}
+	return ast.SkipChildren, true
+}
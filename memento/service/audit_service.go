@@ -0,0 +1,163 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordAudit appends an entry to the audit log. Failures are logged but never
+// abort the audited action.
+func recordAudit(c echo.Context, action string, target string, before interface{}, after interface{}) {
+	actor, _ := c.Get("username").(string)
+	entry := model.AuditLog{
+		CreatedAt: time.Now(),
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		IP:        c.RealIP(),
+		Before:    auditValue(before),
+		After:     auditValue(after),
+	}
+	if err := memento.Db().Create(&entry).Error; err != nil {
+		log.Errorf("Error writing audit log: %s\n", err.Error())
+	}
+}
+
+func auditValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func auditQuery(c echo.Context) (*gorm.DB, error) {
+	db := memento.Db().Model(&model.AuditLog{})
+	if actor := c.QueryParam("actor"); actor != "" {
+		db = db.Where("actor = ?", actor)
+	}
+	if action := c.QueryParam("action"); action != "" {
+		db = db.Where("action = ?", action)
+	}
+	if target := c.QueryParam("target"); target != "" {
+		db = db.Where("target = ?", target)
+	}
+	if ip := c.QueryParam("ip"); ip != "" {
+		db = db.Where("ip = ?", ip)
+	}
+	if from := c.QueryParam("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, err
+		}
+		db = db.Where("created_at >= ?", t)
+	}
+	if to := c.QueryParam("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, err
+		}
+		db = db.Where("created_at < ?", t)
+	}
+	return db, nil
+}
+
+func HandleGetAuditLogs(c echo.Context) error {
+	db, err := auditQuery(c)
+	if err != nil {
+		return utils.RespondError(c, "invalid time range")
+	}
+	format := c.QueryParam("format")
+	if format == "csv" || format == "json" {
+		var logs []model.AuditLog
+		err = db.Order("created_at desc").Find(&logs).Error
+		if err != nil {
+			log.Errorf(err.Error())
+			return utils.RespondError(c, "unknown query error")
+		}
+		return exportAuditLogs(c, format, logs)
+	}
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil {
+		return utils.RespondError(c, "invalid page")
+	}
+	var total int64
+	err = db.Count(&total).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	logs := make([]model.AuditLog, 0, memento.PageSize)
+	err = db.Order("created_at desc").
+		Offset(page * memento.PageSize).
+		Limit(memento.PageSize).
+		Find(&logs).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	result := make([]model.AuditLogViewModel, 0, len(logs))
+	for _, l := range logs {
+		result = append(result, *utils.AuditLogToView(&l))
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"logs":    result,
+		"maxPage": utils.MaxPage(total),
+	})
+}
+
+func exportAuditLogs(c echo.Context, format string, logs []model.AuditLog) error {
+	filename := "audit-" + time.Now().Format("20060102-150405") + "." + format
+	c.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename=\""+filename+"\"")
+	if format == "json" {
+		result := make([]model.AuditLogViewModel, 0, len(logs))
+		for _, l := range logs {
+			result = append(result, *utils.AuditLogToView(&l))
+		}
+		return c.JSON(http.StatusOK, result)
+	}
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().WriteHeader(http.StatusOK)
+	w := csv.NewWriter(c.Response())
+	_ = w.Write([]string{"id", "time", "actor", "action", "target", "ip", "before", "after"})
+	for _, l := range logs {
+		_ = w.Write([]string{
+			strconv.Itoa(int(l.ID)),
+			l.CreatedAt.Format(time.RFC3339),
+			csvCell(l.Actor),
+			csvCell(l.Action),
+			csvCell(l.Target),
+			csvCell(l.IP),
+			csvCell(l.Before),
+			csvCell(l.After),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// csvCell keeps spreadsheets from running a cell as a formula, following
+// the advice of OWASP on CSV injection.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestAuditLogCSVEscapesFormulas(t *testing.T) {
+	admin := newUser(t, "admin")
+	err := memento.Db().Create(&model.AuditLog{
+		Actor:  admin.Username,
+		Action: model.AuditUserDelete,
+		Target: `=HYPERLINK("https://evil.example","open")`,
+		IP:     "127.0.0.1",
+		Before: "+1",
+		After:  "@SUM(A1)",
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := url.Values{"format": {"csv"}, "actor": {admin.Username}}
+	rec := call(t, HandleGetAuditLogs, http.MethodGet, "/api/admin/audit?"+query.Encode(), nil, admin.Username)
+	expectStatus(t, rec, http.StatusOK)
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("%d rows", len(rows))
+	}
+	want := []string{admin.Username, model.AuditUserDelete, `'=HYPERLINK("https://evil.example","open")`, "127.0.0.1", "'+1", "'@SUM(A1)"}
+	for i, cell := range want {
+		if rows[1][i+2] != cell {
+			t.Errorf("%s: %q, want %q", rows[0][i+2], rows[1][i+2], cell)
+		}
+	}
+}
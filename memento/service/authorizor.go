@@ -17,19 +17,30 @@ import (
 )
 
 func HandleLogin(c echo.Context) error {
-	username := c.FormValue("username")
-	password := c.FormValue("password")
+	user, err := checkPassword(c, c.FormValue("username"), c.FormValue("password"))
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	if restriction := user.Restriction(); restriction != "" {
+		return utils.RespondForbidden(c, restriction)
+	}
+
+	return authOk(c, user)
+}
 
+// checkPassword verifies the credentials of a user and locks the account for
+// a while after too many failed attempts.
+func checkPassword(c echo.Context, username string, password string) (*model.User, error) {
 	user, err := query.User.Where(query.User.Username.Eq(username)).First()
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return utils.RespondError(c, "username not exists")
+			return nil, errors.New("username not exists")
 		}
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown query error")
+		return nil, errors.New("unknown query error")
 	}
 	if user.LockUntil.After(time.Now()) {
-		return utils.RespondError(c, "Too many login attempts, please try again later")
+		return nil, errors.New("Too many login attempts, please try again later")
 	}
 	if utils.Md5string(password) != user.PasswordHash {
 		user.PasswordRetry += 1
@@ -37,16 +48,16 @@ func HandleLogin(c echo.Context) error {
 			log.Infof("User %s has been locked due to too many login attempts", user.Username)
 			user.LockUntil = time.Now().Add(time.Minute * 5)
 			user.PasswordRetry = 0
+			recordAudit(c, model.AuditLoginLockout, user.Username, nil, user.LockUntil.Format(time.RFC3339))
 		}
 		err = query.User.Save(user)
 		if err != nil {
 			log.Errorf(err.Error())
-			return utils.RespondError(c, "unknown update error")
+			return nil, errors.New("unknown update error")
 		}
-		return utils.RespondError(c, "incorrect password")
+		return nil, errors.New("incorrect password")
 	}
-
-	return authOk(c, user)
+	return user, nil
 }
 
 func HandleCreate(c echo.Context) error {
@@ -81,6 +92,10 @@ func HandleCreate(c echo.Context) error {
 		TotalPosts:   0,
 		RegisteredAt: time.Now(),
 		IsAdmin:      totalUsers == 0,
+		Role:         model.RoleMember,
+	}
+	if user.IsAdmin {
+		user.Role = model.RoleAdmin
 	}
 	err = query.User.Create(&user)
 	if err != nil {
@@ -147,6 +162,7 @@ func authOk(c echo.Context, user *model.User) error {
 		"accessToken":  t,
 		"refreshToken": refreshToken,
 		"isAdmin":      user.IsAdmin,
+		"role":         user.EffectiveRole(),
 		"expiredAt":    claims.ExpiresAt.Format(time.RFC3339),
 		"user":         utils.UserToView(user, false),
 	})
@@ -180,5 +196,8 @@ func HandleRefreshToken(c echo.Context) error {
 	if err != nil {
 		return utils.RespondError(c, "user not found")
 	}
+	if restriction := user.Restriction(); restriction != "" {
+		return utils.RespondForbidden(c, restriction)
+	}
 	return authOk(c, user)
 }
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+func HandleUserBlock(c echo.Context) error {
+	user, target, err := loadRelationUsers(c)
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	err = memento.Db().Transaction(
+		func(tx *gorm.DB) error {
+			err := tx.Model(user).Association("Blocks").Append(target)
+			if err != nil {
+				return err
+			}
+			if err = removeFollow(tx, user, target); err != nil {
+				return err
+			}
+			if err = removeFollow(tx, target, user); err != nil {
+				return err
+			}
+			// pending requests would turn into a follow when approved
+			return tx.Delete(&model.FollowRequest{},
+				"status = ? AND ((requester = ? AND target = ?) OR (requester = ? AND target = ?))",
+				model.FollowRequestPending, user.Username, target.Username, target.Username, user.Username).
+				Error
+		})
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func HandleUserUnblock(c echo.Context) error {
+	user, target, err := loadRelationUsers(c)
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	err = memento.Db().Model(user).Association("Blocks").Delete(target)
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func HandleUserMute(c echo.Context) error {
+	user, target, err := loadRelationUsers(c)
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	err = memento.Db().Model(user).Association("Mutes").Append(target)
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func HandleUserUnmute(c echo.Context) error {
+	user, target, err := loadRelationUsers(c)
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	err = memento.Db().Model(user).Association("Mutes").Delete(target)
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func HandleGetBlocks(c echo.Context) error {
+	return listRelation(c, "Blocks")
+}
+
+func HandleGetMutes(c echo.Context) error {
+	return listRelation(c, "Mutes")
+}
+
+func listRelation(c echo.Context, association string) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil {
+		return utils.RespondError(c, "invalid page")
+	}
+	var user model.User
+	err = memento.Db().First(&user, "username=?", username).Error
+	if err != nil {
+		return utils.RespondError(c, "username not exists")
+	}
+	users := make([]model.User, 0, memento.PageSize)
+	err = memento.Db().
+		Model(&user).
+		Offset(page * memento.PageSize).
+		Limit(memento.PageSize).
+		Association(association).
+		Find(&users)
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	total := memento.Db().Model(&user).Association(association).Count()
+	result := make([]model.UserViewModel, 0, len(users))
+	for _, u := range users {
+		view := utils.UserToView(&u, checkIsFollowed(username, u.Username))
+		view.IsBlocked = association == "Blocks"
+		view.IsMuted = association == "Mutes"
+		result = append(result, *view)
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"users":   result,
+		"maxPage": utils.MaxPage(total),
+	})
+}
+
+// loadRelationUsers loads the current user and the user named by the
+// "username" form value.
+func loadRelationUsers(c echo.Context) (*model.User, *model.User, error) {
+	username := c.Get("username").(string)
+	targetName := c.FormValue("username")
+	if targetName == username {
+		return nil, nil, errors.New("invalid username")
+	}
+	var user, target model.User
+	err := memento.Db().First(&user, "username=?", username).Error
+	if err == nil {
+		err = memento.Db().First(&target, "username=?", targetName).Error
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errors.New("username not exists")
+		}
+		log.Errorf(err.Error())
+		return nil, nil, errors.New("unknown query error")
+	}
+	return &user, &target, nil
+}
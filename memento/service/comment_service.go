@@ -42,6 +42,18 @@ func HandleCommentCreate(c echo.Context) error {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "unknown query error")
 	}
+	comment, err := createComment(user, post, content)
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	return c.JSON(http.StatusOK, utils.CommentToView(comment, utils.UserToView(user, false), false))
+}
+
+// createComment adds a comment of the user to the post.
+func createComment(user *model.User, post *model.Post, content string) (*model.Comment, error) {
+	if !canViewPost(user.Username, post) {
+		return nil, errors.New("permission denied")
+	}
 	now := time.Now()
 	comment := model.Comment{
 		PostID:    post.ID,
@@ -51,27 +63,27 @@ func HandleCommentCreate(c echo.Context) error {
 		Content:   content,
 		Liked:     0,
 	}
-	err = memento.Db().Transaction(
+	err := memento.Db().Transaction(
 		func(tx *gorm.DB) error {
 			tx.Create(&comment)
-			err := tx.Model(&user).Association("Comments").Append(&comment)
+			err := tx.Model(user).Association("Comments").Append(&comment)
 			if err != nil {
 				return err
 			}
-			err = tx.Model(&post).Association("Comments").Append(&comment)
+			err = tx.Model(post).Association("Comments").Append(&comment)
 			if err != nil {
 				return err
 			}
 			user.TotalComment += 1
 			post.TotalComment += 1
-			tx.Save(&user)
-			tx.Save(&post)
+			tx.Save(user)
+			tx.Save(post)
 			return nil
 		})
 	if err != nil {
-		return utils.RespondError(c, "unknown query error")
+		return nil, errors.New("unknown query error")
 	}
-	return c.JSON(http.StatusOK, utils.CommentToView(&comment, utils.UserToView(user, false), false))
+	return &comment, nil
 }
 
 func HandleCommentEdit(c echo.Context) error {
@@ -110,45 +122,52 @@ func HandleCommentDelete(c echo.Context) error {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "unknown query error")
 	}
-	if comment.Username != username {
+	if comment.Username != username && !userHasPermission(username, model.PermCommentDelete) {
 		return utils.RespondError(c, "permission denied")
 	}
-	user, err := query.User.Where(query.User.Username.Eq(username)).First()
+	if err := deleteComment(&comment); err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func deleteComment(comment *model.Comment) error {
+	user, err := query.User.Where(query.User.Username.Eq(comment.Username)).First()
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return utils.RespondError(c, "username not exists")
+			return errors.New("username not exists")
 		}
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown query error")
+		return errors.New("unknown query error")
 	}
 	var post model.Post
 	err = memento.Db().First(&post, "id=?", comment.PostID).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return utils.RespondError(c, "post not exists")
+			return errors.New("post not exists")
 		}
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown query error")
+		return errors.New("unknown query error")
 	}
 	err = memento.Db().Transaction(
 		func(tx *gorm.DB) error {
-			err := tx.Model(&user).Association("Comments").Delete(&comment)
+			err := tx.Model(&user).Association("Comments").Delete(comment)
 			if err != nil {
 				return err
 			}
-			err = tx.Model(&post).Association("Comments").Delete(&comment)
+			err = tx.Model(&post).Association("Comments").Delete(comment)
 			if err != nil {
 				return err
 			}
-			tx.Delete(&comment)
+			tx.Delete(comment)
 			user.TotalComment -= 1
 			post.TotalComment -= 1
 			return nil
 		})
 	if err != nil {
-		return utils.RespondError(c, "unknown query error")
+		return errors.New("unknown query error")
 	}
-	return c.NoContent(http.StatusOK)
+	return nil
 }
 
 func HandleCommentLike(c echo.Context) error {
@@ -231,8 +250,12 @@ func HandleGetPostComments(c echo.Context) error {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "unknown query error")
 	}
+	viewer := c.Get("username").(string)
+	if !canViewPost(viewer, &post) {
+		return utils.RespondError(c, "post not exists")
+	}
 	comments := make([]model.Comment, 0, memento.PageSize)
-	err = memento.Db().
+	err = visibleComments(memento.Db(), viewer).
 		Model(&post).
 		Association("Comments").
 		Find(
@@ -241,7 +264,7 @@ func HandleGetPostComments(c echo.Context) error {
 				Order("created_at desc").
 				Offset(page*memento.PageSize).
 				Limit(memento.PageSize))
-	total := memento.Db().Model(&post).Association("Comments").Count()
+	total := visibleComments(memento.Db(), viewer).Model(&post).Association("Comments").Count()
 	if err != nil {
 		return utils.RespondError(c, "unknown query error")
 	}
@@ -299,6 +322,7 @@ func HandleGetUserComments(c echo.Context) error {
 	if !findPublic {
 		err = memento.Db().
 			Model(&user).
+			Where("is_hidden = ?", false).
 			Association("Comments").
 			Find(
 				&comments,
@@ -309,6 +333,7 @@ func HandleGetUserComments(c echo.Context) error {
 	} else {
 		err = memento.Db().
 			Joins("JOIN posts ON posts.id = comments.post_id AND posts.is_private = false").
+			Where("comments.is_hidden = ?", false).
 			Order("comments.created_at desc").
 			Offset(page * memento.PageSize).
 			Limit(memento.PageSize).
@@ -343,6 +368,7 @@ func HandleGetUserComments(c echo.Context) error {
 			log.Errorf(err.Error())
 			return utils.RespondError(c, "unknown query error")
 		}
+		signPostLinks(postView, &post, c.Get("username").(string))
 		var likedComments []model.Comment
 		if currentUsername != "" {
 			err = memento.Db().
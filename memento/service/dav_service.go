@@ -0,0 +1,672 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/storage"
+	"Memento/memento/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"golang.org/x/net/webdav"
+)
+
+// DavMethods are the request methods of WebDAV clients.
+var DavMethods = []string{
+	http.MethodOptions, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// davAliasLifetime is how long a post can be opened by the name a client
+// created it with.
+const davAliasLifetime = time.Hour
+
+var (
+	davPostNamePattern = regexp.MustCompile(`\.(\d+)\.md$`)
+	// davLocks holds a lock system for each user, as the locked names are
+	// the paths below the folder of the user.
+	davLocks sync.Map
+	// davAliases maps the names clients created posts with to the posts,
+	// since posts are listed by their title and id. A post id of 0 is an
+	// empty file which becomes a post once it is written.
+	davAliases sync.Map
+	// davPruned is the time the expired aliases were last removed, in
+	// seconds.
+	davPruned atomic.Int64
+)
+
+// davLockSystem returns the lock system of a user.
+func davLockSystem(username string) webdav.LockSystem {
+	ls, _ := davLocks.LoadOrStore(username, webdav.NewMemLS())
+	return ls.(webdav.LockSystem)
+}
+
+// pruneDavAliases removes the expired aliases of all users, at most once a
+// minute, since aliases are only removed when they are looked up otherwise.
+func pruneDavAliases() {
+	now := time.Now()
+	last := davPruned.Load()
+	if now.Unix()-last < 60 || !davPruned.CompareAndSwap(last, now.Unix()) {
+		return
+	}
+	davAliases.Range(func(key, value interface{}) bool {
+		if now.Sub(value.(davAlias).created) >= davAliasLifetime {
+			davAliases.Delete(key)
+		}
+		return true
+	})
+}
+
+type davAlias struct {
+	postID  uint
+	created time.Time
+}
+
+type davInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i *davInfo) Name() string       { return i.name }
+func (i *davInfo) Size() int64        { return i.size }
+func (i *davInfo) ModTime() time.Time { return i.modTime }
+func (i *davInfo) IsDir() bool        { return i.dir }
+func (i *davInfo) Sys() interface{}   { return nil }
+
+func (i *davInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// davDir is a folder listing.
+type davDir struct {
+	info     *davInfo
+	children []os.FileInfo
+}
+
+func (d *davDir) Close() error                   { return nil }
+func (d *davDir) Read([]byte) (int, error)       { return 0, os.ErrInvalid }
+func (d *davDir) Seek(int64, int) (int64, error) { return 0, os.ErrInvalid }
+func (d *davDir) Write([]byte) (int, error)      { return 0, os.ErrPermission }
+func (d *davDir) Stat() (os.FileInfo, error)     { return d.info, nil }
+func (d *davDir) Readdir(count int) ([]os.FileInfo, error) {
+	if count <= 0 {
+		children := d.children
+		d.children = nil
+		return children, nil
+	}
+	if len(d.children) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.children) {
+		count = len(d.children)
+	}
+	children := d.children[:count]
+	d.children = d.children[count:]
+	return children, nil
+}
+
+// davReader is a post or an uploaded file opened for reading.
+type davReader struct {
+	io.ReadSeeker
+	closer io.Closer
+	info   *davInfo
+}
+
+func (f *davReader) Close() error {
+	if f.closer != nil {
+		return f.closer.Close()
+	}
+	return nil
+}
+
+func (f *davReader) Write([]byte) (int, error)          { return 0, os.ErrPermission }
+func (f *davReader) Stat() (os.FileInfo, error)         { return f.info, nil }
+func (f *davReader) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+
+// davWriter collects the content written by a client in a temporary file and
+// saves it when the file is closed.
+type davWriter struct {
+	tmp    *os.File
+	name   string
+	save   func(src *os.File, size int64) error
+	closed bool
+}
+
+func (f *davWriter) Read([]byte) (int, error)           { return 0, os.ErrInvalid }
+func (f *davWriter) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+
+func (f *davWriter) Write(p []byte) (int, error) {
+	return f.tmp.Write(p)
+}
+
+func (f *davWriter) Seek(offset int64, whence int) (int64, error) {
+	return f.tmp.Seek(offset, whence)
+}
+
+func (f *davWriter) Stat() (os.FileInfo, error) {
+	info, err := f.tmp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &davInfo{name: f.name, size: info.Size(), modTime: time.Now()}, nil
+}
+
+func (f *davWriter) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	defer func(tmp *os.File) {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}(f.tmp)
+	info, err := f.tmp.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return f.save(f.tmp, info.Size())
+}
+
+// davFS exposes the posts of a user as Markdown files in /posts and the
+// uploaded files in /files. Changes go through the same functions as the
+// HTTP handlers. Posts created over WebDAV are private.
+type davFS struct {
+	user  *model.User
+	token *model.AccessToken
+}
+
+// allowed checks the scope of the token and the permission of the user.
+func (fs *davFS) allowed(scope string, permission model.Permission) error {
+	if !fs.token.HasScope(scope) {
+		return os.ErrPermission
+	}
+	if permission != "" && !fs.user.HasPermission(permission) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// davPermission returns the permission removing or renaming the entries of
+// a folder needs, like the routes of the API.
+func davPermission(dir string) model.Permission {
+	if dir == "posts" {
+		return model.PermPostWrite
+	}
+	return ""
+}
+
+// split returns the folder and the file name of a path.
+func (fs *davFS) split(name string) (string, string, error) {
+	parts := strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/")
+	switch {
+	case parts[0] == "":
+		return "", "", nil
+	case parts[0] != "posts" && parts[0] != "files":
+		return "", "", os.ErrNotExist
+	case len(parts) == 1:
+		return parts[0], "", nil
+	case len(parts) == 2:
+		return parts[0], parts[1], nil
+	}
+	return "", "", os.ErrNotExist
+}
+
+func (fs *davFS) aliasKey(name string) string {
+	return fs.user.Username + "/" + name
+}
+
+func (fs *davFS) setAlias(name string, postID uint) {
+	davAliases.Store(fs.aliasKey(name), davAlias{postID: postID, created: time.Now()})
+}
+
+// postID resolves the name of a post. It reports false for names which
+// are not taken.
+func (fs *davFS) postID(name string) (uint, bool) {
+	if alias, ok := davAliases.Load(fs.aliasKey(name)); ok {
+		if time.Since(alias.(davAlias).created) < davAliasLifetime {
+			return alias.(davAlias).postID, true
+		}
+		davAliases.Delete(fs.aliasKey(name))
+	}
+	m := davPostNamePattern.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// post loads a post of the user with its content.
+func (fs *davFS) post(id uint) (*model.Post, string, error) {
+	var post model.Post
+	if err := memento.Db().First(&post, "id = ? AND username = ?", id, fs.user.Username).Error; err != nil {
+		return nil, "", os.ErrNotExist
+	}
+	view, err := utils.PostToView(&post, &model.UserViewModel{}, false)
+	if err != nil {
+		return nil, "", err
+	}
+	return &post, view.Content, nil
+}
+
+func davPostName(post *model.Post, content string) string {
+	return postSlug(content) + "." + strconv.Itoa(int(post.ID)) + ".md"
+}
+
+// files lists the uploaded files of the user by their names. Files sharing a
+// name get their id appended.
+func (fs *davFS) files() (map[string]*model.File, []model.File, error) {
+	var files []model.File
+	if err := memento.Db().Order("id").Find(&files, "username = ?", fs.user.Username).Error; err != nil {
+		return nil, nil, err
+	}
+	count := make(map[string]int, len(files))
+	for _, f := range files {
+		count[davFileName(f.Filename)]++
+	}
+	byName := make(map[string]*model.File, len(files))
+	for i := range files {
+		name := davFileName(files[i].Filename)
+		if count[name] > 1 {
+			ext := path.Ext(name)
+			name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), files[i].ID, ext)
+		}
+		byName[name] = &files[i]
+	}
+	return byName, files, nil
+}
+
+func davFileName(name string) string {
+	name = exportName(name)
+	if strings.HasPrefix(name, ".") {
+		return "_" + name
+	}
+	return name
+}
+
+func (fs *davFS) file(name string) (*model.File, error) {
+	byName, _, err := fs.files()
+	if err != nil {
+		return nil, err
+	}
+	f, ok := byName[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return f, nil
+}
+
+func (fs *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (fs *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	dir, base, err := fs.split(name)
+	if err != nil {
+		return nil, err
+	}
+	if base == "" {
+		return &davInfo{name: path.Base("/" + dir), modTime: time.Now(), dir: true}, nil
+	}
+	if dir == "posts" {
+		id, ok := fs.postID(base)
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		if id == 0 {
+			return &davInfo{name: base, modTime: time.Now()}, nil
+		}
+		post, content, err := fs.post(id)
+		if err != nil {
+			return nil, err
+		}
+		return &davInfo{name: base, size: int64(len(content)), modTime: post.EditedAt}, nil
+	}
+	f, err := fs.file(base)
+	if err != nil {
+		return nil, err
+	}
+	info, err := storage.Default().Stat(f.ContentUrl)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return &davInfo{name: base, size: info.Size, modTime: f.CreatedAt}, nil
+}
+
+func (fs *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	write := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	dir, base, err := fs.split(name)
+	if err != nil {
+		return nil, err
+	}
+	if base == "" {
+		if write {
+			return nil, os.ErrPermission
+		}
+		return fs.openDir(dir)
+	}
+	if dir == "posts" {
+		return fs.openPost(base, flag, write)
+	}
+	return fs.openFile(base, flag, write)
+}
+
+func (fs *davFS) openDir(dir string) (webdav.File, error) {
+	info := &davInfo{name: path.Base("/" + dir), modTime: time.Now(), dir: true}
+	switch dir {
+	case "":
+		return &davDir{info: info, children: []os.FileInfo{
+			&davInfo{name: "posts", modTime: time.Now(), dir: true},
+			&davInfo{name: "files", modTime: time.Now(), dir: true},
+		}}, nil
+	case "posts":
+		var posts []model.Post
+		if err := memento.Db().Order("created_at desc").Find(&posts, "username = ?", fs.user.Username).Error; err != nil {
+			return nil, err
+		}
+		children := make([]os.FileInfo, 0, len(posts))
+		for i := range posts {
+			view, err := utils.PostToView(&posts[i], &model.UserViewModel{}, false)
+			if err != nil {
+				log.Errorf(err.Error())
+				continue
+			}
+			children = append(children, &davInfo{
+				name:    davPostName(&posts[i], view.Content),
+				size:    int64(len(view.Content)),
+				modTime: posts[i].EditedAt,
+			})
+		}
+		return &davDir{info: info, children: children}, nil
+	}
+	byName, _, err := fs.files()
+	if err != nil {
+		return nil, err
+	}
+	children := make([]os.FileInfo, 0, len(byName))
+	for name, f := range byName {
+		size := int64(0)
+		if info, err := storage.Default().Stat(f.ContentUrl); err == nil {
+			size = info.Size
+		}
+		children = append(children, &davInfo{name: name, size: size, modTime: f.CreatedAt})
+	}
+	return &davDir{info: info, children: children}, nil
+}
+
+func (fs *davFS) openPost(name string, flag int, write bool) (webdav.File, error) {
+	id, found := fs.postID(name)
+	var post *model.Post
+	content := ""
+	if found && id != 0 {
+		var err error
+		if post, content, err = fs.post(id); err != nil {
+			return nil, err
+		}
+	}
+	if !write {
+		if !found {
+			return nil, os.ErrNotExist
+		}
+		modTime := time.Now()
+		if post != nil {
+			modTime = post.EditedAt
+		}
+		info := &davInfo{name: name, size: int64(len(content)), modTime: modTime}
+		return &davReader{ReadSeeker: strings.NewReader(content), info: info}, nil
+	}
+	if strings.HasPrefix(name, ".") || !strings.HasSuffix(strings.ToLower(name), ".md") {
+		return nil, os.ErrPermission
+	}
+	if post == nil && flag&os.O_CREATE == 0 {
+		return nil, os.ErrNotExist
+	}
+	save := func(src *os.File, size int64) error {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return err
+		}
+		text := strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+		if post != nil {
+			if strings.TrimSpace(text) == "" || text == strings.TrimRight(content, "\n") {
+				return nil
+			}
+			return editPost(post, text, post.IsPrivate)
+		}
+		if strings.TrimSpace(text) == "" {
+			fs.setAlias(name, 0)
+			return nil
+		}
+		created, err := createPost(fs.user, text, true)
+		if err != nil {
+			return err
+		}
+		fs.setAlias(name, created.ID)
+		return nil
+	}
+	scope := model.ScopeCreate
+	if post != nil {
+		scope = model.ScopeUpdate
+	}
+	if err := fs.allowed(scope, model.PermPostWrite); err != nil {
+		return nil, err
+	}
+	return fs.writer(name, save)
+}
+
+func (fs *davFS) openFile(name string, flag int, write bool) (webdav.File, error) {
+	f, err := fs.file(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if !write {
+		if f == nil {
+			return nil, os.ErrNotExist
+		}
+		return fs.openBlob(name, f)
+	}
+	if strings.HasPrefix(name, ".") {
+		return nil, os.ErrPermission
+	}
+	if f == nil && flag&os.O_CREATE == 0 {
+		return nil, os.ErrNotExist
+	}
+	if f != nil {
+		// uploads cannot be changed, except for the empty files clients
+		// create before writing the content
+		if info, err := storage.Default().Stat(f.ContentUrl); err != nil || info.Size > 0 {
+			return nil, os.ErrPermission
+		}
+		if err := fs.allowed(model.ScopeUpdate, model.PermFileUpload); err != nil {
+			return nil, err
+		}
+		return fs.writer(name, func(src *os.File, size int64) error {
+			return replaceFileContent(f, src, fs.user)
+		})
+	}
+	if err := fs.allowed(model.ScopeCreate, model.PermFileUpload); err != nil {
+		return nil, err
+	}
+	return fs.writer(name, func(src *os.File, size int64) error {
+		_, err := saveFile(fs.user, name, src)
+		return err
+	})
+}
+
+// openBlob opens an uploaded file for reading. Blobs which cannot be seeked,
+// as served by remote backends, are downloaded first.
+func (fs *davFS) openBlob(name string, f *model.File) (webdav.File, error) {
+	src, err := storage.Default().Get(f.ContentUrl)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	info := &davInfo{name: name, modTime: f.CreatedAt}
+	if rs, ok := src.(io.ReadSeeker); ok {
+		size, err := rs.Seek(0, io.SeekEnd)
+		if err == nil {
+			info.size = size
+			_, err = rs.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			_ = src.Close()
+			return nil, err
+		}
+		return &davReader{ReadSeeker: rs, closer: src, info: info}, nil
+	}
+	defer func(src io.ReadCloser) {
+		_ = src.Close()
+	}(src)
+	tmp, err := os.CreateTemp("", "memento-dav-")
+	if err != nil {
+		return nil, err
+	}
+	closer := davTempFile{tmp}
+	if info.size, err = io.Copy(tmp, src); err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = closer.Close()
+		return nil, err
+	}
+	return &davReader{ReadSeeker: tmp, closer: closer, info: info}, nil
+}
+
+// davTempFile removes a temporary file when it is closed.
+type davTempFile struct {
+	*os.File
+}
+
+func (f davTempFile) Close() error {
+	err := f.File.Close()
+	_ = os.Remove(f.Name())
+	return err
+}
+
+func (fs *davFS) writer(name string, save func(src *os.File, size int64) error) (webdav.File, error) {
+	tmp, err := os.CreateTemp("", "memento-dav-")
+	if err != nil {
+		return nil, err
+	}
+	return &davWriter{tmp: tmp, name: name, save: save}, nil
+}
+
+func (fs *davFS) RemoveAll(ctx context.Context, name string) error {
+	dir, base, err := fs.split(name)
+	if err != nil {
+		return err
+	}
+	if base == "" {
+		return os.ErrPermission
+	}
+	if err := fs.allowed(model.ScopeDelete, davPermission(dir)); err != nil {
+		return err
+	}
+	if dir == "posts" {
+		id, ok := fs.postID(base)
+		if !ok {
+			return os.ErrNotExist
+		}
+		davAliases.Delete(fs.aliasKey(base))
+		if id == 0 {
+			return nil
+		}
+		post, _, err := fs.post(id)
+		if err != nil {
+			return err
+		}
+		return deletePost(post)
+	}
+	f, err := fs.file(base)
+	if err != nil {
+		return err
+	}
+	return deleteFile(fs.user, f)
+}
+
+func (fs *davFS) Rename(ctx context.Context, oldName string, newName string) error {
+	oldDir, oldBase, err := fs.split(oldName)
+	if err != nil {
+		return err
+	}
+	newDir, newBase, err := fs.split(newName)
+	if err != nil {
+		return err
+	}
+	if oldBase == "" || newBase == "" || oldDir != newDir || strings.HasPrefix(newBase, ".") {
+		return os.ErrPermission
+	}
+	if err := fs.allowed(model.ScopeUpdate, davPermission(oldDir)); err != nil {
+		return err
+	}
+	if oldDir == "posts" {
+		if !strings.HasSuffix(strings.ToLower(newBase), ".md") {
+			return os.ErrPermission
+		}
+		id, ok := fs.postID(oldBase)
+		if !ok {
+			return os.ErrNotExist
+		}
+		if other, taken := fs.postID(newBase); taken && other != id {
+			return os.ErrExist
+		}
+		davAliases.Delete(fs.aliasKey(oldBase))
+		fs.setAlias(newBase, id)
+		return nil
+	}
+	f, err := fs.file(oldBase)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.file(newBase); err == nil {
+		return os.ErrExist
+	}
+	return memento.Db().Model(f).Update("filename", newBase).Error
+}
+
+// HandleDav serves the posts and files of a user over WebDAV, so they can be
+// mounted as a network drive. Clients log in with the username and a
+// personal access token as the password.
+func HandleDav(c echo.Context) error {
+	username := c.Param("username")
+	user, token, ok := basicAuthAccessToken(c, username)
+	if !ok {
+		return nil
+	}
+	pruneDavAliases()
+	handler := &webdav.Handler{
+		Prefix:     "/dav/" + username,
+		FileSystem: &davFS{user: user, token: token},
+		LockSystem: davLockSystem(user.Username),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Errorf("WebDAV %s %s: %s\n", r.Method, r.URL.Path, err.Error())
+			}
+		},
+	}
+	handler.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
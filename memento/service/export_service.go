@@ -0,0 +1,472 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/storage"
+	"Memento/memento/utils"
+	"archive/zip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// exportLifetime is how long the archive of an export can be downloaded.
+	exportLifetime = 24 * time.Hour
+	// exportVersion is the version of the archive layout in the manifest.
+	exportVersion = 1
+)
+
+var (
+	exportFileLinkPattern = regexp.MustCompile(`(https?://[^\s()<>"]+)?/api/file/download/(\d+)`)
+	exportPostLinkPattern = regexp.MustCompile(`(https?://[^\s()<>"]+)?/public/article/(\d+)`)
+)
+
+// exportFrontMatter is the front matter of an exported post. The Markdown
+// importer reads the dates, visibility and tags back.
+type exportFrontMatter struct {
+	ID         uint      `yaml:"id"`
+	Created    time.Time `yaml:"created"`
+	Edited     time.Time `yaml:"edited"`
+	Visibility string    `yaml:"visibility"`
+	Tags       []string  `yaml:"tags,omitempty"`
+	Likes      int64     `yaml:"likes"`
+	Comments   int64     `yaml:"comments"`
+}
+
+type exportComment struct {
+	ID        uint      `json:"id"`
+	PostID    uint      `json:"postId"`
+	PostUrl   string    `json:"postUrl"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+	EditedAt  time.Time `json:"editedAt"`
+}
+
+type exportFile struct {
+	ID        uint      `json:"id"`
+	Filename  string    `json:"filename"`
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type exportPost struct {
+	ID        uint      `json:"id"`
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"createdAt"`
+	IsPrivate bool      `json:"isPrivate"`
+}
+
+// exportManifest describes the content of an export archive.
+type exportManifest struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exportedAt"`
+	Instance   string    `json:"instance"`
+	User       struct {
+		Username     string    `json:"username"`
+		Nickname     string    `json:"nickname"`
+		Bio          string    `json:"bio"`
+		RegisteredAt time.Time `json:"registeredAt"`
+		IsProtected  bool      `json:"isProtected"`
+		Avatar       string    `json:"avatar,omitempty"`
+	} `json:"user"`
+	Posts     []exportPost `json:"posts"`
+	Files     []exportFile `json:"files"`
+	Comments  string       `json:"comments"`
+	Following string       `json:"following"`
+	Followers string       `json:"followers"`
+}
+
+// linkSignature signs a download link until it expires. kind tells what id
+// refers to, like "export" or "file".
+func linkSignature(kind string, id uint, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(memento.GetConfig().AccessTokenSigningKey))
+	mac.Write([]byte(fmt.Sprintf("%s:%d:%d", kind, id, expires)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkLinkSignature verifies the expires and signature parameters of a
+// signed download link.
+func checkLinkSignature(c echo.Context, kind string, id uint) error {
+	expires, err := strconv.ParseInt(c.QueryParam("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return errors.New("the download link expired")
+	}
+	if !hmac.Equal([]byte(c.QueryParam("signature")), []byte(linkSignature(kind, id, expires))) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func exportJobToView(job *model.ExportJob) model.ExportJobViewModel {
+	view := model.ExportJobViewModel{
+		ID:        job.ID,
+		Status:    job.Status,
+		Size:      job.Size,
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt,
+	}
+	if !job.FinishedAt.IsZero() {
+		view.FinishedAt = &job.FinishedAt
+	}
+	if job.Status == model.JobDone {
+		expires := job.ExpiresAt.Unix()
+		view.ExpiresAt = &job.ExpiresAt
+		view.DownloadUrl = fmt.Sprintf("%s/api/user/export/%d/download?expires=%d&signature=%s",
+			federationBase(), job.ID, expires, linkSignature("export", job.ID, expires))
+	}
+	return view
+}
+
+// removeExpiredExports deletes the archives whose download link expired.
+func removeExpiredExports() {
+	var jobs []model.ExportJob
+	err := memento.Db().Find(&jobs, "status = ? AND expires_at < ?", model.JobDone, time.Now()).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	for _, job := range jobs {
+		_ = os.Remove(job.Archive)
+		if err := memento.Db().Model(&job).Update("status", model.JobExpired).Error; err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+}
+
+// HandleCreateExport starts building the archive of all data of the user.
+func HandleCreateExport(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	removeExpiredExports()
+	var running int64
+	err := memento.Db().Model(&model.ExportJob{}).
+		Where("username = ? AND status IN ?", username, []string{model.JobPending, model.JobRunning}).
+		Count(&running).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	if running > 0 {
+		return utils.RespondError(c, "an export is already running")
+	}
+	job := model.ExportJob{Username: username, Status: model.JobPending}
+	if err := memento.Db().Create(&job).Error; err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown insertion error")
+	}
+	go runExport(job.ID)
+	return c.JSON(http.StatusOK, exportJobToView(&job))
+}
+
+func HandleGetExports(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	removeExpiredExports()
+	var jobs []model.ExportJob
+	if err := memento.Db().Order("created_at desc").Find(&jobs, "username = ?", username).Error; err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	result := make([]model.ExportJobViewModel, 0, len(jobs))
+	for i := range jobs {
+		result = append(result, exportJobToView(&jobs[i]))
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func HandleDeleteExport(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	var job model.ExportJob
+	if err := memento.Db().First(&job, "id = ? AND username = ?", c.Param("id"), username).Error; err != nil {
+		return utils.RespondError(c, "export not exists")
+	}
+	if job.Status == model.JobPending || job.Status == model.JobRunning {
+		return utils.RespondError(c, "the export is running")
+	}
+	if err := memento.Db().Delete(&job).Error; err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown deletion error")
+	}
+	_ = os.Remove(job.Archive)
+	return c.NoContent(http.StatusOK)
+}
+
+// HandleDownloadExport sends the archive of an export. The link is signed,
+// so it works without a login until it expires.
+func HandleDownloadExport(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return utils.RespondError(c, "invalid export id")
+	}
+	if err := checkLinkSignature(c, "export", uint(id)); err != nil {
+		return utils.RespondForbidden(c, err.Error())
+	}
+	var job model.ExportJob
+	if err := memento.Db().First(&job, "id = ? AND status = ?", id, model.JobDone).Error; err != nil {
+		return utils.RespondError(c, "export not exists")
+	}
+	name := fmt.Sprintf("memento-%s-%s.zip", job.Username, job.FinishedAt.Format("20060102"))
+	return c.Attachment(job.Archive, name)
+}
+
+func runExport(id uint) {
+	var job model.ExportJob
+	if err := memento.Db().First(&job, id).Error; err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	job.Status = model.JobRunning
+	if err := memento.Db().Save(&job).Error; err != nil {
+		log.Errorf(err.Error())
+	}
+	job.Archive = filepath.Join(memento.GetExportPath(),
+		utils.Md5string(fmt.Sprintf("%d%s%d", job.ID, job.Username, time.Now().UnixNano()))+".zip")
+	err := buildExport(&job)
+	job.FinishedAt = time.Now()
+	if err != nil {
+		log.Errorf(err.Error())
+		_ = os.Remove(job.Archive)
+		job.Status = model.JobFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = model.JobDone
+		job.ExpiresAt = job.FinishedAt.Add(exportLifetime)
+		if info, err := os.Stat(job.Archive); err == nil {
+			job.Size = info.Size()
+		}
+	}
+	if err := memento.Db().Save(&job).Error; err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
+// exportName makes a file name safe to be used in the archive.
+func exportName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, path.Base(name))
+	if name == "." || name == "" {
+		return "file"
+	}
+	return name
+}
+
+// buildExport writes the archive of an export. Posts are Markdown files with
+// front matter linking the files relatively, so the Markdown importer can
+// import the archive again.
+func buildExport(job *model.ExportJob) error {
+	var user model.User
+	if err := memento.Db().First(&user, "username=?", job.Username).Error; err != nil {
+		return errors.New("username not exists")
+	}
+	out, err := os.Create(job.Archive)
+	if err != nil {
+		return err
+	}
+	defer func(out *os.File) {
+		_ = out.Close()
+	}(out)
+	archive := zip.NewWriter(out)
+	base := federationBase()
+	manifest := exportManifest{Version: exportVersion, ExportedAt: time.Now(), Instance: base}
+	manifest.User.Username = user.Username
+	manifest.User.Nickname = user.Nickname
+	manifest.User.Bio = user.Bio
+	manifest.User.RegisteredAt = user.RegisteredAt
+	manifest.User.IsProtected = user.IsProtected
+
+	var files []model.File
+	if err := memento.Db().Order("id").Find(&files, "username = ?", user.Username).Error; err != nil {
+		return err
+	}
+	filePaths := make(map[string]string, len(files))
+	manifest.Files = make([]exportFile, 0, len(files))
+	for _, f := range files {
+		name := fmt.Sprintf("files/%d-%s", f.ID, exportName(f.Filename))
+		if err := exportBlob(archive, name, f.ContentUrl, f.CreatedAt); err != nil {
+			log.Errorf("Error exporting file %d: %s\n", f.ID, err.Error())
+			continue
+		}
+		filePaths[strconv.Itoa(int(f.ID))] = name
+		manifest.Files = append(manifest.Files, exportFile{ID: f.ID, Filename: f.Filename, Path: name, CreatedAt: f.CreatedAt})
+	}
+	if user.AvatarUrl != "" {
+		name := "avatar" + path.Ext(user.AvatarUrl)
+		if err := exportBlob(archive, name, user.AvatarUrl, user.UpdatedAt); err != nil {
+			log.Errorf(err.Error())
+		} else {
+			manifest.User.Avatar = name
+		}
+	}
+
+	var posts []model.Post
+	if err := memento.Db().Preload("Tags").Order("id").Find(&posts, "username = ?", user.Username).Error; err != nil {
+		return err
+	}
+	postIDs := make(map[string]bool, len(posts))
+	for _, p := range posts {
+		postIDs[strconv.Itoa(int(p.ID))] = true
+	}
+	manifest.Posts = make([]exportPost, 0, len(posts))
+	for _, p := range posts {
+		view, err := utils.PostToView(&p, &model.UserViewModel{}, false)
+		if err != nil {
+			log.Errorf("Error exporting post %d: %s\n", p.ID, err.Error())
+			continue
+		}
+		// links to the files and posts of the archive become relative
+		content := exportFileLinkPattern.ReplaceAllStringFunc(view.Content, func(link string) string {
+			if name, ok := filePaths[exportFileLinkPattern.FindStringSubmatch(link)[2]]; ok {
+				return "../" + name
+			}
+			return link
+		})
+		content = exportPostLinkPattern.ReplaceAllStringFunc(content, func(link string) string {
+			if id := exportPostLinkPattern.FindStringSubmatch(link)[2]; postIDs[id] {
+				return id + ".md"
+			}
+			return link
+		})
+		fm := exportFrontMatter{
+			ID:         p.ID,
+			Created:    p.CreatedAt,
+			Edited:     p.EditedAt,
+			Visibility: "public",
+			Likes:      p.TotalLiked,
+			Comments:   p.TotalComment,
+		}
+		if p.IsPrivate {
+			fm.Visibility = "private"
+		}
+		for _, t := range p.Tags {
+			fm.Tags = append(fm.Tags, strings.TrimPrefix(t.Name, "#"))
+		}
+		header, err := yaml.Marshal(&fm)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("posts/%d.md", p.ID)
+		data := "---\n" + string(header) + "---\n" + content + "\n"
+		if err := exportWrite(archive, name, []byte(data), p.EditedAt); err != nil {
+			return err
+		}
+		manifest.Posts = append(manifest.Posts, exportPost{ID: p.ID, Path: name, CreatedAt: p.CreatedAt, IsPrivate: p.IsPrivate})
+	}
+
+	var comments []model.Comment
+	if err := memento.Db().Order("id").Find(&comments, "username = ?", user.Username).Error; err != nil {
+		return err
+	}
+	exported := make([]exportComment, 0, len(comments))
+	for _, c := range comments {
+		exported = append(exported, exportComment{
+			ID:        c.ID,
+			PostID:    c.PostID,
+			PostUrl:   postURL(base, c.PostID),
+			Content:   c.Content,
+			CreatedAt: c.CreatedAt,
+			EditedAt:  c.EditedAt,
+		})
+	}
+	manifest.Comments = "comments.json"
+	if err := exportJSON(archive, manifest.Comments, exported); err != nil {
+		return err
+	}
+
+	var following []string
+	err = memento.Db().Table("users").Joins("JOIN user_follows ON user_follows.follow_id = users.id").
+		Where("user_follows.user_id = ?", user.ID).Order("users.username").Pluck("users.username", &following).Error
+	if err != nil {
+		return err
+	}
+	var followers []string
+	err = memento.Db().Table("users").Joins("JOIN user_follows ON user_follows.user_id = users.id").
+		Where("user_follows.follow_id = ?", user.ID).Order("users.username").Pluck("users.username", &followers).Error
+	if err != nil {
+		return err
+	}
+	var remote []string
+	err = memento.Db().Model(&model.RemoteFollower{}).Where("username = ?", user.Username).
+		Order("actor_id").Pluck("actor_id", &remote).Error
+	if err != nil {
+		return err
+	}
+	manifest.Following = "following.json"
+	if err := exportJSON(archive, manifest.Following, append([]string{}, following...)); err != nil {
+		return err
+	}
+	manifest.Followers = "followers.json"
+	if err := exportJSON(archive, manifest.Followers, append(append([]string{}, followers...), remote...)); err != nil {
+		return err
+	}
+	if err := exportJSON(archive, "manifest.json", manifest); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func exportWrite(archive *zip.Writer, name string, data []byte, modified time.Time) error {
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func exportJSON(archive *zip.Writer, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return exportWrite(archive, name, data, time.Now())
+}
+
+func exportCopy(archive *zip.Writer, name string, src io.Reader, modified time.Time) error {
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+// exportBlob copies a stored blob into the archive.
+func exportBlob(archive *zip.Writer, name string, key string, modified time.Time) error {
+	r, err := storage.Default().Get(key)
+	if err != nil {
+		return err
+	}
+	defer func(r io.ReadCloser) {
+		_ = r.Close()
+	}(r)
+	return exportCopy(archive, name, r, modified)
+}
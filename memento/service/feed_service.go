@@ -0,0 +1,268 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/feed"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/k3a/html2text"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	feedSize        = memento.PageSize
+	feedSummarySize = 200
+)
+
+// HandleRss serves the RSS feed of a user under its original url.
+func HandleRss(c echo.Context) error {
+	username := c.Param("username")
+	if username == "" {
+		return c.JSON(400, "username is required")
+	}
+	base := federationBase()
+	return respondFeed(c, feed.FormatRSS, func() (*feed.Feed, error) {
+		return userFeed(base, username, base+"/rss/"+username)
+	})
+}
+
+func HandleSiteFeed(c echo.Context) error {
+	base := federationBase()
+	format := c.Param("format")
+	return respondFeed(c, format, func() (*feed.Feed, error) {
+		var posts []model.Post
+		err := visiblePosts(memento.Db(), "").
+			Where("is_private = ?", false).
+			Order("created_at desc").
+			Limit(feedSize).
+			Find(&posts).
+			Error
+		if err != nil {
+			return nil, err
+		}
+		return buildFeed(base, &feed.Feed{
+			Title:       memento.GetConfig().SiteName,
+			Link:        base + "/",
+			FeedURL:     base + "/feed/" + format,
+			Description: memento.GetConfig().Description,
+			Icon:        base + "/favicon.png",
+		}, posts), nil
+	})
+}
+
+func HandleUserFeed(c echo.Context) error {
+	base := federationBase()
+	format := c.Param("format")
+	username := c.Param("username")
+	return respondFeed(c, format, func() (*feed.Feed, error) {
+		return userFeed(base, username, base+"/feed/"+format+"/user/"+username)
+	})
+}
+
+func HandleTagFeed(c echo.Context) error {
+	base := federationBase()
+	format := c.Param("format")
+	name := strings.TrimPrefix(c.Param("tag"), "#")
+	return respondFeed(c, format, func() (*feed.Feed, error) {
+		var tag model.Tag
+		if err := memento.Db().First(&tag, "name=?", "#"+name).Error; err != nil {
+			return nil, err
+		}
+		var posts []model.Post
+		err := visiblePosts(memento.Db(), "").
+			Model(&tag).
+			Order("created_at desc").
+			Limit(feedSize).
+			Association("Posts").
+			Find(&posts, "is_private=?", false)
+		if err != nil {
+			return nil, err
+		}
+		return buildFeed(base, &feed.Feed{
+			Title:       "#" + name + " - " + memento.GetConfig().SiteName,
+			Link:        base + "/tag/" + name,
+			FeedURL:     base + "/feed/" + format + "/tag/" + name,
+			Description: "Memos tagged #" + name,
+		}, posts), nil
+	})
+}
+
+func HandleSearchFeed(c echo.Context) error {
+	base := federationBase()
+	format := c.Param("format")
+	keywords := strings.TrimSpace(c.QueryParam("q"))
+	if keywords == "" {
+		return c.JSON(http.StatusBadRequest, "q is required")
+	}
+	return respondFeed(c, format, func() (*feed.Feed, error) {
+		found, err := searchPosts(keywords)
+		if err != nil {
+			return nil, err
+		}
+		posts := make([]model.Post, 0, len(found))
+		for _, post := range found {
+			if !post.IsPrivate && canViewPost("", &post) {
+				posts = append(posts, post)
+			}
+		}
+		sort.Slice(posts, func(i, j int) bool {
+			return posts[i].CreatedAt.After(posts[j].CreatedAt)
+		})
+		if len(posts) > feedSize {
+			posts = posts[:feedSize]
+		}
+		return buildFeed(base, &feed.Feed{
+			Title:       keywords + " - " + memento.GetConfig().SiteName,
+			Link:        base + "/search?q=" + url.QueryEscape(keywords),
+			FeedURL:     base + "/feed/" + format + "/search?q=" + url.QueryEscape(keywords),
+			Description: "Memos matching " + keywords,
+		}, posts), nil
+	})
+}
+
+// respondFeed renders the feed in the requested format and answers
+// conditional requests with 304 Not Modified.
+func respondFeed(c echo.Context, format string, build func() (*feed.Feed, error)) error {
+	if !feed.IsValidFormat(format) {
+		return c.JSON(http.StatusNotFound, "unknown feed format")
+	}
+	f, err := build()
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, "feed not found")
+		}
+		log.Errorf(err.Error())
+		return c.JSON(http.StatusInternalServerError, "error building feed")
+	}
+	data, err := f.Render(format)
+	if err != nil {
+		log.Errorf(err.Error())
+		return c.JSON(http.StatusInternalServerError, "error building feed")
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	lastModified := f.Updated.UTC().Truncate(time.Second)
+	header := c.Response().Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", "public, max-age=300")
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+	if match := c.Request().Header.Get("If-None-Match"); match != "" {
+		if match == etag || match == "*" || strings.Contains(match, etag) {
+			return c.NoContent(http.StatusNotModified)
+		}
+	} else if since, err := http.ParseTime(c.Request().Header.Get("If-Modified-Since")); err == nil {
+		if !lastModified.IsZero() && !lastModified.After(since) {
+			return c.NoContent(http.StatusNotModified)
+		}
+	}
+	return c.Blob(http.StatusOK, feed.ContentType(format), data)
+}
+
+func userFeed(base string, username string, feedURL string) (*feed.Feed, error) {
+	var user model.User
+	if err := memento.Db().First(&user, "username=?", username).Error; err != nil {
+		return nil, err
+	}
+	var posts []model.Post
+	err := visiblePosts(memento.Db(), "").
+		Where("username = ? AND is_private = ?", username, false).
+		Order("created_at desc").
+		Limit(feedSize).
+		Find(&posts).
+		Error
+	if err != nil {
+		return nil, err
+	}
+	author := feedAuthor(base, &user)
+	return buildFeed(base, &feed.Feed{
+		Title:       user.Nickname,
+		Link:        author.URL,
+		FeedURL:     feedURL,
+		Description: user.Bio,
+		Icon:        author.Avatar,
+		Author:      author,
+	}, posts), nil
+}
+
+// buildFeed adds the posts to the feed as items with their full HTML content.
+func buildFeed(base string, f *feed.Feed, posts []model.Post) *feed.Feed {
+	authors := make(map[string]*feed.Author)
+	for _, post := range posts {
+		postView, err := utils.PostToView(&post, &model.UserViewModel{}, false)
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+		author, ok := authors[post.Username]
+		if !ok {
+			var user model.User
+			if err := memento.Db().First(&user, "username=?", post.Username).Error; err == nil {
+				author = feedAuthor(base, &user)
+			}
+			authors[post.Username] = author
+		}
+		contentHtml := postHTML(base, postView.Content)
+		link := postURL(base, post.ID)
+		item := feed.Item{
+			ID:          link,
+			Title:       findTitleInMd(postView.Content),
+			Link:        link,
+			ContentHTML: contentHtml,
+			Summary:     feedSummary(postView.Content),
+			Author:      author,
+			Published:   post.CreatedAt,
+			Updated:     post.EditedAt,
+		}
+		if item.Updated.Before(item.Published) {
+			item.Updated = item.Published
+		}
+		for _, t := range utils.GetTags(postView.Content) {
+			item.Tags = append(item.Tags, strings.TrimPrefix(t, "#"))
+		}
+		if item.Updated.After(f.Updated) {
+			f.Updated = item.Updated
+		}
+		f.Items = append(f.Items, item)
+	}
+	if f.Updated.IsZero() {
+		f.Updated = time.Unix(0, 0)
+	}
+	return f
+}
+
+func feedAuthor(base string, user *model.User) *feed.Author {
+	return &feed.Author{
+		Name:   user.Nickname,
+		URL:    base + "/user/" + user.Username,
+		Avatar: base + "/api/user/avatar/" + utils.UserToView(user, false).Avatar,
+	}
+}
+
+// postHTML renders the markdown of a post with absolute tag links.
+func postHTML(base string, content string) string {
+	contentHtml := string(mdToHTML([]byte(renderTags(content))))
+	return strings.ReplaceAll(contentHtml, `href="/tag/`, `href="`+base+`/tag/`)
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+func feedSummary(content string) string {
+	plain := whitespace.ReplaceAllString(html2text.HTML2Text(string(mdToHTML([]byte(content)))), " ")
+	if runes := []rune(plain); len(runes) > feedSummarySize {
+		plain = string(runes[:feedSummarySize]) + "…"
+	}
+	return strings.TrimSpace(plain)
+}
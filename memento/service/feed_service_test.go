@@ -0,0 +1,59 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// getUserFeed requests the RSS feed of the user with the header.
+func getUserFeed(t *testing.T, username string, header string, value string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/feed/rss/user/"+username, nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("format", "username")
+	c.SetParamValues("rss", username)
+	if err := HandleUserFeed(c); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestFeedIfNoneMatch(t *testing.T) {
+	user := newUser(t, "author")
+	newPost(t, user, "a memo", false)
+	first := getUserFeed(t, user.Username, "", "")
+	expectStatus(t, first, http.StatusOK)
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	rec := getUserFeed(t, user.Username, "If-None-Match", etag)
+	expectStatus(t, rec, http.StatusNotModified)
+	if rec.Body.Len() != 0 {
+		t.Errorf("a body was sent: %s", rec.Body)
+	}
+	expectStatus(t, getUserFeed(t, user.Username, "If-None-Match", `"other"`), http.StatusOK)
+}
+
+func TestFeedIfModifiedSince(t *testing.T) {
+	user := newUser(t, "author")
+	newPost(t, user, "a memo", false)
+	first := getUserFeed(t, user.Username, "", "")
+	expectStatus(t, first, http.StatusOK)
+	lastModified, err := http.ParseTime(first.Header().Get("Last-Modified"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	since := lastModified.Format(http.TimeFormat)
+	expectStatus(t, getUserFeed(t, user.Username, "If-Modified-Since", since), http.StatusNotModified)
+	before := lastModified.Add(-time.Minute).Format(http.TimeFormat)
+	expectStatus(t, getUserFeed(t, user.Username, "If-Modified-Since", before), http.StatusOK)
+}
@@ -2,17 +2,25 @@ package service
 
 import (
 	"Memento/memento"
+	"Memento/memento/audio"
+	"Memento/memento/mastodon"
 	"Memento/memento/model"
 	"Memento/memento/query"
+	"Memento/memento/storage"
 	"Memento/memento/utils"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
-	"path"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -21,7 +29,6 @@ import (
 )
 
 func HandleFileUpload(c echo.Context) error {
-	now := time.Now()
 	username := c.Get("username").(string)
 	if username == "" {
 		return utils.RespondError(c, "invalid token")
@@ -39,10 +46,41 @@ func HandleFileUpload(c echo.Context) error {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "can not read form file")
 	}
+	file0, err := saveUpload(user, file)
+	if err != nil {
+		return respondUploadError(c, user, err)
+	}
+	return c.JSON(200, echo.Map{
+		"Filename": file.Filename,
+		"ID":       file0.ID,
+	})
+}
+
+// respondUploadError responds to a failed upload, with the quota of the user
+// when the upload policy rejected it.
+func respondUploadError(c echo.Context, user *model.User, err error) error {
+	switch {
+	case errors.Is(err, errQuotaExceeded), errors.Is(err, errFileTooLarge):
+		return c.JSON(http.StatusRequestEntityTooLarge, echo.Map{
+			"message": err.Error(),
+			"quota":   quotaView(user),
+		})
+	case errors.Is(err, errFileTypeBlocked):
+		return c.JSON(http.StatusUnsupportedMediaType, echo.Map{
+			"message": err.Error(),
+			"quota":   quotaView(user),
+		})
+	}
+	return utils.RespondError(c, err.Error())
+}
+
+// saveUpload stores an uploaded file in the upload folder and adds it to the
+// files of the user.
+func saveUpload(user *model.User, file *multipart.FileHeader) (*model.File, error) {
 	src, err := file.Open()
 	if err != nil {
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "form file open error")
+		return nil, errors.New("form file open error")
 	}
 	defer func(src multipart.File) {
 		err := src.Close()
@@ -50,49 +88,201 @@ func HandleFileUpload(c echo.Context) error {
 			log.Errorf(err.Error())
 		}
 	}(src)
-	ext := path.Ext(file.Filename)
-	filename := utils.Md5string(fmt.Sprintf("%d%s", now.UnixMilli(), file.Filename)) + ext
-	filepath := path.Join(memento.GetUploadPath(), filename)
-	// Destination
-	dst, err := os.Create(filepath)
+	return saveFile(user, file.Filename, src)
+}
+
+// saveFile stores the content of a file and adds it to the files of the
+// user. Files with the same content share one blob.
+func saveFile(user *model.User, name string, src io.Reader) (*model.File, error) {
+	blob, meta, err := storeBlob(src, func(size int64, mimeType string) error {
+		return checkUpload(user, 0, size, mimeType)
+	}, true)
 	if err != nil {
-		log.Errorf(err.Error())
-		return utils.RespondError(c, "os file open error")
-	}
-	defer func(dst *os.File) {
-		err := dst.Close()
-		if err != nil {
-			log.Errorf(err.Error())
+		if uploadRejected(err) {
+			return nil, err
 		}
-	}(dst)
-	// Copy
-	if _, err = io.Copy(dst, src); err != nil {
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "data copy error")
+		return nil, errors.New("data copy error")
 	}
 	file0 := model.File{
 		Username:   user.Username,
-		Filename:   file.Filename,
-		ContentUrl: filepath,
+		Filename:   name,
+		ContentUrl: blob.Key,
+		Hash:       blob.Hash,
+		Size:       blob.Size,
+		MimeType:   meta.MimeType,
+		Width:      meta.Width,
+		Height:     meta.Height,
+		Duration:   meta.Duration,
+		Codec:      meta.Codec,
 	}
 	err = memento.Db().Transaction(
 		func(tx *gorm.DB) error {
-			err := tx.Model(&user).Association("Files").Append(&file0)
+			// concurrent uploads are counted by now
+			if err := tx.Model(user).Select("used_bytes").Scan(&user.UsedBytes).Error; err != nil {
+				return err
+			}
+			if err := checkQuota(user, 0, blob.Size); err != nil {
+				return err
+			}
+			err := tx.Model(user).Association("Files").Append(&file0)
 			if err != nil {
 				return err
 			}
 			user.TotalFiles += 1
-			tx.Save(&user)
+			user.UsedBytes += blob.Size
+			tx.Save(user)
 			return nil
 		})
 	if err != nil {
+		if err := releaseBlob(blob.Hash); err != nil {
+			log.Errorf(err.Error())
+		}
+		if uploadRejected(err) {
+			return nil, err
+		}
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown error")
+		return nil, errors.New("unknown error")
 	}
-	return c.JSON(200, echo.Map{
-		"Filename": file.Filename,
-		"ID":       file0.ID,
-	})
+	generateVariants(file0)
+	transcribeAudio(file0)
+	return &file0, nil
+}
+
+var (
+	errQuotaExceeded   = errors.New("storage quota exceeded")
+	errFileTooLarge    = errors.New("file too large")
+	errFileTypeBlocked = errors.New("file type not allowed")
+)
+
+// uploadRejected reports whether an upload failed the upload policy.
+func uploadRejected(err error) bool {
+	return errors.Is(err, errQuotaExceeded) || errors.Is(err, errFileTooLarge) || errors.Is(err, errFileTypeBlocked)
+}
+
+// userQuota returns the storage quota of a user in bytes, 0 for none.
+func userQuota(user *model.User) int64 {
+	switch {
+	case user.StorageQuota > 0:
+		return user.StorageQuota
+	case user.StorageQuota < 0:
+		return 0
+	}
+	return memento.GetConfig().DefaultQuota
+}
+
+// checkQuota checks whether the files of a user fit into the quota when a
+// file of oldSize is replaced with one of size.
+func checkQuota(user *model.User, oldSize int64, size int64) error {
+	if quota := userQuota(user); quota > 0 && size > oldSize && user.UsedBytes-oldSize+size > quota {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// checkUpload applies the upload policy to a file with the given size and
+// sniffed MIME type, which replaces a file of oldSize.
+func checkUpload(user *model.User, oldSize int64, size int64, mimeType string) error {
+	if limit := memento.GetConfig().MaxUploadSize; limit > 0 && size > limit {
+		return errFileTooLarge
+	}
+	if !uploadTypeAllowed(mimeType) {
+		return errFileTypeBlocked
+	}
+	return checkQuota(user, oldSize, size)
+}
+
+// uploadTypeAllowed matches a MIME type against the allowed types, which
+// may end with a wildcard like image/*.
+func uploadTypeAllowed(mimeType string) bool {
+	allowed := memento.GetConfig().AllowedTypes
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, t := range allowed {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == mimeType || t == "*/*" || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffType detects the MIME type of content from its first bytes, without
+// parameters like the charset.
+func sniffType(head []byte) string {
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		// the standard library does not know the image types of ISO BMFF
+		switch string(head[8:12]) {
+		case "avif", "avis":
+			return "image/avif"
+		case "heic", "heix", "heim", "heis":
+			return "image/heic"
+		}
+	}
+	t, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		t = echo.MIMEOctetStream
+	}
+	switch t {
+	case echo.MIMEOctetStream, "application/ogg", "audio/wave":
+		// m4a, flac, MP3 without tags and the codecs of Ogg are unknown to
+		// the standard library
+		if a := audio.DetectType(head); a != "" {
+			return a
+		}
+	}
+	return t
+}
+
+func quotaView(user *model.User) *model.QuotaViewModel {
+	allowed := memento.GetConfig().AllowedTypes
+	if allowed == nil {
+		allowed = []string{}
+	}
+	return &model.QuotaViewModel{
+		UsedBytes:     user.UsedBytes,
+		QuotaBytes:    userQuota(user),
+		MaxUploadSize: memento.GetConfig().MaxUploadSize,
+		AllowedTypes:  allowed,
+	}
+}
+
+// HandleGetQuota returns the storage usage and upload limits of the user.
+func HandleGetQuota(c echo.Context) error {
+	username := c.Get("username").(string)
+	var user model.User
+	if err := memento.Db().First(&user, "username=?", username).Error; err != nil {
+		return utils.RespondUnauthorized(c)
+	}
+	return c.JSON(http.StatusOK, quotaView(&user))
+}
+
+// HandleSetQuota sets the storage quota of a user in bytes. 0 applies the
+// default quota and -1 lifts the limit.
+func HandleSetQuota(c echo.Context) error {
+	username := c.FormValue("username")
+	quota, err := strconv.ParseInt(c.FormValue("quota"), 10, 64)
+	if err != nil || quota < -1 {
+		return utils.RespondError(c, "invalid quota")
+	}
+	var user model.User
+	err = memento.Db().First(&user, "username=?", username).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.RespondError(c, "username not exists")
+		}
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	before := user.StorageQuota
+	err = memento.Db().Model(&user).UpdateColumn("storage_quota", quota).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown save error")
+	}
+	recordAudit(c, model.AuditUserQuota, username, before, quota)
+	return c.NoContent(http.StatusOK)
 }
 
 func HandleFileDelete(c echo.Context) error {
@@ -119,21 +309,278 @@ func HandleFileDelete(c echo.Context) error {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "unknown query error")
 	}
-	err = memento.Db().Transaction(
+	if file.Username != user.Username {
+		return utils.RespondForbidden(c, "permission denied")
+	}
+	// files still linked from posts are only deleted when forced
+	if posts := filePosts(file.ID)[file.ID]; len(posts) > 0 && c.QueryParam("force") != "true" {
+		return c.JSON(http.StatusConflict, echo.Map{
+			"message": "file is used by posts",
+			"posts":   posts,
+		})
+	}
+	if err := deleteFile(&user, &file); err != nil {
+		return utils.RespondInternalError(c, err.Error())
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// deleteFile removes a file from the files of the user, and its blob once no
+// other file references it.
+func deleteFile(user *model.User, file *model.File) error {
+	err := memento.Db().Transaction(
 		func(tx *gorm.DB) error {
-			err := tx.Model(&user).Association("Files").Delete(&file)
+			err := tx.Model(user).Association("Files").Delete(file)
+			if err != nil {
+				return err
+			}
+			err = tx.Exec("DELETE FROM post_files WHERE file_id = ?", file.ID).Error
 			if err != nil {
 				return err
 			}
 			user.TotalFiles -= 1
-			tx.Save(&user)
+			user.UsedBytes -= file.Size
+			tx.Save(user)
 			return nil
 		})
 	if err != nil {
-		return utils.RespondInternalError(c, "unknown transaction error")
+		log.Errorf(err.Error())
+		return errors.New("unknown transaction error")
+	}
+	if err := releaseFileContent(file); err != nil {
+		log.Errorf(err.Error())
+	}
+	return nil
+}
+
+// blobLock serializes the reference counting of blobs with their storage, so
+// a blob is never removed while a new reference to it is being added.
+var blobLock sync.Mutex
+
+func blobKey(hash string) string {
+	return storage.UploadPrefix + "sha256/" + hash[:2] + "/" + hash
+}
+
+// blobMeta describes the content of a blob.
+type blobMeta struct {
+	MimeType string
+	Width    int
+	Height   int
+	// Duration and Codec describe audio.
+	Duration float64
+	Codec    string
+}
+
+// storeBlob stores content under its SHA-256 and counts a reference to it.
+// The blob is only written when no file has the same content yet. check is
+// given the size and sniffed MIME type of the content before it is stored.
+// The metadata of images is removed when clean is set, unless configured
+// otherwise.
+func storeBlob(src io.Reader, check func(size int64, mimeType string) error, clean bool) (*model.Blob, blobMeta, error) {
+	var meta blobMeta
+	tmp, err := os.CreateTemp("", "memento-upload-")
+	if err != nil {
+		return nil, meta, err
 	}
-	_ = os.Remove(file.ContentUrl)
-	return c.NoContent(http.StatusOK)
+	defer func(tmp *os.File) {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}(tmp)
+	if limit := memento.GetConfig().MaxUploadSize; limit > 0 && check != nil {
+		// reading one byte more is enough to reject the file
+		src = io.LimitReader(src, limit+1)
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), src)
+	if err != nil {
+		return nil, meta, err
+	}
+	head := make([]byte, 512)
+	n, err := tmp.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, meta, err
+	}
+	meta.MimeType = sniffType(head[:n])
+	if meta.MimeType == "video/mp4" || audio.CanInspect(meta.MimeType) {
+		inspectAudio(tmp, size, &meta)
+	}
+	if check != nil {
+		if err := check(size, meta.MimeType); err != nil {
+			return nil, meta, err
+		}
+	}
+	if strings.HasPrefix(meta.MimeType, "image/") {
+		sum, stripped, err := inspectImage(tmp, size, &meta, clean && !memento.GetConfig().KeepImageMetadata)
+		if err != nil {
+			return nil, meta, err
+		}
+		if stripped >= 0 {
+			size = stripped
+			h = sum
+		}
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	blobLock.Lock()
+	defer blobLock.Unlock()
+	var blob model.Blob
+	err = memento.Db().First(&blob, "hash = ?", hash).Error
+	if err == nil {
+		err = memento.Db().Model(&blob).UpdateColumn("ref_count", gorm.Expr("ref_count + 1")).Error
+		if err != nil {
+			return nil, meta, err
+		}
+		blob.RefCount++
+		return &blob, meta, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, meta, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, meta, err
+	}
+	blob = model.Blob{Hash: hash, Key: blobKey(hash), Size: size, RefCount: 1}
+	if err := storage.Default().Put(blob.Key, tmp, size); err != nil {
+		return nil, meta, err
+	}
+	if err := memento.Db().Create(&blob).Error; err != nil {
+		return nil, meta, err
+	}
+	return &blob, meta, nil
+}
+
+// releaseBlob drops a reference to a blob and removes the blob with its
+// last reference.
+func releaseBlob(hash string) error {
+	blobLock.Lock()
+	defer blobLock.Unlock()
+	var blob model.Blob
+	if err := memento.Db().First(&blob, "hash = ?", hash).Error; err != nil {
+		return err
+	}
+	if blob.RefCount > 1 {
+		return memento.Db().Model(&blob).UpdateColumn("ref_count", gorm.Expr("ref_count - 1")).Error
+	}
+	if err := memento.Db().Delete(&blob).Error; err != nil {
+		return err
+	}
+	deleteVariants(&model.File{Hash: blob.Hash})
+	return storage.Default().Delete(blob.Key)
+}
+
+// releaseFileContent releases the content of a file, which files uploaded
+// before deduplication own alone.
+func releaseFileContent(file *model.File) error {
+	if file.Hash == "" {
+		deleteVariants(file)
+		return storage.Default().Delete(file.ContentUrl)
+	}
+	return releaseBlob(file.Hash)
+}
+
+// replaceFileContent gives a file new content, checked by the upload policy
+// when user is set, and updates the storage used by its owner.
+func replaceFileContent(file *model.File, src io.Reader, user *model.User) error {
+	var check func(size int64, mimeType string) error
+	if user != nil {
+		check = func(size int64, mimeType string) error {
+			return checkUpload(user, file.Size, size, mimeType)
+		}
+	}
+	blob, meta, err := storeBlob(src, check, user != nil)
+	if err != nil {
+		return err
+	}
+	old := *file
+	err = memento.Db().Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(file).
+			Updates(map[string]interface{}{
+				"content_url": blob.Key,
+				"hash":        blob.Hash,
+				"size":        blob.Size,
+				"mime_type":   meta.MimeType,
+				"width":       meta.Width,
+				"height":      meta.Height,
+				"duration":    meta.Duration,
+				"codec":       meta.Codec,
+				"transcript":  "",
+			}).
+			Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&model.User{}).Where("username = ?", file.Username).
+			UpdateColumn("used_bytes", gorm.Expr("used_bytes + ?", blob.Size-old.Size)).
+			Error
+	})
+	if err != nil {
+		if err := releaseBlob(blob.Hash); err != nil {
+			log.Errorf(err.Error())
+		}
+		return err
+	}
+	updated := old
+	updated.ContentUrl, updated.Hash, updated.MimeType = blob.Key, blob.Hash, meta.MimeType
+	generateVariants(updated)
+	transcribeAudio(updated)
+	return releaseFileContent(&old)
+}
+
+// DeduplicateFiles moves the files uploaded before deduplication into blobs
+// named by their content, sharing the blobs of equal files. progress is
+// called for every file with the error of its migration.
+func DeduplicateFiles(progress func(file *model.File, err error)) (int, error) {
+	var files []model.File
+	err := memento.Db().Where("(hash = '' OR hash IS NULL) AND username IS NOT NULL").Order("id").Find(&files).Error
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := range files {
+		err := deduplicateFile(&files[i])
+		if err == nil {
+			count++
+		}
+		if progress != nil {
+			progress(&files[i], err)
+		}
+	}
+	return count, nil
+}
+
+func deduplicateFile(file *model.File) error {
+	src, err := storage.Default().Get(file.ContentUrl)
+	if err != nil {
+		return err
+	}
+	defer func(src io.ReadCloser) {
+		_ = src.Close()
+	}(src)
+	return replaceFileContent(file, src, nil)
+}
+
+// HandleAdminStorageReport shows how much space the deduplication of uploads
+// saves.
+func HandleAdminStorageReport(c echo.Context) error {
+	var report model.StorageReportViewModel
+	err := memento.Db().Model(&model.Blob{}).
+		Select("COUNT(*) AS blobs, COALESCE(SUM(ref_count), 0) AS files, " +
+			"COALESCE(SUM(size), 0) AS stored_bytes, COALESCE(SUM(size * ref_count), 0) AS logical_bytes").
+		Scan(&report).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	err = memento.Db().Model(&model.File{}).
+		Where("(hash = '' OR hash IS NULL) AND username IS NOT NULL").
+		Count(&report.LegacyFiles).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	report.SavedBytes = report.LogicalBytes - report.StoredBytes
+	return c.JSON(http.StatusOK, report)
 }
 
 func HandleGetFile(c echo.Context) error {
@@ -147,7 +594,175 @@ func HandleGetFile(c echo.Context) error {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "unknown query error")
 	}
-	return c.Inline(file.ContentUrl, file.Filename)
+	viewer := c.Get("username").(string)
+	if (viewer == "" || viewer != file.Username) && fileIsPrivate(&file) {
+		if c.QueryParam("signature") == "" {
+			return utils.RespondError(c, "file not exists")
+		}
+		if err := checkLinkSignature(c, "file", file.ID); err != nil {
+			return utils.RespondForbidden(c, err.Error())
+		}
+	}
+	if size := c.QueryParam("size"); size != "" && size != "original" {
+		return serveVariant(c, &file, size)
+	}
+	return serveBlob(c, fileDownload(c, &file))
+}
+
+// fileDownload describes the download of a file. Its hash is the entity tag
+// of files uploaded since deduplication, and urls with the hash, or its
+// beginning, in the v parameter are cached as immutable. The download
+// parameter makes browsers save the file.
+func fileDownload(c echo.Context, file *model.File) download {
+	d := download{
+		Key:         file.ContentUrl,
+		Name:        file.Filename,
+		ContentType: utils.ContentType(file.Filename, file.MimeType),
+		Attachment:  c.QueryParam("download") != "",
+		Private:     fileIsPrivate(file),
+	}
+	if file.Hash != "" {
+		d.ETag = `"` + file.Hash + `"`
+		v := c.QueryParam("v")
+		d.Immutable = len(v) >= 8 && strings.HasPrefix(file.Hash, v)
+	}
+	return d
+}
+
+// download describes how a blob is sent to the client.
+type download struct {
+	Key  string
+	Name string
+	// ContentType is detected from Name when it is empty.
+	ContentType string
+	// ETag is a strong validator of the content, like its hash, if known.
+	ETag string
+	// Immutable marks blobs whose url changes with their content, which are
+	// cached for a year.
+	Immutable bool
+	// Attachment makes browsers save the blob instead of showing it.
+	Attachment bool
+	// Private blobs are only kept in the cache of the browser.
+	Private bool
+}
+
+// cacheControl returns the caching rule of a download: media is cached for
+// a day and other files are revalidated whenever they are used.
+func (d *download) cacheControl(contentType string) string {
+	scope := "public"
+	if d.Private {
+		scope = "private"
+	}
+	if d.Immutable {
+		return scope + ", max-age=31536000, immutable"
+	}
+	switch t, _, _ := strings.Cut(contentType, "/"); t {
+	case "image", "audio", "video", "font":
+		return scope + ", max-age=86400"
+	}
+	return scope + ", no-cache"
+}
+
+// etagMatches reports whether an If-None-Match header lists an entity tag.
+func etagMatches(header string, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// serveBlob sends a stored blob with its validators and caching rule and
+// answers range and conditional requests. Types which run scripts, like HTML
+// and SVG, are always sent as attachments. When redirects are configured the
+// client downloads the blob from a presigned url of the backend instead.
+func serveBlob(c echo.Context, d download) error {
+	contentType := d.ContentType
+	if contentType == "" {
+		contentType = utils.ContentType(d.Name, "")
+	}
+	disposition := "inline"
+	if d.Attachment || utils.IsActiveContent(contentType) {
+		disposition = "attachment"
+	}
+	if formatted := mime.FormatMediaType(disposition, map[string]string{"filename": d.Name}); formatted != "" {
+		disposition = formatted
+	}
+	cacheControl := d.cacheControl(contentType)
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentDisposition, disposition)
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	header.Set("Cache-Control", cacheControl)
+	if d.ETag != "" {
+		header.Set("ETag", d.ETag)
+		if etagMatches(c.Request().Header.Get("If-None-Match"), d.ETag) {
+			return c.NoContent(http.StatusNotModified)
+		}
+	}
+	if memento.GetConfig().Storage.Redirect {
+		u, err := storage.Default().Presign(d.Key, time.Hour, url.Values{
+			"response-content-disposition": {disposition},
+			"response-content-type":        {contentType},
+			"response-cache-control":       {cacheControl},
+		})
+		if err == nil {
+			// the presigned url expires
+			header.Set("Cache-Control", "no-cache")
+			return c.Redirect(http.StatusFound, u)
+		}
+		if !errors.Is(err, storage.ErrNotSupported) {
+			log.Errorf(err.Error())
+		}
+	}
+	info, err := storage.Default().Stat(d.Key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return echo.ErrNotFound
+		}
+		log.Errorf(err.Error())
+		return echo.ErrInternalServerError
+	}
+	r, err := storage.Open(storage.Default(), d.Key, info.Size)
+	if err != nil {
+		log.Errorf(err.Error())
+		return echo.ErrInternalServerError
+	}
+	defer func(r io.ReadCloser) {
+		_ = r.Close()
+	}(r)
+	if rs, ok := r.(io.ReadSeeker); ok {
+		http.ServeContent(c.Response(), c.Request(), d.Name, info.ModTime, rs)
+		return nil
+	}
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(info.Size, 10))
+	header.Set(echo.HeaderLastModified, info.ModTime.UTC().Format(http.TimeFormat))
+	return c.Stream(http.StatusOK, contentType, r)
+}
+
+// SkipGzip skips compressing downloads, which are mostly compressed media
+// and answer range requests with parts of the uncompressed content.
+func SkipGzip(c echo.Context) bool {
+	p := c.Request().URL.Path
+	return strings.HasPrefix(p, "/api/file/download/") || strings.HasPrefix(p, "/api/user/avatar/")
+}
+
+// fileKinds are the values of the type filter of the file list.
+var fileKinds = []string{"image", "video", "audio"}
+
+// filesOfKind narrows a query of files to a kind of MIME type like "audio".
+// Files uploaded before types were sniffed are matched by their names.
+func filesOfKind(query *gorm.DB, kind string) *gorm.DB {
+	byName := memento.Db().Where("1 = 0")
+	for _, ext := range utils.ExtensionsOfKind(kind) {
+		byName = byName.Or("LOWER(filename) LIKE ?", "%"+ext)
+	}
+	return query.Where(
+		memento.Db().Where("mime_type LIKE ?", kind+"/%").
+			Or(memento.Db().Where("mime_type = '' OR mime_type IS NULL").Where(byName)),
+	)
 }
 
 func HandleGetResourcesList(c echo.Context) error {
@@ -162,18 +777,30 @@ func HandleGetResourcesList(c echo.Context) error {
 	if err != nil {
 		return utils.RespondError(c, "User not found")
 	}
+	query := memento.Db().Model(&model.File{}).Where("username=?", username)
+	if kind := c.QueryParam("type"); kind != "" {
+		if !utils.Contains(fileKinds, kind) {
+			return utils.RespondError(c, "Invalid type")
+		}
+		query = filesOfKind(query, kind)
+	}
 	var files []model.File
-	err = memento.Db().Order("created_at DESC").Offset(page*memento.PageSize).Limit(memento.PageSize).Find(&files, "username=?", username).Error
+	err = query.Session(&gorm.Session{}).Order("created_at DESC").Offset(page * memento.PageSize).Limit(memento.PageSize).Find(&files).Error
 	if err != nil {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "Failed to find files")
 	}
 	var total int64
-	err = memento.Db().Table("files").Select("username=?", username).Count(&total).Error
+	err = query.Session(&gorm.Session{}).Count(&total).Error
 	if err != nil {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "Failed to find files")
 	}
+	ids := make([]uint, len(files))
+	for i := range files {
+		ids[i] = files[i].ID
+	}
+	posts := filePosts(ids...)
 	result := make([]model.FileViewModel, len(files))
 
 	for index, file := range files {
@@ -181,6 +808,18 @@ func HandleGetResourcesList(c echo.Context) error {
 			ID:       file.ID,
 			Filename: file.Filename,
 			Time:     file.CreatedAt,
+			MimeType: fileMimeType(&file),
+			Width:    file.Width,
+			Height:   file.Height,
+			// the visibility set by the owner and the one in effect
+			Visibility: file.Visibility,
+			IsPrivate:  fileIsPrivate(&file),
+			// an empty list rather than null for unused files
+			Posts: append([]uint{}, posts[file.ID]...),
+			// the length and codec of audio
+			Duration:   file.Duration,
+			Codec:      file.Codec,
+			Transcript: file.Transcript,
 		}
 	}
 
@@ -189,3 +828,219 @@ func HandleGetResourcesList(c echo.Context) error {
 		"maxPage": utils.MaxPage(total),
 	})
 }
+
+func fileURL(base string, id uint) string {
+	return base + "/api/file/download/" + strconv.Itoa(int(id))
+}
+
+// attachFiles appends files of the user to the content the way the web
+// editor embeds uploads. Files which are already linked are skipped.
+func attachFiles(user *model.User, content string, ids []string) (string, error) {
+	base := federationBase()
+	for _, id := range ids {
+		var file model.File
+		if err := memento.Db().First(&file, "id = ? AND username = ?", id, user.Username).Error; err != nil {
+			return "", errors.New("attachment not exists")
+		}
+		if strings.Contains(content, fileURL(base, file.ID)) {
+			continue
+		}
+		if mediaType(file.Filename) == mastodon.MediaImage {
+			content += "\n\n![image](" + fileURL(base, file.ID) + ")"
+		} else if isAudio(&file) {
+			content += "\n\n![audio](" + fileURL(base, file.ID) + ")"
+		} else {
+			content += "\n\n[" + file.Filename + "](" + fileURL(base, file.ID) + ")"
+		}
+	}
+	return strings.TrimSpace(content), nil
+}
+
+// linkedFiles returns the uploaded files linked from a post, in the order of
+// their first link.
+func linkedFiles(content string) []model.File {
+	var ids []string
+	for _, m := range utils.FileLinkPattern.FindAllStringSubmatch(content, -1) {
+		if !utils.Contains(ids, m[1]) {
+			ids = append(ids, m[1])
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	var files []model.File
+	if err := memento.Db().Find(&files, "id IN ?", ids).Error; err != nil {
+		log.Errorf(err.Error())
+		return nil
+	}
+	result := make([]model.File, 0, len(files))
+	for _, id := range ids {
+		for _, f := range files {
+			if strconv.Itoa(int(f.ID)) == id {
+				result = append(result, f)
+			}
+		}
+	}
+	return result
+}
+
+// fileQueryPattern matches links to uploaded files with their parameters.
+var fileQueryPattern = regexp.MustCompile(`/api/file/download/(\d+)(\?[^\s()<>"'\]]*)?`)
+
+// fileLinkLifetime is how long signed links to private files work at least.
+// Links signed within the same hour are equal, so browsers can cache them.
+const fileLinkLifetime = time.Hour
+
+// signedFileQuery returns the parameters which let anyone download a private
+// file for a while.
+func signedFileQuery(id uint) url.Values {
+	expires := time.Now().Add(fileLinkLifetime).Truncate(time.Hour).Add(time.Hour).Unix()
+	return url.Values{
+		"expires":   {strconv.FormatInt(expires, 10)},
+		"signature": {linkSignature("file", id, expires)},
+	}
+}
+
+// fileLink returns the link to a file for the viewer, which is signed when
+// the file is a private file of the viewer.
+func fileLink(base string, file *model.File, viewer string) string {
+	if viewer == "" || file.Username != viewer || !fileIsPrivate(file) {
+		return fileURL(base, file.ID)
+	}
+	return fileURL(base, file.ID) + "?" + signedFileQuery(file.ID).Encode()
+}
+
+// rewriteFileLinks passes the id and parameters of every file link in the
+// content to rewrite, which changes the parameters in place.
+func rewriteFileLinks(content string, rewrite func(id string, query url.Values)) string {
+	return fileQueryPattern.ReplaceAllStringFunc(content, func(link string) string {
+		m := fileQueryPattern.FindStringSubmatch(link)
+		query, err := url.ParseQuery(strings.TrimPrefix(m[2], "?"))
+		if err != nil {
+			return link
+		}
+		rewrite(m[1], query)
+		if len(query) == 0 {
+			return "/api/file/download/" + m[1]
+		}
+		return "/api/file/download/" + m[1] + "?" + query.Encode()
+	})
+}
+
+// signFileLinks signs the links to private files of the viewer in the content
+// of a post, so the viewer's browser can show them without a token.
+func signFileLinks(content string, viewer string) string {
+	if viewer == "" {
+		return content
+	}
+	private := make(map[string]bool)
+	for _, file := range linkedFiles(content) {
+		if file.Username == viewer && fileIsPrivate(&file) {
+			private[strconv.Itoa(int(file.ID))] = true
+		}
+	}
+	if len(private) == 0 {
+		return content
+	}
+	return rewriteFileLinks(content, func(id string, query url.Values) {
+		if !private[id] {
+			return
+		}
+		n, _ := strconv.Atoi(id)
+		for k, v := range signedFileQuery(uint(n)) {
+			query[k] = v
+		}
+	})
+}
+
+// signPostLinks signs the file links in the view of a post for its author.
+func signPostLinks(view *model.PostViewModel, post *model.Post, viewer string) {
+	if post.Username == viewer {
+		view.Content = signFileLinks(view.Content, viewer)
+	}
+}
+
+// unsignFileLinks removes the signatures from the file links of content
+// which is saved, since they expire.
+func unsignFileLinks(content string) string {
+	return rewriteFileLinks(content, func(id string, query url.Values) {
+		query.Del("expires")
+		query.Del("signature")
+	})
+}
+
+// filePosts returns the posts linking each of the files, oldest first.
+func filePosts(ids ...uint) map[uint][]uint {
+	var links []struct {
+		FileID uint
+		PostID uint
+	}
+	err := memento.Db().Table("post_files").
+		Select("post_files.file_id, post_files.post_id").
+		Joins("JOIN posts ON posts.id = post_files.post_id AND posts.deleted_at IS NULL").
+		Where("post_files.file_id IN ?", ids).
+		Order("post_files.post_id").
+		Scan(&links).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+	}
+	posts := make(map[uint][]uint)
+	for _, link := range links {
+		posts[link.FileID] = append(posts[link.FileID], link.PostID)
+	}
+	return posts
+}
+
+// HandleSetFileVisibility sets the visibility of a file of the user. An
+// empty visibility makes the file follow the posts linking it again.
+func HandleSetFileVisibility(c echo.Context) error {
+	username := c.Get("username").(string)
+	visibility := c.FormValue("visibility")
+	if visibility != "" && visibility != model.FilePublic && visibility != model.FilePrivate {
+		return utils.RespondError(c, "invalid visibility")
+	}
+	var file model.File
+	err := memento.Db().First(&file, "id = ? AND username = ?", c.FormValue("id"), username).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.RespondError(c, "file not exists")
+		}
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	if err := memento.Db().Model(&file).UpdateColumn("visibility", visibility).Error; err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown save error")
+	}
+	file.Visibility = visibility
+	return c.JSON(http.StatusOK, echo.Map{
+		"visibility": visibility,
+		"isPrivate":  fileIsPrivate(&file),
+	})
+}
+
+// HandleGetFileLink returns a link to a file of the user which works without
+// a token. Links to private files are signed and expire.
+func HandleGetFileLink(c echo.Context) error {
+	username := c.Get("username").(string)
+	var file model.File
+	err := memento.Db().First(&file, "id = ? AND username = ?", c.Param("id"), username).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.RespondError(c, "file not exists")
+		}
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	link := fileURL(federationBase(), file.ID)
+	if !fileIsPrivate(&file) {
+		return c.JSON(http.StatusOK, echo.Map{"url": link})
+	}
+	query := signedFileQuery(file.ID)
+	expires, _ := strconv.ParseInt(query.Get("expires"), 10, 64)
+	return c.JSON(http.StatusOK, echo.Map{
+		"url":       link + "?" + query.Encode(),
+		"expiresAt": time.Unix(expires, 0),
+	})
+}
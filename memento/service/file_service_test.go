@@ -0,0 +1,59 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newFile(t *testing.T, user *model.User) *model.File {
+	t.Helper()
+	file, err := saveFile(user, "note.txt", strings.NewReader("some notes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// getFile requests a file as the user, or as a visitor for an empty name.
+func getFile(t *testing.T, file *model.File, username string) int {
+	t.Helper()
+	id := strconv.Itoa(int(file.ID))
+	return call(t, HandleGetFile, http.MethodGet, "/api/file/download/"+id, nil, username, "id", id).Code
+}
+
+func TestUnlinkedFileIsPrivate(t *testing.T) {
+	owner := newUser(t, "owner")
+	file := newFile(t, owner)
+	if code := getFile(t, file, ""); code == http.StatusOK {
+		t.Error("a visitor downloaded an upload no post links")
+	}
+	if code := getFile(t, file, newUser(t, "other").Username); code == http.StatusOK {
+		t.Error("another user downloaded an upload no post links")
+	}
+	if code := getFile(t, file, owner.Username); code != http.StatusOK {
+		t.Errorf("the owner got %d", code)
+	}
+}
+
+func TestFileOfProtectedAccountIsPrivate(t *testing.T) {
+	owner := newUser(t, "owner")
+	file := newFile(t, owner)
+	newPost(t, owner, fmt.Sprintf("[notes](/api/file/download/%d)", file.ID), false)
+	if code := getFile(t, file, ""); code != http.StatusOK {
+		t.Fatalf("the file of a public post got %d", code)
+	}
+	if err := memento.Db().Model(owner).Update("is_protected", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code := getFile(t, file, ""); code == http.StatusOK {
+		t.Error("a visitor downloaded the file of a protected account")
+	}
+	if code := getFile(t, file, owner.Username); code != http.StatusOK {
+		t.Errorf("the owner got %d", code)
+	}
+}
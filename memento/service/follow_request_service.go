@@ -0,0 +1,206 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+// requestFollow records a pending follow request for a protected account.
+func requestFollow(user *model.User, followee *model.User) error {
+	if hasPendingFollowRequest(user.Username, followee.Username) {
+		return errors.New("already requested")
+	}
+	request := model.FollowRequest{
+		Requester: user.Username,
+		Target:    followee.Username,
+		Status:    model.FollowRequestPending,
+	}
+	if err := memento.Db().Create(&request).Error; err != nil {
+		log.Errorf(err.Error())
+		return errors.New("unknown insertion error")
+	}
+	return nil
+}
+
+func hasPendingFollowRequest(requester string, target string) bool {
+	if requester == "" || target == "" {
+		return false
+	}
+	var count int64
+	err := memento.Db().Model(&model.FollowRequest{}).
+		Where("requester = ? AND target = ? AND status = ?", requester, target, model.FollowRequestPending).
+		Count(&count).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return false
+	}
+	return count > 0
+}
+
+func HandleGetIncomingFollowRequests(c echo.Context) error {
+	username := c.Get("username").(string)
+	return listFollowRequests(c, "target = ?", username, func(r *model.FollowRequest) string {
+		return r.Requester
+	})
+}
+
+func HandleGetOutgoingFollowRequests(c echo.Context) error {
+	username := c.Get("username").(string)
+	return listFollowRequests(c, "requester = ?", username, func(r *model.FollowRequest) string {
+		return r.Target
+	})
+}
+
+func listFollowRequests(c echo.Context, condition string, username string, other func(*model.FollowRequest) string) error {
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil {
+		return utils.RespondError(c, "invalid page")
+	}
+	requests := make([]model.FollowRequest, 0, memento.PageSize)
+	err = memento.Db().
+		Where(condition, username).
+		Where("status = ?", model.FollowRequestPending).
+		Order("created_at desc").
+		Offset(page * memento.PageSize).
+		Limit(memento.PageSize).
+		Find(&requests).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	var total int64
+	err = memento.Db().
+		Model(&model.FollowRequest{}).
+		Where(condition, username).
+		Where("status = ?", model.FollowRequestPending).
+		Count(&total).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	result := make([]model.FollowRequestViewModel, 0, len(requests))
+	for _, r := range requests {
+		var user model.User
+		if err := memento.Db().First(&user, "username=?", other(&r)).Error; err != nil {
+			continue
+		}
+		result = append(result, model.FollowRequestViewModel{
+			ID:        r.ID,
+			User:      *utils.UserToView(&user, checkIsFollowed(username, user.Username)),
+			Status:    r.Status,
+			CreatedAt: r.CreatedAt,
+		})
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"requests": result,
+		"maxPage":  utils.MaxPage(total),
+	})
+}
+
+func HandleApproveFollowRequest(c echo.Context) error {
+	request, err := loadIncomingFollowRequest(c)
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	err = memento.Db().Transaction(func(tx *gorm.DB) error {
+		return approveFollowRequest(tx, request)
+	})
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func HandleRejectFollowRequest(c echo.Context) error {
+	request, err := loadIncomingFollowRequest(c)
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	err = memento.Db().Model(request).Update("status", model.FollowRequestRejected).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown update error")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// loadIncomingFollowRequest loads the pending request addressed to the current user.
+func loadIncomingFollowRequest(c echo.Context) (*model.FollowRequest, error) {
+	var request model.FollowRequest
+	err := memento.Db().First(&request, "id = ?", c.FormValue("id")).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("follow request not exists")
+		}
+		log.Errorf(err.Error())
+		return nil, errors.New("unknown query error")
+	}
+	if request.Target != c.Get("username").(string) {
+		return nil, errors.New("permission denied")
+	}
+	if request.Status != model.FollowRequestPending {
+		return nil, errors.New("follow request already handled")
+	}
+	return &request, nil
+}
+
+// approveFollowRequest turns the request into a follow relation. The follow
+// counters are only touched here, never when the request is created.
+// Requests between users who blocked each other in the meantime are dropped
+// instead.
+func approveFollowRequest(tx *gorm.DB, request *model.FollowRequest) error {
+	var requester, target model.User
+	if err := tx.First(&requester, "username=?", request.Requester).Error; err != nil {
+		return err
+	}
+	if err := tx.First(&target, "username=?", request.Target).Error; err != nil {
+		return err
+	}
+	var blocks int64
+	err := tx.Table("user_blocks").
+		Where("(user_id = ? AND block_id = ?) OR (user_id = ? AND block_id = ?)",
+			requester.ID, target.ID, target.ID, requester.ID).
+		Count(&blocks).
+		Error
+	if err != nil {
+		return err
+	}
+	if blocks > 0 {
+		return tx.Delete(request).Error
+	}
+	if err := tx.Model(request).Update("status", model.FollowRequestApproved).Error; err != nil {
+		return err
+	}
+	return addFollow(tx, &requester, &target)
+}
+
+// approveAllFollowRequests accepts every pending request of a user who made
+// the account public again.
+func approveAllFollowRequests(user *model.User) error {
+	var requests []model.FollowRequest
+	err := memento.Db().
+		Find(&requests, "target = ? AND status = ?", user.Username, model.FollowRequestPending).
+		Error
+	if err != nil {
+		return err
+	}
+	return memento.Db().Transaction(func(tx *gorm.DB) error {
+		for i := range requests {
+			if err := approveFollowRequest(tx, &requests[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -37,7 +37,17 @@ func SEOFrontEndMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 		if strings.HasPrefix(c.Request().RequestURI, "/public") {
 			return next(c)
 		}
-		if strings.HasPrefix(c.Request().RequestURI, "/rss") {
+		if strings.HasPrefix(c.Request().RequestURI, "/rss") || strings.HasPrefix(c.Request().RequestURI, "/feed/") {
+			return next(c)
+		}
+		if strings.HasPrefix(c.Request().RequestURI, "/ap/") || strings.HasPrefix(c.Request().RequestURI, "/.well-known/") {
+			return next(c)
+		}
+		if strings.HasPrefix(c.Request().RequestURI, "/micropub") || strings.HasPrefix(c.Request().RequestURI, "/webmention") {
+			return next(c)
+		}
+		if strings.HasPrefix(c.Request().RequestURI, "/oauth/") || strings.HasPrefix(c.Request().RequestURI, "/git/") ||
+			strings.HasPrefix(c.Request().RequestURI, "/dav/") {
 			return next(c)
 		}
 		reqPath := c.Request().URL.Path
@@ -97,7 +107,11 @@ func SEOFrontEndMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 		}
 		ext := filepath.Ext(reqPath)
 
-		return c.Blob(200, getContentType(ext), bytes)
+		contentType := utils.MimeTypeByExtension(ext)
+		if contentType == "" {
+			contentType = "text/plain"
+		}
+		return c.Blob(200, contentType, bytes)
 	}
 }
 
@@ -113,37 +127,6 @@ func handleIcon(c echo.Context) error {
 	return c.File(iconPath)
 }
 
-func getContentType(ext string) string {
-	switch ext {
-	case ".css":
-		return "text/css"
-	case ".js":
-		return "application/javascript"
-	case ".json":
-		return "application/json"
-	case ".png":
-		return "image/png"
-	case ".jpg":
-		return "image/jpeg"
-	case ".jpeg":
-		return "image/jpeg"
-	case ".gif":
-		return "image/gif"
-	case ".svg":
-		return "image/svg+xml"
-	case ".ico":
-		return "image/x-icon"
-	case ".webp":
-		return "image/webp"
-	case ".xml":
-		return "application/xml"
-	case ".pdf":
-		return "application/pdf"
-	default:
-		return "text/plain"
-	}
-}
-
 func seoHtml(html string, reqPath string) string {
 	siteName := memento.GetConfig().SiteName
 	description := memento.GetConfig().Description
@@ -166,7 +149,7 @@ func seoHtml(html string, reqPath string) string {
 			}
 			var post model.Post
 			err = memento.Db().Model(&post).Where("id = ?", id).First(&post).Error
-			if err != nil || post.IsPrivate {
+			if err != nil || !canViewPost("", &post) {
 				return
 			}
 			postView, err := utils.PostToView(&post, &model.UserViewModel{}, false)
@@ -230,7 +213,7 @@ func mdToHTML(md []byte) []byte {
 
 	// create HTML renderer with extensions
 	htmlFlags := html.CommonFlags | html.HrefTargetBlank
-	opts := html.RendererOptions{Flags: htmlFlags}
+	opts := html.RendererOptions{Flags: htmlFlags, RenderNodeHook: renderAudio}
 	renderer := html.NewRenderer(opts)
 
 	return markdown.Render(doc, renderer)
@@ -272,7 +255,7 @@ Sitemap: https://`+domain+`/sitemap.xml
 
 func GenerateSiteMap() {
 	var posts []model.Post
-	err := memento.Db().Model(&posts).Where("is_private = ?", false).Find(&posts).Error
+	err := visiblePosts(memento.Db(), "").Model(&posts).Where("is_private = ?", false).Find(&posts).Error
 	if err != nil {
 		return
 	}
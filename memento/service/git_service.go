@@ -0,0 +1,606 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/gitsync"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gopkg.in/yaml.v3"
+)
+
+// gitLocks serializes the commits and pushes of the repository of a user.
+var gitLocks sync.Map
+
+// gitFrontMatter is the front matter of a post in the Git repository. New
+// files without a visibility become private memos.
+type gitFrontMatter struct {
+	ID         uint      `yaml:"id,omitempty"`
+	Created    time.Time `yaml:"created,omitempty"`
+	Visibility string    `yaml:"visibility,omitempty"`
+}
+
+func gitLock(username string) *sync.Mutex {
+	lock, _ := gitLocks.LoadOrStore(username, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+func gitRepoPath(username string) string {
+	return filepath.Join(memento.GetGitPath(), username)
+}
+
+func gitRepoToView(repo *model.GitRepo) model.GitRepoViewModel {
+	view := model.GitRepoViewModel{Warnings: []string{}}
+	if repo == nil {
+		return view
+	}
+	view.Enabled = true
+	view.CloneUrl = federationBase() + "/git/" + repo.Username
+	view.Head = repo.Head
+	if repo.Warnings != "" {
+		view.Warnings = strings.Split(repo.Warnings, "\n")
+	}
+	if !repo.PushedAt.IsZero() {
+		view.PushedAt = &repo.PushedAt
+	}
+	return view
+}
+
+// gitFile renders a post as a Markdown file with front matter.
+func gitFile(post *model.Post, content string) []byte {
+	fm := gitFrontMatter{ID: post.ID, Created: post.CreatedAt.UTC().Truncate(time.Second), Visibility: "public"}
+	if post.IsPrivate {
+		fm.Visibility = "private"
+	}
+	header, _ := yaml.Marshal(&fm)
+	return []byte("---\n" + string(header) + "---\n" + strings.TrimRight(content, "\n") + "\n")
+}
+
+// parseGitFile splits a pushed file into its front matter and content.
+func parseGitFile(data []byte) (gitFrontMatter, string, error) {
+	var fm gitFrontMatter
+	text := strings.TrimPrefix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\ufeff")
+	if strings.HasPrefix(text, "---\n") {
+		end := strings.Index(text[4:], "\n---")
+		if end < 0 {
+			return fm, "", errors.New("the front matter is not closed")
+		}
+		if err := yaml.Unmarshal([]byte(text[4:4+end]), &fm); err != nil {
+			return fm, "", err
+		}
+		text = text[4+end+4:]
+		text = strings.TrimPrefix(text, "\n")
+	}
+	if fm.Visibility != "" && fm.Visibility != "public" && fm.Visibility != "private" {
+		return fm, "", fmt.Errorf("unknown visibility %s", fm.Visibility)
+	}
+	return fm, strings.TrimRight(text, "\n"), nil
+}
+
+// hasConflictMarkers reports whether the content contains an unresolved
+// merge conflict.
+func hasConflictMarkers(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, "<<<<<<< ") || strings.HasPrefix(line, ">>>>>>> ") {
+			return true
+		}
+	}
+	return false
+}
+
+// postSlug makes a file name from the title of a post.
+func postSlug(content string) string {
+	slug := strings.Builder{}
+	dash := false
+	for _, r := range strings.ToLower(findTitleInMd(content)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			slug.WriteRune(r)
+			dash = false
+		} else if !dash && slug.Len() > 0 {
+			slug.WriteRune('-')
+			dash = true
+		}
+	}
+	name := strings.TrimSuffix(slug.String(), "-")
+	if runes := []rune(name); len(runes) > 50 {
+		name = strings.TrimSuffix(string(runes[:50]), "-")
+	}
+	if name == "" {
+		name = "memo"
+	}
+	return name
+}
+
+// gitFileName names the file of a post by its date and title.
+func gitFileName(post *model.Post, content string, taken map[string]bool) string {
+	name := post.CreatedAt.Format("2006-01-02") + "-" + postSlug(content)
+	if taken[name+".md"] {
+		name += "-" + strconv.Itoa(int(post.ID))
+	}
+	return name + ".md"
+}
+
+// isGitPostFile reports whether a file of the repository is synced. Files in
+// folders are left alone.
+func isGitPostFile(path string) bool {
+	return !strings.Contains(path, "/") && strings.HasSuffix(strings.ToLower(path), ".md")
+}
+
+func gitSignature(user *model.User) gitsync.Signature {
+	name := user.Nickname
+	if name == "" {
+		name = user.Username
+	}
+	return gitsync.Signature{
+		Name:  name,
+		Email: user.Username + "@" + federationHost(federationBase()),
+		When:  time.Now(),
+	}
+}
+
+// syncGitPost commits the current state of a post to the repository of its
+// author, if the author syncs the memos. It runs in the background and
+// always writes what the database holds, so the order of the calls does not
+// matter.
+func syncGitPost(post *model.Post) {
+	var count int64
+	if err := memento.Db().Model(&model.GitRepo{}).Where("username = ?", post.Username).Count(&count).Error; err != nil || count == 0 {
+		return
+	}
+	username, id := post.Username, post.ID
+	go func() {
+		lock := gitLock(username)
+		lock.Lock()
+		defer lock.Unlock()
+		if err := commitGitPosts(username, []uint{id}); err != nil {
+			log.Errorf("Error syncing post %d to git: %s\n", id, err.Error())
+		}
+	}()
+}
+
+// syncGitRepo commits all posts of the user, e.g. after an import.
+func syncGitRepo(username string) {
+	go func() {
+		lock := gitLock(username)
+		lock.Lock()
+		defer lock.Unlock()
+		if err := commitGitPosts(username, nil); err != nil {
+			log.Errorf("Error syncing the git repository of %s: %s\n", username, err.Error())
+		}
+	}()
+}
+
+// commitGitPosts commits the files of the posts, or of all posts of the
+// user when ids is nil. Pushed commits are applied first. The caller holds
+// the lock of the repository.
+func commitGitPosts(username string, ids []uint) error {
+	var repo model.GitRepo
+	if err := memento.Db().First(&repo, "username = ?", username).Error; err != nil {
+		return nil
+	}
+	var user model.User
+	if err := memento.Db().First(&user, "username = ?", username).Error; err != nil {
+		return err
+	}
+	r := gitsync.Open(gitRepoPath(username))
+	for attempt := 0; attempt < 3; attempt++ {
+		// the token of pushes left over is not known, so they only edit
+		if err := applyGitPush(&repo, &user, nil, r); err != nil {
+			return err
+		}
+		changes, message, err := gitPostChanges(&repo, r, ids)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		commit, err := r.Commit(repo.Head, changes, gitSignature(&user), message)
+		if errors.Is(err, gitsync.ErrRefChanged) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return memento.Db().Model(&repo).Update("head", commit).Error
+	}
+	return gitsync.ErrRefChanged
+}
+
+// gitPostChanges returns the changes writing the posts into the files of the
+// repository at its head.
+func gitPostChanges(repo *model.GitRepo, r *gitsync.Repo, ids []uint) ([]gitsync.Change, string, error) {
+	var mappings []model.GitFile
+	if err := memento.Db().Find(&mappings, "username = ?", repo.Username).Error; err != nil {
+		return nil, "", err
+	}
+	files, err := r.Files(repo.Head)
+	if err != nil {
+		return nil, "", err
+	}
+	taken := make(map[string]bool, len(files)+len(mappings))
+	for _, f := range files {
+		taken[f] = true
+	}
+	byPost := make(map[uint]*model.GitFile, len(mappings))
+	for i := range mappings {
+		taken[mappings[i].Path] = true
+		byPost[mappings[i].PostID] = &mappings[i]
+	}
+	var posts []model.Post
+	if ids == nil {
+		if err := memento.Db().Order("id").Find(&posts, "username = ?", repo.Username).Error; err != nil {
+			return nil, "", err
+		}
+		for _, m := range mappings {
+			ids = append(ids, m.PostID)
+		}
+	} else if err := memento.Db().Find(&posts, "id IN ? AND username = ?", ids, repo.Username).Error; err != nil {
+		return nil, "", err
+	}
+	var changes []gitsync.Change
+	var verbs []string
+	existing := make(map[uint]bool, len(posts))
+	for i := range posts {
+		post := &posts[i]
+		existing[post.ID] = true
+		view, err := utils.PostToView(post, &model.UserViewModel{}, false)
+		if err != nil {
+			log.Errorf("Error syncing post %d to git: %s\n", post.ID, err.Error())
+			continue
+		}
+		mapping, ok := byPost[post.ID]
+		verb := "Update"
+		if !ok {
+			mapping = &model.GitFile{Username: repo.Username, PostID: post.ID, Path: gitFileName(post, view.Content, taken)}
+			if err := memento.Db().Create(mapping).Error; err != nil {
+				return nil, "", err
+			}
+			taken[mapping.Path] = true
+			verb = "Add"
+		}
+		changes = append(changes, gitsync.Change{Path: mapping.Path, Content: gitFile(post, view.Content)})
+		verbs = append(verbs, verb+" "+mapping.Path)
+	}
+	// the posts which are gone lose their files
+	for _, id := range ids {
+		mapping, ok := byPost[id]
+		if existing[id] || !ok {
+			continue
+		}
+		changes = append(changes, gitsync.Change{Path: mapping.Path})
+		verbs = append(verbs, "Delete "+mapping.Path)
+		if err := memento.Db().Delete(mapping).Error; err != nil {
+			return nil, "", err
+		}
+	}
+	message := "Sync memos"
+	if len(verbs) == 1 {
+		message = verbs[0]
+	}
+	return changes, message, nil
+}
+
+// applyGitPush applies the commits pushed since the head the posts match.
+// Pushed files become posts, edits or deletions. When a post was changed on
+// the server since the commit a push is based on, the server keeps its
+// version and the pushed file is moved to the conflicts folder. Files the
+// server normalizes, e.g. to add the id of a new post, are committed on top.
+// New files and deletions are skipped unless the token has the create and
+// delete scopes, as the push itself only needs the update scope. A nil
+// token only allows edits.
+func applyGitPush(repo *model.GitRepo, user *model.User, token *model.AccessToken, r *gitsync.Repo) error {
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	if head == "" || head == repo.Head {
+		return nil
+	}
+	changed, err := r.Diff(repo.Head, head)
+	if err != nil {
+		return err
+	}
+	// deletions come last, so moved files keep their posts
+	sort.SliceStable(changed, func(i, j int) bool {
+		return changed[i].Status != "D" && changed[j].Status == "D"
+	})
+	var mappings []model.GitFile
+	if err := memento.Db().Find(&mappings, "username = ?", user.Username).Error; err != nil {
+		return err
+	}
+	byPath := make(map[string]*model.GitFile, len(mappings))
+	byPost := make(map[uint]*model.GitFile, len(mappings))
+	for i := range mappings {
+		byPath[mappings[i].Path] = &mappings[i]
+		byPost[mappings[i].PostID] = &mappings[i]
+	}
+	var warnings []string
+	var fixes []gitsync.Change
+	warnf := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	// serverVersion returns the post and its content when the file at its
+	// path in the base of the push still matches it.
+	serverVersion := func(mapping *model.GitFile) (*model.Post, string, bool) {
+		var post model.Post
+		if err := memento.Db().First(&post, "id = ? AND username = ?", mapping.PostID, user.Username).Error; err != nil {
+			return nil, "", false
+		}
+		view, err := utils.PostToView(&post, &model.UserViewModel{}, false)
+		if err != nil {
+			return nil, "", false
+		}
+		base, err := r.ReadFile(repo.Head, mapping.Path)
+		if err != nil {
+			return &post, view.Content, false
+		}
+		fm, content, err := parseGitFile(base)
+		matches := err == nil && content == strings.TrimRight(view.Content, "\n") &&
+			(fm.Visibility == "private") == post.IsPrivate
+		return &post, view.Content, matches
+	}
+	conflict := func(path string, data []byte, post *model.Post, content string) {
+		warnf("%s: the memo was changed on the server, the pushed version was moved to conflicts/%s", path, path)
+		fixes = append(fixes, gitsync.Change{Path: "conflicts/" + path, Content: data})
+		if path != byPost[post.ID].Path {
+			fixes = append(fixes, gitsync.Change{Path: path})
+		}
+		fixes = append(fixes, gitsync.Change{Path: byPost[post.ID].Path, Content: gitFile(post, content)})
+	}
+	for _, change := range changed {
+		if !isGitPostFile(change.Path) {
+			continue
+		}
+		if change.Status == "D" {
+			mapping, ok := byPath[change.Path]
+			if !ok {
+				continue
+			}
+			post, content, matches := serverVersion(mapping)
+			if post == nil {
+				continue
+			}
+			if !matches {
+				warnf("%s: the memo was changed on the server and was not deleted", change.Path)
+				fixes = append(fixes, gitsync.Change{Path: mapping.Path, Content: gitFile(post, content)})
+				continue
+			}
+			if token == nil || !token.HasScope(model.ScopeDelete) {
+				warnf("%s: deleting memos needs a token with the delete scope, the memo was restored", change.Path)
+				fixes = append(fixes, gitsync.Change{Path: mapping.Path, Content: gitFile(post, content)})
+				continue
+			}
+			if err := deletePost(post); err != nil {
+				warnf("%s: %s", change.Path, err.Error())
+				continue
+			}
+			_ = memento.Db().Delete(mapping).Error
+			delete(byPath, mapping.Path)
+			delete(byPost, mapping.PostID)
+			continue
+		}
+		data, err := r.ReadFile(head, change.Path)
+		if err != nil {
+			return err
+		}
+		fm, content, err := parseGitFile(data)
+		if err != nil {
+			warnf("%s: %s", change.Path, err.Error())
+			continue
+		}
+		if hasConflictMarkers(content) {
+			warnf("%s: the file contains conflict markers and was skipped", change.Path)
+			continue
+		}
+		if strings.TrimSpace(content) == "" {
+			warnf("%s: the file is empty and was skipped", change.Path)
+			continue
+		}
+		if fm.ID == 0 {
+			if token == nil || !token.HasScope(model.ScopeCreate) {
+				warnf("%s: creating memos needs a token with the create scope, the file was skipped", change.Path)
+				continue
+			}
+			post, err := createPost(user, content, fm.Visibility != "public")
+			if err != nil {
+				warnf("%s: %s", change.Path, err.Error())
+				continue
+			}
+			mapping := &model.GitFile{Username: user.Username, PostID: post.ID, Path: change.Path}
+			if err := memento.Db().Create(mapping).Error; err != nil {
+				return err
+			}
+			byPath[mapping.Path] = mapping
+			byPost[mapping.PostID] = mapping
+			fixes = append(fixes, gitsync.Change{Path: change.Path, Content: gitFile(post, content)})
+			continue
+		}
+		mapping, ok := byPost[fm.ID]
+		if !ok {
+			warnf("%s: memo %d does not exist", change.Path, fm.ID)
+			continue
+		}
+		if mapping.Path != change.Path && change.Status == "A" {
+			// a copy of a file keeps the id, unless the original was removed
+			if _, err := r.ReadFile(head, mapping.Path); err == nil {
+				warnf("%s: memo %d is already synced as %s", change.Path, fm.ID, mapping.Path)
+				continue
+			}
+		}
+		post, serverContent, matches := serverVersion(mapping)
+		if post == nil {
+			warnf("%s: memo %d does not exist", change.Path, fm.ID)
+			continue
+		}
+		if !matches {
+			conflict(change.Path, data, post, serverContent)
+			continue
+		}
+		private := post.IsPrivate
+		if fm.Visibility != "" {
+			private = fm.Visibility == "private"
+		}
+		if content != strings.TrimRight(serverContent, "\n") || private != post.IsPrivate {
+			if err := editPost(post, content, private); err != nil {
+				warnf("%s: %s", change.Path, err.Error())
+				continue
+			}
+		}
+		if mapping.Path != change.Path {
+			delete(byPath, mapping.Path)
+			mapping.Path = change.Path
+			byPath[mapping.Path] = mapping
+			if err := memento.Db().Save(mapping).Error; err != nil {
+				return err
+			}
+		}
+		if normalized := gitFile(post, content); string(normalized) != string(data) {
+			fixes = append(fixes, gitsync.Change{Path: change.Path, Content: normalized})
+		}
+	}
+	repo.Head = head
+	repo.Warnings = strings.Join(warnings, "\n")
+	repo.PushedAt = time.Now()
+	if len(fixes) > 0 {
+		commit, err := r.Commit(head, fixes, gitSignature(user), "Apply pushed changes")
+		if err != nil && !errors.Is(err, gitsync.ErrRefChanged) {
+			return err
+		}
+		// when another push landed, the fixes are made again with it
+		if err == nil {
+			repo.Head = commit
+		}
+	}
+	return memento.Db().Model(repo).Updates(map[string]interface{}{
+		"head": repo.Head, "warnings": repo.Warnings, "pushed_at": repo.PushedAt,
+	}).Error
+}
+
+func HandleGetGitRepo(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	var repo model.GitRepo
+	if err := memento.Db().First(&repo, "username = ?", username).Error; err != nil {
+		return c.JSON(http.StatusOK, gitRepoToView(nil))
+	}
+	return c.JSON(http.StatusOK, gitRepoToView(&repo))
+}
+
+// HandleEnableGitRepo creates the repository of the user and commits all
+// posts into it.
+func HandleEnableGitRepo(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	if !gitsync.Available() {
+		return utils.RespondError(c, "git is not installed on the server")
+	}
+	lock := gitLock(username)
+	lock.Lock()
+	defer lock.Unlock()
+	var repo model.GitRepo
+	if err := memento.Db().First(&repo, "username = ?", username).Error; err == nil {
+		return c.JSON(http.StatusOK, gitRepoToView(&repo))
+	}
+	path := gitRepoPath(username)
+	_ = os.RemoveAll(path)
+	if _, err := gitsync.Init(path); err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "error creating the repository")
+	}
+	repo = model.GitRepo{Username: username}
+	if err := memento.Db().Create(&repo).Error; err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown insertion error")
+	}
+	if err := memento.Db().Where("username = ?", username).Delete(&model.GitFile{}).Error; err != nil {
+		log.Errorf(err.Error())
+	}
+	if err := commitGitPosts(username, nil); err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "error syncing the memos")
+	}
+	_ = memento.Db().First(&repo, repo.ID).Error
+	return c.JSON(http.StatusOK, gitRepoToView(&repo))
+}
+
+// HandleDisableGitRepo stops syncing and removes the repository.
+func HandleDisableGitRepo(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	lock := gitLock(username)
+	lock.Lock()
+	defer lock.Unlock()
+	if err := memento.Db().Unscoped().Where("username = ?", username).Delete(&model.GitRepo{}).Error; err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown deletion error")
+	}
+	if err := memento.Db().Where("username = ?", username).Delete(&model.GitFile{}).Error; err != nil {
+		log.Errorf(err.Error())
+	}
+	if err := os.RemoveAll(gitRepoPath(username)); err != nil {
+		log.Errorf(err.Error())
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// HandleGit serves the repository of a user over the smart HTTP protocol.
+// Git clients log in with the username and a personal access token as the
+// password; pushing needs the update scope, and adding and removing memos
+// the create and delete scopes.
+func HandleGit(c echo.Context) error {
+	username := c.Param("username")
+	user, token, ok := basicAuthAccessToken(c, username)
+	if !ok {
+		return nil
+	}
+	var repo model.GitRepo
+	if err := memento.Db().First(&repo, "username = ?", username).Error; err != nil {
+		return c.String(http.StatusNotFound, "repository not found")
+	}
+	push := c.QueryParam("service") == "git-receive-pack" || strings.HasSuffix(c.Request().URL.Path, "/git-receive-pack")
+	if push && (!token.HasScope(model.ScopeUpdate) || !user.HasPermission(model.PermPostWrite)) {
+		return c.String(http.StatusForbidden, "pushing needs a token with the update scope")
+	}
+	handler, err := gitsync.Handler(memento.GetGitPath(), "/git", username)
+	if err != nil {
+		log.Errorf(err.Error())
+		return c.String(http.StatusInternalServerError, "git is not available")
+	}
+	if !push || c.Request().Method != http.MethodPost {
+		handler.ServeHTTP(c.Response(), c.Request())
+		return nil
+	}
+	// the lock keeps the server from committing while the push is received
+	lock := gitLock(username)
+	lock.Lock()
+	defer lock.Unlock()
+	handler.ServeHTTP(c.Response(), c.Request())
+	if err := memento.Db().First(&repo, repo.ID).Error; err != nil {
+		log.Errorf(err.Error())
+		return nil
+	}
+	if err := applyGitPush(&repo, user, token, gitsync.Open(gitRepoPath(username))); err != nil {
+		log.Errorf("Error applying the push to %s: %s\n", username, err.Error())
+	}
+	return nil
+}
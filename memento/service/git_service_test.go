@@ -0,0 +1,177 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/gitsync"
+	"Memento/memento/model"
+	"Memento/memento/storage"
+	"Memento/memento/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// gitClient is a clone of the repository of a user served by a test server.
+type gitClient struct {
+	t   *testing.T
+	dir string
+}
+
+func (g *gitClient) run(args ...string) string {
+	g.t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = g.dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		g.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// file returns the name of the file holding the post.
+func (g *gitClient) file(post *model.Post) string {
+	g.t.Helper()
+	var mapping model.GitFile
+	if err := memento.Db().First(&mapping, "post_id = ?", post.ID).Error; err != nil {
+		g.t.Fatal(err)
+	}
+	return mapping.Path
+}
+
+func (g *gitClient) write(name string, content string) {
+	g.t.Helper()
+	if err := os.WriteFile(filepath.Join(g.dir, name), []byte(content), 0644); err != nil {
+		g.t.Fatal(err)
+	}
+}
+
+// replace edits the content below the front matter of a file.
+func (g *gitClient) replace(name string, old string, new string) {
+	g.t.Helper()
+	data, err := os.ReadFile(filepath.Join(g.dir, name))
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	if !strings.Contains(string(data), old) {
+		g.t.Fatalf("%s does not contain %q:\n%s", name, old, data)
+	}
+	g.write(name, strings.Replace(string(data), old, new, 1))
+}
+
+// push commits all changes and pushes them, then pulls what the server
+// committed on top.
+func (g *gitClient) push(message string) {
+	g.t.Helper()
+	g.run("add", "-A")
+	g.run("commit", "-q", "-m", message)
+	g.run("push", "-q", "origin", "HEAD")
+	g.run("pull", "-q", "--ff-only", "origin")
+}
+
+// cloneGitRepo enables the repository of the user and clones it with a token
+// of the scope.
+func cloneGitRepo(t *testing.T, user *model.User, scope string) *gitClient {
+	t.Helper()
+	if !gitsync.Available() {
+		t.Skip("git is not installed")
+	}
+	expectStatus(t, call(t, HandleEnableGitRepo, http.MethodPost, "/api/user/git", nil, user.Username), http.StatusOK)
+	secret, _, err := newAccessToken(user.Username, "git", scope, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	e.Any("/git/:username/*", HandleGit)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	u, _ := url.Parse(server.URL + "/git/" + user.Username)
+	u.User = url.UserPassword(user.Username, secret)
+	g := &gitClient{t: t, dir: t.TempDir()}
+	g.run("clone", "-q", u.String(), ".")
+	return g
+}
+
+func postContent(t *testing.T, id uint) (string, bool) {
+	t.Helper()
+	var post model.Post
+	if err := memento.Db().First(&post, id).Error; err != nil {
+		return "", false
+	}
+	content, err := utils.PostContent(&post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content), true
+}
+
+func TestGitPush(t *testing.T) {
+	user := newUser(t, "writer")
+	edited := newPost(t, user, "first memo", false)
+	changed := newPost(t, user, "second memo", false)
+	removed := newPost(t, user, "third memo", false)
+	g := cloneGitRepo(t, user, "create update delete")
+
+	// an edit
+	g.replace(g.file(edited), "first memo", "first memo, edited")
+	g.push("Edit")
+	if content, _ := postContent(t, edited.ID); content != "first memo, edited" {
+		t.Errorf("edited memo: %q", content)
+	}
+
+	// a conflict with a change on the server the clone does not have yet
+	serverContent := "second memo, changed on the server"
+	if err := storage.WriteFile(changed.ContentUrl, []byte(serverContent)); err != nil {
+		t.Fatal(err)
+	}
+	name := g.file(changed)
+	g.replace(name, "second memo", "second memo, changed in the clone")
+	g.push("Conflict")
+	if content, _ := postContent(t, changed.ID); content != serverContent {
+		t.Errorf("the server lost its version in a conflict: %q", content)
+	}
+	data, err := os.ReadFile(filepath.Join(g.dir, "conflicts", name))
+	if err != nil || !strings.Contains(string(data), "changed in the clone") {
+		t.Errorf("the pushed version is not in the conflicts: %q, %v", data, err)
+	}
+
+	// a deletion and a new file
+	g.run("rm", "-q", g.file(removed))
+	g.write("new.md", "a new memo\n")
+	g.push("Delete and add")
+	if _, ok := postContent(t, removed.ID); ok {
+		t.Error("the deleted memo still exists")
+	}
+	var count int64
+	memento.Db().Model(&model.Post{}).Where("username = ?", user.Username).Count(&count)
+	if count != 3 {
+		t.Errorf("%d memos, want 3", count)
+	}
+}
+
+func TestGitPushNeedsScopes(t *testing.T) {
+	user := newUser(t, "writer")
+	kept := newPost(t, user, "a memo", false)
+	g := cloneGitRepo(t, user, "update")
+	g.run("rm", "-q", g.file(kept))
+	g.write("new.md", "a new memo\n")
+	g.push("Delete and add")
+	if _, ok := postContent(t, kept.ID); !ok {
+		t.Error("a token without the delete scope deleted a memo")
+	}
+	if _, err := os.Stat(filepath.Join(g.dir, g.file(kept))); err != nil {
+		t.Errorf("the memo was not restored: %v", err)
+	}
+	var count int64
+	memento.Db().Model(&model.Post{}).Where("username = ?", user.Username).Count(&count)
+	if count != 1 {
+		t.Errorf("a token without the create scope created %d memos", count-1)
+	}
+}
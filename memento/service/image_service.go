@@ -0,0 +1,220 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/imaging"
+	"Memento/memento/model"
+	"Memento/memento/storage"
+	"Memento/memento/utils"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// maxImageBytes limits the size of the images which are read into memory to
+// be stripped or scaled.
+const maxImageBytes = 64 << 20
+
+// avatarSize is the size avatars are scaled down to.
+const avatarSize = 256
+
+// imageVariants are the sizes images are scaled down to, by the value of the
+// size parameter of downloads.
+var imageVariants = map[string]int{
+	"thumb":  256,
+	"medium": 1024,
+}
+
+// variantSlots limits how many images are scaled at the same time.
+var variantSlots = make(chan struct{}, runtime.NumCPU())
+
+// inspectImage measures the image in tmp and removes its metadata when strip
+// is set. When the content changes its new hash and size are returned,
+// otherwise a size of -1.
+func inspectImage(tmp *os.File, size int64, meta *blobMeta, strip bool) (hash.Hash, int64, error) {
+	strip = strip && imaging.CanStrip(meta.MimeType) && size <= maxImageBytes
+	n := size
+	if !strip && n > 1<<20 {
+		// the dimensions are at the beginning
+		n = 1 << 20
+	}
+	data := make([]byte, n)
+	if _, err := tmp.ReadAt(data, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, -1, err
+	}
+	var h hash.Hash
+	stripped := int64(-1)
+	if strip {
+		clean, err := imaging.Strip(data, meta.MimeType)
+		if err != nil {
+			// damaged images are stored as they are
+			log.Warnf("can not strip image: %v", err)
+		} else if !bytes.Equal(clean, data) {
+			if err := tmp.Truncate(0); err != nil {
+				return nil, -1, err
+			}
+			if _, err := tmp.WriteAt(clean, 0); err != nil {
+				return nil, -1, err
+			}
+			h = sha256.New()
+			h.Write(clean)
+			stripped = int64(len(clean))
+			data = clean
+		}
+	}
+	if info, err := imaging.Inspect(data, meta.MimeType); err == nil {
+		meta.Width, meta.Height = info.Width, info.Height
+	}
+	return h, stripped, nil
+}
+
+// fileMimeType returns the MIME type of a file, guessed from its name for
+// files uploaded before it was sniffed.
+func fileMimeType(file *model.File) string {
+	if file.MimeType != "" {
+		return file.MimeType
+	}
+	return utils.MimeTypeByExtension(path.Ext(file.Filename))
+}
+
+// variantKey returns the key of a scaled down variant of an image. Files
+// with the same content share their variants.
+func variantKey(file *model.File, size string) string {
+	if file.Hash != "" {
+		return storage.UploadPrefix + "variant/" + size + "/" + file.Hash[:2] + "/" + file.Hash
+	}
+	return storage.UploadPrefix + "variant/" + size + "/file/" + strconv.Itoa(int(file.ID))
+}
+
+// deleteVariants removes the scaled down variants of an image.
+func deleteVariants(file *model.File) {
+	for size := range imageVariants {
+		if err := storage.Default().Delete(variantKey(file, size)); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+}
+
+// fileVariant returns the key of a scaled down variant of an image, which is
+// made when it does not exist yet.
+func fileVariant(file *model.File, size string) (string, error) {
+	key := variantKey(file, size)
+	_, err := storage.Default().Stat(key)
+	if err == nil || !errors.Is(err, storage.ErrNotExist) {
+		return key, err
+	}
+	variantSlots <- struct{}{}
+	defer func() {
+		<-variantSlots
+	}()
+	if _, err := storage.Default().Stat(key); err == nil {
+		// made meanwhile
+		return key, nil
+	}
+	info, err := storage.Default().Stat(file.ContentUrl)
+	if err != nil {
+		return "", err
+	}
+	if info.Size > maxImageBytes {
+		return "", imaging.ErrTooLarge
+	}
+	data, err := storage.ReadFile(file.ContentUrl)
+	if err != nil {
+		return "", err
+	}
+	mimeType := fileMimeType(file)
+	thumb, err := imaging.Thumbnail(data, mimeType, imageVariants[size])
+	if err != nil {
+		return "", err
+	}
+	if err := storage.WriteFile(key, thumb); err != nil {
+		return "", err
+	}
+	if file.Width == 0 {
+		// files uploaded before images were measured
+		if info, err := imaging.Inspect(data, mimeType); err == nil {
+			err := memento.Db().Model(file).
+				UpdateColumns(map[string]interface{}{"width": info.Width, "height": info.Height}).
+				Error
+			if err != nil {
+				log.Errorf(err.Error())
+			}
+		}
+	}
+	return key, nil
+}
+
+// generateVariants makes the scaled down variants of a new image in the
+// background, so they are ready when the image is first shown.
+func generateVariants(file model.File) {
+	if !imaging.CanResize(file.MimeType) {
+		return
+	}
+	go func() {
+		for size := range imageVariants {
+			if _, err := fileVariant(&file, size); err != nil && !errors.Is(err, imaging.ErrTooLarge) {
+				log.Errorf("variant %s of file %d: %v", size, file.ID, err)
+			}
+		}
+	}()
+}
+
+// serveVariant sends a scaled down variant of an image. Files which cannot
+// be scaled are sent as they are.
+func serveVariant(c echo.Context, file *model.File, size string) error {
+	if _, ok := imageVariants[size]; !ok {
+		return utils.RespondError(c, "invalid size")
+	}
+	mimeType := fileMimeType(file)
+	if !imaging.CanResize(mimeType) {
+		return serveBlob(c, fileDownload(c, file))
+	}
+	key, err := fileVariant(file, size)
+	if err != nil {
+		if !errors.Is(err, imaging.ErrTooLarge) {
+			log.Errorf(err.Error())
+		}
+		return serveBlob(c, fileDownload(c, file))
+	}
+	d := fileDownload(c, file)
+	d.Key = key
+	d.ContentType = imaging.VariantType(mimeType)
+	ext := ".png"
+	if d.ContentType == "image/jpeg" {
+		ext = ".jpg"
+	}
+	d.Name = strings.TrimSuffix(file.Filename, path.Ext(file.Filename)) + "." + size + ext
+	// variants only change with the content, which changes the hash
+	d.ETag = `"` + size + "-" + path.Base(key) + `"`
+	return serveBlob(c, d)
+}
+
+// processAvatar scales an uploaded avatar down and removes its metadata.
+// Small GIFs keep their animation and images which cannot be decoded are
+// only stripped.
+func processAvatar(data []byte, ext string) ([]byte, string) {
+	mimeType := sniffType(data)
+	if mimeType == "image/gif" && len(data) <= 1024*1024 {
+		return data, ext
+	}
+	if thumb, err := imaging.Thumbnail(data, mimeType, avatarSize); err == nil {
+		if imaging.VariantType(mimeType) == "image/jpeg" {
+			return thumb, ".jpg"
+		}
+		return thumb, ".png"
+	}
+	if clean, err := imaging.Strip(data, mimeType); err == nil {
+		return clean, ext
+	}
+	return data, ext
+}
@@ -0,0 +1,403 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/importer"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+)
+
+// importExcerptLength limits the content shown for each post of a preview.
+const importExcerptLength = 200
+
+func importJobToView(job *model.ImportJob, detailed bool) model.ImportJobViewModel {
+	view := model.ImportJobViewModel{
+		ID:        job.ID,
+		Source:    job.Source,
+		Filename:  job.Filename,
+		DryRun:    job.DryRun,
+		Status:    job.Status,
+		Total:     job.Total,
+		Done:      job.Done,
+		Imported:  job.Imported,
+		Files:     job.Files,
+		Error:     job.Error,
+		Warnings:  []string{},
+		CreatedAt: job.CreatedAt,
+	}
+	if !job.FinishedAt.IsZero() {
+		view.FinishedAt = &job.FinishedAt
+	}
+	if job.Warnings != "" {
+		view.Warnings = strings.Split(job.Warnings, "\n")
+	}
+	if detailed && job.Preview != "" {
+		if err := json.Unmarshal([]byte(job.Preview), &view.Preview); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+	return view
+}
+
+func HandleGetImportSources(c echo.Context) error {
+	return c.JSON(http.StatusOK, importer.Names())
+}
+
+// HandleCreateImport uploads an archive and starts importing it in the
+// background.
+func HandleCreateImport(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	source := c.FormValue("source")
+	if _, ok := importer.Get(source); !ok {
+		return utils.RespondError(c, "invalid source")
+	}
+	var running int64
+	err := memento.Db().Model(&model.ImportJob{}).
+		Where("username = ? AND status IN ?", username, []string{model.JobPending, model.JobRunning}).
+		Count(&running).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	if running > 0 {
+		return utils.RespondError(c, "an import is already running")
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		return utils.RespondError(c, "can not read form file")
+	}
+	options, err := json.Marshal(importer.Options{
+		Private: c.FormValue("private") == "true",
+		User:    c.FormValue("user"),
+		Replies: c.FormValue("replies") == "true",
+	})
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "invalid options")
+	}
+	src, err := header.Open()
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "form file open error")
+	}
+	defer func(src multipart.File) {
+		_ = src.Close()
+	}(src)
+	archive, err := saveImportArchive(header.Filename, src)
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	job := model.ImportJob{
+		Username: username,
+		Source:   source,
+		Filename: header.Filename,
+		Archive:  archive,
+		Options:  string(options),
+		DryRun:   c.FormValue("dryRun") == "true",
+		Status:   model.JobPending,
+	}
+	if err := memento.Db().Create(&job).Error; err != nil {
+		log.Errorf(err.Error())
+		_ = os.Remove(archive)
+		return utils.RespondError(c, "unknown insertion error")
+	}
+	go runImport(job.ID)
+	return c.JSON(http.StatusOK, importJobToView(&job, false))
+}
+
+// saveImportArchive copies an uploaded archive into the import folder.
+func saveImportArchive(name string, src io.Reader) (string, error) {
+	archive := filepath.Join(memento.GetImportPath(),
+		utils.Md5string(fmt.Sprintf("%d%s", time.Now().UnixNano(), name))+path.Ext(name))
+	dst, err := os.Create(archive)
+	if err != nil {
+		log.Errorf(err.Error())
+		return "", errors.New("os file open error")
+	}
+	defer func(dst *os.File) {
+		_ = dst.Close()
+	}(dst)
+	if _, err := io.Copy(dst, src); err != nil {
+		log.Errorf(err.Error())
+		_ = os.Remove(archive)
+		return "", errors.New("data copy error")
+	}
+	return archive, nil
+}
+
+// importJob loads an import of the user by the id of the request path.
+func importJob(c echo.Context) (*model.ImportJob, error) {
+	username := c.Get("username").(string)
+	if username == "" {
+		return nil, utils.RespondUnauthorized(c)
+	}
+	var job model.ImportJob
+	if err := memento.Db().First(&job, "id = ? AND username = ?", c.Param("id"), username).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.RespondError(c, "import not exists")
+		}
+		log.Errorf(err.Error())
+		return nil, utils.RespondError(c, "unknown query error")
+	}
+	return &job, nil
+}
+
+func HandleGetImports(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	var jobs []model.ImportJob
+	if err := memento.Db().Order("created_at desc").Find(&jobs, "username = ?", username).Error; err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	result := make([]model.ImportJobViewModel, 0, len(jobs))
+	for i := range jobs {
+		result = append(result, importJobToView(&jobs[i], false))
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// HandleGetImport reports the progress of an import, and the preview of a
+// dry run.
+func HandleGetImport(c echo.Context) error {
+	job, err := importJob(c)
+	if job == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, importJobToView(job, true))
+}
+
+// HandleRunImport imports the archive of a finished dry run.
+func HandleRunImport(c echo.Context) error {
+	job, err := importJob(c)
+	if job == nil {
+		return err
+	}
+	if !job.DryRun || job.Status != model.JobDone {
+		return utils.RespondError(c, "only finished dry runs can be imported")
+	}
+	result := memento.Db().Model(&model.ImportJob{}).
+		Where("id = ? AND status = ?", job.ID, model.JobDone).
+		Updates(map[string]interface{}{
+			"dry_run": false, "status": model.JobPending, "done": 0, "warnings": "", "preview": "",
+		})
+	if result.Error != nil {
+		log.Errorf(result.Error.Error())
+		return utils.RespondError(c, "unknown update error")
+	}
+	if result.RowsAffected == 0 {
+		return utils.RespondError(c, "the import is already running")
+	}
+	go runImport(job.ID)
+	return c.NoContent(http.StatusOK)
+}
+
+func HandleDeleteImport(c echo.Context) error {
+	job, err := importJob(c)
+	if job == nil {
+		return err
+	}
+	if job.Status == model.JobPending || job.Status == model.JobRunning {
+		return utils.RespondError(c, "the import is running")
+	}
+	if err := memento.Db().Delete(job).Error; err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown deletion error")
+	}
+	_ = os.Remove(job.Archive)
+	return c.NoContent(http.StatusOK)
+}
+
+// runImport runs an import job. The archive is kept after a dry run so the
+// import can be started from it.
+func runImport(id uint) {
+	var job model.ImportJob
+	if err := memento.Db().First(&job, id).Error; err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	err := importArchive(&job)
+	job.FinishedAt = time.Now()
+	job.Status = model.JobDone
+	if err != nil {
+		job.Status = model.JobFailed
+		job.Error = err.Error()
+	}
+	if !job.DryRun || err != nil {
+		_ = os.Remove(job.Archive)
+	}
+	if err := memento.Db().Save(&job).Error; err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
+func importArchive(job *model.ImportJob) error {
+	imp, ok := importer.Get(job.Source)
+	if !ok {
+		return errors.New("invalid source")
+	}
+	var options importer.Options
+	if err := json.Unmarshal([]byte(job.Options), &options); err != nil {
+		return errors.New("invalid options")
+	}
+	var user model.User
+	if err := memento.Db().First(&user, "username=?", job.Username).Error; err != nil {
+		return errors.New("username not exists")
+	}
+	job.Status = model.JobRunning
+	if err := memento.Db().Save(job).Error; err != nil {
+		log.Errorf(err.Error())
+	}
+	result, err := imp.Read(job.Archive, options)
+	if err != nil {
+		return err
+	}
+	defer func(result *importer.Result) {
+		_ = result.Close()
+	}(result)
+	warnings := result.Warnings
+	job.Total = len(result.Items)
+	if job.DryRun {
+		preview := make([]model.ImportPreviewItem, 0, len(result.Items))
+		for _, item := range result.Items {
+			excerpt := []rune(item.Content)
+			if len(excerpt) > importExcerptLength {
+				excerpt = append(excerpt[:importExcerptLength], '…')
+			}
+			preview = append(preview, model.ImportPreviewItem{
+				Excerpt:     string(excerpt),
+				CreatedAt:   item.CreatedAt,
+				EditedAt:    item.EditedAt,
+				IsPrivate:   item.Private,
+				Tags:        append([]string{}, item.Tags...),
+				Attachments: len(item.Attachments),
+			})
+		}
+		data, err := json.Marshal(preview)
+		if err != nil {
+			return err
+		}
+		job.Preview = string(data)
+		job.Done = job.Total
+		job.Warnings = strings.Join(warnings, "\n")
+		return nil
+	}
+	posts := make(map[string]*model.Post, len(result.Items))
+	for i := range result.Items {
+		item := &result.Items[i]
+		post, files, itemWarnings := importItem(&user, item)
+		warnings = append(warnings, itemWarnings...)
+		if post != nil {
+			posts[item.Key] = post
+			job.Imported++
+		}
+		job.Files += files
+		job.Done = i + 1
+		err := memento.Db().Model(job).Updates(map[string]interface{}{
+			"total": job.Total, "done": job.Done, "imported": job.Imported, "files": job.Files,
+		}).Error
+		if err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+	resolveImportLinks(posts)
+	syncGitRepo(user.Username)
+	onPostsChanged(user.Username)
+	job.Warnings = strings.Join(warnings, "\n")
+	return nil
+}
+
+// importItem stores the attachments and the post of an item. Imported posts
+// are not federated, so followers are not flooded with old posts.
+func importItem(user *model.User, item *importer.Item) (*model.Post, int, []string) {
+	var warnings []string
+	content := item.Content
+	base := federationBase()
+	files := 0
+	for _, a := range item.Attachments {
+		src, err := a.Open()
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %s: %s", item.Key, a.Name, err.Error()))
+			continue
+		}
+		file, err := saveFile(user, a.Name, src)
+		_ = src.Close()
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %s: %s", item.Key, a.Name, err.Error()))
+			continue
+		}
+		content = strings.ReplaceAll(content, a.Ref, fileURL(base, file.ID))
+		files++
+	}
+	tags := utils.GetTags(content)
+	var missing []string
+	for _, t := range item.Tags {
+		t = "#" + strings.ReplaceAll(strings.TrimPrefix(strings.TrimSpace(t), "#"), " ", "_")
+		if t != "#" && !utils.Contains(tags, t) && !utils.Contains(missing, t) {
+			missing = append(missing, t)
+		}
+	}
+	if len(missing) > 0 {
+		content += "\n\n" + strings.Join(missing, " ")
+	}
+	post, err := storePost(user, content, item.Private, item.CreatedAt, item.EditedAt)
+	if err != nil {
+		return nil, files, append(warnings, fmt.Sprintf("%s: %s", item.Key, err.Error()))
+	}
+	return post, files, warnings
+}
+
+// resolveImportLinks replaces the links between the imported items with the
+// urls of their posts.
+func resolveImportLinks(posts map[string]*model.Post) {
+	base := federationBase()
+	for _, post := range posts {
+		text, err := utils.PostContent(post)
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+		content := []byte(text)
+		if !importer.PostLinkPattern.Match(content) {
+			continue
+		}
+		content = importer.PostLinkPattern.ReplaceAllFunc(content, func(link []byte) []byte {
+			if target, ok := posts[importer.PostLinkKey(string(link))]; ok {
+				return []byte(postURL(base, target.ID))
+			}
+			return []byte("#")
+		})
+		oldKey, err := writePostContent(post, string(content))
+		if err == nil {
+			err = memento.Db().Save(post).Error
+		}
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+		removePostContentFile(oldKey)
+		if err := memento.IndexPost(post); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+}
@@ -0,0 +1,1176 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/mastodon"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/k3a/html2text"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+	"html"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mastodonPageSize    = 20
+	mastodonMaxPageSize = 40
+	mastodonMaxMedia    = 4
+	// comments are exposed as replies, with their ids prefixed to keep them
+	// apart from the post ids
+	commentStatusPrefix = "c"
+)
+
+func respondMastodonError(c echo.Context, status int, msg string) error {
+	return c.JSON(status, echo.Map{"error": msg})
+}
+
+// mastodonViewer loads the user of the access token, or nil for anonymous requests.
+func mastodonViewer(c echo.Context) *model.User {
+	username := c.Get("username").(string)
+	if username == "" {
+		return nil
+	}
+	var user model.User
+	if err := memento.Db().First(&user, "username=?", username).Error; err != nil {
+		return nil
+	}
+	return &user
+}
+
+func viewerName(viewer *model.User) string {
+	if viewer == nil {
+		return ""
+	}
+	return viewer.Username
+}
+
+func mastodonTime(t time.Time) string {
+	return t.UTC().Format(mastodon.TimeFormat)
+}
+
+func accountToMastodon(base string, user *model.User) mastodon.Account {
+	avatar := base + "/api/user/avatar/" + utils.UserToView(user, false).Avatar
+	note := ""
+	if user.Bio != "" {
+		note = "<p>" + html.EscapeString(user.Bio) + "</p>"
+	}
+	return mastodon.Account{
+		ID:             strconv.Itoa(int(user.ID)),
+		Username:       user.Username,
+		Acct:           user.Username,
+		DisplayName:    user.Nickname,
+		Locked:         user.IsProtected,
+		Discoverable:   !user.IsProtected,
+		CreatedAt:      mastodonTime(user.RegisteredAt),
+		Note:           note,
+		URL:            base + "/user/" + user.Username,
+		Avatar:         avatar,
+		AvatarStatic:   avatar,
+		FollowersCount: user.TotalFollower,
+		FollowingCount: user.TotalFollows,
+		StatusesCount:  user.TotalPosts,
+		Emojis:         []interface{}{},
+		Fields:         []mastodon.Field{},
+	}
+}
+
+// remoteAccount describes the remote author of a reply received over ActivityPub.
+func remoteAccount(base string, comment *model.Comment) mastodon.Account {
+	avatar := base + "/api/user/avatar/user.png"
+	return mastodon.Account{
+		ID:           comment.Username,
+		Username:     strings.Split(comment.Username, "@")[0],
+		Acct:         comment.Username,
+		DisplayName:  comment.RemoteName,
+		CreatedAt:    mastodonTime(comment.CreatedAt),
+		URL:          comment.RemoteActor,
+		Avatar:       avatar,
+		AvatarStatic: avatar,
+		Emojis:       []interface{}{},
+		Fields:       []mastodon.Field{},
+	}
+}
+
+func mediaType(filename string) string {
+	t, _, _ := strings.Cut(utils.MimeTypeByExtension(path.Ext(filename)), "/")
+	switch t {
+	case mastodon.MediaImage, mastodon.MediaVideo, mastodon.MediaAudio:
+		return t
+	}
+	return mastodon.MediaUnknown
+}
+
+// formatLength formats a duration in seconds like "0:03:07.85".
+func formatLength(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second)).Round(10 * time.Millisecond)
+	return fmt.Sprintf("%d:%02d:%05.2f", int(d.Hours()), int(d.Minutes())%60, math.Mod(d.Seconds(), 60))
+}
+
+func mediaToMastodon(base string, file *model.File, viewer string) mastodon.MediaAttachment {
+	u := fileLink(base, file, viewer)
+	attachment := mastodon.MediaAttachment{
+		ID:         strconv.Itoa(int(file.ID)),
+		Type:       mediaType(file.Filename),
+		URL:        u,
+		PreviewURL: u,
+	}
+	if isAudio(file) {
+		// recordings of phones are often named like videos
+		attachment.Type = mastodon.MediaAudio
+	}
+	if attachment.Type == mastodon.MediaImage {
+		separator := "?"
+		if strings.Contains(u, "?") {
+			separator = "&"
+		}
+		attachment.PreviewURL = u + separator + "size=thumb"
+	}
+	if file.Width > 0 && file.Height > 0 {
+		attachment.Meta = echo.Map{
+			"original": echo.Map{
+				"width":  file.Width,
+				"height": file.Height,
+				"size":   fmt.Sprintf("%dx%d", file.Width, file.Height),
+				"aspect": float64(file.Width) / float64(file.Height),
+			},
+		}
+	}
+	if file.Duration > 0 {
+		attachment.Meta = echo.Map{
+			"length": formatLength(file.Duration),
+			"original": echo.Map{
+				"duration": file.Duration,
+			},
+		}
+	}
+	return attachment
+}
+
+// statusMedia returns the uploaded files linked from the content of a post.
+func statusMedia(base string, content string, viewer string) []mastodon.MediaAttachment {
+	files := linkedFiles(content)
+	attachments := make([]mastodon.MediaAttachment, 0, len(files))
+	for i := range files {
+		attachments = append(attachments, mediaToMastodon(base, &files[i], viewer))
+	}
+	return attachments
+}
+
+func visibilityToMastodon(private bool) string {
+	if private {
+		return mastodon.VisibilityPrivate
+	}
+	return mastodon.VisibilityPublic
+}
+
+// statusBuilder converts posts and comments into statuses, caching the
+// authors of a page.
+type statusBuilder struct {
+	base     string
+	viewer   *model.User
+	accounts map[string]*mastodon.Account
+}
+
+func newStatusBuilder(viewer *model.User) *statusBuilder {
+	return &statusBuilder{
+		base:     federationBase(),
+		viewer:   viewer,
+		accounts: make(map[string]*mastodon.Account),
+	}
+}
+
+func (b *statusBuilder) account(username string) (*mastodon.Account, error) {
+	if account, ok := b.accounts[username]; ok {
+		return account, nil
+	}
+	var user model.User
+	if err := memento.Db().First(&user, "username=?", username).Error; err != nil {
+		return nil, err
+	}
+	account := accountToMastodon(b.base, &user)
+	b.accounts[username] = &account
+	return &account, nil
+}
+
+func (b *statusBuilder) post(post *model.Post) (*mastodon.Status, error) {
+	view, err := utils.PostToView(post, &model.UserViewModel{}, false)
+	if err != nil {
+		return nil, err
+	}
+	signPostLinks(view, post, viewerName(b.viewer))
+	account, err := b.account(post.Username)
+	if err != nil {
+		return nil, err
+	}
+	link := postURL(b.base, post.ID)
+	status := &mastodon.Status{
+		ID:               strconv.Itoa(int(post.ID)),
+		URI:              link,
+		URL:              link,
+		CreatedAt:        mastodonTime(post.CreatedAt),
+		Account:          *account,
+		Content:          postHTML(b.base, view.Content),
+		Visibility:       visibilityToMastodon(post.IsPrivate),
+		MediaAttachments: statusMedia(b.base, view.Content, viewerName(b.viewer)),
+		Mentions:         []interface{}{},
+		Tags:             []mastodon.Tag{},
+		Emojis:           []interface{}{},
+		RepliesCount:     post.TotalComment,
+		FavouritesCount:  post.TotalLiked,
+		Favourited:       isPostLiked(b.viewer, post.ID),
+		Muted:            b.viewer != nil && isMuted(b.viewer.Username, post.Username),
+	}
+	if post.EditedAt.After(post.CreatedAt.Add(time.Second)) {
+		edited := mastodonTime(post.EditedAt)
+		status.EditedAt = &edited
+	}
+	for _, t := range utils.GetTags(view.Content) {
+		t = strings.TrimPrefix(t, "#")
+		status.Tags = append(status.Tags, mastodon.Tag{Name: t, URL: b.base + "/tag/" + t})
+	}
+	return status, nil
+}
+
+func (b *statusBuilder) comment(post *model.Post, comment *model.Comment) (*mastodon.Status, error) {
+	var account mastodon.Account
+	if comment.RemoteActor != "" {
+		account = remoteAccount(b.base, comment)
+	} else {
+		a, err := b.account(comment.Username)
+		if err != nil {
+			return nil, err
+		}
+		account = *a
+	}
+	postAccount, err := b.account(post.Username)
+	if err != nil {
+		return nil, err
+	}
+	postID := strconv.Itoa(int(post.ID))
+	link := postURL(b.base, post.ID)
+	status := &mastodon.Status{
+		ID:                 commentStatusPrefix + strconv.Itoa(int(comment.ID)),
+		URI:                link + "#comment-" + strconv.Itoa(int(comment.ID)),
+		URL:                link,
+		CreatedAt:          mastodonTime(comment.CreatedAt),
+		Account:            account,
+		Content:            "<p>" + strings.ReplaceAll(html.EscapeString(comment.Content), "\n", "<br>") + "</p>",
+		Visibility:         visibilityToMastodon(post.IsPrivate),
+		MediaAttachments:   []mastodon.MediaAttachment{},
+		Mentions:           []interface{}{},
+		Tags:               []mastodon.Tag{},
+		Emojis:             []interface{}{},
+		FavouritesCount:    comment.Liked,
+		InReplyToID:        &postID,
+		InReplyToAccountID: &postAccount.ID,
+	}
+	if comment.EditedAt.After(comment.CreatedAt.Add(time.Second)) {
+		edited := mastodonTime(comment.EditedAt)
+		status.EditedAt = &edited
+	}
+	return status, nil
+}
+
+func (b *statusBuilder) posts(posts []model.Post) []mastodon.Status {
+	result := make([]mastodon.Status, 0, len(posts))
+	for i := range posts {
+		status, err := b.post(&posts[i])
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+		result = append(result, *status)
+	}
+	return result
+}
+
+// paginate applies the max_id, since_id, min_id and limit parameters of
+// Mastodon to a query ordered by id, and sets the Link header of the page.
+func paginate(c echo.Context, db *gorm.DB, column string, dest interface{}, ids func() []uint) error {
+	limit := mastodonPageSize
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 {
+		limit = min(l, mastodonMaxPageSize)
+	}
+	if maxID := c.QueryParam("max_id"); maxID != "" {
+		db = db.Where(column+" < ?", maxID)
+	}
+	if sinceID := c.QueryParam("since_id"); sinceID != "" {
+		db = db.Where(column+" > ?", sinceID)
+	}
+	minID := c.QueryParam("min_id")
+	order := column + " desc"
+	if minID != "" {
+		db = db.Where(column+" > ?", minID)
+		order = column + " asc"
+	}
+	if err := db.Order(order).Limit(limit).Find(dest).Error; err != nil {
+		return err
+	}
+	page := ids()
+	if minID != "" {
+		sort.Slice(page, func(i, j int) bool { return page[i] > page[j] })
+	}
+	setLinkHeader(c, page)
+	return nil
+}
+
+func paginatePosts(c echo.Context, db *gorm.DB) ([]model.Post, error) {
+	var posts []model.Post
+	err := paginate(c, db, "posts.id", &posts, func() []uint {
+		ids := make([]uint, len(posts))
+		for i, p := range posts {
+			ids[i] = p.ID
+		}
+		return ids
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].ID > posts[j].ID })
+	return posts, nil
+}
+
+func setLinkHeader(c echo.Context, ids []uint) {
+	if len(ids) == 0 {
+		return
+	}
+	link := func(param string, id uint, rel string) string {
+		query := c.Request().URL.Query()
+		query.Del("max_id")
+		query.Del("min_id")
+		query.Del("since_id")
+		query.Set(param, strconv.Itoa(int(id)))
+		return "<" + federationBase() + c.Request().URL.Path + "?" + query.Encode() + `>; rel="` + rel + `"`
+	}
+	c.Response().Header().Set("Link", link("max_id", ids[len(ids)-1], "next")+", "+link("min_id", ids[0], "prev"))
+}
+
+func respondStatuses(c echo.Context, viewer *model.User, db *gorm.DB) error {
+	posts, err := paginatePosts(c, db)
+	if err != nil {
+		log.Errorf(err.Error())
+		return respondMastodonError(c, http.StatusInternalServerError, "unknown query error")
+	}
+	return c.JSON(http.StatusOK, newStatusBuilder(viewer).posts(posts))
+}
+
+func HandleMastodonInstance(c echo.Context) error {
+	base := federationBase()
+	config := memento.GetConfig()
+	var users, posts int64
+	if err := memento.Db().Model(&model.User{}).Count(&users).Error; err != nil {
+		log.Errorf(err.Error())
+	}
+	if err := memento.Db().Model(&model.Post{}).Where("is_private = ?", false).Count(&posts).Error; err != nil {
+		log.Errorf(err.Error())
+	}
+	return c.JSON(http.StatusOK, mastodon.Instance{
+		URI:              federationHost(base),
+		Title:            config.SiteName,
+		ShortDescription: config.Description,
+		Description:      config.Description,
+		Version:          mastodon.Version,
+		URLs:             map[string]string{},
+		Stats:            mastodon.InstanceStats{UserCount: users, StatusCount: posts},
+		Thumbnail:        base + "/favicon.png",
+		Languages:        []string{},
+		Registrations:    config.EnableRegister,
+		Configuration: map[string]interface{}{
+			"statuses": echo.Map{
+				"max_characters":              10000,
+				"max_media_attachments":       mastodonMaxMedia,
+				"characters_reserved_per_url": 0,
+			},
+			"media_attachments": echo.Map{
+				"supported_mime_types": []string{"image/jpeg", "image/png", "image/gif", "image/webp", "video/mp4", "audio/mpeg"},
+			},
+		},
+		Rules: []interface{}{},
+	})
+}
+
+func HandleMastodonCustomEmojis(c echo.Context) error {
+	return c.JSON(http.StatusOK, []interface{}{})
+}
+
+// accounts
+
+func mastodonAccountUser(c echo.Context) (*model.User, error) {
+	var user model.User
+	if err := memento.Db().First(&user, "id=?", c.Param("id")).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func HandleMastodonVerifyCredentials(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMastodonError(c, http.StatusUnauthorized, "The access token is invalid")
+	}
+	account := accountToMastodon(federationBase(), viewer)
+	account.Source = &mastodon.Source{
+		Privacy: mastodon.VisibilityPublic,
+		Note:    viewer.Bio,
+		Fields:  []mastodon.Field{},
+	}
+	return c.JSON(http.StatusOK, account)
+}
+
+func HandleMastodonLookupAccount(c echo.Context) error {
+	acct := strings.TrimPrefix(c.QueryParam("acct"), "@")
+	if username, host, found := strings.Cut(acct, "@"); found {
+		if host != federationHost(federationBase()) {
+			return respondMastodonError(c, http.StatusNotFound, "Record not found")
+		}
+		acct = username
+	}
+	var user model.User
+	if err := memento.Db().First(&user, "username=?", acct).Error; err != nil {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	return c.JSON(http.StatusOK, accountToMastodon(federationBase(), &user))
+}
+
+func HandleMastodonGetAccount(c echo.Context) error {
+	user, err := mastodonAccountUser(c)
+	if err != nil {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	return c.JSON(http.StatusOK, accountToMastodon(federationBase(), user))
+}
+
+func HandleMastodonAccountStatuses(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	user, err := mastodonAccountUser(c)
+	if err != nil {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	// there are no pinned posts and no reblogs
+	if c.QueryParam("pinned") == "true" {
+		return c.JSON(http.StatusOK, []mastodon.Status{})
+	}
+	db := memento.Db().Model(&model.Post{}).Where("posts.username = ?", user.Username)
+	if viewerName(viewer) != user.Username {
+		db = visiblePosts(db, viewerName(viewer)).Where("posts.is_private = ?", false)
+	}
+	if c.QueryParam("only_media") != "true" {
+		return respondStatuses(c, viewer, db)
+	}
+	// attachments are only known from the content, so the page is filtered
+	// after loading it and may be shorter than the limit
+	posts, err := paginatePosts(c, db)
+	if err != nil {
+		log.Errorf(err.Error())
+		return respondMastodonError(c, http.StatusInternalServerError, "unknown query error")
+	}
+	result := make([]mastodon.Status, 0, len(posts))
+	for _, status := range newStatusBuilder(viewer).posts(posts) {
+		if len(status.MediaAttachments) > 0 {
+			result = append(result, status)
+		}
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func respondAccounts(c echo.Context, db *gorm.DB) error {
+	var users []model.User
+	err := paginate(c, db, "users.id", &users, func() []uint {
+		ids := make([]uint, len(users))
+		for i, u := range users {
+			ids[i] = u.ID
+		}
+		return ids
+	})
+	if err != nil {
+		log.Errorf(err.Error())
+		return respondMastodonError(c, http.StatusInternalServerError, "unknown query error")
+	}
+	base := federationBase()
+	result := make([]mastodon.Account, 0, len(users))
+	for i := range users {
+		result = append(result, accountToMastodon(base, &users[i]))
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func HandleMastodonFollowers(c echo.Context) error {
+	user, err := mastodonAccountUser(c)
+	if err != nil {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	return respondAccounts(c, memento.Db().Model(&model.User{}).
+		Joins("JOIN user_follows ON user_follows.user_id = users.id").
+		Where("user_follows.follow_id = ?", user.ID))
+}
+
+func HandleMastodonFollowing(c echo.Context) error {
+	user, err := mastodonAccountUser(c)
+	if err != nil {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	return respondAccounts(c, memento.Db().Model(&model.User{}).
+		Joins("JOIN user_follows ON user_follows.follow_id = users.id").
+		Where("user_follows.user_id = ?", user.ID))
+}
+
+func relationship(viewer *model.User, user *model.User) mastodon.Relationship {
+	following := checkIsFollowed(viewer.Username, user.Username)
+	return mastodon.Relationship{
+		ID:             strconv.Itoa(int(user.ID)),
+		Following:      following,
+		ShowingReblogs: following,
+		FollowedBy:     checkIsFollowed(user.Username, viewer.Username),
+		Blocking:       isBlocked(viewer.Username, user.Username),
+		BlockedBy:      isBlocked(user.Username, viewer.Username),
+		Muting:         isMuted(viewer.Username, user.Username),
+		Requested:      hasPendingFollowRequest(viewer.Username, user.Username),
+	}
+}
+
+func HandleMastodonRelationships(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMastodonError(c, http.StatusUnauthorized, "The access token is invalid")
+	}
+	params, _ := c.FormParams()
+	ids := append(params["id[]"], params["id"]...)
+	result := make([]mastodon.Relationship, 0, len(ids))
+	for _, id := range ids {
+		var user model.User
+		if err := memento.Db().First(&user, "id=?", id).Error; err != nil {
+			continue
+		}
+		result = append(result, relationship(viewer, &user))
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func HandleMastodonFollow(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	user, err := mastodonAccountUser(c)
+	if err != nil || viewer == nil {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	if viewer.ID == user.ID {
+		return respondMastodonError(c, http.StatusForbidden, "This action is not allowed")
+	}
+	// following twice is not an error for Mastodon clients
+	if err := followUser(viewer, user); err != nil && !checkIsFollowed(viewer.Username, user.Username) &&
+		!hasPendingFollowRequest(viewer.Username, user.Username) {
+		return respondMastodonError(c, http.StatusForbidden, err.Error())
+	}
+	return c.JSON(http.StatusOK, relationship(viewer, user))
+}
+
+func HandleMastodonUnfollow(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	user, err := mastodonAccountUser(c)
+	if err != nil || viewer == nil {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	if err := unfollowUser(viewer, user); err != nil {
+		return respondMastodonError(c, http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, relationship(viewer, user))
+}
+
+func HandleMastodonFollowRequests(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMastodonError(c, http.StatusUnauthorized, "The access token is invalid")
+	}
+	return respondAccounts(c, memento.Db().Model(&model.User{}).
+		Joins("JOIN follow_requests ON follow_requests.requester = users.username").
+		Where("follow_requests.target = ? AND follow_requests.status = ?", viewer.Username, model.FollowRequestPending).
+		Where("follow_requests.deleted_at IS NULL"))
+}
+
+func HandleMastodonAuthorizeFollowRequest(c echo.Context) error {
+	return handleMastodonFollowRequest(c, func(request *model.FollowRequest) error {
+		return memento.Db().Transaction(func(tx *gorm.DB) error {
+			return approveFollowRequest(tx, request)
+		})
+	})
+}
+
+func HandleMastodonRejectFollowRequest(c echo.Context) error {
+	return handleMastodonFollowRequest(c, func(request *model.FollowRequest) error {
+		return memento.Db().Model(request).Update("status", model.FollowRequestRejected).Error
+	})
+}
+
+func handleMastodonFollowRequest(c echo.Context, handle func(*model.FollowRequest) error) error {
+	viewer := mastodonViewer(c)
+	user, err := mastodonAccountUser(c)
+	if err != nil || viewer == nil {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	var request model.FollowRequest
+	err = memento.Db().First(&request, "requester = ? AND target = ? AND status = ?",
+		user.Username, viewer.Username, model.FollowRequestPending).Error
+	if err != nil {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	if err := handle(&request); err != nil {
+		log.Errorf(err.Error())
+		return respondMastodonError(c, http.StatusInternalServerError, "unknown update error")
+	}
+	return c.JSON(http.StatusOK, relationship(viewer, user))
+}
+
+// statuses
+
+// mastodonStatusRequest is the body of a status sent as a form or as JSON.
+type mastodonStatusRequest struct {
+	Status      string   `json:"status"`
+	MediaIDs    []string `json:"media_ids"`
+	InReplyToID string   `json:"in_reply_to_id"`
+	Visibility  string   `json:"visibility"`
+}
+
+func parseMastodonStatus(c echo.Context) (*mastodonStatusRequest, error) {
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		var req mastodonStatusRequest
+		if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+			return nil, errors.New("invalid json")
+		}
+		return &req, nil
+	}
+	form, err := c.FormParams()
+	if err != nil {
+		return nil, errors.New("invalid form")
+	}
+	return &mastodonStatusRequest{
+		Status:      form.Get("status"),
+		MediaIDs:    append(form["media_ids[]"], form["media_ids"]...),
+		InReplyToID: form.Get("in_reply_to_id"),
+		Visibility:  form.Get("visibility"),
+	}, nil
+}
+
+// statusContent appends the media of a status to its text.
+func statusContent(user *model.User, req *mastodonStatusRequest) (string, error) {
+	if len(req.MediaIDs) > mastodonMaxMedia {
+		return "", errors.New("too many attachments")
+	}
+	return attachFiles(user, strings.TrimSpace(req.Status), req.MediaIDs)
+}
+
+// mastodonPost loads the post of a status id. Replies are not posts.
+func mastodonPost(viewer *model.User, id string) (*model.Post, error) {
+	var post model.Post
+	if err := memento.Db().First(&post, "id=?", id).Error; err != nil {
+		return nil, err
+	}
+	if !canViewPost(viewerName(viewer), &post) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &post, nil
+}
+
+// mastodonComment loads the reply of a status id and the post it belongs to.
+func mastodonComment(viewer *model.User, id string) (*model.Comment, *model.Post, error) {
+	commentID, found := strings.CutPrefix(id, commentStatusPrefix)
+	if !found {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	var comment model.Comment
+	if err := memento.Db().First(&comment, "id=?", commentID).Error; err != nil {
+		return nil, nil, err
+	}
+	post, err := mastodonPost(viewer, strconv.Itoa(int(comment.PostID)))
+	if err != nil {
+		return nil, nil, err
+	}
+	if comment.IsHidden && !userHasPermission(viewerName(viewer), model.PermPostHide) {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	return &comment, post, nil
+}
+
+func HandleMastodonCreateStatus(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMastodonError(c, http.StatusUnauthorized, "The access token is invalid")
+	}
+	req, err := parseMastodonStatus(c)
+	if err != nil {
+		return respondMastodonError(c, http.StatusUnprocessableEntity, err.Error())
+	}
+	builder := newStatusBuilder(viewer)
+	if req.InReplyToID != "" {
+		// replies become comments of the post
+		if !viewer.HasPermission(model.PermCommentWrite) {
+			return respondMastodonError(c, http.StatusForbidden, "permission required: "+string(model.PermCommentWrite))
+		}
+		post, err := mastodonPost(viewer, req.InReplyToID)
+		if err != nil {
+			_, post, err = mastodonComment(viewer, req.InReplyToID)
+		}
+		if err != nil {
+			return respondMastodonError(c, http.StatusNotFound, "Record not found")
+		}
+		if len(req.MediaIDs) > 0 {
+			return respondMastodonError(c, http.StatusUnprocessableEntity, "replies can not have attachments")
+		}
+		content := strings.TrimSpace(html2text.HTML2Text(req.Status))
+		if content == "" {
+			return respondMastodonError(c, http.StatusUnprocessableEntity, "Validation failed: Text can't be blank")
+		}
+		comment, err := createComment(viewer, post, content)
+		if err != nil {
+			return respondMastodonError(c, http.StatusUnprocessableEntity, err.Error())
+		}
+		status, err := builder.comment(post, comment)
+		if err != nil {
+			log.Errorf(err.Error())
+			return respondMastodonError(c, http.StatusInternalServerError, "unknown query error")
+		}
+		return c.JSON(http.StatusOK, status)
+	}
+	if !viewer.HasPermission(model.PermPostWrite) {
+		return respondMastodonError(c, http.StatusForbidden, "permission required: "+string(model.PermPostWrite))
+	}
+	content, err := statusContent(viewer, req)
+	if err != nil {
+		return respondMastodonError(c, http.StatusUnprocessableEntity, err.Error())
+	}
+	if content == "" {
+		return respondMastodonError(c, http.StatusUnprocessableEntity, "Validation failed: Text can't be blank")
+	}
+	private := req.Visibility == mastodon.VisibilityPrivate || req.Visibility == "direct"
+	post, err := createPost(viewer, content, private)
+	if err != nil {
+		return respondMastodonError(c, http.StatusUnprocessableEntity, err.Error())
+	}
+	status, err := builder.post(post)
+	if err != nil {
+		log.Errorf(err.Error())
+		return respondMastodonError(c, http.StatusInternalServerError, "os open file error")
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+func HandleMastodonGetStatus(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	builder := newStatusBuilder(viewer)
+	var status *mastodon.Status
+	post, err := mastodonPost(viewer, c.Param("id"))
+	if err == nil {
+		status, err = builder.post(post)
+	} else if comment, post, e := mastodonComment(viewer, c.Param("id")); e == nil {
+		status, err = builder.comment(post, comment)
+	}
+	if err != nil || status == nil {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+func HandleMastodonStatusContext(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	builder := newStatusBuilder(viewer)
+	context := mastodon.Context{Ancestors: []mastodon.Status{}, Descendants: []mastodon.Status{}}
+	post, err := mastodonPost(viewer, c.Param("id"))
+	if err != nil {
+		_, parent, err := mastodonComment(viewer, c.Param("id"))
+		if err != nil {
+			return respondMastodonError(c, http.StatusNotFound, "Record not found")
+		}
+		if status, err := builder.post(parent); err == nil {
+			context.Ancestors = append(context.Ancestors, *status)
+		}
+		return c.JSON(http.StatusOK, context)
+	}
+	var comments []model.Comment
+	err = visibleComments(memento.Db(), viewerName(viewer)).
+		Where("post_id = ?", post.ID).
+		Order("comments.id asc").
+		Find(&comments).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return respondMastodonError(c, http.StatusInternalServerError, "unknown query error")
+	}
+	for i := range comments {
+		status, err := builder.comment(post, &comments[i])
+		if err != nil {
+			continue
+		}
+		context.Descendants = append(context.Descendants, *status)
+	}
+	return c.JSON(http.StatusOK, context)
+}
+
+// ownStatus loads a post of the viewer for editing or deleting it.
+func ownStatus(c echo.Context) (*model.User, *model.Post, error) {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return nil, nil, respondMastodonError(c, http.StatusUnauthorized, "The access token is invalid")
+	}
+	post, err := mastodonPost(viewer, c.Param("id"))
+	if err != nil || post.Username != viewer.Username {
+		return nil, nil, respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	return viewer, post, nil
+}
+
+func HandleMastodonStatusSource(c echo.Context) error {
+	_, post, err := ownStatus(c)
+	if post == nil {
+		return err
+	}
+	view, err := utils.PostToView(post, &model.UserViewModel{}, false)
+	if err != nil {
+		return respondMastodonError(c, http.StatusInternalServerError, "os open file error")
+	}
+	return c.JSON(http.StatusOK, mastodon.StatusSource{ID: c.Param("id"), Text: view.Content})
+}
+
+func HandleMastodonEditStatus(c echo.Context) error {
+	viewer, post, err := ownStatus(c)
+	if post == nil {
+		return err
+	}
+	req, err := parseMastodonStatus(c)
+	if err != nil {
+		return respondMastodonError(c, http.StatusUnprocessableEntity, err.Error())
+	}
+	content, err := statusContent(viewer, req)
+	if err != nil {
+		return respondMastodonError(c, http.StatusUnprocessableEntity, err.Error())
+	}
+	if content == "" {
+		return respondMastodonError(c, http.StatusUnprocessableEntity, "Validation failed: Text can't be blank")
+	}
+	private := post.IsPrivate
+	if req.Visibility != "" {
+		private = req.Visibility == mastodon.VisibilityPrivate || req.Visibility == "direct"
+	}
+	if err := editPost(post, content, private); err != nil {
+		return respondMastodonError(c, http.StatusUnprocessableEntity, err.Error())
+	}
+	status, err := newStatusBuilder(viewer).post(post)
+	if err != nil {
+		return respondMastodonError(c, http.StatusInternalServerError, "os open file error")
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+func HandleMastodonDeleteStatus(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMastodonError(c, http.StatusUnauthorized, "The access token is invalid")
+	}
+	builder := newStatusBuilder(viewer)
+	if comment, post, err := mastodonComment(viewer, c.Param("id")); err == nil {
+		if comment.Username != viewer.Username && !viewer.HasPermission(model.PermCommentDelete) {
+			return respondMastodonError(c, http.StatusNotFound, "Record not found")
+		}
+		status, err := builder.comment(post, comment)
+		if err != nil {
+			return respondMastodonError(c, http.StatusInternalServerError, "unknown query error")
+		}
+		text := comment.Content
+		status.Text = &text
+		if err := deleteComment(comment); err != nil {
+			return respondMastodonError(c, http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, status)
+	}
+	post, err := mastodonPost(viewer, c.Param("id"))
+	if err != nil || post.Username != viewer.Username {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	if !viewer.HasPermission(model.PermPostWrite) {
+		return respondMastodonError(c, http.StatusForbidden, "permission required: "+string(model.PermPostWrite))
+	}
+	view, err := utils.PostToView(post, &model.UserViewModel{}, false)
+	if err != nil {
+		return respondMastodonError(c, http.StatusInternalServerError, "os open file error")
+	}
+	status, err := builder.post(post)
+	if err != nil {
+		return respondMastodonError(c, http.StatusInternalServerError, "os open file error")
+	}
+	// the source lets clients offer "delete and redraft"
+	status.Text = &view.Content
+	if err := deletePost(post); err != nil {
+		return respondMastodonError(c, http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+func HandleMastodonFavourite(c echo.Context) error {
+	return favouriteStatus(c, likePost)
+}
+
+func HandleMastodonUnfavourite(c echo.Context) error {
+	return favouriteStatus(c, unlikePost)
+}
+
+// favouriteStatus likes or unlikes a post. Repeating the action is not an
+// error for Mastodon clients.
+func favouriteStatus(c echo.Context, action func(*model.User, *model.Post) error) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMastodonError(c, http.StatusUnauthorized, "The access token is invalid")
+	}
+	post, err := mastodonPost(viewer, c.Param("id"))
+	if err != nil {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	if err := action(viewer, post); err != nil && err.Error() != "already liked" && err.Error() != "not liked yet" {
+		return respondMastodonError(c, http.StatusUnprocessableEntity, err.Error())
+	}
+	status, err := newStatusBuilder(viewer).post(post)
+	if err != nil {
+		return respondMastodonError(c, http.StatusInternalServerError, "os open file error")
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+func HandleMastodonFavouritedBy(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	post, err := mastodonPost(viewer, c.Param("id"))
+	if err != nil {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	return respondAccounts(c, memento.Db().Model(&model.User{}).
+		Joins("JOIN user_liked_posts ON user_liked_posts.user_username = users.username").
+		Where("user_liked_posts.post_id = ?", post.ID))
+}
+
+// timelines
+
+func HandleMastodonHomeTimeline(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMastodonError(c, http.StatusUnauthorized, "The access token is invalid")
+	}
+	db := feedPosts(memento.Db().Model(&model.Post{}), viewer.Username).
+		Where(`posts.username = ? OR (posts.is_private = ? AND posts.username IN (
+			SELECT users.username FROM users JOIN user_follows ON user_follows.follow_id = users.id
+			WHERE user_follows.user_id = ?))`, viewer.Username, false, viewer.ID)
+	return respondStatuses(c, viewer, db)
+}
+
+func HandleMastodonPublicTimeline(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	db := feedPosts(memento.Db().Model(&model.Post{}), viewerName(viewer)).
+		Where("posts.is_private = ?", false)
+	// every post is local, so there is nothing to show for remote only timelines
+	if c.QueryParam("remote") == "true" || c.QueryParam("only_media") == "true" {
+		return c.JSON(http.StatusOK, []mastodon.Status{})
+	}
+	return respondStatuses(c, viewer, db)
+}
+
+func HandleMastodonTagTimeline(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	tag := "#" + strings.TrimPrefix(c.Param("hashtag"), "#")
+	db := feedPosts(memento.Db().Model(&model.Post{}), viewerName(viewer)).
+		Joins("JOIN post_tags ON post_tags.post_id = posts.id").
+		Joins("JOIN tags ON tags.id = post_tags.tag_id").
+		Where("tags.name = ? AND (posts.is_private = ? OR posts.username = ?)", tag, false, viewerName(viewer))
+	return respondStatuses(c, viewer, db)
+}
+
+func HandleMastodonFavourites(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMastodonError(c, http.StatusUnauthorized, "The access token is invalid")
+	}
+	db := feedPosts(memento.Db().Model(&model.Post{}), viewer.Username).
+		Joins("JOIN user_liked_posts ON user_liked_posts.post_id = posts.id").
+		Where("user_liked_posts.user_username = ?", viewer.Username).
+		Where("posts.is_private = ? OR posts.username = ?", false, viewer.Username)
+	return respondStatuses(c, viewer, db)
+}
+
+// notifications
+
+type notificationKind int64
+
+// Notifications are derived from comments and follow requests rather than
+// stored. Their ids are the creation time in milliseconds followed by a
+// digit of the kind, which keeps them ordered and usable as max_id.
+const (
+	kindMention notificationKind = iota + 1
+	kindFollowRequest
+	kindFollow
+)
+
+func notificationID(t time.Time, kind notificationKind) int64 {
+	return t.UnixMilli()*10 + int64(kind)
+}
+
+type derivedNotification struct {
+	id           int64
+	notification mastodon.Notification
+}
+
+func HandleMastodonNotifications(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMastodonError(c, http.StatusUnauthorized, "The access token is invalid")
+	}
+	limit := mastodonPageSize
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 {
+		limit = min(l, mastodonMaxPageSize)
+	}
+	var maxID, sinceID int64
+	if id, err := strconv.ParseInt(c.QueryParam("max_id"), 10, 64); err == nil {
+		maxID = id
+	}
+	if id, err := strconv.ParseInt(c.QueryParam("since_id"), 10, 64); err == nil {
+		sinceID = id
+	}
+	if id, err := strconv.ParseInt(c.QueryParam("min_id"), 10, 64); err == nil {
+		sinceID = id
+	}
+	params, _ := c.FormParams()
+	types := append(params["types[]"], params["types"]...)
+	excluded := append(params["exclude_types[]"], params["exclude_types"]...)
+	wanted := func(t string) bool {
+		return !utils.Contains(excluded, t) && (len(types) == 0 || utils.Contains(types, t))
+	}
+	// notifications are loaded per kind and merged, so each kind is limited
+	// by the time range of the page
+	timeRange := func(db *gorm.DB, column string) *gorm.DB {
+		if maxID > 0 {
+			db = db.Where(column+" <= ?", time.UnixMilli(maxID/10+1))
+		}
+		if sinceID > 0 {
+			db = db.Where(column+" >= ?", time.UnixMilli(sinceID/10))
+		}
+		return db.Order(column + " desc").Limit(limit + 1)
+	}
+	builder := newStatusBuilder(viewer)
+	var result []derivedNotification
+	add := func(id int64, n mastodon.Notification) {
+		if (maxID > 0 && id >= maxID) || (sinceID > 0 && id <= sinceID) {
+			return
+		}
+		n.ID = strconv.FormatInt(id, 10)
+		result = append(result, derivedNotification{id, n})
+	}
+	if wanted(mastodon.NotificationMention) {
+		var comments []model.Comment
+		err := timeRange(visibleComments(memento.Db(), viewer.Username), "comments.created_at").
+			Joins("JOIN posts ON posts.id = comments.post_id AND posts.deleted_at IS NULL").
+			Where("posts.username = ? AND comments.username <> ?", viewer.Username, viewer.Username).
+			Find(&comments).
+			Error
+		if err != nil {
+			log.Errorf(err.Error())
+		}
+		for i := range comments {
+			var post model.Post
+			if err := memento.Db().First(&post, "id=?", comments[i].PostID).Error; err != nil {
+				continue
+			}
+			status, err := builder.comment(&post, &comments[i])
+			if err != nil {
+				continue
+			}
+			add(notificationID(comments[i].CreatedAt, kindMention), mastodon.Notification{
+				Type:      mastodon.NotificationMention,
+				CreatedAt: mastodonTime(comments[i].CreatedAt),
+				Account:   status.Account,
+				Status:    status,
+			})
+		}
+	}
+	addRequests := func(status string, notificationType string, kind notificationKind) {
+		if !wanted(notificationType) {
+			return
+		}
+		var requests []model.FollowRequest
+		err := timeRange(memento.Db(), "updated_at").
+			Find(&requests, "target = ? AND status = ?", viewer.Username, status).
+			Error
+		if err != nil {
+			log.Errorf(err.Error())
+		}
+		for _, r := range requests {
+			account, err := builder.account(r.Requester)
+			if err != nil {
+				continue
+			}
+			add(notificationID(r.UpdatedAt, kind), mastodon.Notification{
+				Type:      notificationType,
+				CreatedAt: mastodonTime(r.UpdatedAt),
+				Account:   *account,
+			})
+		}
+	}
+	addRequests(model.FollowRequestPending, mastodon.NotificationFollowRequest, kindFollowRequest)
+	addRequests(model.FollowRequestApproved, mastodon.NotificationFollow, kindFollow)
+	sort.Slice(result, func(i, j int) bool { return result[i].id > result[j].id })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	notifications := make([]mastodon.Notification, 0, len(result))
+	for _, n := range result {
+		notifications = append(notifications, n.notification)
+	}
+	if len(result) > 0 {
+		setNotificationLinkHeader(c, result[len(result)-1].id, result[0].id)
+	}
+	return c.JSON(http.StatusOK, notifications)
+}
+
+func setNotificationLinkHeader(c echo.Context, last int64, first int64) {
+	link := func(param string, id int64, rel string) string {
+		query := url.Values{}
+		query.Set(param, strconv.FormatInt(id, 10))
+		return "<" + federationBase() + c.Request().URL.Path + "?" + query.Encode() + `>; rel="` + rel + `"`
+	}
+	c.Response().Header().Set("Link", link("max_id", last, "next")+", "+link("min_id", first, "prev"))
+}
+
+// media
+
+func HandleMastodonUploadMedia(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMastodonError(c, http.StatusUnauthorized, "The access token is invalid")
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		return respondMastodonError(c, http.StatusUnprocessableEntity, "Validation failed: File can't be blank")
+	}
+	file, err := saveUpload(viewer, header)
+	if err != nil {
+		return respondMastodonError(c, http.StatusUnprocessableEntity, err.Error())
+	}
+	return c.JSON(http.StatusOK, mediaToMastodon(federationBase(), file, file.Username))
+}
+
+// HandleMastodonGetMedia returns an attachment of the viewer. Descriptions
+// are not stored, so updating an attachment returns it unchanged.
+func HandleMastodonGetMedia(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMastodonError(c, http.StatusUnauthorized, "The access token is invalid")
+	}
+	var file model.File
+	if err := memento.Db().First(&file, "id = ? AND username = ?", c.Param("id"), viewer.Username).Error; err != nil {
+		return respondMastodonError(c, http.StatusNotFound, "Record not found")
+	}
+	return c.JSON(http.StatusOK, mediaToMastodon(federationBase(), &file, file.Username))
+}
@@ -0,0 +1,511 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/memos"
+	"Memento/memento/model"
+	"Memento/memento/storage"
+	"Memento/memento/utils"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+)
+
+const (
+	memosPageSize    = 100
+	memosMaxPageSize = 1000
+)
+
+// memosRequest is the body of the memo endpoints. Fields left out of a
+// patch request keep their value.
+type memosRequest struct {
+	Content        *string `json:"content"`
+	Visibility     *string `json:"visibility"`
+	ResourceIDList []uint  `json:"resourceIdList"`
+	RowStatus      string  `json:"rowStatus"`
+	Pinned         *bool   `json:"pinned"`
+}
+
+func respondMemosError(c echo.Context, status int, msg string) error {
+	return c.JSON(status, echo.Map{"message": msg})
+}
+
+func memosVisibility(private bool) string {
+	if private {
+		return memos.VisibilityPrivate
+	}
+	return memos.VisibilityPublic
+}
+
+// memosPrivate maps a Memos visibility onto a post. Memos creates private
+// memos by default, so anything but PUBLIC is private.
+func memosPrivate(visibility string) bool {
+	return visibility != memos.VisibilityPublic
+}
+
+func userToMemos(user *model.User) memos.User {
+	role := memos.RoleUser
+	if user.EffectiveRole() == model.RoleAdmin {
+		role = memos.RoleAdmin
+	}
+	return memos.User{
+		ID:        user.ID,
+		RowStatus: memos.RowStatusNormal,
+		CreatedTs: user.RegisteredAt.Unix(),
+		UpdatedTs: user.UpdatedAt.Unix(),
+		Username:  user.Username,
+		Role:      role,
+		Nickname:  user.Nickname,
+		AvatarURL: federationBase() + "/api/user/avatar/" + utils.UserToView(user, false).Avatar,
+	}
+}
+
+func fileToMemos(base string, file *model.File, creatorID uint, viewer string) memos.Resource {
+	t := utils.ContentType(file.Filename, file.MimeType)
+	size := file.Size
+	if file.Hash == "" {
+		if info, err := storage.Default().Stat(file.ContentUrl); err == nil {
+			size = info.Size
+		}
+	}
+	return memos.Resource{
+		ID:        file.ID,
+		CreatorID: creatorID,
+		CreatedTs: file.CreatedAt.Unix(),
+		UpdatedTs: file.UpdatedAt.Unix(),
+		Filename:  file.Filename,
+		// clients link resources by their external link when it is set
+		ExternalLink: fileLink(base, file, viewer),
+		Type:         t,
+		Size:         size,
+	}
+}
+
+// memoBuilder converts posts to memos for the viewer, loading each author
+// once.
+type memoBuilder struct {
+	base   string
+	viewer string
+	users  map[string]*model.User
+}
+
+func newMemoBuilder(viewer string) *memoBuilder {
+	return &memoBuilder{base: federationBase(), viewer: viewer, users: make(map[string]*model.User)}
+}
+
+func (b *memoBuilder) memo(post *model.Post) (*memos.Memo, error) {
+	user, ok := b.users[post.Username]
+	if !ok {
+		user = &model.User{}
+		if err := memento.Db().First(user, "username=?", post.Username).Error; err != nil {
+			return nil, err
+		}
+		b.users[post.Username] = user
+	}
+	view, err := utils.PostToView(post, &model.UserViewModel{}, false)
+	if err != nil {
+		return nil, err
+	}
+	signPostLinks(view, post, b.viewer)
+	files := linkedFiles(view.Content)
+	resources := make([]memos.Resource, 0, len(files))
+	for i := range files {
+		if files[i].Username == user.Username {
+			resources = append(resources, fileToMemos(b.base, &files[i], user.ID, b.viewer))
+		}
+	}
+	name := user.Nickname
+	if name == "" {
+		name = user.Username
+	}
+	return &memos.Memo{
+		ID:              post.ID,
+		RowStatus:       memos.RowStatusNormal,
+		CreatorID:       user.ID,
+		CreatedTs:       post.CreatedAt.Unix(),
+		UpdatedTs:       post.EditedAt.Unix(),
+		DisplayTs:       post.CreatedAt.Unix(),
+		Content:         view.Content,
+		Visibility:      memosVisibility(post.IsPrivate),
+		CreatorName:     name,
+		CreatorUsername: user.Username,
+		ResourceList:    resources,
+		RelationList:    []interface{}{},
+	}, nil
+}
+
+// memosFilter applies the filters shared by the memo lists. An empty result
+// is reported by false, e.g. for archived or pinned memos which Memento
+// does not have.
+func memosFilter(c echo.Context, db *gorm.DB) (*gorm.DB, bool) {
+	if c.QueryParam("rowStatus") == memos.RowStatusArchived || c.QueryParam("pinned") == "true" {
+		return db, false
+	}
+	switch c.QueryParam("visibility") {
+	case memos.VisibilityPublic:
+		db = db.Where("posts.is_private = ?", false)
+	case memos.VisibilityPrivate, memos.VisibilityProtected:
+		db = db.Where("posts.is_private = ?", true)
+	}
+	if tag := c.QueryParam("tag"); tag != "" {
+		db = db.Where(`posts.id IN (
+			SELECT post_tags.post_id FROM post_tags JOIN tags ON tags.id = post_tags.tag_id WHERE tags.name = ?)`,
+			"#"+strings.TrimPrefix(tag, "#"))
+	}
+	return db, true
+}
+
+// memosPage parses the offset and limit of a memo list. The limit defaults
+// to memosPageSize and is capped at memosMaxPageSize.
+func memosPage(c echo.Context) (int, int, error) {
+	offset, limit := 0, memosPageSize
+	if value := c.QueryParam("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("invalid offset")
+		}
+		offset = n
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("invalid limit")
+		}
+		if n > 0 {
+			limit = min(n, memosMaxPageSize)
+		}
+	}
+	return offset, limit, nil
+}
+
+// respondMemos lists the posts of a query as memos. The content filter needs
+// the content, which is not in the database, so offset and limit are
+// applied after it.
+func respondMemos(c echo.Context, db *gorm.DB) error {
+	offset, limit, err := memosPage(c)
+	if err != nil {
+		return respondMemosError(c, http.StatusBadRequest, err.Error())
+	}
+	content := c.QueryParam("content")
+	db, ok := memosFilter(c, db)
+	if !ok {
+		return c.JSON(http.StatusOK, []memos.Memo{})
+	}
+	db = db.Order("posts.created_at DESC")
+	if content == "" {
+		db = db.Offset(offset).Limit(limit)
+	}
+	var posts []model.Post
+	if err := db.Find(&posts).Error; err != nil {
+		log.Errorf(err.Error())
+		return respondMemosError(c, http.StatusInternalServerError, "unknown query error")
+	}
+	builder := newMemoBuilder(viewerName(mastodonViewer(c)))
+	result := make([]memos.Memo, 0, min(len(posts), limit))
+	for i := range posts {
+		memo, err := builder.memo(&posts[i])
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+		if content != "" && !strings.Contains(strings.ToLower(memo.Content), strings.ToLower(content)) {
+			continue
+		}
+		result = append(result, *memo)
+		if content != "" && len(result) == offset+limit {
+			break
+		}
+	}
+	if content != "" {
+		result = result[min(offset, len(result)):]
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// HandleMemosListMemos lists the memos of a creator, by default the viewer.
+// Other users' lists only contain the public memos the viewer may see.
+func HandleMemosListMemos(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	creator := viewer
+	if id := c.QueryParam("creatorId"); id != "" {
+		creator = &model.User{}
+		if err := memento.Db().First(creator, "id=?", id).Error; err != nil {
+			return c.JSON(http.StatusOK, []memos.Memo{})
+		}
+	} else if username := c.QueryParam("creatorUsername"); username != "" {
+		creator = &model.User{}
+		if err := memento.Db().First(creator, "username=?", username).Error; err != nil {
+			return c.JSON(http.StatusOK, []memos.Memo{})
+		}
+	}
+	db := memento.Db().Model(&model.Post{})
+	if creator != nil && viewer != nil && creator.Username == viewer.Username {
+		return respondMemos(c, db.Where("posts.username = ?", viewer.Username))
+	}
+	db = visiblePosts(db, viewerName(viewer)).Where("posts.is_private = ?", false)
+	if creator != nil {
+		if isBlockedEitherWay(viewerName(viewer), creator.Username) {
+			return c.JSON(http.StatusOK, []memos.Memo{})
+		}
+		db = db.Where("posts.username = ?", creator.Username)
+	}
+	return respondMemos(c, db)
+}
+
+// HandleMemosListAllMemos lists the public memos of everyone, like the
+// explore page of Memos.
+func HandleMemosListAllMemos(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	db := feedPosts(memento.Db().Model(&model.Post{}), viewerName(viewer)).
+		Where("posts.is_private = ?", false)
+	return respondMemos(c, db)
+}
+
+func HandleMemosGetMemo(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	var post model.Post
+	if err := memento.Db().First(&post, "id=?", c.Param("id")).Error; err != nil || !canViewPost(viewerName(viewer), &post) {
+		return respondMemosError(c, http.StatusNotFound, "memo not found")
+	}
+	memo, err := newMemoBuilder(viewerName(viewer)).memo(&post)
+	if err != nil {
+		log.Errorf(err.Error())
+		return respondMemosError(c, http.StatusInternalServerError, "os open file error")
+	}
+	return c.JSON(http.StatusOK, memo)
+}
+
+// memoContent attaches the resources of a request to the content of a memo.
+func memoContent(user *model.User, content string, req *memosRequest) (string, error) {
+	ids := make([]string, len(req.ResourceIDList))
+	for i, id := range req.ResourceIDList {
+		ids[i] = strconv.Itoa(int(id))
+	}
+	return attachFiles(user, strings.TrimSpace(content), ids)
+}
+
+func parseMemosRequest(c echo.Context) (*memosRequest, error) {
+	var req memosRequest
+	if err := c.Bind(&req); err != nil {
+		return nil, errors.New("malformatted request")
+	}
+	if req.RowStatus == memos.RowStatusArchived {
+		return nil, errors.New("archiving memos is not supported")
+	}
+	if req.Pinned != nil && *req.Pinned {
+		return nil, errors.New("pinning memos is not supported")
+	}
+	return &req, nil
+}
+
+func HandleMemosCreateMemo(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMemosError(c, http.StatusUnauthorized, "missing user in session")
+	}
+	req, err := parseMemosRequest(c)
+	if err != nil {
+		return respondMemosError(c, http.StatusBadRequest, err.Error())
+	}
+	if req.Content == nil {
+		return respondMemosError(c, http.StatusBadRequest, "content is required")
+	}
+	content, err := memoContent(viewer, *req.Content, req)
+	if err != nil {
+		return respondMemosError(c, http.StatusBadRequest, err.Error())
+	}
+	if content == "" {
+		return respondMemosError(c, http.StatusBadRequest, "content is required")
+	}
+	visibility := ""
+	if req.Visibility != nil {
+		visibility = *req.Visibility
+	}
+	post, err := createPost(viewer, content, memosPrivate(visibility))
+	if err != nil {
+		return respondMemosError(c, http.StatusInternalServerError, err.Error())
+	}
+	memo, err := newMemoBuilder(viewer.Username).memo(post)
+	if err != nil {
+		log.Errorf(err.Error())
+		return respondMemosError(c, http.StatusInternalServerError, "os open file error")
+	}
+	return c.JSON(http.StatusOK, memo)
+}
+
+// ownMemo loads a post of the viewer by the id of the request path.
+func ownMemo(c echo.Context) (*model.User, *model.Post, error) {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return nil, nil, respondMemosError(c, http.StatusUnauthorized, "missing user in session")
+	}
+	var post model.Post
+	if err := memento.Db().First(&post, "id=?", c.Param("id")).Error; err != nil || !canViewPost(viewer.Username, &post) {
+		return nil, nil, respondMemosError(c, http.StatusNotFound, "memo not found")
+	}
+	if post.Username != viewer.Username {
+		return nil, nil, respondMemosError(c, http.StatusForbidden, "permission denied")
+	}
+	return viewer, &post, nil
+}
+
+func HandleMemosPatchMemo(c echo.Context) error {
+	viewer, post, err := ownMemo(c)
+	if viewer == nil {
+		return err
+	}
+	req, err := parseMemosRequest(c)
+	if err != nil {
+		return respondMemosError(c, http.StatusBadRequest, err.Error())
+	}
+	view, err := utils.PostToView(post, &model.UserViewModel{}, false)
+	if err != nil {
+		return respondMemosError(c, http.StatusInternalServerError, "os open file error")
+	}
+	content := view.Content
+	if req.Content != nil {
+		content = *req.Content
+	}
+	content, err = memoContent(viewer, content, req)
+	if err != nil {
+		return respondMemosError(c, http.StatusBadRequest, err.Error())
+	}
+	if content == "" {
+		return respondMemosError(c, http.StatusBadRequest, "content is required")
+	}
+	private := post.IsPrivate
+	if req.Visibility != nil {
+		private = memosPrivate(*req.Visibility)
+	}
+	if err := editPost(post, content, private); err != nil {
+		return respondMemosError(c, http.StatusInternalServerError, err.Error())
+	}
+	memo, err := newMemoBuilder(viewer.Username).memo(post)
+	if err != nil {
+		log.Errorf(err.Error())
+		return respondMemosError(c, http.StatusInternalServerError, "os open file error")
+	}
+	return c.JSON(http.StatusOK, memo)
+}
+
+func HandleMemosDeleteMemo(c echo.Context) error {
+	viewer, post, err := ownMemo(c)
+	if viewer == nil {
+		return err
+	}
+	if err := deletePost(post); err != nil {
+		return respondMemosError(c, http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, true)
+}
+
+func HandleMemosUploadResource(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMemosError(c, http.StatusUnauthorized, "missing user in session")
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		return respondMemosError(c, http.StatusBadRequest, "can not read form file")
+	}
+	file, err := saveUpload(viewer, header)
+	if err != nil {
+		return respondMemosError(c, http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, fileToMemos(federationBase(), file, viewer.ID, viewer.Username))
+}
+
+func HandleMemosListResources(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMemosError(c, http.StatusUnauthorized, "missing user in session")
+	}
+	var files []model.File
+	if err := memento.Db().Order("created_at DESC").Find(&files, "username=?", viewer.Username).Error; err != nil {
+		log.Errorf(err.Error())
+		return respondMemosError(c, http.StatusInternalServerError, "unknown query error")
+	}
+	base := federationBase()
+	result := make([]memos.Resource, 0, len(files))
+	for i := range files {
+		result = append(result, fileToMemos(base, &files[i], viewer.ID, viewer.Username))
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func HandleMemosDeleteResource(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMemosError(c, http.StatusUnauthorized, "missing user in session")
+	}
+	var file model.File
+	if err := memento.Db().First(&file, "id = ? AND username = ?", c.Param("id"), viewer.Username).Error; err != nil {
+		return respondMemosError(c, http.StatusNotFound, "resource not found")
+	}
+	if err := deleteFile(viewer, &file); err != nil {
+		return respondMemosError(c, http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, true)
+}
+
+// HandleMemosListTags lists the tags used by the memos of the viewer,
+// without the leading "#" as Memos stores them.
+func HandleMemosListTags(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMemosError(c, http.StatusUnauthorized, "missing user in session")
+	}
+	var names []string
+	err := memento.Db().Model(&model.Tag{}).Distinct("tags.name").
+		Joins("JOIN post_tags ON post_tags.tag_id = tags.id").
+		Joins("JOIN posts ON posts.id = post_tags.post_id").
+		Where("posts.username = ? AND posts.deleted_at IS NULL", viewer.Username).
+		Order("tags.name").Pluck("tags.name", &names).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return respondMemosError(c, http.StatusInternalServerError, "unknown query error")
+	}
+	result := make([]string, len(names))
+	for i, name := range names {
+		result[i] = strings.TrimPrefix(name, "#")
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// HandleMemosCreateTag accepts a tag. Tags of Memento exist through the posts
+// using them, so the tag is only listed once a memo uses it.
+func HandleMemosCreateTag(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMemosError(c, http.StatusUnauthorized, "missing user in session")
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return respondMemosError(c, http.StatusBadRequest, "malformatted request")
+	}
+	name := strings.TrimPrefix(strings.TrimSpace(req.Name), "#")
+	if name == "" || strings.ContainsAny(name, " \t\n#") {
+		return respondMemosError(c, http.StatusBadRequest, "invalid tag name")
+	}
+	tag := model.Tag{Name: "#" + name}
+	if err := memento.Db().FirstOrCreate(&tag, "name = ?", tag.Name).Error; err != nil {
+		log.Errorf(err.Error())
+		return respondMemosError(c, http.StatusInternalServerError, "unknown insertion error")
+	}
+	return c.JSON(http.StatusOK, name)
+}
+
+func HandleMemosGetMe(c echo.Context) error {
+	viewer := mastodonViewer(c)
+	if viewer == nil {
+		return respondMemosError(c, http.StatusUnauthorized, "missing user in session")
+	}
+	return c.JSON(http.StatusOK, userToMemos(viewer))
+}
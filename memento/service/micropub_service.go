@@ -0,0 +1,350 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// micropubRequest is the normalized form of a form encoded or JSON Micropub request.
+type micropubRequest struct {
+	Type       []string                 `json:"type"`
+	Action     string                   `json:"action"`
+	URL        string                   `json:"url"`
+	Properties map[string][]interface{} `json:"properties"`
+	Replace    map[string][]interface{} `json:"replace"`
+	Add        map[string][]interface{} `json:"add"`
+	Delete     json.RawMessage          `json:"delete"`
+}
+
+// micropubError is an error reported in the format of the Micropub specification.
+type micropubError struct {
+	status      int
+	code        string
+	description string
+}
+
+func (e *micropubError) Error() string {
+	return e.description
+}
+
+func invalidMicropubRequest(description string) error {
+	return &micropubError{http.StatusBadRequest, "invalid_request", description}
+}
+
+func respondMicropubError(c echo.Context, err error) error {
+	var e *micropubError
+	if !errors.As(err, &e) {
+		e = &micropubError{http.StatusInternalServerError, "server_error", err.Error()}
+	}
+	return c.JSON(e.status, echo.Map{
+		"error":             e.code,
+		"error_description": e.description,
+	})
+}
+
+// micropubUser authenticates the request with a personal access token sent
+// in the Authorization header or the access_token parameter.
+func micropubUser(c echo.Context, scope string) (*model.User, error) {
+	secret := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+	if secret == "" {
+		secret = c.FormValue("access_token")
+	}
+	if secret == "" {
+		return nil, &micropubError{http.StatusUnauthorized, "unauthorized", "missing access token"}
+	}
+	user, _, err := authenticateAccessToken(secret, scope)
+	if err != nil {
+		if errors.Is(err, errInsufficientScope) {
+			return nil, &micropubError{http.StatusForbidden, "insufficient_scope", "the token lacks the " + scope + " scope"}
+		}
+		if errors.Is(err, errInvalidAccessToken) {
+			return nil, &micropubError{http.StatusUnauthorized, "unauthorized", err.Error()}
+		}
+		return nil, &micropubError{http.StatusForbidden, "forbidden", err.Error()}
+	}
+	if !user.HasPermission(model.PermPostWrite) {
+		return nil, &micropubError{http.StatusForbidden, "forbidden", "permission required: " + string(model.PermPostWrite)}
+	}
+	return user, nil
+}
+
+func HandleMicropubQuery(c echo.Context) error {
+	user, err := micropubUser(c, "")
+	if err != nil {
+		return respondMicropubError(c, err)
+	}
+	switch c.QueryParam("q") {
+	case "config", "syndicate-to":
+		return c.JSON(http.StatusOK, echo.Map{"syndicate-to": []string{}})
+	case "source":
+		post, err := micropubPost(user, c.QueryParam("url"))
+		if err != nil {
+			return respondMicropubError(c, invalidMicropubRequest(err.Error()))
+		}
+		view, err := utils.PostToView(post, &model.UserViewModel{}, false)
+		if err != nil {
+			return respondMicropubError(c, errors.New("os open file error"))
+		}
+		categories := make([]string, 0)
+		for _, t := range utils.GetTags(view.Content) {
+			categories = append(categories, strings.TrimPrefix(t, "#"))
+		}
+		return c.JSON(http.StatusOK, echo.Map{
+			"type": []string{"h-entry"},
+			"properties": echo.Map{
+				"content":    []string{view.Content},
+				"category":   categories,
+				"visibility": []string{micropubVisibility(post.IsPrivate)},
+				"published":  []string{post.CreatedAt.Format("2006-01-02T15:04:05Z07:00")},
+				"url":        []string{postURL(federationBase(), post.ID)},
+			},
+		})
+	}
+	return respondMicropubError(c, invalidMicropubRequest("unsupported query"))
+}
+
+func HandleMicropub(c echo.Context) error {
+	req, err := parseMicropubRequest(c)
+	if err != nil {
+		return respondMicropubError(c, invalidMicropubRequest(err.Error()))
+	}
+	switch req.Action {
+	case "", "create":
+		return micropubCreate(c, req)
+	case "update":
+		return micropubUpdate(c, req)
+	case "delete":
+		user, err := micropubUser(c, model.ScopeDelete)
+		if err != nil {
+			return respondMicropubError(c, err)
+		}
+		post, err := micropubPost(user, req.URL)
+		if err != nil {
+			return respondMicropubError(c, invalidMicropubRequest(err.Error()))
+		}
+		if err := deletePost(post); err != nil {
+			return respondMicropubError(c, err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+	return respondMicropubError(c, invalidMicropubRequest("unsupported action"))
+}
+
+func micropubCreate(c echo.Context, req *micropubRequest) error {
+	user, err := micropubUser(c, model.ScopeCreate)
+	if err != nil {
+		return respondMicropubError(c, err)
+	}
+	if len(req.Type) > 0 && req.Type[0] != "h-entry" {
+		return respondMicropubError(c, invalidMicropubRequest("only h-entry is supported"))
+	}
+	content := micropubString(req.Properties["content"])
+	if name := micropubString(req.Properties["name"]); name != "" {
+		content = "# " + name + "\n\n" + content
+	}
+	content = addTags(content, req.Properties["category"])
+	if strings.TrimSpace(content) == "" {
+		return respondMicropubError(c, invalidMicropubRequest("empty content"))
+	}
+	private := micropubString(req.Properties["visibility"]) == "private" ||
+		micropubString(req.Properties["post-status"]) == "draft"
+	post, err := createPost(user, content, private)
+	if err != nil {
+		return respondMicropubError(c, err)
+	}
+	c.Response().Header().Set("Location", postURL(federationBase(), post.ID))
+	return c.NoContent(http.StatusCreated)
+}
+
+func micropubUpdate(c echo.Context, req *micropubRequest) error {
+	user, err := micropubUser(c, model.ScopeUpdate)
+	if err != nil {
+		return respondMicropubError(c, err)
+	}
+	post, err := micropubPost(user, req.URL)
+	if err != nil {
+		return respondMicropubError(c, invalidMicropubRequest(err.Error()))
+	}
+	view, err := utils.PostToView(post, &model.UserViewModel{}, false)
+	if err != nil {
+		return respondMicropubError(c, errors.New("os open file error"))
+	}
+	content := view.Content
+	private := post.IsPrivate
+	for property, values := range req.Replace {
+		switch property {
+		case "content":
+			content = micropubString(values)
+		case "visibility":
+			private = micropubString(values) == "private"
+		default:
+			return respondMicropubError(c, invalidMicropubRequest("unsupported property: "+property))
+		}
+	}
+	for property, values := range req.Add {
+		if property != "category" {
+			return respondMicropubError(c, invalidMicropubRequest("unsupported property: "+property))
+		}
+		content = addTags(content, values)
+	}
+	if len(req.Delete) > 0 {
+		var deleted map[string][]interface{}
+		if err := json.Unmarshal(req.Delete, &deleted); err != nil {
+			return respondMicropubError(c, invalidMicropubRequest("only category values can be deleted"))
+		}
+		for property, values := range deleted {
+			if property != "category" {
+				return respondMicropubError(c, invalidMicropubRequest("unsupported property: "+property))
+			}
+			content = removeTags(content, values)
+		}
+	}
+	if strings.TrimSpace(content) == "" {
+		return respondMicropubError(c, invalidMicropubRequest("empty content"))
+	}
+	if err := editPost(post, content, private); err != nil {
+		return respondMicropubError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func parseMicropubRequest(c echo.Context) (*micropubRequest, error) {
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		var req micropubRequest
+		if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+			return nil, errors.New("invalid json")
+		}
+		return &req, nil
+	}
+	form, err := c.FormParams()
+	if err != nil {
+		return nil, errors.New("invalid form")
+	}
+	req := &micropubRequest{
+		Action:     form.Get("action"),
+		URL:        form.Get("url"),
+		Properties: make(map[string][]interface{}),
+	}
+	if h := form.Get("h"); h != "" {
+		req.Type = []string{"h-" + h}
+	}
+	for key, values := range form {
+		switch key {
+		case "h", "action", "url", "access_token":
+			continue
+		}
+		key = strings.TrimSuffix(key, "[]")
+		for _, v := range values {
+			req.Properties[key] = append(req.Properties[key], v)
+		}
+	}
+	return req, nil
+}
+
+// micropubString returns the first value of a property. HTML content is kept
+// as is, since markdown renders it.
+func micropubString(values []interface{}) string {
+	if len(values) == 0 {
+		return ""
+	}
+	switch v := values[0].(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if s, ok := v["html"].(string); ok {
+			return s
+		}
+		if s, ok := v["value"].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+func addTags(content string, categories []interface{}) string {
+	existing := utils.GetTags(content)
+	for _, category := range categories {
+		name, _ := category.(string)
+		name = strings.Join(strings.Fields(name), "_")
+		if name == "" || utils.Contains(existing, "#"+name) || utils.Contains(existing, name) {
+			continue
+		}
+		content += " #" + name
+		existing = append(existing, name)
+	}
+	return content
+}
+
+func removeTags(content string, categories []interface{}) string {
+	for _, category := range categories {
+		name, ok := category.(string)
+		if !ok || name == "" {
+			continue
+		}
+		lines := strings.Split(content, "\n")
+		for i, line := range lines {
+			words := strings.Split(line, " ")
+			kept := words[:0]
+			for _, w := range words {
+				if w != "#"+name {
+					kept = append(kept, w)
+				}
+			}
+			lines[i] = strings.Join(kept, " ")
+		}
+		content = strings.Join(lines, "\n")
+	}
+	return content
+}
+
+func micropubVisibility(private bool) string {
+	if private {
+		return "private"
+	}
+	return "public"
+}
+
+func postURL(base string, id uint) string {
+	return base + "/public/article/" + strconv.Itoa(int(id))
+}
+
+// postIDFromURL returns the post referenced by a public article url.
+func postIDFromURL(raw string) (uint, bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return 0, false
+	}
+	idStr, found := strings.CutPrefix(strings.TrimSuffix(u.Path, "/"), "/public/article/")
+	if !found {
+		return 0, false
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// micropubPost loads a post of the user by its url.
+func micropubPost(user *model.User, raw string) (*model.Post, error) {
+	id, ok := postIDFromURL(raw)
+	if !ok {
+		return nil, errors.New("invalid url")
+	}
+	var post model.Post
+	if err := memento.Db().First(&post, "id=?", id).Error; err != nil {
+		return nil, errors.New("post not exists")
+	}
+	if post.Username != user.Username {
+		return nil, errors.New("permission denied")
+	}
+	return &post, nil
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultSuspendDays = 7
+
+func HandleReportCreate(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	targetType := c.FormValue("type")
+	target := c.FormValue("target")
+	reason := c.FormValue("reason")
+	if reason == "" || len([]rune(reason)) > 500 {
+		return utils.RespondError(c, "invalid reason")
+	}
+	owner, err := reportTargetOwner(targetType, target)
+	if err == nil && !reportTargetVisible(username, targetType, target) {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.RespondError(c, "report target not exists")
+		}
+		return utils.RespondError(c, err.Error())
+	}
+	if owner == username {
+		return utils.RespondError(c, "can not report yourself")
+	}
+	report := model.Report{
+		Reporter:   username,
+		TargetType: targetType,
+		Target:     target,
+		Reason:     reason,
+		Status:     model.ReportStatusPending,
+	}
+	if err := memento.Db().Create(&report).Error; err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown insertion error")
+	}
+	return c.JSON(http.StatusOK, utils.ReportToView(&report, false))
+}
+
+func HandleGetMyReports(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil {
+		return utils.RespondError(c, "invalid page")
+	}
+	return listReports(c, memento.Db().Where("reporter = ?", username), page, false)
+}
+
+func HandleGetReports(c echo.Context) error {
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil {
+		return utils.RespondError(c, "invalid page")
+	}
+	db := memento.Db()
+	if status := c.QueryParam("status"); status != "" {
+		db = db.Where("status = ?", status)
+	}
+	return listReports(c, db, page, true)
+}
+
+func listReports(c echo.Context, db *gorm.DB, page int, forModerator bool) error {
+	reports := make([]model.Report, 0, memento.PageSize)
+	err := db.Session(&gorm.Session{}).
+		Order("created_at desc").
+		Offset(page * memento.PageSize).
+		Limit(memento.PageSize).
+		Find(&reports).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	var total int64
+	err = db.Session(&gorm.Session{}).Model(&model.Report{}).Count(&total).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	result := make([]model.ReportViewModel, 0, len(reports))
+	for _, r := range reports {
+		result = append(result, *utils.ReportToView(&r, forModerator))
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"reports": result,
+		"maxPage": utils.MaxPage(total),
+	})
+}
+
+func HandleResolveReport(c echo.Context) error {
+	moderator := c.Get("username").(string)
+	action := c.FormValue("action")
+	note := c.FormValue("note")
+	var report model.Report
+	err := memento.Db().First(&report, "id=?", c.FormValue("id")).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.RespondError(c, "report not exists")
+		}
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	if report.Status != model.ReportStatusPending {
+		return utils.RespondError(c, "report already handled")
+	}
+	owner, err := reportTargetOwner(report.TargetType, report.Target)
+	if err != nil && action != model.ModerationDismiss {
+		return utils.RespondError(c, "report target not exists")
+	}
+	switch action {
+	case model.ModerationHide:
+		err = hideReportTarget(&report)
+	case model.ModerationWarn:
+		err = memento.Db().Create(&model.Warning{
+			Username:  owner,
+			Moderator: moderator,
+			Reason:    reportWarningReason(&report, note),
+			ReportID:  report.ID,
+		}).Error
+	case model.ModerationSuspend:
+		if !userHasPermission(moderator, model.PermUserSuspend) {
+			return utils.RespondForbidden(c, "permission required: "+string(model.PermUserSuspend))
+		}
+		days, convErr := strconv.Atoi(c.FormValue("days"))
+		if convErr != nil || days <= 0 {
+			days = defaultSuspendDays
+		}
+		until := time.Now().AddDate(0, 0, days)
+		err = suspendUser(owner, until, reportWarningReason(&report, note))
+		if err == nil {
+			recordAudit(c, model.AuditUserSuspend, owner, nil, until.Format(time.RFC3339))
+		}
+	case model.ModerationDismiss:
+	default:
+		return utils.RespondError(c, "invalid action")
+	}
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, err.Error())
+	}
+	report.Status = model.ReportStatusResolved
+	if action == model.ModerationDismiss {
+		report.Status = model.ReportStatusDismissed
+	}
+	report.Action = action
+	report.Moderator = moderator
+	report.Note = note
+	report.ResolvedAt = time.Now()
+	if err := memento.Db().Save(&report).Error; err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown update error")
+	}
+	recordAudit(c, model.AuditReportAction, "report:"+strconv.Itoa(int(report.ID)), nil, action)
+	return c.JSON(http.StatusOK, utils.ReportToView(&report, true))
+}
+
+func HandleGetWarnings(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	var warnings []model.Warning
+	err := memento.Db().Order("created_at desc").Find(&warnings, "username = ?", username).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	result := make([]model.WarningViewModel, 0, len(warnings))
+	for _, w := range warnings {
+		result = append(result, model.WarningViewModel{
+			ID:        w.ID,
+			Reason:    w.Reason,
+			ReportID:  w.ReportID,
+			CreatedAt: w.CreatedAt,
+		})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func HandleSuspendUser(c echo.Context) error {
+	username := c.FormValue("username")
+	reason := c.FormValue("reason")
+	days, err := strconv.Atoi(c.FormValue("days"))
+	if err != nil || days <= 0 {
+		return utils.RespondError(c, "invalid days")
+	}
+	until := time.Now().AddDate(0, 0, days)
+	if err := suspendUser(username, until, reason); err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	recordAudit(c, model.AuditUserSuspend, username, nil, until.Format(time.RFC3339))
+	return c.NoContent(http.StatusOK)
+}
+
+func HandleBanUser(c echo.Context) error {
+	username := c.FormValue("username")
+	reason := c.FormValue("reason")
+	if username == c.Get("username").(string) {
+		return utils.RespondError(c, "can not ban yourself")
+	}
+	result := memento.Db().Model(&model.User{}).
+		Where("username = ?", username).
+		Updates(map[string]interface{}{"is_banned": true, "ban_reason": reason})
+	if result.Error != nil {
+		log.Errorf(result.Error.Error())
+		return utils.RespondError(c, "unknown update error")
+	}
+	if result.RowsAffected == 0 {
+		return utils.RespondError(c, "user not exists")
+	}
+	recordAudit(c, model.AuditUserBan, username, nil, reason)
+	return c.NoContent(http.StatusOK)
+}
+
+// HandleRestoreUser lifts both the suspension and the ban of a user.
+func HandleRestoreUser(c echo.Context) error {
+	username := c.FormValue("username")
+	var user model.User
+	err := memento.Db().First(&user, "username=?", username).Error
+	if err != nil {
+		return utils.RespondError(c, "username not exists")
+	}
+	before := user.Restriction()
+	err = memento.Db().Model(&user).Updates(map[string]interface{}{
+		"is_banned":       false,
+		"ban_reason":      "",
+		"suspended_until": time.Time{},
+		"suspend_reason":  "",
+	}).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown update error")
+	}
+	recordAudit(c, model.AuditUserRestore, username, before, nil)
+	return c.NoContent(http.StatusOK)
+}
+
+func suspendUser(username string, until time.Time, reason string) error {
+	result := memento.Db().Model(&model.User{}).
+		Where("username = ?", username).
+		Updates(map[string]interface{}{"suspended_until": until, "suspend_reason": reason})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("username not exists")
+	}
+	return nil
+}
+
+// reportTargetOwner validates a report target and returns the username responsible for it.
+func reportTargetOwner(targetType string, target string) (string, error) {
+	switch targetType {
+	case model.ReportTargetPost:
+		var post model.Post
+		if err := memento.Db().First(&post, "id=?", target).Error; err != nil {
+			return "", err
+		}
+		return post.Username, nil
+	case model.ReportTargetComment:
+		var comment model.Comment
+		if err := memento.Db().First(&comment, "id=?", target).Error; err != nil {
+			return "", err
+		}
+		return comment.Username, nil
+	case model.ReportTargetUser:
+		var user model.User
+		if err := memento.Db().First(&user, "username=?", target).Error; err != nil {
+			return "", err
+		}
+		return user.Username, nil
+	}
+	return "", errors.New("invalid report type")
+}
+
+// reportTargetVisible reports whether the reporter may open the reported post
+// or the post of the reported comment, so the errors of reports do not tell
+// which other posts exist and who wrote them.
+func reportTargetVisible(reporter string, targetType string, target string) bool {
+	var post model.Post
+	switch targetType {
+	case model.ReportTargetPost:
+		if err := memento.Db().First(&post, "id=?", target).Error; err != nil {
+			return false
+		}
+	case model.ReportTargetComment:
+		var comment model.Comment
+		if err := memento.Db().First(&comment, "id=?", target).Error; err != nil {
+			return false
+		}
+		if err := memento.Db().First(&post, "id=?", comment.PostID).Error; err != nil {
+			return false
+		}
+	default:
+		return true
+	}
+	return canViewPost(reporter, &post)
+}
+
+func hideReportTarget(report *model.Report) error {
+	switch report.TargetType {
+	case model.ReportTargetPost:
+		var post model.Post
+		if err := memento.Db().First(&post, "id=?", report.Target).Error; err != nil {
+			return err
+		}
+		if err := memento.Db().Model(&post).Update("is_hidden", true).Error; err != nil {
+			return err
+		}
+		onPostsChanged(post.Username)
+		return nil
+	case model.ReportTargetComment:
+		return memento.Db().Model(&model.Comment{}).Where("id = ?", report.Target).Update("is_hidden", true).Error
+	}
+	return errors.New("only posts and comments can be hidden")
+}
+
+func reportWarningReason(report *model.Report, note string) string {
+	if note != "" {
+		return note
+	}
+	return report.Reason
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func report(t *testing.T, reporter *model.User, targetType string, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	form := url.Values{"type": {targetType}, "target": {target}, "reason": {"spam"}}
+	return call(t, HandleReportCreate, http.MethodPost, "/api/report", form, reporter.Username)
+}
+
+func TestReportNoteIsInternal(t *testing.T) {
+	author := newUser(t, "author")
+	reporter := newUser(t, "reporter")
+	moderator := newUser(t, "moderator")
+	post := newPost(t, author, "a note", false)
+	expectStatus(t, report(t, reporter, model.ReportTargetPost, strconv.Itoa(int(post.ID))), http.StatusOK)
+	var r model.Report
+	if err := memento.Db().Last(&r, "reporter = ?", reporter.Username).Error; err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"id": {strconv.Itoa(int(r.ID))}, "action": {model.ModerationDismiss}, "note": {"internal remark"}}
+	expectStatus(t, call(t, HandleResolveReport, http.MethodPost, "/api/moderation/resolve", form, moderator.Username), http.StatusOK)
+	rec := call(t, HandleGetMyReports, http.MethodGet, "/api/report/mine?page=0", nil, reporter.Username)
+	expectStatus(t, rec, http.StatusOK)
+	if strings.Contains(rec.Body.String(), "internal remark") {
+		t.Errorf("the reporter got the note of the moderator: %s", rec.Body)
+	}
+	rec = call(t, HandleGetReports, http.MethodGet, "/api/moderation/reports?page=0", nil, moderator.Username)
+	if !strings.Contains(rec.Body.String(), "internal remark") {
+		t.Errorf("the moderators did not get the note: %s", rec.Body)
+	}
+}
+
+func TestReportInvisibleTargets(t *testing.T) {
+	author := newUser(t, "author")
+	reporter := newUser(t, "reporter")
+	private := newPost(t, author, "a private note", true)
+	comment, err := createComment(author, private, "a comment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := report(t, reporter, model.ReportTargetPost, "999999").Body.String()
+	for _, target := range []struct {
+		kind string
+		id   uint
+	}{{model.ReportTargetPost, private.ID}, {model.ReportTargetComment, comment.ID}} {
+		rec := report(t, reporter, target.kind, strconv.Itoa(int(target.id)))
+		if rec.Body.String() != missing {
+			t.Errorf("%s %d: got %s, want %s", target.kind, target.id, rec.Body, missing)
+		}
+	}
+}
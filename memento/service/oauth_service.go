@@ -0,0 +1,391 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// oauthCodeLifetime is how long an authorization code may be exchanged.
+const oauthCodeLifetime = 10 * time.Minute
+
+// oauthRequest holds the parameters of the app, authorize and token
+// endpoints, which clients send either as a form or as JSON.
+type oauthRequest struct {
+	ClientName   string `json:"client_name" form:"client_name" query:"client_name"`
+	Website      string `json:"website" form:"website" query:"website"`
+	RedirectURIs string `json:"redirect_uris" form:"redirect_uris" query:"redirect_uris"`
+	RedirectURI  string `json:"redirect_uri" form:"redirect_uri" query:"redirect_uri"`
+	Scopes       string `json:"scopes" form:"scopes" query:"scopes"`
+	Scope        string `json:"scope" form:"scope" query:"scope"`
+	ClientID     string `json:"client_id" form:"client_id" query:"client_id"`
+	ClientSecret string `json:"client_secret" form:"client_secret" query:"client_secret"`
+	ResponseType string `json:"response_type" form:"response_type" query:"response_type"`
+	State        string `json:"state" form:"state" query:"state"`
+	GrantType    string `json:"grant_type" form:"grant_type" query:"grant_type"`
+	Code         string `json:"code" form:"code" query:"code"`
+	Username     string `json:"username" form:"username" query:"username"`
+	Password     string `json:"password" form:"password" query:"password"`
+	Token        string `json:"token" form:"token" query:"token"`
+}
+
+func respondOAuthError(c echo.Context, status int, code string, description string) error {
+	return c.JSON(status, echo.Map{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// validScopes reports whether every scope is known. Granular scopes such as
+// "write:statuses" are accepted as well.
+func validScopes(scopes []string) bool {
+	for _, s := range scopes {
+		base, _, _ := strings.Cut(s, ":")
+		if !utils.Contains(model.OAuthScopes, base) {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleCreateApp registers a client application.
+func HandleCreateApp(c echo.Context) error {
+	var req oauthRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": "invalid request"})
+	}
+	name := strings.TrimSpace(req.ClientName)
+	if name == "" || len([]rune(name)) > 50 {
+		return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": "Validation failed: Application name is invalid"})
+	}
+	redirects := strings.Fields(req.RedirectURIs)
+	if len(redirects) == 0 {
+		return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": "Validation failed: Redirect URI can't be blank"})
+	}
+	for _, r := range redirects {
+		if r != model.OAuthRedirectOOB && !isAppRedirect(r) {
+			return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": "Validation failed: Redirect URI must be an absolute URI"})
+		}
+	}
+	scopes := strings.Fields(req.Scopes)
+	if len(scopes) == 0 {
+		scopes = []string{model.ScopeRead}
+	}
+	if !validScopes(scopes) {
+		return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": "Validation failed: Scopes are invalid"})
+	}
+	clientID, err := randomSecret("")
+	if err != nil {
+		log.Errorf(err.Error())
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "token generation failed"})
+	}
+	clientSecret, err := randomSecret("")
+	if err != nil {
+		log.Errorf(err.Error())
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "token generation failed"})
+	}
+	app := model.OAuthApp{
+		Name:         name,
+		Website:      req.Website,
+		RedirectURIs: strings.Join(redirects, " "),
+		Scopes:       strings.Join(scopes, " "),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+	}
+	if err := memento.Db().Create(&app).Error; err != nil {
+		log.Errorf(err.Error())
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "unknown insertion error"})
+	}
+	view := appToMastodon(&app)
+	view["client_id"] = app.ClientID
+	view["client_secret"] = app.ClientSecret
+	return c.JSON(http.StatusOK, view)
+}
+
+// isAppRedirect accepts absolute uris, including the custom schemes of
+// mobile apps.
+func isAppRedirect(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme != "" && (u.Host != "" || u.Opaque != "" || u.Path != "")
+}
+
+// HandleVerifyAppCredentials returns the app of the access token.
+func HandleVerifyAppCredentials(c echo.Context) error {
+	token, ok := c.Get("accessToken").(*model.AccessToken)
+	if !ok || token.AppID == 0 {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "The access token is invalid"})
+	}
+	var app model.OAuthApp
+	if err := memento.Db().First(&app, "id=?", token.AppID).Error; err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "The access token is invalid"})
+	}
+	return c.JSON(http.StatusOK, appToMastodon(&app))
+}
+
+func appToMastodon(app *model.OAuthApp) echo.Map {
+	redirects := strings.Fields(app.RedirectURIs)
+	return echo.Map{
+		"id":            strconv.Itoa(int(app.ID)),
+		"name":          app.Name,
+		"website":       nilIfEmpty(app.Website),
+		"scopes":        strings.Fields(app.Scopes),
+		"redirect_uri":  strings.Join(redirects, "\n"),
+		"redirect_uris": redirects,
+		"vapid_key":     "",
+	}
+}
+
+func nilIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+var authorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Authorize {{.App}} - {{.Site}}</title>
+<style>
+body { font-family: sans-serif; max-width: 360px; margin: 48px auto; padding: 0 16px; color: #222; }
+input { display: block; width: 100%; box-sizing: border-box; margin: 8px 0 16px; padding: 8px; }
+button { padding: 8px 24px; }
+.error { color: #c00; }
+code { font-size: 1.2em; word-break: break-all; }
+</style>
+</head>
+<body>
+<h2>{{.Site}}</h2>
+{{if .Code}}
+<p>Copy this authorization code and paste it into {{.App}}:</p>
+<p><code>{{.Code}}</code></p>
+{{else}}
+<p><b>{{.App}}</b> wants to access your account with the scopes <b>{{.Scope}}</b>.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="client_id" value="{{.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="hidden" name="response_type" value="code">
+<label>Username<input name="username" autocomplete="username" value="{{.Username}}" required></label>
+<label>Password<input name="password" type="password" autocomplete="current-password" required></label>
+<button type="submit">Authorize</button>
+</form>
+{{end}}
+</body>
+</html>`))
+
+type authorizePage struct {
+	Site        string
+	App         string
+	ClientID    string
+	RedirectURI string
+	Scope       string
+	State       string
+	Username    string
+	Error       string
+	Code        string
+}
+
+// authorizeApp validates the client of an authorization request and returns
+// the requested scopes, which default to the scopes of the app.
+func authorizeApp(req *oauthRequest) (*model.OAuthApp, string, error) {
+	if req.ResponseType != "code" {
+		return nil, "", errors.New("unsupported response type")
+	}
+	var app model.OAuthApp
+	if err := memento.Db().First(&app, "client_id=?", req.ClientID).Error; err != nil {
+		return nil, "", errors.New("unknown client")
+	}
+	if !app.AllowsRedirect(req.RedirectURI) {
+		return nil, "", errors.New("invalid redirect uri")
+	}
+	scopes := strings.Fields(req.Scope)
+	if len(scopes) == 0 {
+		return &app, app.Scopes, nil
+	}
+	appScopes := strings.Fields(app.Scopes)
+	for _, s := range scopes {
+		if !utils.Contains(appScopes, s) {
+			return nil, "", errors.New("invalid scope")
+		}
+	}
+	return &app, strings.Join(scopes, " "), nil
+}
+
+func HandleAuthorizeForm(c echo.Context) error {
+	var req oauthRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "invalid request")
+	}
+	app, scope, err := authorizeApp(&req)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	return renderAuthorizePage(c, http.StatusOK, &authorizePage{
+		App:         app.Name,
+		ClientID:    app.ClientID,
+		RedirectURI: req.RedirectURI,
+		Scope:       scope,
+		State:       req.State,
+	})
+}
+
+// HandleAuthorize signs the user in and redirects back to the app with an
+// authorization code.
+func HandleAuthorize(c echo.Context) error {
+	var req oauthRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "invalid request")
+	}
+	app, scope, err := authorizeApp(&req)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	page := &authorizePage{
+		App:         app.Name,
+		ClientID:    app.ClientID,
+		RedirectURI: req.RedirectURI,
+		Scope:       scope,
+		State:       req.State,
+		Username:    req.Username,
+	}
+	user, err := checkPassword(c, req.Username, req.Password)
+	if err == nil {
+		if restriction := user.Restriction(); restriction != "" {
+			err = errors.New(restriction)
+		}
+	}
+	if err != nil {
+		page.Error = err.Error()
+		return renderAuthorizePage(c, http.StatusUnauthorized, page)
+	}
+	code, err := randomSecret("")
+	if err != nil {
+		log.Errorf(err.Error())
+		return c.String(http.StatusInternalServerError, "token generation failed")
+	}
+	err = memento.Db().Create(&model.OAuthCode{
+		AppID:       app.ID,
+		Username:    user.Username,
+		CodeHash:    hashAccessToken(code),
+		RedirectURI: req.RedirectURI,
+		Scope:       scope,
+		ExpiresAt:   time.Now().Add(oauthCodeLifetime),
+	}).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return c.String(http.StatusInternalServerError, "unknown insertion error")
+	}
+	if req.RedirectURI == model.OAuthRedirectOOB {
+		page.Code = code
+		return renderAuthorizePage(c, http.StatusOK, page)
+	}
+	redirect, _ := url.Parse(req.RedirectURI)
+	query := redirect.Query()
+	query.Set("code", code)
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	redirect.RawQuery = query.Encode()
+	return c.Redirect(http.StatusFound, redirect.String())
+}
+
+func renderAuthorizePage(c echo.Context, status int, page *authorizePage) error {
+	page.Site = memento.GetConfig().SiteName
+	var b strings.Builder
+	if err := authorizeTemplate.Execute(&b, page); err != nil {
+		log.Errorf(err.Error())
+		return c.String(http.StatusInternalServerError, "template error")
+	}
+	return c.HTML(status, b.String())
+}
+
+// HandleOAuthToken exchanges an authorization code or the credentials of a
+// user for an access token.
+func HandleOAuthToken(c echo.Context) error {
+	var req oauthRequest
+	if err := c.Bind(&req); err != nil {
+		return respondOAuthError(c, http.StatusBadRequest, "invalid_request", "invalid request")
+	}
+	var app model.OAuthApp
+	err := memento.Db().First(&app, "client_id = ? AND client_secret = ?", req.ClientID, req.ClientSecret).Error
+	if err != nil {
+		return respondOAuthError(c, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+	}
+	var username, scope string
+	switch req.GrantType {
+	case "authorization_code":
+		var code model.OAuthCode
+		err := memento.Db().First(&code, "code_hash = ? AND app_id = ?", hashAccessToken(req.Code), app.ID).Error
+		if err != nil {
+			return respondOAuthError(c, http.StatusBadRequest, "invalid_grant", "invalid authorization code")
+		}
+		// codes may only be used once
+		if err := memento.Db().Unscoped().Delete(&code).Error; err != nil {
+			log.Errorf(err.Error())
+		}
+		if code.ExpiresAt.Before(time.Now()) || req.RedirectURI != code.RedirectURI {
+			return respondOAuthError(c, http.StatusBadRequest, "invalid_grant", "invalid authorization code")
+		}
+		username, scope = code.Username, code.Scope
+	case "password":
+		user, err := checkPassword(c, req.Username, req.Password)
+		if err != nil {
+			return respondOAuthError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+		}
+		username, scope = user.Username, app.Scopes
+		if req.Scope != "" {
+			scope = req.Scope
+		}
+		for _, s := range strings.Fields(scope) {
+			if !utils.Contains(strings.Fields(app.Scopes), s) {
+				return respondOAuthError(c, http.StatusBadRequest, "invalid_scope", "invalid scope")
+			}
+		}
+	default:
+		return respondOAuthError(c, http.StatusBadRequest, "unsupported_grant_type", "unsupported grant type")
+	}
+	secret, token, err := newAccessToken(username, app.Name, scope, app.ID)
+	if err != nil {
+		return respondOAuthError(c, http.StatusInternalServerError, "server_error", err.Error())
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"access_token": secret,
+		"token_type":   "Bearer",
+		"scope":        token.Scope,
+		"created_at":   token.CreatedAt.Unix(),
+	})
+}
+
+// HandleOAuthRevoke deletes a token issued to the app.
+func HandleOAuthRevoke(c echo.Context) error {
+	var req oauthRequest
+	if err := c.Bind(&req); err != nil {
+		return respondOAuthError(c, http.StatusBadRequest, "invalid_request", "invalid request")
+	}
+	var app model.OAuthApp
+	err := memento.Db().First(&app, "client_id = ? AND client_secret = ?", req.ClientID, req.ClientSecret).Error
+	if err != nil {
+		return respondOAuthError(c, http.StatusForbidden, "unauthorized_client", "client authentication failed")
+	}
+	err = memento.Db().Delete(&model.AccessToken{}, "token_hash = ? AND app_id = ?", hashAccessToken(req.Token), app.ID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Errorf(err.Error())
+		return respondOAuthError(c, http.StatusInternalServerError, "server_error", "unknown deletion error")
+	}
+	return c.JSON(http.StatusOK, echo.Map{})
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestOAuthTokenNeedsRedirectURI(t *testing.T) {
+	user := newUser(t, "author")
+	const redirect = "https://app.example/callback"
+	clientID, _ := randomSecret("")
+	app := model.OAuthApp{
+		Name:         "app",
+		RedirectURIs: redirect,
+		Scopes:       model.ScopeRead,
+		ClientID:     clientID,
+		ClientSecret: "secret",
+	}
+	if err := memento.Db().Create(&app).Error; err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		redirect string
+		status   int
+	}{
+		{"", http.StatusBadRequest},
+		{"https://app.example/other", http.StatusBadRequest},
+		{redirect, http.StatusOK},
+	}
+	for _, test := range tests {
+		code, _ := randomSecret("")
+		err := memento.Db().Create(&model.OAuthCode{
+			AppID:       app.ID,
+			Username:    user.Username,
+			CodeHash:    hashAccessToken(code),
+			RedirectURI: redirect,
+			Scope:       model.ScopeRead,
+			ExpiresAt:   time.Now().Add(time.Minute),
+		}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {app.ClientID},
+			"client_secret": {app.ClientSecret},
+			"code":          {code},
+		}
+		if test.redirect != "" {
+			form.Set("redirect_uri", test.redirect)
+		}
+		rec := call(t, HandleOAuthToken, http.MethodPost, "/oauth/token", form, "")
+		if rec.Code != test.status {
+			t.Errorf("redirect uri %q: status %d (%s), want %d", test.redirect, rec.Code, rec.Body, test.status)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/storage"
+	"Memento/memento/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+)
+
+// orphanGrace is how old stored blobs nothing references have to be before
+// they are collected, so blobs which are being written are kept.
+const orphanGrace = 24 * time.Hour
+
+// linkedFileIDs returns the ids of the files linked from any post or comment.
+// Links are read from the contents rather than the recorded links of posts,
+// so files linked by other users or from posts written before links were
+// recorded are found as well.
+func linkedFileIDs() (map[string]bool, error) {
+	linked := make(map[string]bool)
+	collect := func(content string) {
+		for _, m := range utils.FileLinkPattern.FindAllStringSubmatch(content, -1) {
+			linked[m[1]] = true
+		}
+	}
+	var posts []model.Post
+	err := memento.Db().FindInBatches(&posts, 100, func(tx *gorm.DB, batch int) error {
+		for i := range posts {
+			content, err := utils.PostContent(&posts[i])
+			if err != nil {
+				// the files of a post which can not be read are kept
+				return err
+			}
+			collect(string(content))
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	var comments []string
+	if err := memento.Db().Model(&model.Comment{}).Pluck("content", &comments).Error; err != nil {
+		return nil, err
+	}
+	for _, content := range comments {
+		collect(content)
+	}
+	return linked, nil
+}
+
+// CollectOrphans finds the uploads older than days which no post or comment
+// links, and the stored blobs nothing references: replaced avatars, blobs
+// and variants left behind by interrupted uploads and removals. They are
+// removed when remove is set.
+func CollectOrphans(days int, remove bool) (*model.OrphanReportViewModel, error) {
+	report := &model.OrphanReportViewModel{
+		Files:   make([]model.OrphanFileViewModel, 0),
+		Blobs:   make([]string, 0),
+		Removed: remove,
+	}
+	linked, err := linkedFileIDs()
+	if err != nil {
+		return nil, err
+	}
+	var files []model.File
+	err = memento.Db().
+		Where("username IS NOT NULL AND username <> '' AND created_at < ?", time.Now().AddDate(0, 0, -days)).
+		Order("id").
+		Find(&files).
+		Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		file := &files[i]
+		if linked[strconv.Itoa(int(file.ID))] {
+			continue
+		}
+		if remove {
+			var user model.User
+			if err := memento.Db().First(&user, "username=?", file.Username).Error; err != nil {
+				log.Errorf(err.Error())
+				continue
+			}
+			if err := deleteFile(&user, file); err != nil {
+				log.Errorf(err.Error())
+				continue
+			}
+		}
+		report.Files = append(report.Files, model.OrphanFileViewModel{
+			ID:        file.ID,
+			Username:  file.Username,
+			Filename:  file.Filename,
+			Size:      file.Size,
+			CreatedAt: file.CreatedAt,
+		})
+		report.Bytes += file.Size
+	}
+	blobs, err := orphanBlobs()
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range blobs {
+		if remove {
+			if err := storage.Default().Delete(object.Key); err != nil {
+				log.Errorf(err.Error())
+				continue
+			}
+		}
+		report.Blobs = append(report.Blobs, object.Key)
+		report.Bytes += object.Size
+	}
+	return report, nil
+}
+
+// orphanBlobs returns the avatars and uploaded blobs nothing references.
+func orphanBlobs() ([]storage.Object, error) {
+	referenced := make(map[string]bool)
+	var keys []string
+	if err := memento.Db().Model(&model.User{}).Pluck("avatar_url", &keys).Error; err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		referenced[key] = true
+	}
+	if err := memento.Db().Model(&model.Blob{}).Pluck("key", &keys).Error; err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		referenced[key] = true
+	}
+	// files uploaded before deduplication keep their own blobs
+	var legacy []model.File
+	err := memento.Db().Select("id", "content_url").
+		Where("username IS NOT NULL AND (hash = '' OR hash IS NULL)").
+		Find(&legacy).
+		Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range legacy {
+		referenced[legacy[i].ContentUrl] = true
+		for size := range imageVariants {
+			referenced[variantKey(&legacy[i], size)] = true
+		}
+	}
+	blobs := make(map[string]bool)
+	if err := memento.Db().Model(&model.Blob{}).Pluck("hash", &keys).Error; err != nil {
+		return nil, err
+	}
+	for _, hash := range keys {
+		blobs[hash] = true
+	}
+	cutoff := time.Now().Add(-orphanGrace)
+	orphans := make([]storage.Object, 0)
+	for _, prefix := range []string{storage.AvatarPrefix, storage.UploadPrefix} {
+		objects, err := storage.Default().List(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			if referenced[object.Key] || object.ModTime.After(cutoff) {
+				continue
+			}
+			// variants of deduplicated images are named by the hash of
+			// their blob
+			variant := strings.TrimPrefix(object.Key, storage.UploadPrefix+"variant/")
+			if variant != object.Key && !strings.Contains(variant, "/file/") {
+				if hash := variant[strings.LastIndex(variant, "/")+1:]; blobs[hash] {
+					continue
+				}
+			}
+			orphans = append(orphans, object)
+		}
+	}
+	return orphans, nil
+}
+
+// StartOrphanCollection collects the orphaned uploads once a day as the
+// storage section of the config says.
+func StartOrphanCollection() {
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		for {
+			config := memento.GetConfig().Storage
+			if config.OrphanDays > 0 {
+				report, err := CollectOrphans(config.OrphanDays, config.RemoveOrphans)
+				if err != nil {
+					log.Errorf("collecting orphaned uploads: %v", err)
+				} else if len(report.Files) > 0 || len(report.Blobs) > 0 {
+					action := "found"
+					if report.Removed {
+						action = "removed"
+					}
+					log.Infof("%s %d orphaned uploads and %d blobs, %d bytes",
+						action, len(report.Files), len(report.Blobs), report.Bytes)
+				}
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// HandleAdminOrphans reports the orphaned uploads older than the days
+// parameter, or the configured age, and removes them on POST. The age is at
+// least a day, so files which were just uploaded for a post being written
+// are kept.
+func HandleAdminOrphans(c echo.Context) error {
+	days := memento.GetConfig().Storage.OrphanDays
+	if value := c.QueryParam("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil {
+			return utils.RespondError(c, "invalid days")
+		}
+	}
+	if days < 1 {
+		return utils.RespondError(c, "days must be at least 1")
+	}
+	remove := c.Request().Method == http.MethodPost
+	report, err := CollectOrphans(days, remove)
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondInternalError(c, "collecting orphaned uploads failed")
+	}
+	if remove {
+		recordAudit(c, model.AuditStorageClean, "storage", nil, echo.Map{
+			"days":  days,
+			"files": len(report.Files),
+			"blobs": len(report.Blobs),
+			"bytes": report.Bytes,
+		})
+	}
+	return c.JSON(http.StatusOK, report)
+}
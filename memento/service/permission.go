@@ -0,0 +1,128 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+// RequirePermission returns a middleware which rejects the request unless the
+// current user has a role granting the permission.
+func RequirePermission(permission model.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			username, ok := c.Get("username").(string)
+			if !ok || username == "" {
+				return utils.RespondUnauthorized(c)
+			}
+			var user model.User
+			err := memento.Db().First(&user, "username=?", username).Error
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return utils.RespondUnauthorized(c)
+				}
+				log.Errorf(err.Error())
+				return utils.RespondInternalError(c, "unknown query error")
+			}
+			if !user.HasPermission(permission) {
+				return utils.RespondForbidden(c, "permission required: "+string(permission))
+			}
+			return next(c)
+		}
+	}
+}
+
+// userHasPermission reports whether the user exists and has the permission.
+func userHasPermission(username string, permission model.Permission) bool {
+	if username == "" {
+		return false
+	}
+	var user model.User
+	err := memento.Db().First(&user, "username=?", username).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Errorf(err.Error())
+		}
+		return false
+	}
+	return user.HasPermission(permission)
+}
+
+// setUserRole updates the role of a user and keeps the legacy IsAdmin flag in sync.
+func setUserRole(username string, role model.Role) error {
+	if !role.IsValid() {
+		return errors.New("invalid role")
+	}
+	return memento.Db().Transaction(func(tx *gorm.DB) error {
+		var user model.User
+		err := tx.First(&user, "username=?", username).Error
+		if err != nil {
+			return err
+		}
+		if role != model.RoleAdmin {
+			if err := keepLastAdmin(tx, &user); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"role":     role,
+			"is_admin": role == model.RoleAdmin,
+		}).Error
+	})
+}
+
+// keepLastAdmin refuses to take the admin role from the user, by a change of
+// role or a deletion, when no other admin is left.
+func keepLastAdmin(tx *gorm.DB, user *model.User) error {
+	if user.EffectiveRole() != model.RoleAdmin {
+		return nil
+	}
+	var admins int64
+	err := tx.Model(&model.User{}).Where("role = ?", model.RoleAdmin).Count(&admins).Error
+	if err != nil {
+		return err
+	}
+	if admins <= 1 {
+		return errors.New("can not remove the last admin")
+	}
+	return nil
+}
+
+func HandleGetRoles(c echo.Context) error {
+	roles := make([]echo.Map, 0, len(model.Roles))
+	for _, r := range model.Roles {
+		roles = append(roles, echo.Map{
+			"role":        r,
+			"permissions": model.RolePermissions[r],
+		})
+	}
+	return c.JSON(http.StatusOK, roles)
+}
+
+func HandleSetUserRole(c echo.Context) error {
+	username := c.FormValue("username")
+	role := model.Role(c.FormValue("role"))
+	if !role.IsValid() {
+		return utils.RespondError(c, "invalid role")
+	}
+	var user model.User
+	err := memento.Db().First(&user, "username=?", username).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.RespondError(c, "username not exists")
+		}
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	err = setUserRole(username, role)
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	recordAudit(c, model.AuditUserRole, username, string(user.EffectiveRole()), string(role))
+	return c.NoContent(http.StatusOK)
+}
@@ -2,8 +2,10 @@ package service
 
 import (
 	"Memento/memento"
+	"Memento/memento/activitypub"
 	"Memento/memento/model"
 	"Memento/memento/query"
+	"Memento/memento/storage"
 	"Memento/memento/utils"
 	"errors"
 	"fmt"
@@ -12,8 +14,6 @@ import (
 	"gorm.io/gorm"
 	"math/rand"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -41,46 +41,57 @@ func HandlePostCreate(c echo.Context) error {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "unknown query error")
 	}
+	content := c.FormValue("content")
+	if content == "" {
+		return utils.RespondError(c, "empty content")
+	}
+	post, err := createPost(user, content, private)
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	pv, err := utils.PostToView(
+		post,
+		utils.UserToView(user, checkIsFollowed(c.Get("username").(string), user.Username)),
+		false)
+	if err != nil {
+		return utils.RespondError(c, "os open file error")
+	}
+	signPostLinks(pv, post, c.Get("username").(string))
+	return c.JSON(http.StatusOK, *pv)
+}
 
+// createPost stores a new post of the user and updates everything derived
+// from it: tags, counters, the search index, feeds and federation.
+func createPost(user *model.User, content string, private bool) (*model.Post, error) {
 	now := time.Now()
+	post, err := storePost(user, content, private, now, now)
+	if err != nil {
+		return nil, err
+	}
+	federatePost(activitypub.ActivityCreate, post)
+	sendWebmentions(post)
+	syncGitPost(post)
+	onPostsChanged(user.Username)
+	return post, nil
+}
+
+// storePost stores a post with its tags, counters and search index, but
+// does not announce it. Imports use it to keep the original timestamps.
+func storePost(user *model.User, content string, private bool, createdAt time.Time, editedAt time.Time) (*model.Post, error) {
+	content = unsignFileLinks(content)
 	post := &model.Post{
 		IsPrivate:    private,
 		Username:     user.Username,
 		TotalLiked:   0,
-		CreatedAt:    now,
-		EditedAt:     now,
+		CreatedAt:    createdAt,
+		EditedAt:     editedAt,
 		TotalComment: 0,
 	}
-	content := c.FormValue("content")
-	if content == "" {
-		return utils.RespondError(c, "empty content")
-	}
-	contentFilename := utils.Md5string(fmt.Sprintf("%d%d", now.Unix(), rand.Int())) + ".md"
-	subDir := utils.Md5string(fmt.Sprintf("%s%d", now.Month().String(), now.Year()))
-	contentFilepath := filepath.Join(memento.GetPostPath(), subDir, contentFilename)
-	subDirPath := filepath.Join(memento.GetPostPath(), subDir)
-	if _, err := os.Stat(subDirPath); os.IsNotExist(err) {
-		err = os.Mkdir(subDirPath, os.ModePerm)
-		if err != nil {
-			log.Errorf(err.Error())
-			return utils.RespondError(c, "os file create error")
-		}
-	}
-
-	file, err := os.OpenFile(contentFilepath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0777)
+	_, err := writePostContent(post, content)
 	if err != nil {
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "os file open error")
+		return nil, errors.New("data write error")
 	}
-	defer func(file *os.File) {
-		_ = file.Close()
-	}(file)
-	if _, err = file.WriteString(content); err != nil {
-		log.Errorf(err.Error())
-		return utils.RespondError(c, "data write error")
-	}
-
-	post.ContentUrl = contentFilepath
 	contentTags := utils.GetTags(content)
 	err = query.Q.Transaction(
 		func(tx *query.Query) error {
@@ -111,23 +122,17 @@ func HandlePostCreate(c echo.Context) error {
 		})
 	if err != nil {
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown insertion error")
+		return nil, errors.New("unknown insertion error")
 	}
-	pv, err := utils.PostToView(
-		post,
-		utils.UserToView(user, checkIsFollowed(c.Get("username").(string), user.Username)),
-		false)
-	if err != nil {
-		return utils.RespondError(c, "os open file error")
+	if err = memento.LinkPostFiles(memento.Db(), post, content); err != nil {
+		log.Errorf(err.Error())
 	}
-	err = memento.IndexPost(post)
-	if err != nil {
+	if err = memento.IndexPost(post); err != nil {
 		log.Errorf(err.Error())
-		return utils.RespondInternalError(c, "index failed")
 	}
-	defer onPostsChanged(username)
-	return c.JSON(http.StatusOK, *pv)
+	return post, nil
 }
+
 func HandlePostDelete(c echo.Context) error {
 	username := c.Get("username")
 	if username == "" {
@@ -148,6 +153,13 @@ func HandlePostDelete(c echo.Context) error {
 	if post.Username != username {
 		return utils.RespondError(c, "permission denied")
 	}
+	if err := deletePost(&post); err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func deletePost(post *model.Post) error {
 	err := memento.Db().Transaction(
 		func(tx *gorm.DB) error {
 			var user model.User
@@ -157,43 +169,53 @@ func HandlePostDelete(c echo.Context) error {
 				return err
 			}
 			var tags []model.Tag
-			err = tx.Model(&post).Association("Tags").Find(&tags)
+			err = tx.Model(post).Association("Tags").Find(&tags)
 			if err != nil {
 				log.Errorf(err.Error())
 				return err
 			}
 			for _, tag := range tags {
-				err = tx.Model(&tag).Association("Posts").Delete(&post)
+				err = tx.Model(&tag).Association("Posts").Delete(post)
 				if err != nil {
 					log.Errorf(err.Error())
 					return err
 				}
 			}
-			err = tx.Model(&user).Association("Posts").Delete(&post)
+			err = tx.Model(&user).Association("Posts").Delete(post)
 			if err != nil {
 				log.Errorf(err.Error())
 				return err
 			}
-			if err = tx.Delete(&post).Error; err != nil {
+			if err = tx.Delete(post).Error; err != nil {
 				log.Errorf(err.Error())
 				return err
 			}
 			user.TotalPosts -= 1
 			tx.Save(&user)
 			tx.Delete(&model.Comment{}, "post_id=?", post.ID)
+			if err = tx.Model(post).Association("Files").Clear(); err != nil {
+				log.Errorf(err.Error())
+				return err
+			}
 			return nil
 		})
 	if err != nil {
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown deletion error")
+		return errors.New("unknown deletion error")
 	}
-	err = os.Remove(post.ContentUrl)
-	if err != nil {
-		log.Errorf(err.Error())
+	if !post.IsPrivate {
+		federatePost(activitypub.ActivityDelete, post)
 	}
-	defer onPostsChanged(username.(string))
-	return c.NoContent(http.StatusOK)
+	if post.ContentUrl != "" {
+		if err := storage.Default().Delete(post.ContentUrl); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+	syncGitPost(post)
+	onPostsChanged(post.Username)
+	return nil
 }
+
 func HandlePostEdit(c echo.Context) error {
 	username := c.Get("username")
 	if username == "" {
@@ -220,7 +242,6 @@ func HandlePostEdit(c echo.Context) error {
 	}
 	var post model.Post
 	err = memento.Db().First(&post, "id=?", id).Error
-	post.IsPrivate = private
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return utils.RespondError(c, "post not exists")
@@ -231,34 +252,135 @@ func HandlePostEdit(c echo.Context) error {
 	if post.Username != username {
 		return utils.RespondError(c, "permission denied")
 	}
+	content := c.FormValue("content")
+	if content == "" {
+		return utils.RespondError(c, "empty content")
+	}
+	if err := editPost(&post, content, private); err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// writePostContent sets the content of a post without saving the post. New
+// posts are kept in the database or stored as files as configured. Posts in
+// the database stay there, and posts stored as files move into the database
+// in database mode, leaving behind the key of their file, which is returned
+// so it can be removed once the post is saved.
+func writePostContent(post *model.Post, content string) (string, error) {
+	config := memento.GetConfig().Storage
+	if config.PostContent == "database" || (post.ID != 0 && post.ContentUrl == "") {
+		data, encoding, err := utils.EncodeContent(content, config.CompressPosts)
+		if err != nil {
+			return "", err
+		}
+		oldKey := post.ContentUrl
+		post.Content, post.ContentEncoding, post.ContentUrl = data, encoding, ""
+		return oldKey, nil
+	}
+	if post.ContentUrl == "" {
+		now := time.Now()
+		contentFilename := utils.Md5string(fmt.Sprintf("%d%d", now.Unix(), rand.Int())) + ".md"
+		subDir := utils.Md5string(fmt.Sprintf("%s%d", now.Month().String(), now.Year()))
+		post.ContentUrl = storage.PostPrefix + subDir + "/" + contentFilename
+	}
+	post.Content, post.ContentEncoding = nil, ""
+	return "", storage.WriteFile(post.ContentUrl, []byte(content))
+}
+
+// removePostContentFile removes the file a post moved out of.
+func removePostContentFile(key string) {
+	if key == "" {
+		return
+	}
+	if err := storage.Default().Delete(key); err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
+// MigratePostContents moves the contents of the posts stored as files into
+// the database. Each post is read back and compared within the transaction
+// which moves it, and its file is removed afterwards unless keep is set.
+// Posts whose file cannot be read stay as they are. progress is called for
+// every post with the error of its migration.
+func MigratePostContents(compress bool, keep bool, progress func(post *model.Post, err error)) (int, error) {
+	var posts []model.Post
+	err := memento.Db().Select("id").Where("content_url <> ''").Order("id").Find(&posts).Error
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, p := range posts {
+		var post model.Post
+		if err := memento.Db().First(&post, p.ID).Error; err != nil {
+			return count, err
+		}
+		err := migratePostContent(&post, compress)
+		if err == nil {
+			count++
+			if !keep {
+				removePostContentFile(post.ContentUrl)
+			}
+		}
+		if progress != nil {
+			progress(&post, err)
+		}
+	}
+	return count, nil
+}
+
+func migratePostContent(post *model.Post, compress bool) error {
+	content, err := utils.PostContent(post)
+	if err != nil {
+		return err
+	}
+	data, encoding, err := utils.EncodeContent(content, compress)
+	if err != nil {
+		return err
+	}
+	return memento.Db().Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Post{}).Where("id = ? AND content_url = ?", post.ID, post.ContentUrl).
+			UpdateColumns(map[string]interface{}{"content": data, "content_encoding": encoding, "content_url": ""})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errors.New("the post was changed concurrently")
+		}
+		var stored model.Post
+		if err := tx.First(&stored, post.ID).Error; err != nil {
+			return err
+		}
+		migrated, err := utils.PostContent(&stored)
+		if err != nil {
+			return err
+		}
+		if migrated != content {
+			return errors.New("the content read back differs")
+		}
+		return nil
+	})
+}
+
+// editPost replaces the content and visibility of a post.
+func editPost(post *model.Post, content string, private bool) error {
+	content = unsignFileLinks(content)
+	wasPrivate := post.IsPrivate
+	post.IsPrivate = private
 	var oldTags1 []model.Tag
-	err = memento.Db().Model(&post).Association("Tags").Find(&oldTags1)
+	err := memento.Db().Model(post).Association("Tags").Find(&oldTags1)
 	oldTags := make([]string, len(oldTags1))
 	for i, t := range oldTags1 {
 		oldTags[i] = t.Name
 	}
 	if err != nil {
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown query error")
+		return errors.New("unknown query error")
 	}
-	content := c.FormValue("content")
-	if content == "" {
-		return utils.RespondError(c, "empty content")
-	}
-	contentFilepath := post.ContentUrl
-
-	file, err := os.OpenFile(contentFilepath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0777)
+	oldKey, err := writePostContent(post, content)
 	if err != nil {
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "os file open error")
-	}
-	defer func(file *os.File) {
-		_ = file.Close()
-	}(file)
-
-	if _, err = file.WriteString(content); err != nil {
-		log.Errorf(err.Error())
-		return utils.RespondError(c, "data copy error")
+		return errors.New("data copy error")
 	}
 	newTags := utils.GetTags(content)
 	tagsToAdd, tagsToDel := utils.CalcTagsDiff(oldTags, newTags)
@@ -275,33 +397,47 @@ func HandlePostEdit(c echo.Context) error {
 						continue
 					}
 				}
-				err = tx.Model(&tag).Association("Posts").Append(&post)
+				err = tx.Model(&tag).Association("Posts").Append(post)
 				if err != nil {
 					log.Errorf(err.Error())
 					return err
 				}
 			}
 			for _, tag := range tagsToDel {
-				err = tx.Model(&post).Association("Tags").Delete(&tag)
+				err = tx.Model(post).Association("Tags").Delete(&tag)
 				if err != nil {
 					log.Errorf(err.Error())
 					return err
 				}
 			}
 			post.EditedAt = time.Now()
-			err = tx.Save(&post).Error
+			err = tx.Save(post).Error
 			if err != nil {
 				log.Errorf(err.Error())
 				return err
 			}
+			if err = memento.LinkPostFiles(tx, post, content); err != nil {
+				log.Errorf(err.Error())
+				return err
+			}
 			return nil
 		})
 	if err != nil {
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown query error")
-	}
-	defer onPostsChanged(username.(string))
-	return c.NoContent(http.StatusOK)
+		return errors.New("unknown query error")
+	}
+	removePostContentFile(oldKey)
+	switch {
+	case !wasPrivate:
+		federatePost(activitypub.ActivityUpdate, post)
+	case !private:
+		// followers never got the private post
+		federatePost(activitypub.ActivityCreate, post)
+	}
+	sendWebmentions(post)
+	syncGitPost(post)
+	onPostsChanged(post.Username)
+	return nil
 }
 func HandleGetPost(c echo.Context) error {
 	id := c.QueryParam("id")
@@ -314,6 +450,9 @@ func HandleGetPost(c echo.Context) error {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "unknown query error")
 	}
+	if !canViewPost(c.Get("username").(string), &post) {
+		return utils.RespondError(c, "post not exists")
+	}
 	var user model.User
 	memento.Db().First(&user, "username=?", post.Username)
 	var likePosts []model.Post
@@ -332,6 +471,7 @@ func HandleGetPost(c echo.Context) error {
 	if err != nil {
 		return utils.RespondError(c, "os open file error")
 	}
+	signPostLinks(pv, &post, c.Get("username").(string))
 	return c.JSON(http.StatusOK, *pv)
 }
 
@@ -365,7 +505,7 @@ func HandleGetUserPosts(c echo.Context) error {
 			return utils.RespondError(c, "unknown query error")
 		}
 	} else {
-		err = memento.Db().
+		err = visiblePosts(memento.Db(), userself.(string)).
 			Where("username=? and is_private=?", username, false).
 			Order("created_at desc").Offset(page * memento.PageSize).
 			Limit(memento.PageSize).
@@ -374,8 +514,7 @@ func HandleGetUserPosts(c echo.Context) error {
 		if err != nil {
 			return utils.RespondError(c, "unknown query error")
 		}
-		err = memento.Db().
-			Model(&model.Post{}).
+		err = visiblePosts(memento.Db().Model(&model.Post{}), userself.(string)).
 			Where("username=? and is_private=?", username, false).
 			Count(&total).
 			Error
@@ -403,6 +542,7 @@ func HandleGetUserPosts(c echo.Context) error {
 			log.Errorf(err.Error())
 			continue
 		}
+		signPostLinks(pv, &post, c.Get("username").(string))
 		result = append(result, *pv)
 	}
 	return c.JSON(http.StatusOK, echo.Map{
@@ -435,44 +575,35 @@ func HandlePostLike(c echo.Context) error {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "unknown query error")
 	}
-	var likedPost []model.Post
-	err = memento.Db().
-		Model(&user).
-		Association("Likes").
-		Find(&likedPost, "id=?", post.ID)
-	if err != nil {
-		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown query error")
+	if err := likePost(&user, &post); err != nil {
+		return utils.RespondError(c, err.Error())
 	}
-	if len(likedPost) > 0 {
-		log.Infof("liked post: %d", len(likedPost))
-		return utils.RespondError(c, "already liked")
+	return c.NoContent(http.StatusOK)
+}
+
+// likePost adds the post to the likes of the user and updates the like
+// counters of the post and its author.
+func likePost(user *model.User, post *model.Post) error {
+	if !canViewPost(user.Username, post) {
+		return errors.New("post not exists")
 	}
-	var author model.User
-	err = memento.Db().First(&author, "username=?", post.Username).Error
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return utils.RespondError(c, "post not exists")
-		}
-		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown query error")
+	if isPostLiked(user, post.ID) {
+		return errors.New("already liked")
 	}
-	err = memento.Db().Transaction(
+	err := memento.Db().Transaction(
 		func(tx *gorm.DB) error {
-			err := tx.Model(&user).Association("Likes").Append(&post)
+			err := tx.Model(user).Association("Likes").Append(post)
 			if err != nil {
 				return err
 			}
-			post.TotalLiked += 1
-			author.TotalLiked += 1
-			tx.Save(&post)
-			tx.Save(&user)
-			return nil
+			return addLikeCount(tx, post, 1)
 		})
 	if err != nil {
-		return utils.RespondError(c, "unknown query error")
+		log.Errorf(err.Error())
+		return errors.New("unknown query error")
 	}
-	return c.NoContent(http.StatusOK)
+	post.TotalLiked += 1
+	return nil
 }
 
 func HandlePostCancelLike(c echo.Context) error {
@@ -499,40 +630,46 @@ func HandlePostCancelLike(c echo.Context) error {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "unknown query error")
 	}
-	var likedPost model.Post
-	err = memento.Db().Model(&user).Association("Likes").Find(&likedPost, "id=?", post.ID)
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return utils.RespondError(c, "not liked yet")
-		}
-		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown query error")
+	if err := unlikePost(&user, &post); err != nil {
+		return utils.RespondError(c, err.Error())
 	}
-	var author model.User
-	err = memento.Db().First(&author, "username=?", post.Username).Error
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return utils.RespondError(c, "post not exists")
-		}
-		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown query error")
+	return c.NoContent(http.StatusOK)
+}
+
+func unlikePost(user *model.User, post *model.Post) error {
+	if !isPostLiked(user, post.ID) {
+		return errors.New("not liked yet")
 	}
-	err = memento.Db().Transaction(
+	err := memento.Db().Transaction(
 		func(tx *gorm.DB) error {
-			err := tx.Model(&user).Association("Likes").Delete(&post)
+			err := tx.Model(user).Association("Likes").Delete(post)
 			if err != nil {
 				return err
 			}
-			post.TotalLiked -= 1
-			author.TotalLiked -= 1
-			tx.Save(&post)
-			tx.Save(&user)
-			return nil
+			return addLikeCount(tx, post, -1)
 		})
 	if err != nil {
-		return utils.RespondError(c, "unknown query error")
+		log.Errorf(err.Error())
+		return errors.New("unknown query error")
 	}
-	return c.NoContent(http.StatusOK)
+	post.TotalLiked -= 1
+	return nil
+}
+
+func isPostLiked(user *model.User, postID uint) bool {
+	if user == nil || user.ID == 0 {
+		return false
+	}
+	var likePosts []model.Post
+	err := memento.Db().
+		Model(user).
+		Association("Likes").
+		Find(&likePosts, "id=?", postID)
+	if err != nil {
+		log.Errorf(err.Error())
+		return false
+	}
+	return len(likePosts) > 0
 }
 
 func HandleGetTaggedPost(c echo.Context) error {
@@ -562,7 +699,9 @@ func HandleGetTaggedPost(c echo.Context) error {
 		return utils.RespondError(c, "unknown query error")
 	}
 	posts := make([]model.Post, 0, memento.PageSize)
-	err = memento.Db().Model(&tag).Order("created_at desc").
+	err = feedPosts(memento.Db(), username).
+		Model(&tag).
+		Order("created_at desc").
 		Offset(page*memento.PageSize).
 		Limit(memento.PageSize).
 		Association("Posts").
@@ -570,7 +709,11 @@ func HandleGetTaggedPost(c echo.Context) error {
 	if err != nil {
 		return utils.RespondError(c, "unknown query error")
 	}
-	total := memento.Db().Model(&tag).Association("Posts").Count()
+	total := feedPosts(memento.Db(), username).
+		Model(&tag).
+		Where("is_private=? or username=?", false, username).
+		Association("Posts").
+		Count()
 	result := make([]model.PostViewModel, 0, memento.PageSize)
 	for _, p := range posts {
 		var user model.User
@@ -592,6 +735,7 @@ func HandleGetTaggedPost(c echo.Context) error {
 			log.Errorf(err.Error())
 			continue
 		}
+		signPostLinks(pv, &p, c.Get("username").(string))
 		result = append(result, *pv)
 	}
 	return c.JSON(http.StatusOK, echo.Map{
@@ -614,7 +758,7 @@ func HandleGetAllPosts(c echo.Context) error {
 		return utils.RespondError(c, "invalid page")
 	}
 	posts := make([]model.Post, 0, memento.PageSize)
-	err = memento.Db().
+	err = feedPosts(memento.Db(), username.(string)).
 		Order("created_at desc").
 		Offset(page*memento.PageSize).
 		Limit(memento.PageSize).
@@ -624,7 +768,10 @@ func HandleGetAllPosts(c echo.Context) error {
 		return utils.RespondError(c, "unknown query error")
 	}
 	var total int64
-	err = memento.Db().Model(&model.Post{}).Where("is_private=?", false).Count(&total).Error
+	err = feedPosts(memento.Db().Model(&model.Post{}), username.(string)).
+		Where("is_private=?", false).
+		Count(&total).
+		Error
 	if err != nil {
 		return utils.RespondError(c, "unknown query error")
 	}
@@ -652,6 +799,7 @@ func HandleGetAllPosts(c echo.Context) error {
 			log.Errorf(err.Error())
 			continue
 		}
+		signPostLinks(pv, &p, c.Get("username").(string))
 		result = append(result, *pv)
 	}
 	return c.JSON(http.StatusOK, echo.Map{
@@ -680,7 +828,8 @@ func HandleGetLikedPosts(c echo.Context) error {
 		return utils.RespondError(c, "username not exists")
 	}
 	posts := make([]model.Post, 0, memento.PageSize)
-	err = memento.Db().Model(&user).
+	err = feedPosts(memento.Db(), currentUserName.(string)).
+		Model(&user).
 		Order("created_at desc").
 		Offset(page*memento.PageSize).
 		Limit(memento.PageSize).
@@ -690,7 +839,7 @@ func HandleGetLikedPosts(c echo.Context) error {
 		return utils.RespondError(c, "unknown query error")
 	}
 	var totalLikes int64
-	err = memento.Db().Model(&model.Post{}).
+	err = feedPosts(memento.Db().Model(&model.Post{}), currentUserName.(string)).
 		Joins("JOIN user_liked_posts ON user_liked_posts.post_id = posts.id").
 		Where("posts.is_private = ? OR posts.username = ?", false, currentUserName).
 		Count(&totalLikes).Error
@@ -721,6 +870,7 @@ func HandleGetLikedPosts(c echo.Context) error {
 			log.Errorf(err.Error())
 			continue
 		}
+		signPostLinks(pv, &p, c.Get("username").(string))
 		result = append(result, *pv)
 	}
 	return c.JSON(http.StatusOK, echo.Map{
@@ -795,19 +945,20 @@ func HandleGetFollowingPosts(c echo.Context) error {
 	}
 	// Get the posts of followed users
 	var posts []model.Post
-	err = memento.Db().
+	err = feedPosts(memento.Db(), username).
 		Limit(memento.PageSize).
 		Offset(memento.PageSize*page).
 		Where("username IN ?", followedUsernames).
+		Where("posts.is_private = ? OR posts.username = ?", false, username).
 		Find(&posts).
 		Error
 	if err != nil {
 		return utils.RespondError(c, "unknown query error")
 	}
 	var total int64
-	err = memento.Db().
-		Model(&model.Post{}).
+	err = feedPosts(memento.Db().Model(&model.Post{}), username).
 		Where("username IN ?", followedUsernames).
+		Where("posts.is_private = ? OR posts.username = ?", false, username).
 		Count(&total).
 		Error
 	if err != nil {
@@ -834,6 +985,7 @@ func HandleGetFollowingPosts(c echo.Context) error {
 			log.Errorf(err.Error())
 			continue
 		}
+		signPostLinks(pv, &p, c.Get("username").(string))
 		result = append(result, *pv)
 	}
 	return c.JSON(http.StatusOK, echo.Map{
@@ -842,10 +994,39 @@ func HandleGetFollowingPosts(c echo.Context) error {
 	})
 }
 
-func onPostsChanged(username string) {
-	GenerateSiteMap()
-	_, err := cacheRss(username)
+func HandlePostHide(c echo.Context) error {
+	return setPostHidden(c, true)
+}
+
+func HandlePostUnhide(c echo.Context) error {
+	return setPostHidden(c, false)
+}
+
+func setPostHidden(c echo.Context, hidden bool) error {
+	postId := c.FormValue("id")
+	var post model.Post
+	err := memento.Db().First(&post, "id=?", postId).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.RespondError(c, "post not exists")
+		}
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	err = memento.Db().Model(&post).Update("is_hidden", hidden).Error
 	if err != nil {
-		log.Errorf("Error caching RSS: %s\n", err.Error())
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown update error")
 	}
+	action := model.AuditPostUnhide
+	if hidden {
+		action = model.AuditPostHide
+	}
+	recordAudit(c, action, "post:"+strconv.Itoa(int(post.ID)), nil, nil)
+	defer onPostsChanged(post.Username)
+	return c.NoContent(http.StatusOK)
+}
+
+func onPostsChanged(username string) {
+	GenerateSiteMap()
 }
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"Memento/memento/model"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestFollowingPostsHidePrivatePosts(t *testing.T) {
+	author := newUser(t, "author")
+	follower := newUser(t, "follower")
+	follow(t, follower, author)
+	public := newPost(t, author, "public note", false)
+	newPost(t, author, "private note", true)
+	rec := call(t, HandleGetFollowingPosts, http.MethodGet, "/api/post/following?page=0", nil, follower.Username)
+	expectStatus(t, rec, http.StatusOK)
+	var result struct {
+		Posts []model.PostViewModel `json:"posts"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Posts) != 1 || result.Posts[0].PostID != public.ID {
+		t.Errorf("the follower got %+v, want only post %d", result.Posts, public.ID)
+	}
+}
@@ -25,22 +25,8 @@ func HandlePublicArticle(c echo.Context) error {
 	if err != nil {
 		return c.JSON(400, "Invalid id")
 	}
-	if htmlTemplate == "" {
-		file, err := os.Open("assets/public_article.html")
-		if err != nil {
-			return c.JSON(500, "Error reading template")
-		}
-		defer func(file *os.File) {
-			err := file.Close()
-			if err != nil {
-				log.Errorf("Error closing file: %s\n", err.Error())
-			}
-		}(file)
-		data, err := io.ReadAll(file)
-		if err != nil {
-			return c.JSON(500, "Error reading template")
-		}
-		htmlTemplate = string(data)
+	if err := loadArticleTemplate(); err != nil {
+		return c.JSON(500, "Error reading template")
 	}
 	html, err := renderArticle(id)
 	if err != nil {
@@ -49,70 +35,129 @@ func HandlePublicArticle(c echo.Context) error {
 	return c.HTMLBlob(200, []byte(html))
 }
 
-func renderArticle(id int) (string, error) {
-	siteName := memento.GetConfig().SiteName
-	description := memento.GetConfig().Description
-	title := siteName
-	url := scheme + "://" + domain + "/public/article/" + strconv.Itoa(id)
-	preview := "/icons/icon-192-maskable.png"
-	article := ""
-	scripts := ""
-	icon := "/favicon.png"
+// loadArticleTemplate reads the template of public articles once.
+func loadArticleTemplate() error {
+	if htmlTemplate != "" {
+		return nil
+	}
+	file, err := os.Open("assets/public_article.html")
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %s\n", err.Error())
+		}
+	}(file)
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	htmlTemplate = string(data)
+	return nil
+}
 
+// articlePage holds the values filled into the template of public articles.
+type articlePage struct {
+	Title       string
+	Description string
+	Url         string
+	Preview     string
+	Icon        string
+	Content     string
+	Avatar      string
+	Nickname    string
+	Username    string
+	// UserUrl and HomeUrl are the targets of the links in the page header.
+	UserUrl string
+	HomeUrl string
+	// Head is added to the end of the head element.
+	Head string
+}
+
+// newArticlePage fills the title, description and scripts of a page showing
+// the markdown content rendered as contentHtml.
+func newArticlePage(content string, contentHtml string) *articlePage {
+	page := &articlePage{Content: contentHtml}
+	plain := html2text.HTML2Text(contentHtml)
+	if len([]rune(plain)) > 100 {
+		plain = string([]rune(plain)[:100])
+	}
+	description := strings.ReplaceAll(plain, "\n", " ")
+	description = strings.ReplaceAll(description, "\r", " ")
+	regex := regexp.MustCompile(`\s+`)
+	page.Description = regex.ReplaceAllString(description, " ")
+	page.Title = findTitleInMd(content)
+	if page.Title == "" {
+		page.Title = memento.GetConfig().SiteName
+	}
+	return page
+}
+
+func (page *articlePage) render() string {
+	scripts := ""
+	if strings.Contains(page.Content, "<span class=\"math inline\">") {
+		scripts += "<script id=\"MathJax-script\" async src=\"https://cdn.jsdelivr.net/npm/mathjax@3/es5/tex-mml-chtml.js\"></script>\n"
+	}
 	html := htmlTemplate
+	html = strings.ReplaceAll(html, "{{Title}}", page.Title)
+	html = strings.ReplaceAll(html, "{{Description}}", page.Description)
+	html = strings.ReplaceAll(html, "{{SiteName}}", memento.GetConfig().SiteName)
+	html = strings.ReplaceAll(html, "{{Url}}", page.Url)
+	html = strings.ReplaceAll(html, "{{Preview}}", page.Preview)
+	html = strings.ReplaceAll(html, "{{Icon}}", page.Icon)
+	html = strings.Replace(html, "{{Content}}", page.Content, 1)
+	html = strings.Replace(html, "{{Avatar}}", page.Avatar, 1)
+	html = strings.Replace(html, "{{Nickname}}", page.Nickname, 1)
+	html = strings.Replace(html, "{{Username}}", page.Username, 1)
+	html = strings.Replace(html, "{{UserUrl}}", page.UserUrl, 1)
+	html = strings.Replace(html, "{{HomeUrl}}", page.HomeUrl, 1)
+	html = strings.Replace(html, "<!-- Scripts -->", scripts, 1)
+	if page.Head != "" {
+		html = strings.Replace(html, "</head>", page.Head+"</head>", 1)
+	}
+	return html
+}
 
+func renderArticle(id int) (string, error) {
+	icon := "/favicon.png"
 	if memento.GetConfig().IconVersion > 0 {
 		icon = icon + "?v=" + strconv.Itoa(int(memento.GetConfig().IconVersion))
 	}
 	var post model.Post
 	err := memento.Db().Model(&post).Where("id = ?", id).First(&post).Error
-	if err != nil || post.IsPrivate {
+	if err != nil {
 		return "", err
 	}
-	if post.IsPrivate {
+	if !canViewPost("", &post) {
 		return "", fmt.Errorf("post is private")
 	}
 	postView, err := utils.PostToView(&post, &model.UserViewModel{}, false)
+	if err != nil {
+		return "", err
+	}
 	var author model.User
 	err = memento.Db().Model(&author).Where("username = ?", post.Username).First(&author).Error
 	if err != nil {
 		return "", err
 	}
 	authorView := utils.UserToView(&author, false)
-	contentHtml := string(mdToHTML([]byte(renderTags(postView.Content))))
-	article = contentHtml
-	plain := html2text.HTML2Text(contentHtml)
-	if len([]rune(plain)) > 100 {
-		plain = string([]rune(plain)[:100])
-	}
-	description = plain
-	title = findTitleInMd(postView.Content)
-	preview = "/api/user/avatar/" + authorView.Avatar
-	if title == "" {
-		title = siteName
-	}
-	if strings.Contains(article, "<span class=\"math inline\">") {
-		scripts += "<script id=\"MathJax-script\" async src=\"https://cdn.jsdelivr.net/npm/mathjax@3/es5/tex-mml-chtml.js\"></script>\n"
-	}
-
-	description = strings.ReplaceAll(description, "\n", " ")
-	description = strings.ReplaceAll(description, "\r", " ")
-	regex := regexp.MustCompile(`\s+`)
-	description = regex.ReplaceAllString(description, " ")
-	preview = scheme + "://" + domain + preview
-
-	html = strings.ReplaceAll(html, "{{Title}}", title)
-	html = strings.ReplaceAll(html, "{{Description}}", description)
-	html = strings.ReplaceAll(html, "{{SiteName}}", siteName)
-	html = strings.ReplaceAll(html, "{{Url}}", url)
-	html = strings.ReplaceAll(html, "{{Preview}}", preview)
-	html = strings.ReplaceAll(html, "{{Icon}}", icon)
-	html = strings.Replace(html, "{{Content}}", article, 1)
-	html = strings.Replace(html, "{{Avatar}}", preview, 1)
-	html = strings.Replace(html, "{{Nickname}}", authorView.Nickname, 1)
-	html = strings.Replace(html, "{{Username}}", authorView.Username, 1)
-	html = strings.Replace(html, "<!-- Scripts -->", scripts, 1)
-	return html, nil
+	base := scheme + "://" + domain
+	page := newArticlePage(postView.Content, string(mdToHTML([]byte(renderTags(postView.Content)))))
+	page.Url = base + "/public/article/" + strconv.Itoa(id)
+	page.Preview = base + "/api/user/avatar/" + authorView.Avatar
+	page.Icon = icon
+	page.Avatar = page.Preview
+	page.Nickname = authorView.Nickname
+	page.Username = authorView.Username
+	page.UserUrl = "/user/" + authorView.Username
+	page.HomeUrl = "/"
+	// feed and IndieWeb discovery
+	page.Head = "<link rel=\"alternate\" type=\"application/atom+xml\" href=\"" + base + "/feed/atom/user/" + authorView.Username + "\">\n" +
+		"<link rel=\"webmention\" href=\"" + base + "/webmention\">\n" +
+		"<link rel=\"micropub\" href=\"" + base + "/micropub\">\n"
+	return page.render(), nil
 }
 
 func renderTags(md string) string {
@@ -6,6 +6,7 @@ import (
 	"Memento/memento/utils"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
 	"net/http"
 	"strconv"
 	"strings"
@@ -23,8 +24,12 @@ func HandleUserSearch(c echo.Context) error {
 	}
 	var users []model.User
 	var total int64
+	db := memento.Db()
+	if blockedBy := blockedUsernames(c.Get("username").(string)); len(blockedBy) > 0 {
+		db = db.Where("username NOT IN ?", blockedBy)
+	}
 	if strings.HasPrefix(keyword, "@") {
-		err = memento.Db().
+		err = db.Session(&gorm.Session{}).
 			Limit(memento.PageSize).
 			Offset(page*memento.PageSize).
 			Where("username LIKE ?", keyword[1:]+"%").
@@ -33,7 +38,7 @@ func HandleUserSearch(c echo.Context) error {
 		if err != nil {
 			return utils.RespondInternalError(c, "search failed")
 		}
-		err = memento.Db().
+		err = db.Session(&gorm.Session{}).
 			Model(&model.User{}).
 			Where("username LIKE ?", "%"+keyword[1:]+"%").
 			Count(&total).
@@ -42,7 +47,7 @@ func HandleUserSearch(c echo.Context) error {
 			return utils.RespondInternalError(c, "search failed")
 		}
 	} else {
-		err = memento.Db().
+		err = db.Session(&gorm.Session{}).
 			Limit(memento.PageSize).
 			Offset(page*memento.PageSize).
 			Where("username LIKE ? OR nickname LIKE ? OR bio LIKE ?", "%"+keyword+"%", "%"+keyword+"%", "%"+keyword+"%").
@@ -51,7 +56,7 @@ func HandleUserSearch(c echo.Context) error {
 		if err != nil {
 			return utils.RespondInternalError(c, "search failed")
 		}
-		err = memento.Db().
+		err = db.Session(&gorm.Session{}).
 			Model(&model.User{}).
 			Where("username LIKE ? OR nickname LIKE ? OR bio LIKE ?", "%"+keyword+"%", "%"+keyword+"%", "%"+keyword+"%").
 			Count(&total).
@@ -81,36 +86,18 @@ func HandlePostSearch(c echo.Context) error {
 	if keywords == "" {
 		return utils.RespondError(c, "invalid keyword")
 	}
-	keywordsList := strings.Split(keywords, " ")
-	var posts []model.Post
-	isFirst := true
-	for _, keyword := range keywordsList {
-		if keyword == "" {
-			continue
-		}
-		keyword = strings.TrimSpace(keyword)
-		result, err := doSearch(keyword)
-		log.Infof("search result: %d", len(result))
-		if err != nil {
-			log.Errorf("search failed: %v", err)
-			return utils.RespondInternalError(c, "search failed")
-		}
-		if isFirst {
-			isFirst = false
-			posts = result
-		} else {
-			newResult := make([]model.Post, 0, len(posts))
-			for _, post := range posts {
-				for _, newPost := range result {
-					if post.ID == newPost.ID {
-						newResult = append(newResult, post)
-						break
-					}
-				}
-			}
-			posts = newResult
+	posts, err := searchPosts(keywords)
+	if err != nil {
+		log.Errorf("search failed: %v", err)
+		return utils.RespondInternalError(c, "search failed")
+	}
+	visible := make([]model.Post, 0, len(posts))
+	for _, post := range posts {
+		if canViewPost(username.(string), &post) && !isMuted(username.(string), post.Username) {
+			visible = append(visible, post)
 		}
 	}
+	posts = visible
 	result := make([]model.PostViewModel, 0, memento.PageSize)
 	for index, post := range posts {
 		if index < page*memento.PageSize {
@@ -160,6 +147,7 @@ func HandlePostSearch(c echo.Context) error {
 			log.Errorf("search failed: %v", err)
 			return utils.RespondInternalError(c, "search failed")
 		}
+		signPostLinks(postView, &post, c.Get("username").(string))
 		result = append(result, *postView)
 	}
 	return c.JSON(http.StatusOK, echo.Map{
@@ -168,6 +156,40 @@ func HandlePostSearch(c echo.Context) error {
 	})
 }
 
+// searchPosts returns the posts matching all space separated keywords.
+func searchPosts(keywords string) ([]model.Post, error) {
+	keywordsList := strings.Split(keywords, " ")
+	var posts []model.Post
+	isFirst := true
+	for _, keyword := range keywordsList {
+		if keyword == "" {
+			continue
+		}
+		keyword = strings.TrimSpace(keyword)
+		result, err := doSearch(keyword)
+		log.Infof("search result: %d", len(result))
+		if err != nil {
+			return nil, err
+		}
+		if isFirst {
+			isFirst = false
+			posts = result
+		} else {
+			newResult := make([]model.Post, 0, len(posts))
+			for _, post := range posts {
+				for _, newPost := range result {
+					if post.ID == newPost.ID {
+						newResult = append(newResult, post)
+						break
+					}
+				}
+			}
+			posts = newResult
+		}
+	}
+	return posts, nil
+}
+
 func doSearch(keyword string) ([]model.Post, error) {
 	if strings.HasPrefix(keyword, "#") {
 		// search tag
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/query"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TestMain runs the tests against a server in a temporary home folder.
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "memento-test-")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	_ = os.Setenv("HOME", home)
+	if err := memento.Init(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	query.SetDefault(memento.Db())
+	code := m.Run()
+	_ = os.RemoveAll(home)
+	os.Exit(code)
+}
+
+var users atomic.Int64
+
+// newUser creates a member. Usernames are made unique, so the tests do not
+// share users.
+func newUser(t *testing.T, name string) *model.User {
+	t.Helper()
+	user := &model.User{
+		Username:     fmt.Sprintf("%s%d", name, users.Add(1)),
+		Nickname:     name,
+		Role:         model.RoleMember,
+		RegisteredAt: time.Now(),
+	}
+	if err := memento.Db().Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// newPost creates a post of the user.
+func newPost(t *testing.T, user *model.User, content string, private bool) *model.Post {
+	t.Helper()
+	post, err := createPost(user, content, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return post
+}
+
+// follow makes the follower follow the user.
+func follow(t *testing.T, follower *model.User, user *model.User) {
+	t.Helper()
+	if err := addFollow(memento.Db(), follower, user); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// call runs a handler for a request of the user, or of a visitor for an
+// empty username. The form is sent as the body, and params are the names
+// and values of the path parameters.
+func call(t *testing.T, handler echo.HandlerFunc, method string, target string, form url.Values, username string, params ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	if form != nil {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set("username", username)
+	var names, values []string
+	for i := 0; i+1 < len(params); i += 2 {
+		names, values = append(names, params[i]), append(values, params[i+1])
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+	if err := handler(c); err != nil {
+		var he *echo.HTTPError
+		if !errors.As(err, &he) {
+			t.Fatalf("%s %s: %v", method, target, err)
+		}
+		rec.Code = he.Code
+	}
+	return rec
+}
+
+// expectStatus fails the test unless the response has the status.
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status %d (%s), want %d", rec.Code, strings.TrimSpace(rec.Body.String()), status)
+	}
+}
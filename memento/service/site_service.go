@@ -0,0 +1,630 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/feed"
+	"Memento/memento/model"
+	"Memento/memento/storage"
+	"Memento/memento/utils"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+var siteTagLinkPattern = regexp.MustCompile(`href="/tag/([^"]*)"`)
+
+// SiteOptions configures the export of public memos as a static website.
+type SiteOptions struct {
+	// Username limits the site to the memos of a user. Otherwise all public
+	// memos of the instance are exported.
+	Username string
+	// Url is the address the site is published at. Feeds and the sitemap use
+	// it for absolute links, without it their links are relative.
+	Url string
+	// Output is the directory the site is written to, or the zip archive
+	// when Zip is set.
+	Output string
+	Zip    bool
+}
+
+type sitePost struct {
+	post model.Post
+	// content is the markdown of the post and html its rendering, whose links
+	// point at the instance until localize makes them relative.
+	content string
+	html    string
+	tags    []string
+}
+
+// siteOutput writes the files of a static site.
+type siteOutput interface {
+	write(name string, data []byte) error
+	copy(name string, src io.Reader) error
+	close() error
+}
+
+type siteDirectory string
+
+func (d siteDirectory) write(name string, data []byte) error {
+	p := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0644)
+}
+
+func (d siteDirectory) copy(name string, src io.Reader) error {
+	p := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return err
+	}
+	out, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (d siteDirectory) close() error {
+	return nil
+}
+
+type siteArchive struct {
+	file    *os.File
+	archive *zip.Writer
+}
+
+func (a *siteArchive) write(name string, data []byte) error {
+	return exportWrite(a.archive, name, data, time.Now())
+}
+
+func (a *siteArchive) copy(name string, src io.Reader) error {
+	return exportCopy(a.archive, name, src, time.Now())
+}
+
+func (a *siteArchive) close() error {
+	if err := a.archive.Close(); err != nil {
+		_ = a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+type staticSite struct {
+	options SiteOptions
+	out     siteOutput
+	// base is the address of the instance, empty when it is unknown.
+	base  string
+	posts []*sitePost
+	// tags maps the tag names to their folders and files the ids of the
+	// copied files to their paths.
+	tags    map[string]string
+	tagged  map[string][]*sitePost
+	files   map[string]string
+	postIDs map[string]bool
+	authors map[string]*model.User
+	avatars map[string]string
+	icon    string
+	pages   []string
+}
+
+// ExportStaticSite writes the public memos of a user or of the whole instance
+// as a static website with index, tag and post pages, feeds and a sitemap.
+// All links between the pages are relative, so the site can be hosted
+// anywhere.
+func ExportStaticSite(options SiteOptions) error {
+	if err := loadArticleTemplate(); err != nil {
+		return err
+	}
+	options.Url = strings.TrimSuffix(options.Url, "/")
+	if options.Url != "" {
+		options.Url += "/"
+	}
+	s := &staticSite{
+		options: options,
+		tags:    make(map[string]string),
+		tagged:  make(map[string][]*sitePost),
+		files:   make(map[string]string),
+		postIDs: make(map[string]bool),
+		authors: make(map[string]*model.User),
+		avatars: make(map[string]string),
+	}
+	if memento.GetConfig().PublicUrl != "" || domain != "" {
+		s.base = federationBase()
+	}
+	db := visiblePosts(memento.Db(), "").Where("is_private = ?", false)
+	if options.Username != "" {
+		var user model.User
+		if err := memento.Db().First(&user, "username = ?", options.Username).Error; err != nil {
+			return errors.New("username not exists")
+		}
+		db = db.Where("username = ?", options.Username)
+	}
+	var posts []model.Post
+	if err := db.Order("created_at desc").Find(&posts).Error; err != nil {
+		return err
+	}
+	if options.Zip {
+		if err := os.MkdirAll(filepath.Dir(options.Output), 0777); err != nil {
+			return err
+		}
+		file, err := os.Create(options.Output)
+		if err != nil {
+			return err
+		}
+		s.out = &siteArchive{file: file, archive: zip.NewWriter(file)}
+	} else {
+		s.out = siteDirectory(options.Output)
+	}
+	err := s.build(posts)
+	if closeErr := s.out.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil && options.Zip {
+		_ = os.Remove(options.Output)
+	}
+	return err
+}
+
+func (s *staticSite) build(posts []model.Post) error {
+	for _, post := range posts {
+		view, err := utils.PostToView(&post, &model.UserViewModel{}, false)
+		if err != nil {
+			log.Errorf("Error exporting post %d: %s\n", post.ID, err.Error())
+			continue
+		}
+		p := &sitePost{
+			post:    post,
+			content: view.Content,
+			html:    string(mdToHTML([]byte(renderTags(view.Content)))),
+		}
+		for _, tag := range utils.GetTags(view.Content) {
+			name := strings.TrimPrefix(tag, "#")
+			if _, ok := s.tags[name]; !ok {
+				s.tags[name] = exportName(name)
+			}
+			if !utils.Contains(p.tags, name) {
+				p.tags = append(p.tags, name)
+				s.tagged[name] = append(s.tagged[name], p)
+			}
+		}
+		s.posts = append(s.posts, p)
+		s.postIDs[strconv.Itoa(int(post.ID))] = true
+		if err := s.copyAuthor(post.Username); err != nil {
+			return err
+		}
+		for _, f := range linkedFiles(view.Content) {
+			id := strconv.Itoa(int(f.ID))
+			if _, ok := s.files[id]; ok {
+				continue
+			}
+			name := fmt.Sprintf("files/%d-%s", f.ID, exportName(f.Filename))
+			if err := s.copyFile(name, f.ContentUrl, false); err != nil {
+				log.Errorf("Error exporting file %d: %s\n", f.ID, err.Error())
+				continue
+			}
+			s.files[id] = name
+		}
+	}
+	s.copyIcon()
+
+	config := memento.GetConfig()
+	if err := s.writeList("", config.SiteName, config.Description, nil, s.posts); err != nil {
+		return err
+	}
+	for _, p := range s.posts {
+		if err := s.writePost(p); err != nil {
+			return err
+		}
+	}
+	usernames := make([]string, 0, len(s.authors))
+	for username := range s.authors {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	for _, username := range usernames {
+		var posts []*sitePost
+		for _, p := range s.posts {
+			if p.post.Username == username {
+				posts = append(posts, p)
+			}
+		}
+		author := s.authors[username]
+		if err := s.writeList(userDir(username), author.Nickname, author.Bio, author, posts); err != nil {
+			return err
+		}
+	}
+	names := make([]string, 0, len(s.tags))
+	for name := range s.tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dir := "tag/" + s.tags[name] + "/"
+		if err := s.writeList(dir, "#"+name, "Memos tagged #"+name, nil, s.tagged[name]); err != nil {
+			return err
+		}
+	}
+	return s.writeSiteMap()
+}
+
+// copyAuthor adds the author of a post and copies the avatar.
+func (s *staticSite) copyAuthor(username string) error {
+	if _, ok := s.authors[username]; ok {
+		return nil
+	}
+	var user model.User
+	if err := memento.Db().First(&user, "username = ?", username).Error; err != nil {
+		return err
+	}
+	s.authors[username] = &user
+	avatar := utils.UserToView(&user, false).Avatar
+	src, local := user.AvatarUrl, false
+	if user.AvatarUrl == "" {
+		src, local = filepath.Join("frontend", "build", "web", "assets", "assets", "user.png"), true
+	}
+	name := "avatars/" + exportName(avatar)
+	if err := s.copyFile(name, src, local); err != nil {
+		log.Errorf("Error exporting avatar of %s: %s\n", username, err.Error())
+		return nil
+	}
+	s.avatars[username] = name
+	return nil
+}
+
+func (s *staticSite) copyIcon() {
+	src := filepath.Join("frontend", "build", "web", "favicon.png")
+	if memento.GetConfig().IconVersion > 0 {
+		src = filepath.Join(memento.GetBasePath(), "icons", "favicon.png")
+	}
+	if err := s.copyFile("favicon.png", src, true); err != nil {
+		log.Errorf("Error exporting icon: %s\n", err.Error())
+		return
+	}
+	s.icon = "favicon.png"
+}
+
+// copyFile copies a stored blob, or a file of the server when local is set,
+// into the site.
+func (s *staticSite) copyFile(name string, src string, local bool) error {
+	var r io.ReadCloser
+	var err error
+	if local {
+		r, err = os.Open(src)
+	} else {
+		r, err = storage.Default().Get(src)
+	}
+	if err != nil {
+		return err
+	}
+	defer func(r io.ReadCloser) {
+		_ = r.Close()
+	}(r)
+	return s.out.copy(name, r)
+}
+
+// relative returns the prefix leading from the file to the root of the site.
+func relative(name string) string {
+	return strings.Repeat("../", strings.Count(name, "/"))
+}
+
+// absolute returns the prefix of links in feeds and the sitemap, which are
+// relative to the file when the address of the site is unknown.
+func (s *staticSite) absolute(name string) string {
+	if s.options.Url != "" {
+		return s.options.Url
+	}
+	return relative(name)
+}
+
+// localize points the links of rendered content at the pages and files of
+// the site, given the prefix leading to its root.
+func (s *staticSite) localize(contentHtml string, prefix string) string {
+	contentHtml = siteTagLinkPattern.ReplaceAllStringFunc(contentHtml, func(link string) string {
+		name, err := url.PathUnescape(siteTagLinkPattern.FindStringSubmatch(link)[1])
+		if dir, ok := s.tags[name]; ok && err == nil {
+			return `href="` + prefix + "tag/" + url.PathEscape(dir) + `/index.html"`
+		}
+		return `href="` + prefix + `index.html"`
+	})
+	contentHtml = exportFileLinkPattern.ReplaceAllStringFunc(contentHtml, func(link string) string {
+		m := exportFileLinkPattern.FindStringSubmatch(link)
+		if name, ok := s.files[m[2]]; ok && (m[1] == "" || m[1] == s.base) {
+			return prefix + sitePath(name)
+		}
+		return link
+	})
+	return exportPostLinkPattern.ReplaceAllStringFunc(contentHtml, func(link string) string {
+		m := exportPostLinkPattern.FindStringSubmatch(link)
+		if s.postIDs[m[2]] && (m[1] == "" || m[1] == s.base) {
+			return prefix + "post/" + m[2] + ".html"
+		}
+		return link
+	})
+}
+
+// sitePath escapes the segments of a path in the site for use in links.
+func sitePath(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func userDir(username string) string {
+	return "user/" + exportName(username) + "/"
+}
+
+func (s *staticSite) userPath(username string) string {
+	return sitePath(userDir(username)) + "index.html"
+}
+
+// page fills the template of public articles for the file name of the site.
+// The header shows the author, or the site when author is nil.
+func (s *staticSite) page(name string, page *articlePage, author *model.User, feedDir string) []byte {
+	prefix := relative(name)
+	if s.options.Url != "" {
+		page.Url = s.options.Url + sitePath(name)
+	}
+	if s.icon != "" {
+		page.Icon = prefix + s.icon
+	}
+	page.HomeUrl = prefix + "index.html"
+	if s.base != "" {
+		page.HomeUrl = s.base + "/"
+	}
+	if author != nil {
+		page.Avatar = prefix + sitePath(s.avatars[author.Username])
+		page.Nickname = author.Nickname
+		page.Username = author.Username
+		page.UserUrl = prefix + s.userPath(author.Username)
+	} else {
+		page.Avatar = page.Icon
+		page.Nickname = memento.GetConfig().SiteName
+		page.UserUrl = prefix + "index.html"
+	}
+	page.Preview = page.Avatar
+	if s.options.Url != "" {
+		page.Preview = s.options.Url + strings.TrimPrefix(page.Avatar, prefix)
+	}
+	page.Head = "<link rel=\"alternate\" type=\"application/atom+xml\" href=\"" + prefix + sitePath(feedDir) + "atom.xml\">\n"
+	return []byte(page.render())
+}
+
+func (s *staticSite) writePost(p *sitePost) error {
+	name := "post/" + strconv.Itoa(int(p.post.ID)) + ".html"
+	prefix := relative(name)
+	contentHtml := s.localize(p.html, prefix) +
+		"\n<p class=\"memo-meta\"><time datetime=\"" + p.post.CreatedAt.Format(time.RFC3339) + "\">" +
+		p.post.CreatedAt.Format("2006-01-02 15:04") + "</time></p>"
+	page := newArticlePage(p.content, contentHtml)
+	author := s.authors[p.post.Username]
+	s.pages = append(s.pages, name)
+	return s.out.write(name, s.page(name, page, author, userDir(author.Username)))
+}
+
+// writeList writes the paged index of the posts in the folder dir together
+// with its feeds.
+func (s *staticSite) writeList(dir string, title string, description string, author *model.User, posts []*sitePost) error {
+	pages := (len(posts) + memento.PageSize - 1) / memento.PageSize
+	if pages == 0 {
+		pages = 1
+	}
+	pageName := func(n int) string {
+		if n == 1 {
+			return dir + "index.html"
+		}
+		return dir + "page/" + strconv.Itoa(n) + ".html"
+	}
+	for n := 1; n <= pages; n++ {
+		name := pageName(n)
+		prefix := relative(name)
+		content := strings.Builder{}
+		content.WriteString("<h1>" + html.EscapeString(title) + "</h1>\n")
+		if description != "" {
+			content.WriteString("<p>" + html.EscapeString(description) + "</p>\n")
+		}
+		end := n * memento.PageSize
+		if end > len(posts) {
+			end = len(posts)
+		}
+		for _, p := range posts[(n-1)*memento.PageSize : end] {
+			content.WriteString("<hr>\n<section class=\"memo\">\n" + s.localize(p.html, prefix))
+			content.WriteString("<p class=\"memo-meta\"><a href=\"" + prefix + "post/" + strconv.Itoa(int(p.post.ID)) + ".html\">" +
+				p.post.CreatedAt.Format("2006-01-02 15:04") + "</a>")
+			if author == nil {
+				if a, ok := s.authors[p.post.Username]; ok {
+					content.WriteString(" · <a href=\"" + prefix + s.userPath(a.Username) + "\">" + html.EscapeString(a.Nickname) + "</a>")
+				}
+			}
+			content.WriteString("</p>\n</section>\n")
+		}
+		if pages > 1 {
+			content.WriteString("<hr>\n<nav>")
+			if n > 1 {
+				content.WriteString("<a href=\"" + prefix + sitePath(pageName(n-1)) + "\">Newer</a> ")
+			}
+			if n < pages {
+				content.WriteString("<a href=\"" + prefix + sitePath(pageName(n+1)) + "\">Older</a>")
+			}
+			content.WriteString("</nav>\n")
+		}
+		page := &articlePage{Title: title, Description: description, Content: content.String()}
+		if dir != "" {
+			page.Title = title + " - " + memento.GetConfig().SiteName
+		}
+		s.pages = append(s.pages, name)
+		if err := s.out.write(name, s.page(name, page, author, dir)); err != nil {
+			return err
+		}
+	}
+	return s.writeFeeds(dir, title, description, author, posts)
+}
+
+// writeFeeds writes the feeds of the posts in all formats into the folder dir.
+func (s *staticSite) writeFeeds(dir string, title string, description string, author *model.User, posts []*sitePost) error {
+	if len(posts) > feedSize {
+		posts = posts[:feedSize]
+	}
+	files := map[string]string{feed.FormatRSS: "rss.xml", feed.FormatAtom: "atom.xml", feed.FormatJSON: "feed.json"}
+	for _, format := range []string{feed.FormatRSS, feed.FormatAtom, feed.FormatJSON} {
+		name := dir + files[format]
+		prefix := s.absolute(name)
+		feedAuthor := func(user *model.User) *feed.Author {
+			return &feed.Author{
+				Name:   user.Nickname,
+				URL:    prefix + s.userPath(user.Username),
+				Avatar: prefix + sitePath(s.avatars[user.Username]),
+			}
+		}
+		f := &feed.Feed{
+			Title:       title,
+			Link:        prefix + sitePath(dir) + "index.html",
+			FeedURL:     prefix + sitePath(name),
+			Description: description,
+		}
+		if s.icon != "" {
+			f.Icon = prefix + s.icon
+		}
+		if author != nil {
+			f.Author = feedAuthor(author)
+			f.Icon = f.Author.Avatar
+		}
+		for _, p := range posts {
+			link := prefix + "post/" + strconv.Itoa(int(p.post.ID)) + ".html"
+			item := feed.Item{
+				ID:          link,
+				Title:       findTitleInMd(p.content),
+				Link:        link,
+				ContentHTML: s.localize(p.html, prefix),
+				Summary:     feedSummary(p.content),
+				Published:   p.post.CreatedAt,
+				Updated:     p.post.EditedAt,
+				Tags:        p.tags,
+			}
+			if a, ok := s.authors[p.post.Username]; ok {
+				item.Author = feedAuthor(a)
+			}
+			if item.Updated.Before(item.Published) {
+				item.Updated = item.Published
+			}
+			if item.Updated.After(f.Updated) {
+				f.Updated = item.Updated
+			}
+			f.Items = append(f.Items, item)
+		}
+		if f.Updated.IsZero() {
+			f.Updated = time.Unix(0, 0)
+		}
+		data, err := f.Render(format)
+		if err != nil {
+			return err
+		}
+		if err := s.out.write(name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *staticSite) writeSiteMap() error {
+	lastmod := make(map[string]time.Time, len(s.posts))
+	for _, p := range s.posts {
+		lastmod["post/"+strconv.Itoa(int(p.post.ID))+".html"] = p.post.EditedAt
+	}
+	sitemap := strings.Builder{}
+	sitemap.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)
+	for _, name := range s.pages {
+		sitemap.WriteString(`
+<url>
+	<loc>` + html.EscapeString(s.absolute("sitemap.xml")+sitePath(name)) + `</loc>`)
+		if t, ok := lastmod[name]; ok {
+			sitemap.WriteString(`
+	<lastmod>` + t.Format("2006-01-02") + `</lastmod>`)
+		}
+		sitemap.WriteString(`
+</url>`)
+	}
+	sitemap.WriteString(`
+</urlset>`)
+	return s.out.write("sitemap.xml", []byte(sitemap.String()))
+}
+
+// HandleAdminExportSite publishes the public memos of a user or of the whole
+// instance as a static website. The site is sent as a zip archive, or with
+// format=dir written to the site folder of the server.
+func HandleAdminExportSite(c echo.Context) error {
+	options := SiteOptions{
+		Username: c.FormValue("username"),
+		Url:      c.FormValue("url"),
+	}
+	if options.Url != "" {
+		u, err := url.Parse(options.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return utils.RespondError(c, "invalid url")
+		}
+	}
+	target := "instance"
+	if options.Username != "" {
+		target = options.Username
+		var user model.User
+		if err := memento.Db().First(&user, "username = ?", options.Username).Error; err != nil {
+			return utils.RespondError(c, "username not exists")
+		}
+	}
+	switch c.FormValue("format") {
+	case "", "zip":
+		options.Zip = true
+		options.Output = filepath.Join(memento.GetSitePath(),
+			utils.Md5string(fmt.Sprintf("%s%d", target, time.Now().UnixNano()))+".zip")
+		defer func(name string) {
+			_ = os.Remove(name)
+		}(options.Output)
+		if err := ExportStaticSite(options); err != nil {
+			log.Errorf(err.Error())
+			return utils.RespondError(c, "error exporting the site")
+		}
+		recordAudit(c, model.AuditSiteExport, target, nil, "zip")
+		name := "memento-site-" + time.Now().Format("20060102") + ".zip"
+		if options.Username != "" {
+			name = "memento-" + exportName(options.Username) + "-site-" + time.Now().Format("20060102") + ".zip"
+		}
+		return c.Attachment(options.Output, name)
+	case "dir":
+		options.Output = filepath.Join(memento.GetSitePath(), exportName(target))
+		if options.Username != "" {
+			options.Output = filepath.Join(memento.GetSitePath(), "user", exportName(target))
+		}
+		if err := os.RemoveAll(options.Output); err != nil {
+			log.Errorf(err.Error())
+			return utils.RespondError(c, "error exporting the site")
+		}
+		if err := ExportStaticSite(options); err != nil {
+			log.Errorf(err.Error())
+			return utils.RespondError(c, "error exporting the site")
+		}
+		recordAudit(c, model.AuditSiteExport, target, nil, options.Output)
+		return c.JSON(http.StatusOK, echo.Map{"path": options.Output})
+	}
+	return utils.RespondError(c, "unknown format")
+}
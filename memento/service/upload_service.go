@@ -0,0 +1,464 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+)
+
+// Resumable uploads follow the core of the tus protocol 1.0.0 with the
+// creation, expiration, checksum and termination extensions, see
+// https://tus.io/protocols/resumable-upload. The last chunk is answered with
+// the saved file like HandleFileUpload. The CORS middleware answers OPTIONS
+// requests, so the features are announced when an upload is created.
+
+const (
+	tusVersion = "1.0.0"
+	// uploadSessionLifetime is how long an upload is kept after its last
+	// chunk.
+	uploadSessionLifetime = 24 * time.Hour
+	// statusChecksumMismatch is the status tus uses for chunks which do not
+	// match their checksum.
+	statusChecksumMismatch = 460
+)
+
+// uploadLocks serializes the chunks of each upload.
+var uploadLocks sync.Map
+
+func uploadLock(id string) *sync.Mutex {
+	lock, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+func uploadSessionPath(id string) string {
+	return filepath.Join(memento.GetUploadSessionPath(), id)
+}
+
+func setTusHeaders(c echo.Context) {
+	header := c.Response().Header()
+	header.Set("Tus-Resumable", tusVersion)
+	header.Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Tus-Extension, "+
+		"Tus-Max-Size, Tus-Checksum-Algorithm, Upload-Offset, Upload-Length, Upload-Expires, Upload-Metadata, Memento-File-Id")
+}
+
+// unsupportedTusVersion reports whether the client speaks another version of
+// tus.
+func unsupportedTusVersion(c echo.Context) bool {
+	return c.Request().Header.Get("Tus-Resumable") != tusVersion
+}
+
+func respondTusVersion(c echo.Context) error {
+	c.Response().Header().Set("Tus-Version", tusVersion)
+	return c.JSON(http.StatusPreconditionFailed, echo.Map{"message": "unsupported tus version"})
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header, a comma separated
+// list of keys with base64 encoded values.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("invalid upload metadata")
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// checkSessionQuota checks whether an upload fits into the quota of the
+// user, along with the other uploads in progress.
+func checkSessionQuota(user *model.User, session *model.UploadSession) error {
+	var reserved int64
+	err := memento.Db().Model(&model.UploadSession{}).
+		Select("COALESCE(SUM(length), 0)").
+		Where("username = ? AND id <> ? AND file_id = 0 AND expires_at > ?", user.Username, session.ID, time.Now()).
+		Scan(&reserved).
+		Error
+	if err != nil {
+		return err
+	}
+	reserving := *user
+	reserving.UsedBytes += reserved
+	return checkQuota(&reserving, 0, session.Length)
+}
+
+// removeUploadSession removes an upload with its data.
+func removeUploadSession(session *model.UploadSession) {
+	if err := os.Remove(uploadSessionPath(session.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Errorf(err.Error())
+	}
+	if err := memento.Db().Delete(session).Error; err != nil {
+		log.Errorf(err.Error())
+	}
+	uploadLocks.Delete(session.ID)
+}
+
+// removeExpiredUploads removes the uploads which were abandoned or completed
+// a day ago.
+func removeExpiredUploads() {
+	var sessions []model.UploadSession
+	if err := memento.Db().Find(&sessions, "expires_at < ?", time.Now()).Error; err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	for i := range sessions {
+		removeUploadSession(&sessions[i])
+	}
+}
+
+// findUploadSession loads the upload of the id parameter, which only its
+// owner may see.
+func findUploadSession(c echo.Context) (*model.UploadSession, error) {
+	username := c.Get("username").(string)
+	var session model.UploadSession
+	err := memento.Db().First(&session, "id = ? AND username = ? AND expires_at > ?", c.Param("id"), username, time.Now()).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func respondUploadSessionError(c echo.Context, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"message": "upload not exists"})
+	}
+	log.Errorf(err.Error())
+	return utils.RespondError(c, "unknown query error")
+}
+
+func setUploadHeaders(c echo.Context, session *model.UploadSession) {
+	header := c.Response().Header()
+	header.Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	header.Set("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	header.Set("Cache-Control", "no-store")
+	if session.FileID != 0 {
+		header.Set("Memento-File-Id", strconv.Itoa(int(session.FileID)))
+	}
+}
+
+// HandleCreateUpload starts a resumable upload of Upload-Length bytes. The
+// name of the file is the filename of Upload-Metadata, and a sha256 in hex
+// there is verified when the upload is complete.
+func HandleCreateUpload(c echo.Context) error {
+	username := c.Get("username").(string)
+	if username == "" {
+		return utils.RespondUnauthorized(c)
+	}
+	setTusHeaders(c)
+	if unsupportedTusVersion(c) {
+		return respondTusVersion(c)
+	}
+	removeExpiredUploads()
+	length, err := strconv.ParseInt(c.Request().Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return utils.RespondError(c, "invalid upload length")
+	}
+	header := c.Request().Header.Get("Upload-Metadata")
+	metadata, err := parseUploadMetadata(header)
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = metadata["name"]
+	}
+	filename = path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if filename == "." || filename == "/" {
+		filename = "upload"
+	}
+	checksum := strings.ToLower(metadata["sha256"])
+	if _, err := hex.DecodeString(checksum); err != nil || (checksum != "" && len(checksum) != sha256.Size*2) {
+		return utils.RespondError(c, "invalid checksum")
+	}
+	var user model.User
+	if err := memento.Db().First(&user, "username = ?", username).Error; err != nil {
+		return utils.RespondUnauthorized(c)
+	}
+	if limit := memento.GetConfig().MaxUploadSize; limit > 0 && length > limit {
+		return respondUploadError(c, &user, errFileTooLarge)
+	}
+	id, err := randomSecret("")
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown error")
+	}
+	session := model.UploadSession{
+		ID:        id,
+		Username:  username,
+		Filename:  filename,
+		Length:    length,
+		Metadata:  header,
+		Checksum:  checksum,
+		ExpiresAt: time.Now().Add(uploadSessionLifetime),
+	}
+	if err := checkSessionQuota(&user, &session); err != nil {
+		return respondUploadError(c, &user, err)
+	}
+	if err := os.MkdirAll(memento.GetUploadSessionPath(), 0777); err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown error")
+	}
+	if err := os.WriteFile(uploadSessionPath(id), nil, 0644); err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown error")
+	}
+	if err := memento.Db().Create(&session).Error; err != nil {
+		_ = os.Remove(uploadSessionPath(id))
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown insertion error")
+	}
+	response := c.Response().Header()
+	response.Set("Location", federationBase()+"/api/file/uploads/"+id)
+	response.Set("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	response.Set("Tus-Version", tusVersion)
+	response.Set("Tus-Extension", "creation,expiration,checksum,termination")
+	response.Set("Tus-Checksum-Algorithm", "sha1,md5,sha256")
+	if limit := memento.GetConfig().MaxUploadSize; limit > 0 {
+		response.Set("Tus-Max-Size", strconv.FormatInt(limit, 10))
+	}
+	return c.NoContent(http.StatusCreated)
+}
+
+// HandleGetUpload reports how much of an upload was received, so the client
+// resumes from there.
+func HandleGetUpload(c echo.Context) error {
+	setTusHeaders(c)
+	if unsupportedTusVersion(c) {
+		return respondTusVersion(c)
+	}
+	session, err := findUploadSession(c)
+	if err != nil {
+		return respondUploadSessionError(c, err)
+	}
+	setUploadHeaders(c, session)
+	c.Response().Header().Set("Upload-Length", strconv.FormatInt(session.Length, 10))
+	if session.Metadata != "" {
+		c.Response().Header().Set("Upload-Metadata", session.Metadata)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// uploadChecksum parses the Upload-Checksum header of a chunk.
+func uploadChecksum(header string) (hash.Hash, []byte, error) {
+	if header == "" {
+		return nil, nil, nil
+	}
+	algorithm, encoded, _ := strings.Cut(header, " ")
+	want, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, errors.New("invalid checksum")
+	}
+	switch algorithm {
+	case "sha1":
+		return sha1.New(), want, nil
+	case "md5":
+		return md5.New(), want, nil
+	case "sha256":
+		return sha256.New(), want, nil
+	}
+	return nil, nil, errors.New("unsupported checksum algorithm")
+}
+
+// HandleAppendUpload appends a chunk at Upload-Offset. Chunks with an
+// Upload-Checksum are only kept when they match it. The upload is saved as a
+// file of the user with its last chunk.
+func HandleAppendUpload(c echo.Context) error {
+	setTusHeaders(c)
+	if unsupportedTusVersion(c) {
+		return respondTusVersion(c)
+	}
+	lock := uploadLock(c.Param("id"))
+	lock.Lock()
+	defer lock.Unlock()
+	session, err := findUploadSession(c)
+	if err != nil {
+		return respondUploadSessionError(c, err)
+	}
+	if c.Request().Header.Get(echo.HeaderContentType) != "application/offset+octet-stream" {
+		return c.JSON(http.StatusUnsupportedMediaType, echo.Map{"message": "invalid content type"})
+	}
+	offset, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != session.Offset || session.FileID != 0 {
+		setUploadHeaders(c, session)
+		return c.JSON(http.StatusConflict, echo.Map{"message": "upload offset mismatch"})
+	}
+	sum, want, err := uploadChecksum(c.Request().Header.Get("Upload-Checksum"))
+	if err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	var user model.User
+	if err := memento.Db().First(&user, "username = ?", session.Username).Error; err != nil {
+		return utils.RespondUnauthorized(c)
+	}
+	// the quota may have shrunk or been used up meanwhile
+	if err := checkSessionQuota(&user, session); err != nil {
+		return respondUploadError(c, &user, err)
+	}
+	f, err := os.OpenFile(uploadSessionPath(session.ID), os.O_WRONLY, 0)
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "upload data missing")
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown error")
+	}
+	var w io.Writer = f
+	if sum != nil {
+		w = io.MultiWriter(f, sum)
+	}
+	n, copyErr := io.Copy(w, io.LimitReader(c.Request().Body, session.Length-offset))
+	mismatch := sum != nil && copyErr == nil && !bytes.Equal(sum.Sum(nil), want)
+	if sum != nil && (copyErr != nil || mismatch) {
+		// the chunk is sent again as a whole
+		n = 0
+		if err := f.Truncate(offset); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+	if err := f.Close(); err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown error")
+	}
+	if mismatch {
+		setUploadHeaders(c, session)
+		return c.JSON(statusChecksumMismatch, echo.Map{"message": "checksum mismatch"})
+	}
+	session.Offset += n
+	session.ExpiresAt = time.Now().Add(uploadSessionLifetime)
+	err = memento.Db().Model(session).
+		UpdateColumns(map[string]interface{}{"offset": session.Offset, "expires_at": session.ExpiresAt}).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown save error")
+	}
+	if copyErr != nil {
+		// the client resumes from what was received
+		log.Errorf(copyErr.Error())
+		return utils.RespondError(c, "chunk incomplete")
+	}
+	if !session.TypeChecked && (session.Offset >= 512 || session.Offset == session.Length) {
+		if err := checkUploadType(session); err != nil {
+			removeUploadSession(session)
+			return respondUploadError(c, &user, err)
+		}
+	}
+	setUploadHeaders(c, session)
+	if session.Offset < session.Length {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return completeUpload(c, &user, session)
+}
+
+// checkUploadType rejects an upload of a type which is not allowed as soon
+// as its type can be sniffed.
+func checkUploadType(session *model.UploadSession) error {
+	f, err := os.Open(uploadSessionPath(session.ID))
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if !uploadTypeAllowed(sniffType(head[:n])) {
+		return errFileTypeBlocked
+	}
+	session.TypeChecked = true
+	return memento.Db().Model(session).UpdateColumn("type_checked", true).Error
+}
+
+// completeUpload verifies a complete upload and saves it as a file of the
+// user. The session is kept until it expires to answer offset queries.
+func completeUpload(c echo.Context, user *model.User, session *model.UploadSession) error {
+	f, err := os.Open(uploadSessionPath(session.ID))
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "upload data missing")
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	if session.Checksum != "" {
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			log.Errorf(err.Error())
+			return utils.RespondError(c, "unknown error")
+		}
+		if hex.EncodeToString(h.Sum(nil)) != session.Checksum {
+			removeUploadSession(session)
+			return c.JSON(statusChecksumMismatch, echo.Map{"message": "checksum mismatch"})
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			log.Errorf(err.Error())
+			return utils.RespondError(c, "unknown error")
+		}
+	}
+	file, err := saveFile(user, session.Filename, f)
+	if err != nil {
+		removeUploadSession(session)
+		return respondUploadError(c, user, err)
+	}
+	if err := os.Remove(uploadSessionPath(session.ID)); err != nil {
+		log.Errorf(err.Error())
+	}
+	session.FileID = file.ID
+	if err := memento.Db().Model(session).UpdateColumn("file_id", file.ID).Error; err != nil {
+		log.Errorf(err.Error())
+	}
+	c.Response().Header().Set("Memento-File-Id", strconv.Itoa(int(file.ID)))
+	return c.JSON(http.StatusOK, echo.Map{
+		"Filename": file.Filename,
+		"ID":       file.ID,
+	})
+}
+
+// HandleDeleteUpload cancels an upload and removes its data.
+func HandleDeleteUpload(c echo.Context) error {
+	setTusHeaders(c)
+	if unsupportedTusVersion(c) {
+		return respondTusVersion(c)
+	}
+	lock := uploadLock(c.Param("id"))
+	lock.Lock()
+	defer lock.Unlock()
+	session, err := findUploadSession(c)
+	if err != nil {
+		return respondUploadSessionError(c, err)
+	}
+	removeUploadSession(session)
+	return c.NoContent(http.StatusNoContent)
+}
@@ -3,21 +3,17 @@ package service
 import (
 	"Memento/memento"
 	"Memento/memento/model"
+	"Memento/memento/storage"
 	"Memento/memento/utils"
-	"bytes"
 	"context"
 	"errors"
 	"github.com/go-oauth2/oauth2/v4/server"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
-	"github.com/nfnt/resize"
 	"gorm.io/gorm"
-	"image"
-	"image/jpeg"
 	"io"
 	"mime/multipart"
 	"net/http"
-	"os"
 	"path"
 	"strconv"
 	"strings"
@@ -57,6 +53,10 @@ func HandleUserCreateWrapper(c echo.Context, s *server.Server) error {
 		TotalPosts:   0,
 		RegisteredAt: time.Now(),
 		IsAdmin:      totalUsers == 0,
+		Role:         model.RoleMember,
+	}
+	if user.IsAdmin {
+		user.Role = model.RoleAdmin
 	}
 	err = memento.Db().Create(&user).Error
 	if err != nil {
@@ -132,6 +132,7 @@ func HandleUserEdit(c echo.Context) error {
 	nickname := form["nickname"]
 	bio := form["bio"]
 	hasAvatar := true
+	oldAvatar := ""
 	avatar, err := c.FormFile("avatar")
 	if err != nil {
 		hasAvatar = false
@@ -154,6 +155,11 @@ func HandleUserEdit(c echo.Context) error {
 		}
 		user.Bio = bio[0]
 	}
+	approvePending := false
+	if protected := form["protected"]; len(protected) == 1 {
+		approvePending = user.IsProtected && protected[0] != "true"
+		user.IsProtected = protected[0] == "true"
+	}
 	if hasAvatar {
 		// Source
 		file, err := avatar.Open()
@@ -172,47 +178,43 @@ func HandleUserEdit(c echo.Context) error {
 			log.Errorf(err.Error())
 			return utils.RespondError(c, "read file error")
 		}
-		size := avatar.Size
-		ext := path.Ext(avatar.Filename)
-		if size > 1024*1024 {
-			avatarData = resizeImage(avatarData, 256, 256)
-			ext = ".jpg"
-		}
+		avatarData, ext := processAvatar(avatarData, path.Ext(avatar.Filename))
 		filename := utils.Md5string(strconv.FormatInt(time.Now().UnixMilli(), 10)) + ext
 		// Destination
-		filepath := path.Join(memento.GetAvatarPath(), filename)
-		dst, err := os.OpenFile(filepath, os.O_CREATE|os.O_RDWR, 0777)
-		if err != nil {
-			log.Errorf(err.Error())
-			return utils.RespondError(c, "os file open error")
-		}
-		defer func(dst *os.File) {
-			err := dst.Close()
-			if err != nil {
-				log.Errorf(err.Error())
-			}
-		}(dst)
-		// write
-		_, err = dst.Write(avatarData)
-		if err != nil {
+		key := storage.AvatarPrefix + filename
+		if err := storage.WriteFile(key, avatarData); err != nil {
 			log.Errorf(err.Error())
 			return utils.RespondError(c, "write file error")
 		}
-		if user.AvatarUrl != "" {
-			err := os.Remove(user.AvatarUrl)
-			if err != nil {
-				log.Errorf(err.Error())
-			}
-		}
-		user.AvatarUrl = filepath
+		oldAvatar, user.AvatarUrl = user.AvatarUrl, key
 	}
 	if err := memento.Db().Save(&user).Error; err != nil {
 		log.Errorf(err.Error())
+		if hasAvatar {
+			removeAvatar(user.AvatarUrl)
+		}
 		return utils.RespondError(c, "unknown save error")
 	}
+	// the replaced avatar is removed once the new one is saved
+	removeAvatar(oldAvatar)
+	if approvePending {
+		if err := approveAllFollowRequests(&user); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
 	return c.JSON(http.StatusOK, utils.UserToView(&user, false))
 }
 
+// removeAvatar removes a replaced avatar from the storage.
+func removeAvatar(key string) {
+	if key == "" {
+		return
+	}
+	if err := storage.Default().Delete(key); err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
 func checkIsFollowed(selfUsername string, username string) bool {
 	if selfUsername == "" || selfUsername == username {
 		return false
@@ -259,7 +261,11 @@ func HandleGetUser(c echo.Context) error {
 		}
 		isFollowed = checkIsFollowed(currentUser.Username, user.Username)
 	}
-	return c.JSON(http.StatusOK, utils.UserToView(&user, isFollowed))
+	view := utils.UserToView(&user, isFollowed)
+	view.IsBlocked = isBlocked(currentUser.Username, user.Username)
+	view.IsMuted = isMuted(currentUser.Username, user.Username)
+	view.IsRequested = hasPendingFollowRequest(currentUser.Username, user.Username)
+	return c.JSON(http.StatusOK, view)
 }
 
 func PasswordAuthorizationHandler(ctx context.Context, clientID, username, password string) (userID string, err error) {
@@ -363,23 +369,73 @@ func HandleUserFollow(c echo.Context) error {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "unknown query error")
 	}
-	err = memento.Db().Transaction(
+	if err := followUser(&user, &followee); err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	if followee.IsProtected {
+		return c.JSON(http.StatusOK, echo.Map{
+			"status": model.FollowRequestPending,
+		})
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// followUser follows the followee, or asks for approval if the followee is a
+// protected account.
+func followUser(user *model.User, followee *model.User) error {
+	if isBlockedEitherWay(user.Username, followee.Username) {
+		return errors.New("permission denied")
+	}
+	if checkIsFollowed(user.Username, followee.Username) {
+		return errors.New("already followed")
+	}
+	if followee.IsProtected {
+		return requestFollow(user, followee)
+	}
+	err := memento.Db().Transaction(
 		func(tx *gorm.DB) error {
-			err := tx.Model(&user).Association("Follows").Append(&followee)
-			if err != nil {
-				return err
-			}
-			followee.TotalFollower += 1
-			user.TotalFollows += 1
-			tx.Save(&user)
-			tx.Save(&followee)
-			return nil
+			return addFollow(tx, user, followee)
 		})
 	if err != nil {
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown query error")
+		return errors.New("unknown query error")
 	}
-	return c.NoContent(http.StatusOK)
+	return nil
+}
+
+// addFollow creates the follow relation and updates the follow counters.
+func addFollow(tx *gorm.DB, user *model.User, followee *model.User) error {
+	err := tx.Model(user).Association("Follows").Append(followee)
+	if err != nil {
+		return err
+	}
+	err = tx.Model(user).Update("total_follows", gorm.Expr("total_follows + 1")).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(followee).Update("total_follower", gorm.Expr("total_follower + 1")).Error
+}
+
+// removeFollow deletes the follow relation from follower to followee, if any,
+// and keeps the follow counters of both users consistent.
+func removeFollow(tx *gorm.DB, follower *model.User, followee *model.User) error {
+	var follows []model.User
+	err := tx.Model(follower).Association("Follows").Find(&follows, "username=?", followee.Username)
+	if err != nil {
+		return err
+	}
+	if len(follows) == 0 {
+		return nil
+	}
+	err = tx.Model(follower).Association("Follows").Delete(followee)
+	if err != nil {
+		return err
+	}
+	err = tx.Model(follower).Update("total_follows", gorm.Expr("total_follows - 1")).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(followee).Update("total_follower", gorm.Expr("total_follower - 1")).Error
 }
 
 func HandleUserUnfollow(c echo.Context) error {
@@ -405,23 +461,30 @@ func HandleUserUnfollow(c echo.Context) error {
 		log.Errorf(err.Error())
 		return utils.RespondError(c, "unknown query error")
 	}
-	err = memento.Db().Transaction(
+	if err := unfollowUser(&user, &followee); err != nil {
+		return utils.RespondError(c, err.Error())
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// unfollowUser removes the follow relation together with a pending request.
+func unfollowUser(user *model.User, followee *model.User) error {
+	err := memento.Db().Transaction(
 		func(tx *gorm.DB) error {
-			err := tx.Model(&user).Association("Follows").Delete(&followee)
+			err := tx.Delete(&model.FollowRequest{},
+				"requester = ? AND target = ? AND status = ?",
+				user.Username, followee.Username, model.FollowRequestPending).
+				Error
 			if err != nil {
 				return err
 			}
-			followee.TotalFollower -= 1
-			user.TotalFollows -= 1
-			tx.Save(&user)
-			tx.Save(&followee)
-			return nil
+			return removeFollow(tx, user, followee)
 		})
 	if err != nil {
 		log.Errorf(err.Error())
-		return utils.RespondError(c, "unknown query error")
+		return errors.New("unknown query error")
 	}
-	return c.NoContent(http.StatusOK)
+	return nil
 }
 
 func HandlerGetUserFollower(c echo.Context) error {
@@ -484,30 +547,14 @@ func HandleGetAvatar(c echo.Context) error {
 	if name == "user.png" {
 		return c.Redirect(http.StatusMovedPermanently, "/assets/assets/user.png")
 	}
-	return c.File(path.Join(memento.GetAvatarPath(), name))
-}
-
-func resizeImage(src []byte, width, height int) []byte {
-	// 读取原始图像
-	img, _, err := image.Decode(bytes.NewReader(src))
-	if err != nil {
-		log.Printf("Error decoding image: %v", err)
-		return src
-	}
-
-	if img.Bounds().Dx()/img.Bounds().Dy() != width/height {
-		height = img.Bounds().Dy() * width / img.Bounds().Dx()
-	}
-
-	// 调整图像大小
-	resizedImg := resize.Resize(uint(width), uint(height), img, resize.Lanczos3)
-
-	// 将调整后的图像编码为字节数组
-	buf := new(bytes.Buffer)
-	if err := jpeg.Encode(buf, resizedImg, nil); err != nil {
-		log.Printf("Error encoding image: %v", err)
-		return src
+	if strings.ContainsAny(name, "/\\") {
+		return echo.ErrNotFound
 	}
-
-	return buf.Bytes()
+	// a new avatar gets a new name
+	return serveBlob(c, download{
+		Key:       storage.AvatarPrefix + name,
+		Name:      name,
+		ETag:      `"` + name + `"`,
+		Immutable: true,
+	})
 }
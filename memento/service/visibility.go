@@ -0,0 +1,181 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+)
+
+// visiblePosts restricts a post query to the posts which may be listed to the viewer.
+func visiblePosts(db *gorm.DB, viewer string) *gorm.DB {
+	db = db.Where("posts.is_hidden = ?", false)
+	if blocked := blockedUsernames(viewer); len(blocked) > 0 {
+		db = db.Where("posts.username NOT IN ?", blocked)
+	}
+	// posts of protected accounts are only listed to their approved followers
+	db = db.Where(`posts.username NOT IN (
+		SELECT username FROM users WHERE is_protected = ? AND username <> ? AND id NOT IN (
+			SELECT follow_id FROM user_follows WHERE user_id = (SELECT id FROM users WHERE username = ?)))`,
+		true, viewer, viewer)
+	return db
+}
+
+// feedPosts is visiblePosts without the authors the viewer has muted. It is
+// used for timelines, while a muted user's own page stays reachable.
+func feedPosts(db *gorm.DB, viewer string) *gorm.DB {
+	db = visiblePosts(db, viewer)
+	if muted := mutedUsernames(viewer); len(muted) > 0 {
+		db = db.Where("posts.username NOT IN ?", muted)
+	}
+	return db
+}
+
+// visibleComments restricts a comment query to the comments shown to the viewer.
+func visibleComments(db *gorm.DB, viewer string) *gorm.DB {
+	db = db.Where("comments.is_hidden = ?", false)
+	hidden := append(blockedUsernames(viewer), mutedUsernames(viewer)...)
+	if len(hidden) > 0 {
+		db = db.Where("comments.username NOT IN ?", hidden)
+	}
+	return db
+}
+
+// canViewPost reports whether the viewer is allowed to open the post.
+func canViewPost(viewer string, post *model.Post) bool {
+	if post.Username == viewer && viewer != "" {
+		return true
+	}
+	if post.IsPrivate {
+		return false
+	}
+	if isBlockedEitherWay(viewer, post.Username) {
+		return false
+	}
+	if isProtected(post.Username) && !checkIsFollowed(viewer, post.Username) {
+		return false
+	}
+	if post.IsHidden {
+		return userHasPermission(viewer, model.PermPostHide)
+	}
+	return true
+}
+
+// fileIsPrivate reports whether a file may only be downloaded by its owner or
+// with a signed link. Files follow the posts of their owner linking them
+// unless a visibility was set: they are public when anyone may open one of
+// those posts, and private otherwise, including when no post links them.
+func fileIsPrivate(file *model.File) bool {
+	switch file.Visibility {
+	case model.FilePublic:
+		return false
+	case model.FilePrivate:
+		return true
+	}
+	var posts []model.Post
+	err := memento.Db().
+		Joins("JOIN post_files ON post_files.post_id = posts.id").
+		Where("post_files.file_id = ? AND posts.is_private = ?", file.ID, false).
+		Find(&posts).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return true
+	}
+	for i := range posts {
+		if canViewPost("", &posts[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// blockedUsernames returns the users the viewer has blocked together with the
+// users who have blocked the viewer.
+func blockedUsernames(viewer string) []string {
+	if viewer == "" {
+		return nil
+	}
+	var blocked, blockedBy []string
+	err := memento.Db().Model(&model.User{}).
+		Joins("JOIN user_blocks ON user_blocks.block_id = users.id").
+		Where("user_blocks.user_id = (SELECT id FROM users WHERE username = ?)", viewer).
+		Pluck("users.username", &blocked).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+	}
+	err = memento.Db().Model(&model.User{}).
+		Joins("JOIN user_blocks ON user_blocks.user_id = users.id").
+		Where("user_blocks.block_id = (SELECT id FROM users WHERE username = ?)", viewer).
+		Pluck("users.username", &blockedBy).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+	}
+	return append(blocked, blockedBy...)
+}
+
+func mutedUsernames(viewer string) []string {
+	if viewer == "" {
+		return nil
+	}
+	var muted []string
+	err := memento.Db().Model(&model.User{}).
+		Joins("JOIN user_mutes ON user_mutes.mute_id = users.id").
+		Where("user_mutes.user_id = (SELECT id FROM users WHERE username = ?)", viewer).
+		Pluck("users.username", &muted).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+	}
+	return muted
+}
+
+// isBlocked reports whether the blocker has blocked the other user.
+func isBlocked(blocker string, username string) bool {
+	if blocker == "" || username == "" || blocker == username {
+		return false
+	}
+	var count int64
+	err := memento.Db().Table("user_blocks").
+		Where("user_id = (SELECT id FROM users WHERE username = ?)", blocker).
+		Where("block_id = (SELECT id FROM users WHERE username = ?)", username).
+		Count(&count).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return false
+	}
+	return count > 0
+}
+
+func isProtected(username string) bool {
+	var user model.User
+	err := memento.Db().Select("is_protected").First(&user, "username=?", username).Error
+	if err != nil {
+		return false
+	}
+	return user.IsProtected
+}
+
+func isBlockedEitherWay(a string, b string) bool {
+	return isBlocked(a, b) || isBlocked(b, a)
+}
+
+func isMuted(muter string, username string) bool {
+	if muter == "" || username == "" || muter == username {
+		return false
+	}
+	var count int64
+	err := memento.Db().Table("user_mutes").
+		Where("user_id = (SELECT id FROM users WHERE username = ?)", muter).
+		Where("mute_id = (SELECT id FROM users WHERE username = ?)", username).
+		Count(&count).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return false
+	}
+	return count > 0
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"Memento/memento"
+	"Memento/memento/model"
+	"Memento/memento/utils"
+	"Memento/memento/webmention"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// HandleWebmention receives a webmention. The source is verified in the
+// background, as recommended by the specification.
+func HandleWebmention(c echo.Context) error {
+	source := c.FormValue("source")
+	target := c.FormValue("target")
+	if !webmention.IsValidURL(source) || !webmention.IsValidURL(target) || source == target {
+		return c.JSON(http.StatusBadRequest, "invalid source or target")
+	}
+	postID, ok := postIDFromURL(target)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, "target is not a post")
+	}
+	if u, _ := url.Parse(target); u.Host != federationHost(federationBase()) {
+		return c.JSON(http.StatusBadRequest, "target is not on this site")
+	}
+	var post model.Post
+	if err := memento.Db().First(&post, "id=?", postID).Error; err != nil || !canViewPost("", &post) {
+		return c.JSON(http.StatusBadRequest, "target is not a post")
+	}
+	mention := model.Webmention{PostID: post.ID, Source: source}
+	if err := memento.Db().Where(&mention).FirstOrCreate(&mention).Error; err != nil {
+		log.Errorf(err.Error())
+		return c.JSON(http.StatusInternalServerError, "unknown insertion error")
+	}
+	err := memento.Db().Model(&mention).Updates(map[string]interface{}{
+		"target": target,
+		"status": model.WebmentionPending,
+	}).Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return c.JSON(http.StatusInternalServerError, "unknown update error")
+	}
+	go verifyWebmention(mention, target)
+	return c.NoContent(http.StatusAccepted)
+}
+
+// verifyWebmention accepts the mention if the source links to the target.
+// A source which no longer links to the target removes the mention.
+func verifyWebmention(mention model.Webmention, target string) {
+	verified, err := webmention.Verify(webmention.DefaultFetcher, mention.Source, target)
+	updates := map[string]interface{}{"verified_at": time.Now()}
+	if err != nil {
+		log.Errorf("Error verifying webmention from %s: %s\n", mention.Source, err.Error())
+		updates["status"] = model.WebmentionRejected
+	} else {
+		updates["status"] = model.WebmentionAccepted
+		updates["author"] = verified.Author
+		updates["title"] = verified.Title
+		updates["content"] = verified.Content
+	}
+	if err := memento.Db().Model(&mention).Updates(updates).Error; err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
+func HandleGetPostMentions(c echo.Context) error {
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil {
+		return utils.RespondError(c, "invalid page")
+	}
+	var post model.Post
+	err = memento.Db().First(&post, "id=?", c.QueryParam("id")).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.RespondError(c, "post not exists")
+		}
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	if !canViewPost(c.Get("username").(string), &post) {
+		return utils.RespondError(c, "post not exists")
+	}
+	db := memento.Db().Where("post_id = ? AND status = ?", post.ID, model.WebmentionAccepted)
+	mentions := make([]model.Webmention, 0, memento.PageSize)
+	err = db.Session(&gorm.Session{}).
+		Order("created_at desc").
+		Offset(page * memento.PageSize).
+		Limit(memento.PageSize).
+		Find(&mentions).
+		Error
+	if err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	var total int64
+	if err := db.Session(&gorm.Session{}).Model(&model.Webmention{}).Count(&total).Error; err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown query error")
+	}
+	result := make([]model.WebmentionViewModel, 0, len(mentions))
+	for _, m := range mentions {
+		result = append(result, model.WebmentionViewModel{
+			ID:        m.ID,
+			PostID:    m.PostID,
+			Source:    m.Source,
+			Author:    m.Author,
+			Title:     m.Title,
+			Content:   m.Content,
+			CreatedAt: m.CreatedAt,
+		})
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"mentions": result,
+		"maxPage":  utils.MaxPage(total),
+	})
+}
+
+// HandleDeleteMention lets the author of a post remove a mention of it.
+func HandleDeleteMention(c echo.Context) error {
+	username := c.Get("username").(string)
+	var mention model.Webmention
+	if err := memento.Db().First(&mention, "id=?", c.Param("id")).Error; err != nil {
+		return utils.RespondError(c, "mention not exists")
+	}
+	var post model.Post
+	if err := memento.Db().First(&post, "id=?", mention.PostID).Error; err != nil || post.Username != username {
+		return utils.RespondError(c, "permission denied")
+	}
+	if err := memento.Db().Delete(&mention).Error; err != nil {
+		log.Errorf(err.Error())
+		return utils.RespondError(c, "unknown deletion error")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// sendWebmentions notifies the sites linked from a public post.
+func sendWebmentions(post *model.Post) {
+	if !canViewPost("", post) {
+		return
+	}
+	view, err := utils.PostToView(post, &model.UserViewModel{}, false)
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	base := federationBase()
+	host := federationHost(base)
+	source := postURL(base, post.ID)
+	var targets []string
+	for _, link := range webmention.Links(string(mdToHTML([]byte(view.Content)))) {
+		if u, err := url.Parse(link); err == nil && u.Host != host {
+			targets = append(targets, link)
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+	go func() {
+		for _, target := range targets {
+			endpoint, err := webmention.Discover(webmention.DefaultFetcher, target)
+			if err != nil || endpoint == "" {
+				continue
+			}
+			if err := webmention.DefaultFetcher.Send(endpoint, source, target); err != nil {
+				log.Errorf("Error sending webmention to %s: %s\n", endpoint, err.Error())
+			}
+		}
+	}()
+}
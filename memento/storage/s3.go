@@ -0,0 +1,349 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3TimeFormat      = "20060102T150405Z"
+)
+
+// S3 stores the blobs in a bucket of an S3 compatible service, e.g. AWS S3
+// or MinIO. Requests are signed with AWS signature version 4.
+type S3 struct {
+	// Endpoint is the url of the service, e.g. https://s3.amazonaws.com or
+	// http://localhost:9000.
+	Endpoint  *url.URL
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle puts the bucket into the path instead of the host name,
+	// which MinIO and most self-hosted services need.
+	PathStyle bool
+	Client    *http.Client
+	now       func() time.Time
+}
+
+// NewS3 returns a backend storing the blobs in bucket.
+func NewS3(endpoint string, region string, bucket string, accessKey string, secretKey string, pathStyle bool) (*S3, error) {
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	if bucket == "" {
+		return nil, errors.New("missing S3 bucket")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{
+		Endpoint:  u,
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		PathStyle: pathStyle,
+		Client:    &http.Client{Timeout: 10 * time.Minute},
+		now:       time.Now,
+	}, nil
+}
+
+// s3Error is the error document of S3.
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// url returns the url of a key, or of the bucket for an empty key.
+func (s *S3) url(key string, query url.Values) *url.URL {
+	u := *s.Endpoint
+	p := strings.TrimSuffix(u.Path, "/")
+	if s.PathStyle {
+		p += "/" + s.Bucket
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	p += "/" + key
+	u.Path = p
+	u.RawPath = s3Escape(p, false)
+	u.RawQuery = s3Query(query)
+	return &u
+}
+
+// s3Escape encodes a string the way S3 does for signing: everything but the
+// unreserved characters of RFC 3986, and slashes unless encodeSlash is set.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query encodes query parameters sorted by name, as signing requires.
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func s3Hash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func (s *S3) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+}
+
+// signature signs a canonical request made at t.
+func (s *S3) signature(t time.Time, canonicalRequest string) string {
+	stringToSign := s3Algorithm + "\n" + t.Format(s3TimeFormat) + "\n" + s.scope(t) + "\n" + s3Hash([]byte(canonicalRequest))
+	key := s3HMAC([]byte("AWS4"+s.SecretKey), t.Format("20060102"))
+	key = s3HMAC(key, s.Region)
+	key = s3HMAC(key, "s3")
+	key = s3HMAC(key, "aws4_request")
+	return hex.EncodeToString(s3HMAC(key, stringToSign))
+}
+
+// canonicalRequest builds the request which is signed, with the given
+// headers in lower case.
+func canonicalRequest(method string, u *url.URL, headers map[string]string, payloadHash string) (string, string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	return strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n"), signedHeaders
+}
+
+// do sends a signed request. body is sent with the given length.
+func (s *S3) do(method string, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	return s.doRange(method, key, query, body, size, "")
+}
+
+// doRange sends a signed request for the part of a blob in byteRange, a
+// Range header, or for all of it when it is empty.
+func (s *S3) doRange(method string, key string, query url.Values, body io.Reader, size int64, byteRange string) (*http.Response, error) {
+	u := s.url(key, query)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := s3Hash(nil)
+	if body != nil {
+		payloadHash = s3UnsignedPayload
+		req.ContentLength = size
+	}
+	t := s.now().UTC()
+	headers := map[string]string{
+		"host":                 u.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           t.Format(s3TimeFormat),
+	}
+	canonical, signedHeaders := canonicalRequest(method, u, headers, payloadHash)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", headers["x-amz-date"])
+	if byteRange != "" {
+		// headers besides host and those of S3 need not be signed
+		req.Header.Set("Range", byteRange)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.AccessKey, s.scope(t), signedHeaders, s.signature(t, canonical)))
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		_ = resp.Body.Close()
+		return nil, ErrNotExist
+	}
+	if resp.StatusCode >= 300 {
+		defer func(body io.ReadCloser) {
+			_ = body.Close()
+		}(resp.Body)
+		var e s3Error
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if xml.Unmarshal(data, &e) != nil || e.Code == "" {
+			e.Code = resp.Status
+		}
+		return nil, fmt.Errorf("S3 %s %s: %s %s", method, key, e.Code, e.Message)
+	}
+	return resp, nil
+}
+
+func (s *S3) Put(key string, r io.Reader, size int64) error {
+	if size < 0 {
+		// S3 needs the length up front
+		tmp, err := os.CreateTemp("", "memento-s3-")
+		if err != nil {
+			return err
+		}
+		defer func(tmp *os.File) {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}(tmp)
+		if size, err = io.Copy(tmp, r); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = tmp
+	}
+	// the transport closes the body, which belongs to the caller
+	body := io.NopCloser(r)
+	if size == 0 {
+		// an empty body of unknown type would be sent chunked
+		body = http.NoBody
+	}
+	resp, err := s.do(http.MethodPut, key, nil, body, size)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) GetRange(key string, offset int64, length int64) (io.ReadCloser, error) {
+	resp, err := s.doRange(http.MethodGet, key, nil, nil, 0, fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Stat(key string) (*Object, error) {
+	resp, err := s.do(http.MethodHead, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &Object{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (s *S3) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, nil, 0)
+	if errors.Is(err, ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3) List(prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, Object{Key: c.Key, Size: c.Size, ModTime: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) Presign(key string, expires time.Duration, params url.Values) (string, error) {
+	t := s.now().UTC()
+	query := url.Values{}
+	for k, v := range params {
+		query[k] = v
+	}
+	query.Set("X-Amz-Algorithm", s3Algorithm)
+	query.Set("X-Amz-Credential", s.AccessKey+"/"+s.scope(t))
+	query.Set("X-Amz-Date", t.Format(s3TimeFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	u := s.url(key, query)
+	canonical, _ := canonicalRequest(http.MethodGet, u, map[string]string{"host": u.Host}, s3UnsignedPayload)
+	u.RawQuery += "&X-Amz-Signature=" + s.signature(t, canonical)
+	return u.String(), nil
+}
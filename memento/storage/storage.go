@@ -0,0 +1,209 @@
+// Package storage keeps the blobs of Memento: the contents of posts, uploaded
+// files and avatars. Blobs are addressed by slash separated keys relative to
+// the backend, e.g. upload/<name>, so the database does not depend on where
+// they are stored.
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Key prefixes of the kinds of blobs.
+const (
+	PostPrefix   = "post/"
+	UploadPrefix = "upload/"
+	AvatarPrefix = "avatar/"
+)
+
+// Prefixes lists the key prefixes of all blobs, e.g. to migrate them.
+var Prefixes = []string{PostPrefix, UploadPrefix, AvatarPrefix}
+
+var (
+	// ErrNotExist is returned for keys without a blob.
+	ErrNotExist = fs.ErrNotExist
+	// ErrNotSupported is returned by backends which cannot presign urls.
+	ErrNotSupported = errors.New("not supported by the storage backend")
+)
+
+// Object describes a stored blob.
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage is a backend blobs are stored in.
+type Storage interface {
+	// Put stores the content of r under key, replacing an existing blob.
+	// size is the length of the content, or -1 when it is unknown.
+	Put(key string, r io.Reader, size int64) error
+	// Get opens the blob of key. Local blobs can be seeked.
+	Get(key string) (io.ReadCloser, error)
+	Stat(key string) (*Object, error)
+	// Delete removes the blob of key. Missing blobs are not an error.
+	Delete(key string) error
+	// List returns the blobs whose keys start with prefix.
+	List(prefix string) ([]Object, error)
+	// Presign returns a url the blob can be downloaded from without
+	// credentials until it expires. params are signed along, e.g.
+	// response-content-disposition.
+	Presign(key string, expires time.Duration, params url.Values) (string, error)
+}
+
+// RangeReader is implemented by backends whose blobs cannot be seeked but
+// read in parts.
+type RangeReader interface {
+	// GetRange opens length bytes of the blob of key from offset on.
+	GetRange(key string, offset int64, length int64) (io.ReadCloser, error)
+}
+
+var (
+	lock    sync.RWMutex
+	current Storage
+)
+
+// SetDefault sets the backend used by the server.
+func SetDefault(s Storage) {
+	lock.Lock()
+	defer lock.Unlock()
+	current = s
+}
+
+// Default returns the backend used by the server.
+func Default() Storage {
+	lock.RLock()
+	defer lock.RUnlock()
+	return current
+}
+
+// ReadFile returns the content of a blob of the default backend.
+func ReadFile(key string) ([]byte, error) {
+	r, err := Default().Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer func(r io.ReadCloser) {
+		_ = r.Close()
+	}(r)
+	return io.ReadAll(r)
+}
+
+// Open opens a blob of size bytes for reading at any offset, as
+// http.ServeContent needs to answer range requests. Blobs of backends which
+// can neither seek nor read parts cannot be seeked.
+func Open(s Storage, key string, size int64) (io.ReadCloser, error) {
+	if rr, ok := s.(RangeReader); ok {
+		return &rangeSeeker{r: rr, key: key, size: size}, nil
+	}
+	return s.Get(key)
+}
+
+// rangeSeeker reads a blob from a RangeReader, starting a new request after
+// each seek.
+type rangeSeeker struct {
+	r      RangeReader
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (s *rangeSeeker) Read(p []byte) (int, error) {
+	if s.offset >= s.size {
+		return 0, io.EOF
+	}
+	if s.body == nil {
+		body, err := s.r.GetRange(s.key, s.offset, s.size-s.offset)
+		if err != nil {
+			return 0, err
+		}
+		s.body = body
+	}
+	n, err := s.body.Read(p)
+	s.offset += int64(n)
+	return n, err
+}
+
+func (s *rangeSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if offset != s.offset {
+		_ = s.Close()
+		s.offset = offset
+	}
+	return offset, nil
+}
+
+func (s *rangeSeeker) Close() error {
+	if s.body == nil {
+		return nil
+	}
+	err := s.body.Close()
+	s.body = nil
+	return err
+}
+
+// WriteFile stores data as a blob of the default backend.
+func WriteFile(key string, data []byte) error {
+	return Default().Put(key, bytes.NewReader(data), int64(len(data)))
+}
+
+// Copy copies a blob from one backend to another.
+func Copy(dst Storage, src Storage, key string) error {
+	info, err := src.Stat(key)
+	if err != nil {
+		return err
+	}
+	r, err := src.Get(key)
+	if err != nil {
+		return err
+	}
+	defer func(r io.ReadCloser) {
+		_ = r.Close()
+	}(r)
+	return dst.Put(key, r, info.Size)
+}
+
+// Migrate copies the blobs below the prefixes from one backend to another,
+// skipping blobs the destination already holds with the same size, so an
+// interrupted migration can be resumed. The copied blobs are removed from
+// the source when remove is set. progress is called after each blob.
+func Migrate(dst Storage, src Storage, prefixes []string, remove bool, progress func(Object)) (int, error) {
+	count := 0
+	for _, prefix := range prefixes {
+		objects, err := src.List(prefix)
+		if err != nil {
+			return count, err
+		}
+		for _, object := range objects {
+			if existing, err := dst.Stat(object.Key); err != nil || existing.Size != object.Size {
+				if err := Copy(dst, src, object.Key); err != nil {
+					return count, err
+				}
+			}
+			if remove {
+				if err := src.Delete(object.Key); err != nil {
+					return count, err
+				}
+			}
+			count++
+			if progress != nil {
+				progress(object)
+			}
+		}
+	}
+	return count, nil
+}
@@ -17,6 +17,10 @@ var (
 			AccessTokenSigningKey:  "accnyne",
 			RefreshTokenSigningKey: "refmaya",
 		},
+		StorageConfig{
+			Driver:      "local",
+			PostContent: "file",
+		},
 	}
 )
 
@@ -40,9 +44,61 @@ type ServerConfig struct {
 	IconVersion            uint
 	AccessTokenSigningKey  string
 	RefreshTokenSigningKey string
+	// EnableFederation publishes the users as ActivityPub actors.
+	EnableFederation bool `yaml:"enable_federation"`
+	// PublicUrl is the external base url, e.g. https://memento.example.com.
+	// The host of the current request is used when it is empty.
+	PublicUrl string `yaml:"public_url"`
+	// DefaultQuota is the storage quota of users in bytes, 0 for none.
+	DefaultQuota int64 `yaml:"default_quota"`
+	// MaxUploadSize limits the size of each uploaded file in bytes, 0 for
+	// none.
+	MaxUploadSize int64 `yaml:"max_upload_size"`
+	// AllowedTypes lists the MIME types of the files which can be uploaded,
+	// e.g. image/* or application/pdf. All types are allowed when it is
+	// empty.
+	AllowedTypes []string `yaml:"allowed_types"`
+	// KeepImageMetadata keeps the EXIF and GPS metadata of uploaded images,
+	// which is removed by default.
+	KeepImageMetadata bool `yaml:"keep_image_metadata"`
+	// DevMode lets federation and webmentions reach plain http urls and the
+	// addresses of the local network, to test with local instances. It must
+	// not be enabled on public servers.
+	DevMode bool `yaml:"dev_mode"`
+}
+
+// StorageConfig selects where the contents of posts, uploaded files and
+// avatars are stored.
+type StorageConfig struct {
+	// Driver is "local", the default, or "s3".
+	Driver string
+	// Endpoint, Region and Bucket locate the bucket of an S3 compatible
+	// service, e.g. http://localhost:9000 for MinIO.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	// PathStyle puts the bucket into the path, which MinIO needs.
+	PathStyle bool `yaml:"path_style"`
+	// Redirect sends downloads to presigned urls of the bucket instead of
+	// proxying them through the server.
+	Redirect bool
+	// PostContent is "file", the default, to store the contents of new posts
+	// with the backend, or "database" to keep them in the posts table.
+	PostContent string `yaml:"post_content"`
+	// CompressPosts compresses the contents kept in the database with gzip.
+	CompressPosts bool `yaml:"compress_posts"`
+	// OrphanDays is the age in days after which uploads no post or comment
+	// links are collected every day. 0 disables the collection.
+	OrphanDays int `yaml:"orphan_days"`
+	// RemoveOrphans removes the collected uploads, which are only reported
+	// in the log otherwise.
+	RemoveOrphans bool `yaml:"remove_orphans"`
 }
 
 type MementoConfig struct {
 	DbConfig     `yaml:"database"`
 	ServerConfig `yaml:"server"`
+	Storage      StorageConfig `yaml:"storage"`
 }
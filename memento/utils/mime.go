@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// mimeTypes maps file extensions to MIME types. Unlike mime.TypeByExtension
+// it does not depend on the tables installed on the host.
+var mimeTypes = map[string]string{
+	// images
+	".apng": "image/apng",
+	".avif": "image/avif",
+	".bmp":  "image/bmp",
+	".gif":  "image/gif",
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".ico":  "image/x-icon",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".webp": "image/webp",
+	// audio
+	".aac":  "audio/aac",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".mid":  "audio/midi",
+	".midi": "audio/midi",
+	".mp3":  "audio/mpeg",
+	".oga":  "audio/ogg",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".weba": "audio/webm",
+	// video
+	".avi":  "video/x-msvideo",
+	".m4v":  "video/mp4",
+	".mkv":  "video/x-matroska",
+	".mov":  "video/quicktime",
+	".mp4":  "video/mp4",
+	".mpeg": "video/mpeg",
+	".ogv":  "video/ogg",
+	".webm": "video/webm",
+	// documents
+	".csv":   "text/csv",
+	".doc":   "application/msword",
+	".docx":  "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".epub":  "application/epub+zip",
+	".htm":   "text/html",
+	".html":  "text/html",
+	".ics":   "text/calendar",
+	".md":    "text/markdown",
+	".odp":   "application/vnd.oasis.opendocument.presentation",
+	".ods":   "application/vnd.oasis.opendocument.spreadsheet",
+	".odt":   "application/vnd.oasis.opendocument.text",
+	".pdf":   "application/pdf",
+	".ppt":   "application/vnd.ms-powerpoint",
+	".pptx":  "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".rtf":   "application/rtf",
+	".txt":   "text/plain",
+	".xhtml": "application/xhtml+xml",
+	".xls":   "application/vnd.ms-excel",
+	".xlsx":  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	// web
+	".css":         "text/css",
+	".js":          "text/javascript",
+	".json":        "application/json",
+	".map":         "application/json",
+	".mjs":         "text/javascript",
+	".wasm":        "application/wasm",
+	".webmanifest": "application/manifest+json",
+	".xml":         "application/xml",
+	// fonts
+	".otf":   "font/otf",
+	".ttf":   "font/ttf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	// archives
+	".7z":  "application/x-7z-compressed",
+	".gz":  "application/gzip",
+	".rar": "application/vnd.rar",
+	".tar": "application/x-tar",
+	".zip": "application/zip",
+}
+
+// MimeTypeByExtension returns the MIME type of a file extension like
+// ".png", or an empty string for unknown extensions.
+func MimeTypeByExtension(ext string) string {
+	return mimeTypes[strings.ToLower(ext)]
+}
+
+// ExtensionsOfKind returns the file extensions of the MIME types of a kind
+// like "audio", in order.
+func ExtensionsOfKind(kind string) []string {
+	exts := make([]string, 0)
+	for ext, t := range mimeTypes {
+		if strings.HasPrefix(t, kind+"/") {
+			exts = append(exts, ext)
+		}
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+// ContentType returns the MIME type of a file by its name, falling back to
+// the type sniffed from its content and then to application/octet-stream.
+func ContentType(name string, sniffed string) string {
+	if t := MimeTypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	if sniffed != "" {
+		return sniffed
+	}
+	return "application/octet-stream"
+}
+
+// IsActiveContent reports whether browsers run scripts of a MIME type,
+// which must not be shown inline from the origin of the site.
+func IsActiveContent(mimeType string) bool {
+	switch mimeType {
+	case "text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml",
+		"text/javascript", "application/javascript":
+		return true
+	}
+	return false
+}
@@ -2,10 +2,15 @@ package utils
 
 import (
 	"Memento/memento/model"
+	"Memento/memento/storage"
+	"bytes"
+	"compress/gzip"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
+	"io"
 	"net/http"
-	"os"
+	"regexp"
 	"strings"
 
 	"github.com/labstack/echo/v4"
@@ -13,6 +18,9 @@ import (
 
 const pageSize = 20
 
+// FileLinkPattern matches the links to uploaded files, with their id.
+var FileLinkPattern = regexp.MustCompile(`/api/file/download/(\d+)`)
+
 func Md5string(s string) string {
 	hasher := md5.New()
 	hasher.Write([]byte(s))
@@ -32,6 +40,13 @@ func RespondUnauthorized(c echo.Context) error {
 			"message": "invalid token",
 		})
 }
+func RespondForbidden(c echo.Context, msg interface{}) error {
+	return c.JSON(http.StatusForbidden,
+		echo.Map{
+			"message": msg,
+		})
+}
+
 func RespondInternalError(c echo.Context, msg interface{}) error {
 	return c.JSON(http.StatusInternalServerError,
 		echo.Map{
@@ -119,14 +134,61 @@ func Contains(array []string, value string) bool {
 	return false
 }
 
+// PostContent returns the Markdown of a post, which is kept in the database
+// or, for posts stored as files, by the storage backend.
+func PostContent(post *model.Post) (string, error) {
+	if post.ContentUrl != "" {
+		content, err := storage.ReadFile(post.ContentUrl)
+		return string(content), err
+	}
+	return DecodeContent(post.Content, post.ContentEncoding)
+}
+
+// EncodeContent prepares the Markdown of a post for the database, compressed
+// with gzip when compress is set.
+func EncodeContent(content string, compress bool) ([]byte, string, error) {
+	if !compress {
+		return []byte(content), "", nil
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(content)); err != nil {
+		return nil, "", err
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), model.ContentGzip, nil
+}
+
+// DecodeContent returns the Markdown of a post kept in the database.
+func DecodeContent(data []byte, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return string(data), nil
+	case model.ContentGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		return string(content), nil
+	}
+	return "", errors.New("unknown content encoding " + encoding)
+}
+
 func PostToView(post *model.Post, user *model.UserViewModel, liked bool) (*model.PostViewModel, error) {
-	content, err := os.ReadFile(post.ContentUrl)
+	content, err := PostContent(post)
 	if err != nil {
 		return nil, err
 	}
 	return &model.PostViewModel{
 		IsLiked:      liked,
 		IsPrivate:    post.IsPrivate,
+		IsHidden:     post.IsHidden,
 		PostID:       post.ID,
 		User:         *user,
 		TotalLiked:   post.TotalLiked,
@@ -138,15 +200,23 @@ func PostToView(post *model.Post, user *model.UserViewModel, liked bool) (*model
 }
 
 func CommentToView(comment *model.Comment, user *model.UserViewModel, isLiked bool) *model.CommentViewModel {
+	if comment.RemoteActor != "" {
+		user = &model.UserViewModel{
+			Username: comment.Username,
+			Nickname: comment.RemoteName,
+			Avatar:   "user.png",
+		}
+	}
 	return &model.CommentViewModel{
-		CommentID: comment.ID,
-		PostID:    comment.PostID,
-		User:      *user,
-		CreatedAt: comment.CreatedAt,
-		EditedAt:  comment.EditedAt,
-		Content:   comment.Content,
-		Liked:     comment.Liked,
-		IsLiked:   isLiked,
+		CommentID:   comment.ID,
+		PostID:      comment.PostID,
+		User:        *user,
+		CreatedAt:   comment.CreatedAt,
+		EditedAt:    comment.EditedAt,
+		Content:     comment.Content,
+		Liked:       comment.Liked,
+		IsLiked:     isLiked,
+		RemoteActor: comment.RemoteActor,
 	}
 }
 
@@ -175,7 +245,42 @@ func UserToView(user *model.User, isFollowed bool) *model.UserViewModel {
 		Avatar:        avatar,
 		IsFollowed:    isFollowed,
 		IsAdmin:       user.IsAdmin,
+		Role:          user.EffectiveRole(),
+		IsProtected:   user.IsProtected,
+	}
+}
+
+func AuditLogToView(l *model.AuditLog) *model.AuditLogViewModel {
+	return &model.AuditLogViewModel{
+		ID:        l.ID,
+		CreatedAt: l.CreatedAt,
+		Actor:     l.Actor,
+		Action:    l.Action,
+		Target:    l.Target,
+		IP:        l.IP,
+		Before:    l.Before,
+		After:     l.After,
+	}
+}
+
+func ReportToView(report *model.Report, forModerator bool) *model.ReportViewModel {
+	view := &model.ReportViewModel{
+		ID:         report.ID,
+		Reporter:   report.Reporter,
+		TargetType: report.TargetType,
+		Target:     report.Target,
+		Reason:     report.Reason,
+		Status:     report.Status,
+		Action:     report.Action,
+		CreatedAt:  report.CreatedAt,
+		ResolvedAt: report.ResolvedAt,
+	}
+	// the note is internal to the moderators
+	if forModerator {
+		view.Moderator = report.Moderator
+		view.Note = report.Note
 	}
+	return view
 }
 
 func MaxPage(total int64) int64 {
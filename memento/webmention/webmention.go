@@ -0,0 +1,277 @@
+package webmention
+
+import (
+	"Memento/memento/netguard"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// maxPageSize limits the size of fetched pages.
+const maxPageSize = 2 << 20
+
+// Page is a fetched web page.
+type Page struct {
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// Fetcher loads remote pages and posts the outgoing mentions. It is an
+// interface so that it can be stubbed in tests.
+type Fetcher interface {
+	Fetch(url string) (*Page, error)
+	Send(endpoint string, source string, target string) error
+}
+
+type httpFetcher struct {
+	client *http.Client
+}
+
+// DefaultFetcher only connects to public addresses, as the sources of
+// mentions and the links of posts are chosen by others.
+var DefaultFetcher Fetcher = &httpFetcher{client: netguard.NewClient(10*time.Second, false)}
+
+func (f *httpFetcher) Fetch(u string) (*Page, error) {
+	resp, err := f.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: status %d", u, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return nil, err
+	}
+	return &Page{URL: resp.Request.URL.String(), Header: resp.Header, Body: body}, nil
+}
+
+func (f *httpFetcher) Send(endpoint string, source string, target string) error {
+	resp, err := f.client.PostForm(endpoint, url.Values{"source": {source}, "target": {target}})
+	if err != nil {
+		return err
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sending webmention to %s: status %d", endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+// Mention is the content of a verified source page.
+type Mention struct {
+	Author  string
+	Title   string
+	Content string
+}
+
+// IsValidURL reports whether u is an absolute http(s) url.
+func IsValidURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// Verify fetches the source page and checks that it links to the target.
+func Verify(fetcher Fetcher, source string, target string) (*Mention, error) {
+	if err := netguard.CheckURL(source, false); err != nil {
+		return nil, err
+	}
+	page, err := fetcher.Fetch(source)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(bytes.NewReader(page.Body))
+	if err != nil {
+		return nil, err
+	}
+	base, _ := url.Parse(page.URL)
+	found := false
+	mention := &Mention{}
+	walk(doc, func(n *html.Node) {
+		switch n.Data {
+		case "a", "link":
+			if href := attr(n, "href"); href != "" && resolve(base, href) == target {
+				found = true
+			}
+		case "title":
+			if n.FirstChild != nil && mention.Title == "" {
+				mention.Title = strings.TrimSpace(n.FirstChild.Data)
+			}
+		case "meta":
+			if attr(n, "name") == "author" && mention.Author == "" {
+				mention.Author = attr(n, "content")
+			}
+		}
+		if mention.Author == "" && hasClass(n, "p-author") {
+			mention.Author = strings.TrimSpace(text(n))
+		}
+		if mention.Content == "" && hasClass(n, "e-content") {
+			mention.Content = strings.TrimSpace(text(n))
+		}
+	})
+	if !found {
+		return nil, errors.New("source does not link to target")
+	}
+	if mention.Content == "" {
+		mention.Content = mention.Title
+	}
+	if runes := []rune(mention.Content); len(runes) > 500 {
+		mention.Content = string(runes[:500])
+	}
+	return mention, nil
+}
+
+// Discover returns the webmention endpoint of the target page, or an empty
+// string if it does not accept webmentions.
+func Discover(fetcher Fetcher, target string) (string, error) {
+	if err := netguard.CheckURL(target, false); err != nil {
+		return "", err
+	}
+	page, err := fetcher.Fetch(target)
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := discover(page)
+	if err != nil || endpoint == "" {
+		return "", err
+	}
+	// the endpoint is chosen by the target as well
+	if err := netguard.CheckURL(endpoint, false); err != nil {
+		return "", err
+	}
+	return endpoint, nil
+}
+
+// discover finds the webmention endpoint in the headers or the links of a
+// page.
+func discover(page *Page) (string, error) {
+	base, _ := url.Parse(page.URL)
+	for _, link := range page.Header.Values("Link") {
+		for _, part := range strings.Split(link, ",") {
+			ref, params, found := strings.Cut(part, ";")
+			if found && hasRel(params, "webmention") {
+				return resolve(base, strings.Trim(strings.TrimSpace(ref), "<>")), nil
+			}
+		}
+	}
+	doc, err := html.Parse(bytes.NewReader(page.Body))
+	if err != nil {
+		return "", err
+	}
+	endpoint := ""
+	walk(doc, func(n *html.Node) {
+		if endpoint != "" || (n.Data != "link" && n.Data != "a") {
+			return
+		}
+		if href, ok := attrOk(n, "href"); ok && containsWord(attr(n, "rel"), "webmention") {
+			endpoint = resolve(base, href)
+		}
+	})
+	return endpoint, nil
+}
+
+// Links returns the absolute http(s) links of a html fragment.
+func Links(fragment string) []string {
+	doc, err := html.Parse(strings.NewReader(fragment))
+	if err != nil {
+		return nil
+	}
+	var links []string
+	seen := make(map[string]bool)
+	walk(doc, func(n *html.Node) {
+		if n.Data != "a" {
+			return
+		}
+		href := attr(n, "href")
+		if IsValidURL(href) && !seen[href] {
+			seen[href] = true
+			links = append(links, href)
+		}
+	})
+	return links
+}
+
+func walk(n *html.Node, fn func(*html.Node)) {
+	if n.Type == html.ElementNode {
+		fn(n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, fn)
+	}
+}
+
+func attrOk(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func attr(n *html.Node, key string) string {
+	v, _ := attrOk(n, key)
+	return v
+}
+
+func hasClass(n *html.Node, class string) bool {
+	return containsWord(attr(n, "class"), class)
+}
+
+func containsWord(s string, word string) bool {
+	for _, w := range strings.Fields(s) {
+		if strings.EqualFold(w, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasRel(params string, rel string) bool {
+	for _, p := range strings.Split(params, ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(p), "=")
+		if found && strings.EqualFold(key, "rel") && containsWord(strings.Trim(value, `"`), rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func text(n *html.Node) string {
+	var b strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+	return b.String()
+}
+
+func resolve(base *url.URL, ref string) string {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ref
+	}
+	if base == nil {
+		return u.String()
+	}
+	return base.ResolveReference(u).String()
+}
@@ -0,0 +1,112 @@
+package webmention
+
+import (
+	"Memento/memento/netguard"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// stubFetcher serves fixed pages by url and records what is fetched.
+type stubFetcher struct {
+	pages   map[string]*Page
+	fetched []string
+}
+
+func (f *stubFetcher) Fetch(u string) (*Page, error) {
+	f.fetched = append(f.fetched, u)
+	page, ok := f.pages[u]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return page, nil
+}
+
+func (f *stubFetcher) Send(endpoint string, source string, target string) error {
+	return nil
+}
+
+func page(u string, body string) *Page {
+	return &Page{URL: u, Header: http.Header{}, Body: []byte(body)}
+}
+
+const target = "https://memento.example.com/post/1"
+
+func TestVerify(t *testing.T) {
+	source := "https://blog.example.org/reply"
+	fetcher := &stubFetcher{pages: map[string]*Page{
+		source: page(source, `<title>Reply</title><meta name="author" content="Bob">
+<div class="e-content">Nice <a href="`+target+`">post</a></div>`),
+	}}
+	mention, err := Verify(fetcher, source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mention.Author != "Bob" || mention.Title != "Reply" || mention.Content != "Nice post" {
+		t.Errorf("got %+v", mention)
+	}
+	if _, err := Verify(fetcher, source, "https://memento.example.com/post/2"); err == nil {
+		t.Error("source without a link to the target was verified")
+	}
+}
+
+func TestVerifyRefusesPrivateSources(t *testing.T) {
+	for _, source := range []string{
+		"http://127.0.0.1:8080/admin",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]/",
+		"http://localhost:6379/",
+		"http://192.168.1.1/",
+		"file:///etc/passwd",
+	} {
+		fetcher := &stubFetcher{pages: map[string]*Page{
+			source: page(source, `<a href="`+target+`">post</a>`),
+		}}
+		if _, err := Verify(fetcher, source, target); err == nil {
+			t.Errorf("source %s was verified", source)
+		} else if !errors.Is(err, netguard.ErrAddress) && !errors.Is(err, netguard.ErrScheme) {
+			t.Errorf("source %s: %v", source, err)
+		}
+		if len(fetcher.fetched) > 0 {
+			t.Errorf("source %s: fetched %v", source, fetcher.fetched)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	linked := "https://blog.example.org/a"
+	headed := "https://blog.example.org/b"
+	none := "https://blog.example.org/c"
+	headers := http.Header{}
+	headers.Set("Link", `<https://hooks.example.org/webmention>; rel="webmention"`)
+	fetcher := &stubFetcher{pages: map[string]*Page{
+		linked: page(linked, `<link rel="webmention" href="/webmention">`),
+		headed: {URL: headed, Header: headers},
+		none:   page(none, `<p>no endpoint</p>`),
+	}}
+	for u, want := range map[string]string{
+		linked: "https://blog.example.org/webmention",
+		headed: "https://hooks.example.org/webmention",
+		none:   "",
+	} {
+		endpoint, err := Discover(fetcher, u)
+		if err != nil || endpoint != want {
+			t.Errorf("Discover(%s) = %q, %v, want %q", u, endpoint, err, want)
+		}
+	}
+}
+
+func TestDiscoverRefusesPrivateAddresses(t *testing.T) {
+	public := "https://blog.example.org/a"
+	fetcher := &stubFetcher{pages: map[string]*Page{
+		public: page(public, `<link rel="webmention" href="http://10.0.0.1/hook">`),
+	}}
+	for _, u := range []string{"http://127.0.0.1/", "https://metadata.localhost/", public} {
+		if endpoint, err := Discover(fetcher, u); !errors.Is(err, netguard.ErrAddress) {
+			t.Errorf("Discover(%s) = %q, %v, want %v", u, endpoint, err, netguard.ErrAddress)
+		}
+	}
+	if len(fetcher.fetched) != 1 || fetcher.fetched[0] != public {
+		t.Errorf("fetched %v", fetcher.fetched)
+	}
+}
@@ -86,7 +86,7 @@ export default function Editor({initialText, isPublic, fullHeight, onChanged, su
     setState(prevState => ({...prevState, isUploadingImage: true}));
     const input = document.createElement("input");
     input.type = "file";
-    input.accept = "image/*";
+    input.accept = "image/*,audio/*";
     let isClicked = false;
     input.onchange = async () => {
       if(isClicked) return;
@@ -94,7 +94,8 @@ export default function Editor({initialText, isPublic, fullHeight, onChanged, su
       try {
         const file = input.files?.item(0);
         const id = await network.uploadFile(file!);
-        const url = `![image](${app.server}/api/file/download/${id})`;
+        const alt = file!.type.startsWith("audio/") ? "audio" : "image";
+        const url = `![${alt}](${app.server}/api/file/download/${id})`;
         setState(prev => ({
           ...prev,
           text: prev.text.slice(0, start) + url + prev.text.slice(end)
@@ -237,11 +237,28 @@ export function MarkdownWidget({content, limitHeight}: {content: string, limitHe
             },
             input(props) {
                 return <input {...props} disabled={false}/>
+            },
+            img(props) {
+                if(isAudioEmbed(props.alt, props.src)) {
+                    return <audio controls preload={"metadata"} src={props.src} className={"w-full my-2"}/>
+                }
+                return <img {...props}/>
             }
         }}>{content}</Markdown>
     </div>
 }
 
+const audioExtensions = ["aac", "flac", "m4a", "mp3", "oga", "ogg", "opus", "wav", "weba"];
+
+// Audio is embedded like an image, as `![audio](url)` or by linking an audio file.
+function isAudioEmbed(alt?: string, src?: string) {
+    if(alt?.toLowerCase() === "audio") {
+        return true;
+    }
+    const ext = src?.split(/[?#]/)[0].split(".").pop()?.toLowerCase();
+    return ext !== undefined && audioExtensions.includes(ext);
+}
+
 function CodeWidget({props}: {props: any}) {
     const {children, className, ...rest} = props;
     const match = /language-(\w+)/.exec(children?.props?.className || '');
@@ -64,6 +64,10 @@ export interface Resource {
     id: number;
     filename: string;
     time: string;
+    posts?: number[];
+    mimeType?: string;
+    duration?: number;
+    codec?: string;
 }
 
 export interface ServerConfig{
@@ -166,11 +166,15 @@ export const network = {
         const res = await axios.get(`${app.server}/api/post/get?id=${postId}`);
         return res.data as Post;
     },
-    getResources: async (page: number) => {
-        const res = await axios.get(`${app.server}/api/file/all?page=${page}`);
+    getResources: async (page: number, type?: string) => {
+        const res = await axios.get(`${app.server}/api/file/all?page=${page}${type ? `&type=${type}` : ""}`);
         const json = res.data;
         return [json.files as Resource[], json.maxPage as number] as [Resource[], number];
     },
+    getFileLink: async (id: number) => {
+        const res = await axios.get(`${app.server}/api/file/link/${id}`);
+        return res.data.url as string;
+    },
     uploadFile: async (file: File, onUploadProgress?: (progress: number) => void) => {
         const formData = new FormData();
         formData.append("file", file);
@@ -182,8 +186,8 @@ export const network = {
         });
         return res.data.ID as string;
     },
-    deleteFile: async (fileId: string) => {
-        await axios.delete(`${app.server}/api/file/delete/${fileId}`);
+    deleteFile: async (fileId: string, force: boolean = false) => {
+        await axios.delete(`${app.server}/api/file/delete/${fileId}${force ? "?force=true" : ""}`);
     },
     editInfo: async (nickname: string | null, bio: string | null, avatar: File | null) => {
         const data = new FormData();
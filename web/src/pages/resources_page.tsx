@@ -1,16 +1,19 @@
 import React, {useCallback, useContext, useEffect, useState} from "react";
-import {MdOutlineCloudUpload, MdOutlineCopyAll, MdOutlineDescription} from "react-icons/md";
-import {Tr} from "../components/translate.tsx";
+import {MdOutlineAudiotrack, MdOutlineCloudUpload, MdOutlineCopyAll, MdOutlineDescription} from "react-icons/md";
+import {Tr, translate} from "../components/translate.tsx";
 import MultiPageList from "../components/multi_page_list.tsx";
 import {network} from "../network/network.ts";
 import {Resource} from "../network/model.ts";
 import {IconButton, TapRegion} from "../components/button.tsx";
-import {Button, Progress} from "@nextui-org/react";
+import {Button, Progress, Tab, Tabs} from "@nextui-org/react";
 import showMessage, {dialogCanceler, showDialog} from "../components/message.tsx";
 import app from "../app.ts";
 
+const resourceTypes = [["", "All"], ["image", "Images"], ["video", "Videos"], ["audio", "Audio"]];
+
 export default function ResourcesPage() {
     const [listKey, setListKey] = useState(0);
+    const [type, setType] = useState("");
 
     const handleUpload = useCallback((file: File) => {
         console.log("uploading file", file);
@@ -31,8 +34,11 @@ export default function ResourcesPage() {
 
     return <div className={"px-4 pt-4 overflow-y-auto w-full h-full"}>
         <UploadWidget onUpload={handleUpload}></UploadWidget>
-        <div className={"h-4"}/>
-        <ResourcesList key={listKey} onDelete={onDelete}></ResourcesList>
+        <Tabs aria-label={"Types"} variant={"underlined"} color={"primary"} className={"w-full pt-2"}
+              selectedKey={type} onSelectionChange={(key) => setType(key.toString())}>
+            {resourceTypes.map(([key, name]) => <Tab key={key} title={translate(name)}/>)}
+        </Tabs>
+        <ResourcesList key={`${type}-${listKey}`} type={type} onDelete={onDelete}></ResourcesList>
     </div>
 }
 
@@ -81,12 +87,14 @@ function UploadWidget({onUpload}: {onUpload: (file: File) => void}) {
     </div>
 }
 
-function ResourcesList({onDelete}: {onDelete: () => void}) {
+function ResourcesList({type, onDelete}: {type: string, onDelete: () => void}) {
     const builder = useCallback((item: Resource) => {
         return <ResourceWidget key={item.id} resource={item} onDelete={onDelete}></ResourceWidget>
     }, [onDelete])
 
-    return <MultiPageList itemBuilder={builder} loader={network.getResources}></MultiPageList>
+    const loader = useCallback((page: number) => network.getResources(page, type), [type]);
+
+    return <MultiPageList itemBuilder={builder} loader={loader}></MultiPageList>
 }
 
 function ResourceWidget({resource, onDelete}: {resource: Resource, onDelete: () => void}) {
@@ -97,10 +105,11 @@ function ResourceWidget({resource, onDelete}: {resource: Resource, onDelete: ()
         });
     }}>
         <div className={"h-12 w-full flex flex-row items-center px-4"}>
-            <MdOutlineDescription size={24}/>
+            {resource.mimeType?.startsWith("audio/") ? <MdOutlineAudiotrack size={24}/> : <MdOutlineDescription size={24}/>}
             <span className={"w-2"}/>
             <span>{resource.filename}</span>
             <span className={"flex-grow"}/>
+            {resource.duration ? <span className={"px-2 text-sm text-default-500"}>{formatDuration(resource.duration)}</span> : null}
             <span>{formatTime(resource.time)}</span>
         </div>
     </TapRegion>
@@ -111,6 +120,12 @@ function formatTime(time: string) {
     return date.toLocaleString();
 }
 
+function formatDuration(seconds: number) {
+    const total = Math.round(seconds);
+    const minutes = Math.floor(total / 60);
+    return `${minutes}:${(total % 60).toString().padStart(2, "0")}`;
+}
+
 function UploadingDialog({file, onUpload}: {file: File, onUpload: () => void}) {
     const [progress, setProgress] = useState(0);
     
@@ -141,6 +156,18 @@ function ItemInfoDialog({resource, onDelete}: {resource: Resource, onDelete: ()
 
     const canceler = useContext(dialogCanceler);
 
+    const usedBy = resource.posts?.length ?? 0;
+
+    const isAudio = resource.mimeType?.startsWith("audio/") ?? false;
+    const [audioUrl, setAudioUrl] = useState<string | null>(null);
+
+    useEffect(() => {
+        // private files are played from a signed link
+        if (isAudio) {
+            network.getFileLink(resource.id).then(setAudioUrl).catch(() => setAudioUrl(null));
+        }
+    }, [isAudio, resource.id]);
+
     return <div className={"p-2"}>
         <div className={"border rounded flex flex-row items-center py-1"}>
             <span className={"flex-grow overflow-auto px-2"}>{`https://${app.server}/api/file/download/${resource.id}`}</span>
@@ -153,11 +180,22 @@ function ItemInfoDialog({resource, onDelete}: {resource: Resource, onDelete: ()
                 <MdOutlineCopyAll/>
             </IconButton>
         </div>
+        {isAudio && <>
+            {audioUrl && <audio controls preload={"metadata"} src={audioUrl} className={"w-full mt-2"}/>}
+            {resource.duration ? <p className={"mt-1 text-sm"}>
+                {`${formatDuration(resource.duration)}${resource.codec ? `, ${resource.codec}` : ""}`}
+            </p> : null}
+        </>}
+        {usedBy > 0 && <p className={"mt-2 text-sm text-warning"}>
+            {translate(usedBy === 1
+                ? "Used by 1 memo, which will show a broken link"
+                : "Used by {count} memos, which will show a broken link").replace("{count}", usedBy.toString())}
+        </p>}
         <div className={"flex flex-row-reverse"}>
             <Button className={"mt-2 h-8"} color={"danger"} onClick={() => {
                 if (isDeleting) return;
                 setIsDeleting(true);
-                network.deleteFile(resource.id.toString()).then(() => {
+                network.deleteFile(resource.id.toString(), usedBy > 0).then(() => {
                     setIsDeleting(false);
                     showMessage({
                         text: "Delete success",
@@ -170,7 +208,7 @@ function ItemInfoDialog({resource, onDelete}: {resource: Resource, onDelete: ()
                         text: "Delete failed",
                     });
                 });
-            }}><Tr>Delete</Tr></Button>
+            }}><Tr>{usedBy > 0 ? "Delete anyway" : "Delete"}</Tr></Button>
         </div>
     </div>
 }
\ No newline at end of file